@@ -2,22 +2,42 @@ package application
 
 import (
 	"io"
+	"net"
+	"os"
 
 	"github.com/rosenhouse/tubes/lib/awsclient"
+	"github.com/rosenhouse/tubes/lib/director"
+	"golang.org/x/net/context"
 )
 
 type awsClient interface {
 	GetLatestNATBoxAMIID() (string, error)
 	UpsertStack(stackName string, template string, parameters map[string]string) error
-	WaitForStack(stackName string, pundit awsclient.CloudFormationStatusPundit) error
-	DeleteStack(stackName string) error
+	PlanStack(ctx context.Context, stackName string, template string, parameters map[string]string) (awsclient.StackPlan, error)
+	StackExists(stackName string) (bool, error)
+	StackStatus(stackName string) (string, error)
+	DetectStackDrift(ctx context.Context, stackName string) (awsclient.StackDrift, error)
+	ListEnvironmentStacks() ([]awsclient.EnvironmentStack, error)
+	ListDeletedBaseStacks(environments []string) ([]awsclient.EnvironmentStack, error)
+	WaitForStack(ctx context.Context, stackName string, pundit awsclient.CloudFormationStatusPundit) error
+	DeleteStack(stackName string, retainResources ...string) error
 	CreateKeyPair(stackName string) (string, error)
+	KeyPairExists(stackName string) (bool, error)
+	ImportKeyPair(stackName string, pemBytes []byte) error
 	DeleteKeyPair(stackName string) error
+	ListKeyPairs() ([]string, error)
 	GetBaseStackResources(stackName string) (awsclient.BaseStackResources, error)
 	GetStackResources(stackName string) (map[string]string, error)
 	CreateAccessKey(userName string) (string, string, error)
 	DeleteAccessKey(userName, accessKey string) error
 	ListAccessKeys(userName string) ([]string, error)
+	AssumeRole(roleARN, sessionName string) (awsclient.TemporaryCredentials, error)
+	InstanceState(instanceID string) (string, error)
+	NATGatewayState(natGatewayID string) (string, error)
+	ListBOSHVMs(vpcID, directorName string) (awsclient.BOSHVMs, error)
+	DeleteBOSHVMs(ctx context.Context, vms awsclient.BOSHVMs) error
+	ListElasticIPs() ([]awsclient.ElasticIP, error)
+	ReleaseElasticIP(allocationID string) error
 }
 
 type logger interface {
@@ -30,11 +50,46 @@ type logger interface {
 type configStore interface {
 	Get(string) ([]byte, error)
 	Set(string, []byte) error
+	Delete(string) error
+	List() ([]string, error)
 	IsEmpty() (bool, error)
 }
 
+type lockingConfigStore interface {
+	Lock() ([]string, error)
+	Unlock() ([]string, error)
+}
+
+type objectStore interface {
+	GetObject(bucket, key string) ([]byte, error)
+	PutObject(bucket, key string, value []byte) error
+	DeleteObject(bucket, key string) error
+	ListObjects(bucket, prefix string) ([]string, error)
+}
+
+type environmentStates interface {
+	Kind() string
+	Names() ([]string, error)
+	Locks() (map[string]*StateLock, error)
+}
+
+type stateLocker interface {
+	AcquireLock(command string) error
+	ReleaseLock() error
+	ForceUnlock() (*StateLock, error)
+}
+
+type historyConfigStore interface {
+	History() ([]byte, error)
+	Diff(revision string) ([]byte, error)
+}
+
 type manifestBuilder interface {
-	Build(name string, resources awsclient.BaseStackResources, accessKey, secretKey string) ([]byte, string, error)
+	Build(name string, resources awsclient.BaseStackResources, accessKey, secretKey string, credentials director.Credentials, ssl director.SSL) ([]byte, director.Credentials, error)
+}
+
+type certificateGenerator interface {
+	Generate(commonName string, ips []string) ([]byte, []byte, error)
 }
 
 type httpClient interface {
@@ -45,14 +100,52 @@ type cloudConfigGenerator interface {
 	Generate(resources map[string]string) ([]byte, error)
 }
 
+type directorDeployer interface {
+	Deploy(ctx context.Context, host string, privateKey []byte, checkHostKey func(hostKey []byte) error, files map[string][]byte, output io.Writer) ([]byte, error)
+}
+
+type sshTunneler interface {
+	Tunnel(host string, privateKey []byte, checkHostKey func(hostKey []byte) error) (func(network, addr string) (net.Conn, error), io.Closer, error)
+}
+
+type directorClient interface {
+	Info(target director.Target, username, password string) (director.Info, error)
+}
+
 type Application struct {
 	AWSClient            awsClient
 	StateDir             string
 	Logger               logger
 	ResultWriter         io.Writer
+	Input                io.Reader
 	ConfigStore          configStore
 	ManifestBuilder      manifestBuilder
 	HTTPClient           httpClient
 	CredentialsGenerator credentialsGenerator
 	CloudConfigGenerator cloudConfigGenerator
+	CertificateGenerator certificateGenerator
+	DirectorDeployer     directorDeployer
+	SSHTunneler          sshTunneler
+	DirectorClient       directorClient
+
+	// StateLocker guards remote state against concurrent runs, and is nil for local state
+	StateLocker stateLocker
+
+	// EnvironmentStates is where list and gc find the state of every environment
+	EnvironmentStates environmentStates
+
+	// currentStep is how far the command has got, for its checkpoint
+	currentStep string
+}
+
+// getOptional reads a value from the config store, treating a missing key as empty
+func (a *Application) getOptional(key string) ([]byte, error) {
+	value, err := a.ConfigStore.Get(key)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return value, nil
 }
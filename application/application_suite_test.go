@@ -11,6 +11,7 @@ import (
 	"github.com/onsi/gomega/gbytes"
 	"github.com/rosenhouse/tubes/application"
 	"github.com/rosenhouse/tubes/mocks"
+	"golang.org/x/net/context"
 
 	"testing"
 )
@@ -28,8 +29,10 @@ var (
 	awsClient *mocks.AWSClient
 
 	app *application.Application
+	ctx context.Context
 
 	stackName            string
+	upOptions            application.UpOptions
 	logBuffer            *gbytes.Buffer
 	resultBuffer         *gbytes.Buffer
 	configStore          *mocks.FunctionalConfigStore
@@ -37,6 +40,10 @@ var (
 	httpClient           *mocks.HTTPClient
 	credentialsGenerator *mocks.CredentialsGenerator
 	cloudConfigGenerator *mocks.CloudConfigGenerator
+	certificateGenerator *mocks.CertificateGenerator
+	directorDeployer     *mocks.DirectorDeployer
+	sshTunneler          *mocks.SSHTunneler
+	directorClient       *mocks.DirectorClient
 )
 
 var _ = BeforeEach(func() {
@@ -46,6 +53,10 @@ var _ = BeforeEach(func() {
 	httpClient = &mocks.HTTPClient{}
 	credentialsGenerator = &mocks.CredentialsGenerator{}
 	cloudConfigGenerator = &mocks.CloudConfigGenerator{}
+	certificateGenerator = &mocks.CertificateGenerator{}
+	directorDeployer = &mocks.DirectorDeployer{}
+	sshTunneler = &mocks.SSHTunneler{}
+	directorClient = &mocks.DirectorClient{}
 
 	logBuffer = gbytes.NewBuffer()
 	resultBuffer = gbytes.NewBuffer()
@@ -59,7 +70,13 @@ var _ = BeforeEach(func() {
 		HTTPClient:           httpClient,
 		CredentialsGenerator: credentialsGenerator,
 		CloudConfigGenerator: cloudConfigGenerator,
+		CertificateGenerator: certificateGenerator,
+		DirectorDeployer:     directorDeployer,
+		SSHTunneler:          sshTunneler,
+		DirectorClient:       directorClient,
 	}
 
+	ctx = context.Background()
 	stackName = fmt.Sprintf("some-stack-name-%x", rand.Int31())
+	upOptions = application.UpOptions{}
 })
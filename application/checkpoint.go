@@ -0,0 +1,71 @@
+package application
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/net/context"
+	"gopkg.in/yaml.v2"
+)
+
+const checkpointKey = "checkpoint.yml"
+
+// Checkpoint records where a command stopped when it was interrupted.  up is
+// resumable, so running it again carries on from there.
+type Checkpoint struct {
+	Command string `yaml:"command"`
+	Step    string `yaml:"step"`
+	Time    string `yaml:"time"`
+}
+
+// step records that the command has reached the named step, and stops it
+// there once ctx is cancelled, e.g. by Ctrl-C
+func (a *Application) step(ctx context.Context, name string) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("interrupted before %s", name)
+	}
+	a.currentStep = name
+	return nil
+}
+
+// SaveCheckpoint writes where an interrupted up or down stopped to the state
+// directory, unless it stopped before its first step.  Only those two record
+// their steps.
+func (a *Application) SaveCheckpoint(command string) error {
+	step := a.currentStep
+	if step == "" {
+		a.Logger.Printf("Interrupted before %s changed anything\n", command)
+		return nil
+	}
+
+	checkpointYAML, err := yaml.Marshal(Checkpoint{
+		Command: command,
+		Step:    step,
+		Time:    time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return err // not tested
+	}
+	err = a.ConfigStore.Set(checkpointKey, checkpointYAML)
+	if err != nil {
+		return err
+	}
+
+	a.Logger.Printf("Interrupted while %s.  Saved a checkpoint to the state directory; run %s again to carry on.\n", step, command)
+	return nil
+}
+
+// loadCheckpoint returns the checkpoint of an interrupted run, or nil if there isn't one
+func (a *Application) loadCheckpoint() (*Checkpoint, error) {
+	checkpointYAML, err := a.getOptional(checkpointKey)
+	if err != nil || checkpointYAML == nil {
+		return nil, err
+	}
+
+	checkpoint := &Checkpoint{}
+	err = yaml.Unmarshal(checkpointYAML, checkpoint)
+	if err != nil {
+		return nil, fmt.Errorf("malformed %s in state directory: %s", checkpointKey, err)
+	}
+	return checkpoint, nil
+}
@@ -0,0 +1,145 @@
+package application_test
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/rosenhouse/tubes/lib/awsclient"
+	"github.com/rosenhouse/tubes/lib/director"
+	"github.com/rosenhouse/tubes/mocks"
+	"golang.org/x/net/context"
+	"gopkg.in/yaml.v2"
+)
+
+var _ = Describe("Checkpoints", func() {
+	var cancel context.CancelFunc
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+
+		awsClient.GetBaseStackResourcesCall.Returns.Resources = awsclient.BaseStackResources{
+			BOSHUser:      "some-bosh-user",
+			BOSHElasticIP: "some-elastic-ip",
+		}
+		awsClient.GetStackResourcesCalls = make([]mocks.GetStackResourcesCall, 1)
+		manifestBuilder.BuildCall.Returns.Credentials = director.Credentials{Admin: "some-bosh-password"}
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	Context("when up is interrupted while waiting for the base stack", func() {
+		BeforeEach(func() {
+			awsClient.WaitForStackCalls = make([]mocks.WaitForStackCall, 1)
+			awsClient.WaitForStackCalls[0].Returns.Error = errors.New("stopped waiting")
+		})
+
+		It("should save that step to the state directory", func() {
+			Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError("stopped waiting"))
+			cancel()
+
+			Expect(app.SaveCheckpoint("up")).To(Succeed())
+
+			var checkpoint struct {
+				Command string `yaml:"command"`
+				Step    string `yaml:"step"`
+				Time    string `yaml:"time"`
+			}
+			Expect(yaml.Unmarshal(configStore.Values["checkpoint.yml"], &checkpoint)).To(Succeed())
+			Expect(checkpoint.Command).To(Equal("up"))
+			Expect(checkpoint.Step).To(Equal("waiting for the base stack"))
+			Expect(checkpoint.Time).NotTo(BeEmpty())
+			Expect(logBuffer).To(gbytes.Say("Interrupted while waiting for the base stack.  Saved a checkpoint to the state directory; run up again to carry on."))
+		})
+	})
+
+	Context("when up is interrupted before it starts changing things", func() {
+		It("should not save a checkpoint", func() {
+			cancel()
+
+			Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError("interrupted before creating the keypair"))
+			Expect(awsClient.CreateKeyPairCall.Receives.StackName).To(BeEmpty())
+
+			Expect(app.SaveCheckpoint("up")).To(Succeed())
+			Expect(configStore.Values).NotTo(HaveKey("checkpoint.yml"))
+			Expect(logBuffer).To(gbytes.Say("Interrupted before up changed anything"))
+		})
+	})
+
+	Context("when an earlier up was interrupted", func() {
+		BeforeEach(func() {
+			configStore.Values["checkpoint.yml"] = []byte("command: up\nstep: waiting for the base stack\ntime: \"2016-01-02T03:04:05Z\"\n")
+		})
+
+		It("should say where it stopped, and remove the checkpoint once up finishes", func() {
+			Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+			Expect(logBuffer).To(gbytes.Say("Resuming after up was interrupted while waiting for the base stack, at 2016-01-02T03:04:05Z"))
+			Expect(logBuffer).To(gbytes.Say("Finished"))
+			Expect(configStore.Values).NotTo(HaveKey("checkpoint.yml"))
+		})
+
+		It("should pass the context on to the waits, so interrupting stops them", func() {
+			Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+			Expect(awsClient.WaitForStackCalls[0].Receives.Context).To(BeIdenticalTo(ctx))
+		})
+
+		It("should keep the checkpoint if up fails again", func() {
+			awsClient.WaitForStackCalls = make([]mocks.WaitForStackCall, 1)
+			awsClient.WaitForStackCalls[0].Returns.Error = errors.New("some error")
+
+			Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError("some error"))
+			Expect(configStore.Values).To(HaveKey("checkpoint.yml"))
+		})
+
+		Context("when the checkpoint was saved by down", func() {
+			It("should not claim to resume, since up starts over", func() {
+				configStore.Values["checkpoint.yml"] = []byte("command: down\nstep: deleting the base stack\ntime: \"2016-01-02T03:04:05Z\"\n")
+
+				Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+				Expect(logBuffer).To(gbytes.Say("down was interrupted while deleting the base stack, at 2016-01-02T03:04:05Z.  Booting again, recreating whatever it deleted"))
+				Expect(logBuffer.Contents()).NotTo(ContainSubstring("Resuming"))
+				Expect(configStore.Values).NotTo(HaveKey("checkpoint.yml"))
+			})
+		})
+
+		Context("when the checkpoint is malformed", func() {
+			It("should return an error", func() {
+				configStore.Values["checkpoint.yml"] = []byte("nope")
+
+				Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError(HavePrefix("malformed checkpoint.yml in state directory")))
+			})
+		})
+	})
+
+	Context("when saving the checkpoint fails", func() {
+		It("should return the error", func() {
+			awsClient.WaitForStackCalls = make([]mocks.WaitForStackCall, 1)
+			awsClient.WaitForStackCalls[0].Returns.Error = errors.New("stopped waiting")
+			Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError("stopped waiting"))
+			configStore.Errors["checkpoint.yml"] = errors.New("some error")
+
+			Expect(app.SaveCheckpoint("up")).To(MatchError("some error"))
+		})
+	})
+
+	Context("when down is interrupted", func() {
+		It("should stop before deleting anything, and keep the state directory", func() {
+			awsClient.StackStatusCalls = make([]mocks.StackStatusCall, 2)
+			awsClient.StackStatusCalls[0].Returns.Status = "CREATE_COMPLETE"
+			awsClient.StackStatusCalls[1].Returns.Status = "CREATE_COMPLETE"
+			configStore.Values["ssh-key"] = []byte("some-key")
+			cancel()
+
+			Expect(app.Destroy(ctx, stackName, nil)).To(MatchError(ContainSubstring("interrupted before deleting the BOSH VMs")))
+			Expect(awsClient.DeleteStackCallCount).To(Equal(0))
+			Expect(awsClient.DeleteKeyPairCall.Receives.StackName).To(BeEmpty())
+			Expect(configStore.Values).To(HaveKey("ssh-key"))
+		})
+	})
+})
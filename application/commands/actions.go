@@ -1,34 +1,244 @@
 package commands
 
-import "github.com/rosenhouse/tubes/application"
+import (
+	"fmt"
+	"strings"
+	"sync"
 
-func (c *Up) Execute(args []string) error {
-	app, err := c.InitApp(args)
+	"github.com/rosenhouse/tubes/application"
+	"golang.org/x/net/context"
+)
+
+// unlockedCommands only read the state, so they run without the state lock
+var unlockedCommands = map[string]bool{
+	"plan":         true,
+	"show":         true,
+	"status":       true,
+	"drift":        true,
+	"history":      true,
+	"force-unlock": true,
+}
+
+// checkpointedCommands record their steps, so save a checkpoint when interrupted
+var checkpointedCommands = map[string]bool{
+	"up":   true,
+	"down": true,
+}
+
+type cliCommand interface {
+	Execute(ctx context.Context, args []string) error
+}
+
+// Execute runs the named command, as parsed into the options.  Commands stop
+// at the next safe point once ctx is cancelled.
+func (c *CLIOptions) Execute(ctx context.Context, name string, args []string) error {
+	byName := map[string]cliCommand{
+		"up":                 &c.Up,
+		"plan":               &c.Plan,
+		"down":               &c.Down,
+		"show":               &c.Show,
+		"status":             &c.Status,
+		"drift":              &c.Drift,
+		"list":               &c.List,
+		"gc":                 &c.GC,
+		"deploy-director":    &c.DeployDirector,
+		"rotate-credentials": &c.RotateCredentials,
+		"unlock":             &c.Unlock,
+		"lock":               &c.Lock,
+		"history":            &c.History,
+		"force-unlock":       &c.ForceUnlock,
+	}
+	cmd, ok := byName[name]
+	if !ok {
+		return fmt.Errorf("programming error: unknown command %q", name)
+	}
+	return cmd.Execute(ctx, args)
+}
+
+// heldLock is the state lock held by the running command, if any
+type heldLock struct {
+	sync.Mutex
+	locker interface {
+		ReleaseLock() error
+	}
+}
+
+// ReleaseLock releases the state lock, if the running command holds it, for
+// when tubes has to quit right away
+func (c *CLIOptions) ReleaseLock() error {
+	c.heldLock.Lock()
+	defer c.heldLock.Unlock()
+
+	if c.heldLock.locker == nil {
+		return nil
+	}
+	err := c.heldLock.locker.ReleaseLock()
+	c.heldLock.locker = nil
+	return err
+}
+
+// run builds the application and runs the action, holding the state lock
+// around commands that change the state, which first migrate it to the
+// current schema version, and save a checkpoint if they are interrupted
+func (c *CLIOptions) run(ctx context.Context, command string, args []string, action func(*application.Application) error) error {
+	app, err := c.InitApp(command, args)
 	if err != nil {
 		return err
 	}
 
-	return app.Boot(c.Name)
-}
+	if app.StateLocker == nil || unlockedCommands[command] {
+		err = app.CheckStateSchema()
+		if err != nil {
+			return err
+		}
+		return action(app)
+	}
 
-func (c *Down) Execute(args []string) error {
-	app, err := c.InitApp(args)
+	c.heldLock.Lock()
+	err = app.StateLocker.AcquireLock(command)
+	if err == nil {
+		c.heldLock.locker = app.StateLocker
+	}
+	c.heldLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = app.MigrateState()
+	if err == nil {
+		err = action(app)
+	}
+	if err != nil && ctx.Err() != nil && checkpointedCommands[command] {
+		checkpointErr := app.SaveCheckpoint(command)
+		if checkpointErr != nil {
+			app.Logger.Printf("Also failed to save a checkpoint: %s\n", checkpointErr)
+		}
+	}
+	releaseErr := c.ReleaseLock()
 	if err != nil {
+		if releaseErr != nil {
+			app.Logger.Printf("Also failed to release the state lock: %s\n", releaseErr)
+		}
 		return err
 	}
+	return releaseErr
+}
+
+func (c *Up) Execute(ctx context.Context, args []string) error {
+	return c.run(ctx, "up", args, func(app *application.Application) error {
+		return app.Boot(ctx, c.Name, c.UpOptions.options())
+	})
+}
+
+func (c *Plan) Execute(ctx context.Context, args []string) error {
+	return c.run(ctx, "plan", args, func(app *application.Application) error {
+		return app.Plan(ctx, c.Name, c.UpOptions.options())
+	})
+}
+
+func (o UpOptions) options() application.UpOptions {
+	return application.UpOptions{
+		PrivateDirector:   o.PrivateDirector,
+		NATGateway:        o.NATGateway,
+		AvailabilityZones: o.AvailabilityZones,
+		InstanceProfile:   o.InstanceProfile,
+	}
+}
+
+func (c *Down) Execute(ctx context.Context, args []string) error {
+	return c.run(ctx, "down", args, func(app *application.Application) error {
+		return app.Destroy(ctx, c.Name, splitList(c.Retain))
+	})
+}
+
+func (c *Show) Execute(ctx context.Context, args []string) error {
+	return c.run(ctx, "show", args, func(app *application.Application) error {
+		return app.Show(c.Name, application.ShowOptions{
+			SSHKey:          c.SSHKey,
+			BoshIP:          c.BoshIP,
+			BoshPassword:    c.BoshPassword,
+			BoshEnvironment: c.BoshEnvironment,
+			BoshUUID:        c.BoshUUID,
+			IAMPolicy:       c.IAMPolicy,
+			JSON:            c.JSON,
+			Secrets:         c.Secrets,
+		})
+	})
+}
+
+func (c *Status) Execute(ctx context.Context, args []string) error {
+	return c.run(ctx, "status", args, func(app *application.Application) error {
+		return app.Status(c.Name, c.JSON)
+	})
+}
 
-	return app.Destroy(c.Name)
+func (c *Drift) Execute(ctx context.Context, args []string) error {
+	return c.run(ctx, "drift", args, func(app *application.Application) error {
+		return app.Drift(ctx, c.Name, c.ShowFixes)
+	})
 }
 
-func (c *Show) Execute(args []string) error {
-	app, err := c.InitApp(args)
+func (c *List) Execute(ctx context.Context, args []string) error {
+	app, err := c.InitAccountApp(args)
 	if err != nil {
 		return err
 	}
-	return app.Show(c.Name, application.ShowOptions{
-		SSHKey:          c.SSHKey,
-		BoshIP:          c.BoshIP,
-		BoshPassword:    c.BoshPassword,
-		BoshEnvironment: c.BoshEnvironment,
+	return app.List()
+}
+
+func (c *GC) Execute(ctx context.Context, args []string) error {
+	app, err := c.InitAccountApp(args)
+	if err != nil {
+		return err
+	}
+	return app.GC(ctx, c.Yes)
+}
+
+func (c *DeployDirector) Execute(ctx context.Context, args []string) error {
+	return c.run(ctx, "deploy-director", args, func(app *application.Application) error {
+		return app.DeployDirector(ctx, c.Name)
+	})
+}
+
+func (c *RotateCredentials) Execute(ctx context.Context, args []string) error {
+	return c.run(ctx, "rotate-credentials", args, func(app *application.Application) error {
+		return app.RotateCredentials(c.Name, splitList(c.Only))
+	})
+}
+
+// splitList flattens repeated and comma-separated flag values
+func splitList(values []string) []string {
+	items := []string{}
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item != "" {
+				items = append(items, strings.TrimSpace(item))
+			}
+		}
+	}
+	return items
+}
+
+func (c *Unlock) Execute(ctx context.Context, args []string) error {
+	return c.run(ctx, "unlock", args, func(app *application.Application) error {
+		return app.Unlock(c.Name)
+	})
+}
+
+func (c *Lock) Execute(ctx context.Context, args []string) error {
+	return c.run(ctx, "lock", args, func(app *application.Application) error {
+		return app.Lock(c.Name)
+	})
+}
+
+func (c *History) Execute(ctx context.Context, args []string) error {
+	return c.run(ctx, "history", args, func(app *application.Application) error {
+		return app.History(c.Name, c.Diff)
+	})
+}
+
+func (c *ForceUnlock) Execute(ctx context.Context, args []string) error {
+	return c.run(ctx, "force-unlock", args, func(app *application.Application) error {
+		return app.ForceUnlock(c.Name)
 	})
 }
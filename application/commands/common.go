@@ -1,16 +1,21 @@
 package commands
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/jessevdk/go-flags"
 	"github.com/rosenhouse/tubes/application"
 	"github.com/rosenhouse/tubes/lib/awsclient"
+	"github.com/rosenhouse/tubes/lib/boshinit"
 	"github.com/rosenhouse/tubes/lib/boshio"
 	"github.com/rosenhouse/tubes/lib/cloudconfig"
 	"github.com/rosenhouse/tubes/lib/credentials"
@@ -18,6 +23,32 @@ import (
 	"github.com/rosenhouse/tubes/lib/webclient"
 )
 
+type configStore interface {
+	Get(string) ([]byte, error)
+	Set(string, []byte) error
+	Delete(string) error
+	List() ([]string, error)
+	IsEmpty() (bool, error)
+}
+
+type environmentStates interface {
+	Kind() string
+	Names() ([]string, error)
+	Locks() (map[string]*application.StateLock, error)
+}
+
+// allowedOnDirtyState are the commands that only read the state directory,
+// or, like lock, are how to clean it up
+var allowedOnDirtyState = map[string]bool{
+	"plan":    true,
+	"show":    true,
+	"status":  true,
+	"drift":   true,
+	"history": true,
+	"unlock":  true,
+	"lock":    true,
+}
+
 func parseError(fmtString string, args ...interface{}) *flags.Error {
 	return &flags.Error{Message: fmt.Sprintf(fmtString, args...)}
 }
@@ -68,60 +99,182 @@ func (c *AWSConfig) buildClient() (*awsclient.Client, error) {
 	return awsclient.New(config)
 }
 
-func (options *CLIOptions) InitApp(args []string) (*application.Application, error) {
-	if options == nil {
-		return nil, errors.New("programming error: missing parent reference in command")
+func (c *CLIOptions) loadPassphrase() ([]byte, error) {
+	if c.PassphraseFile == "" {
+		return []byte(c.Passphrase), nil
 	}
-	if len(args) > 0 {
-		return nil, parseError("unknown args: %+v\n", args)
-	}
-	if err := options.checkStackName(); err != nil {
-		return nil, err
+	if c.Passphrase != "" {
+		return nil, parseError("set either a passphrase or a passphrase file, not both")
 	}
 
-	awsClient, err := options.AWSConfig.buildClient()
+	passphrase, err := ioutil.ReadFile(c.PassphraseFile)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("reading passphrase file: %s", err)
+	}
+	passphrase = bytes.TrimSpace(passphrase)
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("passphrase file is empty: %s", c.PassphraseFile)
 	}
+	return passphrase, nil
+}
 
+func (options *CLIOptions) localStateDir() (string, error) {
 	stateDir := options.StateDir
 	if stateDir != "" {
 		fileInfo, err := os.Stat(stateDir)
 		if err != nil {
-			return nil, fmt.Errorf("state directory not found: %s", err)
+			return "", fmt.Errorf("state directory not found: %s", err)
 		}
 		if !fileInfo.IsDir() {
-			return nil, fmt.Errorf("state directory not a directory: %s", stateDir)
+			return "", fmt.Errorf("state directory not a directory: %s", stateDir)
 		}
 
 	} else {
 		workingDir, err := os.Getwd()
 		if err != nil {
-			return nil, err
+			return "", err
 		}
 		stateDir = filepath.Join(workingDir, "environments", options.Name)
 		err = os.MkdirAll(stateDir, 0700)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return stateDir, nil
+}
+
+// remoteState parses a state URL like s3://bucket/prefix
+func (options *CLIOptions) remoteState(awsClient *awsclient.Client) (*application.S3ConfigStore, error) {
+	if options.StateDir != "" || options.Git {
+		return nil, parseError("--state-url can't be combined with --state-dir or --git")
+	}
+
+	stateURL, err := url.Parse(options.StateURL)
+	if err != nil {
+		return nil, parseError("invalid state URL: %s", err)
+	}
+	if stateURL.Scheme != "s3" || stateURL.Host == "" {
+		return nil, parseError("invalid state URL %q, expecting s3://bucket/prefix", options.StateURL)
+	}
+
+	return &application.S3ConfigStore{
+		Client:      awsClient,
+		Bucket:      stateURL.Host,
+		Prefix:      strings.Trim(stateURL.Path, "/"),
+		Owner:       lockOwner(),
+		SettleDelay: options.LockSettleDelay,
+	}, nil
+}
+
+// owner identifies who runs tubes, and where, in the tags of new stacks
+func owner() string {
+	userName := os.Getenv("USER")
+	if userName == "" {
+		userName = "unknown"
+	}
+	hostName, err := os.Hostname()
+	if err != nil {
+		hostName = "unknown"
+	}
+	return fmt.Sprintf("%s@%s", userName, hostName)
+}
+
+// lockOwner identifies this run in the state lock
+func lockOwner() string {
+	return fmt.Sprintf("%s (pid %d)", owner(), os.Getpid())
+}
+
+// InitApp builds the application for the named command
+func (options *CLIOptions) InitApp(command string, args []string) (*application.Application, error) {
+	if options == nil {
+		return nil, errors.New("programming error: missing parent reference in command")
+	}
+	if len(args) > 0 {
+		return nil, parseError("unknown args: %+v\n", args)
+	}
+	if err := options.checkStackName(); err != nil {
+		return nil, err
+	}
+
+	awsClient, err := options.AWSConfig.buildClient()
+	if err != nil {
+		return nil, err
+	}
+
+	logger := log.New(os.Stderr, "", 0)
+	awsClient.Logger = logger
+	awsClient.Owner = owner()
+
+	passphrase, err := options.loadPassphrase()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		stateDir    string
+		baseStore   configStore
+		localState  *application.FilesystemConfigStore
+		remoteState *application.S3ConfigStore
+	)
+	if options.StateURL != "" {
+		remoteState, err = options.remoteState(awsClient)
+		if err != nil {
+			return nil, err
+		}
+		baseStore = remoteState
+	} else {
+		stateDir, err = options.localStateDir()
 		if err != nil {
 			return nil, err
 		}
+		localState = &application.FilesystemConfigStore{RootDir: stateDir, Owner: lockOwner()}
+		baseStore = localState
 	}
 
-	configStore := &application.FilesystemConfigStore{RootDir: stateDir}
+	var configStore configStore = &application.EncryptedConfigStore{
+		Store:      baseStore,
+		Passphrase: passphrase,
+		SealedKeys: application.DefaultSealedKeys,
+	}
+	if options.Git {
+		gitConfigStore := &application.GitConfigStore{
+			Store:   configStore,
+			RootDir: stateDir,
+			Command: command,
+			Force:   options.Force,
+		}
+		if !allowedOnDirtyState[command] {
+			err = gitConfigStore.EnsureClean()
+			if err != nil {
+				return nil, err
+			}
+		}
+		configStore = gitConfigStore
+	}
 
 	boshIOHttpClient := &webclient.HTTPClient{
 		BaseURL: options.BoshIOURL,
 	}
 
 	credentialsGenerator := credentials.Generator{Length: 12}
+	remoteDeployer := boshinit.New("ec2-user", options.SSHPort)
 
-	return &application.Application{
+	app := &application.Application{
 		AWSClient:            awsClient,
-		Logger:               log.New(os.Stderr, "", 0),
+		Logger:               logger,
 		ResultWriter:         os.Stdout,
 		ConfigStore:          configStore,
 		HTTPClient:           &webclient.HTTPClient{},
 		CredentialsGenerator: credentialsGenerator,
 		CloudConfigGenerator: &cloudconfig.Generator{},
+		CertificateGenerator: credentials.CertificateGenerator{},
+		DirectorDeployer:     remoteDeployer,
+		SSHTunneler:          remoteDeployer,
+		DirectorClient: &director.InfoClient{
+			Port:    options.DirectorPort,
+			Timeout: options.DirectorTimeout,
+		},
 		ManifestBuilder: &application.ManifestBuilder{
 			DirectorManifestGenerator: director.DirectorManifestGenerator{},
 			BoshIOClient: &boshio.Client{
@@ -130,5 +283,76 @@ func (options *CLIOptions) InitApp(args []string) (*application.Application, err
 			},
 			CredentialsGenerator: credentialsGenerator,
 		},
+	}
+	if remoteState != nil {
+		app.StateLocker = remoteState
+	} else {
+		app.StateLocker = localState
+	}
+	return app, nil
+}
+
+// InitAccountApp builds the application for commands that look at every
+// environment, so have no name or state directory of their own
+func (options *CLIOptions) InitAccountApp(args []string) (*application.Application, error) {
+	if options == nil {
+		return nil, errors.New("programming error: missing parent reference in command")
+	}
+	if len(args) > 0 {
+		return nil, parseError("unknown args: %+v\n", args)
+	}
+
+	awsClient, err := options.AWSConfig.buildClient()
+	if err != nil {
+		return nil, err
+	}
+
+	logger := log.New(os.Stderr, "", 0)
+	awsClient.Logger = logger
+
+	environmentStates, err := options.environmentStates(awsClient)
+	if err != nil {
+		return nil, err
+	}
+
+	return &application.Application{
+		AWSClient:         awsClient,
+		Logger:            logger,
+		ResultWriter:      os.Stdout,
+		Input:             os.Stdin,
+		EnvironmentStates: environmentStates,
 	}, nil
 }
+
+// environmentStates finds the state of every environment under --state-url
+// or --state-dir, defaulting to <working_dir>/environments
+func (options *CLIOptions) environmentStates(awsClient *awsclient.Client) (environmentStates, error) {
+	if options.StateURL != "" {
+		remoteState, err := options.remoteState(awsClient)
+		if err != nil {
+			return nil, err
+		}
+		return application.S3EnvironmentStates{
+			Client: awsClient,
+			Bucket: remoteState.Bucket,
+			Prefix: remoteState.Prefix,
+		}, nil
+	}
+
+	if options.StateDir != "" {
+		fileInfo, err := os.Stat(options.StateDir)
+		if err != nil {
+			return nil, fmt.Errorf("state directory not found: %s", err)
+		}
+		if !fileInfo.IsDir() {
+			return nil, fmt.Errorf("state directory not a directory: %s", options.StateDir)
+		}
+		return application.LocalEnvironmentStates{Dir: options.StateDir}, nil
+	}
+
+	workingDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	return application.LocalEnvironmentStates{Dir: filepath.Join(workingDir, "environments")}, nil
+}
@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"time"
 
@@ -14,6 +15,8 @@ import (
 	"github.com/rosenhouse/tubes/application"
 	"github.com/rosenhouse/tubes/application/commands"
 	"github.com/rosenhouse/tubes/lib/awsclient"
+	"github.com/rosenhouse/tubes/lib/director"
+	"golang.org/x/net/context"
 )
 
 func expectAreSameDirectory(dir1, dir2 string) {
@@ -59,7 +62,7 @@ var _ = Describe("InitApp", func() {
 	})
 
 	It("should pull in AWS client config", func() {
-		app, err := options.InitApp(nil)
+		app, err := options.InitApp("some-command", nil)
 		Expect(err).NotTo(HaveOccurred())
 
 		awsClient := app.AWSClient.(*awsclient.Client)
@@ -69,12 +72,252 @@ var _ = Describe("InitApp", func() {
 		Expect(*ec2Client.Config.Region).To(Equal("some-region"))
 	})
 
+	It("should share the application logger with the AWS client", func() {
+		app, err := options.InitApp("some-command", nil)
+		Expect(err).NotTo(HaveOccurred())
+		awsClient := app.AWSClient.(*awsclient.Client)
+		Expect(awsClient.Logger).To(BeIdenticalTo(app.Logger))
+	})
+
+	It("should tag new stacks with the user and host running tubes", func() {
+		defer os.Setenv("USER", os.Getenv("USER"))
+		os.Setenv("USER", "some-user")
+		hostName, err := os.Hostname()
+		Expect(err).NotTo(HaveOccurred())
+
+		app, err := options.InitApp("some-command", nil)
+		Expect(err).NotTo(HaveOccurred())
+		awsClient := app.AWSClient.(*awsclient.Client)
+		Expect(awsClient.Owner).To(Equal("some-user@" + hostName))
+	})
+
+	Describe("building the application for commands across every environment", func() {
+		It("should not need a name, or make a state directory", func() {
+			options.Name = ""
+
+			app, err := options.InitAccountApp(nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			awsClient := app.AWSClient.(*awsclient.Client)
+			Expect(awsClient.CloudFormationWaitTimeout).To(Equal(1 * time.Second))
+			Expect(app.ConfigStore).To(BeNil())
+			Expect(app.Input).To(Equal(os.Stdin))
+			_, err = os.Stat(filepath.Join(workingDir, "environments"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It("should reject extra args", func() {
+			_, err := options.InitAccountApp([]string{"some-arg"})
+			Expect(err).To(MatchError(ContainSubstring("unknown args")))
+		})
+
+		It("should find the state of every environment under the working directory", func() {
+			app, err := options.InitAccountApp(nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			environmentStates := app.EnvironmentStates.(application.LocalEnvironmentStates)
+			expectAreSameDirectory(environmentStates.Dir, filepath.Join(workingDir, "environments"))
+		})
+
+		Context("when the state directory is set", func() {
+			It("should find the state of every environment there", func() {
+				options.StateDir = workingDir
+
+				app, err := options.InitAccountApp(nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(app.EnvironmentStates).To(Equal(application.LocalEnvironmentStates{Dir: workingDir}))
+			})
+
+			It("should return an error when it is missing", func() {
+				options.StateDir = filepath.Join(workingDir, "missing")
+
+				_, err := options.InitAccountApp(nil)
+				Expect(err).To(MatchError(ContainSubstring("state directory not found")))
+			})
+		})
+
+		Context("when the state is remote", func() {
+			It("should find the state of every environment under the prefix", func() {
+				options.StateURL = "s3://some-bucket/some/prefix/"
+
+				app, err := options.InitAccountApp(nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				environmentStates := app.EnvironmentStates.(application.S3EnvironmentStates)
+				Expect(environmentStates.Bucket).To(Equal("some-bucket"))
+				Expect(environmentStates.Prefix).To(Equal("some/prefix"))
+				Expect(environmentStates.Client).To(BeIdenticalTo(app.AWSClient))
+			})
+
+			It("should return an error when the state URL is invalid", func() {
+				options.StateURL = "http://some-bucket/prefix"
+
+				_, err := options.InitAccountApp(nil)
+				Expect(err).To(MatchError(ContainSubstring("invalid state URL")))
+			})
+		})
+	})
+
+	It("should point the director client at the director port", func() {
+		options.DirectorPort = 12345
+		options.DirectorTimeout = 3 * time.Second
+
+		app, err := options.InitApp("some-command", nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		directorClient := app.DirectorClient.(*director.InfoClient)
+		Expect(directorClient.Port).To(Equal(12345))
+		Expect(directorClient.Timeout).To(Equal(3 * time.Second))
+	})
+
+	It("should open SSH tunnels with the same settings as the deployer", func() {
+		options.SSHPort = 2222
+
+		app, err := options.InitApp("some-command", nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(app.SSHTunneler).To(BeIdenticalTo(app.DirectorDeployer))
+	})
+
+	Describe("encrypting the state directory", func() {
+		It("should seal the secrets with the passphrase", func() {
+			options.Passphrase = "some-passphrase"
+
+			app, err := options.InitApp("some-command", nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			configStore := app.ConfigStore.(*application.EncryptedConfigStore)
+			Expect(configStore.Passphrase).To(Equal([]byte("some-passphrase")))
+			Expect(configStore.SealedKeys).To(Equal(application.DefaultSealedKeys))
+		})
+
+		It("should read the passphrase from a file, trimming whitespace", func() {
+			passphraseFile := filepath.Join(workingDir, "passphrase")
+			Expect(ioutil.WriteFile(passphraseFile, []byte("some-passphrase\n"), 0600)).To(Succeed())
+			options.PassphraseFile = passphraseFile
+
+			app, err := options.InitApp("some-command", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(app.ConfigStore.(*application.EncryptedConfigStore).Passphrase).To(Equal([]byte("some-passphrase")))
+		})
+
+		Context("when both a passphrase and a passphrase file are set", func() {
+			It("should return an error", func() {
+				options.Passphrase = "some-passphrase"
+				options.PassphraseFile = "some-file"
+
+				_, err := options.InitApp("some-command", nil)
+				Expect(err).To(MatchError(ContainSubstring("not both")))
+			})
+		})
+
+		Context("when the passphrase file is missing or empty", func() {
+			It("should return an error", func() {
+				options.PassphraseFile = filepath.Join(workingDir, "nope")
+				_, err := options.InitApp("some-command", nil)
+				Expect(err).To(MatchError(ContainSubstring("reading passphrase file")))
+
+				Expect(ioutil.WriteFile(options.PassphraseFile, []byte("\n"), 0600)).To(Succeed())
+				_, err = options.InitApp("some-command", nil)
+				Expect(err).To(MatchError(ContainSubstring("passphrase file is empty")))
+			})
+		})
+	})
+
+	Context("when the state is remote", func() {
+		It("should keep the state in S3, behind the encrypting store, and lock it", func() {
+			options.StateURL = "s3://some-bucket/some/prefix/"
+
+			app, err := options.InitApp("some-command", nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			remoteState := app.ConfigStore.(*application.EncryptedConfigStore).Store.(*application.S3ConfigStore)
+			Expect(remoteState.Bucket).To(Equal("some-bucket"))
+			Expect(remoteState.Prefix).To(Equal("some/prefix"))
+			Expect(remoteState.Client).To(BeIdenticalTo(app.AWSClient))
+			Expect(remoteState.Owner).To(ContainSubstring(fmt.Sprintf("(pid %d)", os.Getpid())))
+			Expect(app.StateLocker).To(BeIdenticalTo(remoteState))
+
+			_, err = os.Stat(filepath.Join(workingDir, "environments"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It("should lock local state too", func() {
+			app, err := options.InitApp("some-command", nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			localState := app.ConfigStore.(*application.EncryptedConfigStore).Store.(*application.FilesystemConfigStore)
+			Expect(app.StateLocker).To(BeIdenticalTo(localState))
+			Expect(localState.Owner).To(ContainSubstring(fmt.Sprintf("(pid %d)", os.Getpid())))
+		})
+
+		Context("when the state URL is invalid", func() {
+			It("should return an error", func() {
+				for _, stateURL := range []string{"http://some-bucket/prefix", "s3:///prefix", "%"} {
+					options.StateURL = stateURL
+					_, err := options.InitApp("some-command", nil)
+					Expect(err).To(MatchError(ContainSubstring("invalid state URL")))
+				}
+			})
+		})
+
+		Context("when combined with a state directory or git", func() {
+			It("should return an error", func() {
+				options.StateURL = "s3://some-bucket/prefix"
+				options.Git = true
+
+				_, err := options.InitApp("some-command", nil)
+				Expect(err).To(MatchError(ContainSubstring("can't be combined")))
+			})
+		})
+	})
+
+	Context("when changes should be committed to git", func() {
+		It("should wrap the store, naming the command", func() {
+			options.Git = true
+			options.Force = true
+
+			app, err := options.InitApp("some-command", nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			configStore := app.ConfigStore.(*application.GitConfigStore)
+			Expect(configStore.Command).To(Equal("some-command"))
+			Expect(configStore.Force).To(BeTrue())
+			expectAreSameDirectory(configStore.RootDir, filepath.Join(workingDir, "environments", "some-stack-name"))
+			Expect(configStore.Store).To(BeAssignableToTypeOf(&application.EncryptedConfigStore{}))
+		})
+
+		Context("when the state directory has uncommitted changes", func() {
+			BeforeEach(func() {
+				options.Git = true
+				cmd := exec.Command("git", "init", "--quiet")
+				cmd.Dir = workingDir
+				Expect(cmd.Run()).To(Succeed())
+
+				stateDir := filepath.Join(workingDir, "environments", "some-stack-name")
+				Expect(os.MkdirAll(stateDir, 0700)).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(stateDir, "stray"), []byte("stray"), 0600)).To(Succeed())
+			})
+
+			It("should refuse commands that change the state, before they run", func() {
+				_, err := options.InitApp("up", nil)
+				Expect(err).To(MatchError(ContainSubstring("uncommitted changes")))
+			})
+
+			It("should allow commands that only read it, or lock it", func() {
+				for _, command := range []string{"show", "history", "lock"} {
+					_, err := options.InitApp(command, nil)
+					Expect(err).NotTo(HaveOccurred())
+				}
+			})
+		})
+	})
+
 	Context("when the state directory is not set", func() {
 		It("should create a subdirectory of the working directory", func() {
-			app, err := options.InitApp(nil)
+			app, err := options.InitApp("some-command", nil)
 			Expect(err).NotTo(HaveOccurred())
 
-			configStore := app.ConfigStore.(*application.FilesystemConfigStore)
+			configStore := app.ConfigStore.(*application.EncryptedConfigStore).Store.(*application.FilesystemConfigStore)
 			expectedConfigRootDir := filepath.Join(workingDir, "environments", "some-stack-name")
 			expectAreSameDirectory(configStore.RootDir, expectedConfigRootDir)
 		})
@@ -85,7 +328,7 @@ var _ = Describe("InitApp", func() {
 			It("should return an error", func() {
 				options.StateDir = fmt.Sprintf("-nope-%x-nope", rand.Int31())
 
-				_, err := options.InitApp(nil)
+				_, err := options.InitApp("some-command", nil)
 				Expect(err).To(MatchError(ContainSubstring("state directory not found")))
 			})
 		})
@@ -95,9 +338,31 @@ var _ = Describe("InitApp", func() {
 				Expect(ioutil.WriteFile(someFilePath, []byte("whatever"), 0600)).To(Succeed())
 				options.StateDir = someFilePath
 
-				_, err := options.InitApp(nil)
+				_, err := options.InitApp("some-command", nil)
 				Expect(err).To(MatchError(ContainSubstring("state directory not a directory")))
 			})
 		})
 	})
 })
+
+var _ = Describe("Execute", func() {
+	var options *commands.CLIOptions
+
+	BeforeEach(func() {
+		options = commands.New()
+	})
+
+	It("should run the named command", func() {
+		err := options.Execute(context.Background(), "list", []string{"some-arg"})
+		Expect(err).To(MatchError(ContainSubstring("unknown args")))
+	})
+
+	It("should reject an unknown command", func() {
+		err := options.Execute(context.Background(), "nope", nil)
+		Expect(err).To(MatchError(`programming error: unknown command "nope"`))
+	})
+
+	It("should have no state lock to release when no command holds one", func() {
+		Expect(options.ReleaseLock()).To(Succeed())
+	})
+})
@@ -5,21 +5,67 @@ import "time"
 type CLIOptions struct {
 	Name      string    `short:"n" long:"name"  description:"Name of environment to manipulate"`
 	AWSConfig AWSConfig `group:"aws"`
-	StateDir  string    `short:"s" long:"state-dir" description:"Path to directory where state is stored.  Typically you'd track this in a private git repository or other secure location.  Defaults to <working_dir>/environments/<name>"`
+	StateDir  string    `short:"s" long:"state-dir" description:"Path to directory where state is stored.  Typically you'd track this in a private git repository or other secure location.  Defaults to <working_dir>/environments/<name>.  For list and gc, the directory holding the state directory of every environment, defaulting to <working_dir>/environments"`
+	StateURL  string    `long:"state-url" env:"TUBES_STATE_URL" description:"Keep the state in S3 instead, e.g. s3://my-bucket/environments/<name>, with a lock so only one command changes it at a time.  For list and gc, the prefix holding the state of every environment, e.g. s3://my-bucket/environments"`
 
 	BoshIOURL string `long:"bosh-io-url" default:"https://bosh.io" env:"TUBES_BOSH_IO_URL" description:"URL of BOSH hub.  Override for testing."`
+	SSHPort   int    `long:"ssh-port" default:"22" env:"TUBES_SSH_PORT" description:"SSH port of the NAT box.  Override for testing."`
+
+	DirectorPort    int           `long:"director-port" default:"25555" env:"TUBES_DIRECTOR_PORT" description:"API port of the BOSH director.  Override for testing."`
+	DirectorTimeout time.Duration `long:"director-timeout" default:"10s" env:"TUBES_DIRECTOR_TIMEOUT" description:"How long to wait for the BOSH director API to answer.  Override for testing."`
+
+	LockSettleDelay time.Duration `long:"lock-settle-delay" default:"2s" env:"TUBES_LOCK_SETTLE_DELAY" description:"How long to wait before checking that a lock taken in S3 is still ours.  Override for testing."`
+
+	Passphrase     string `long:"passphrase" env:"TUBES_PASSPHRASE" description:"encrypt the secrets in the state directory with this passphrase.  Prefer the env var, or a passphrase file."`
+	PassphraseFile string `long:"passphrase-file" env:"TUBES_PASSPHRASE_FILE" description:"path to a file holding the passphrase, or a random key"`
+
+	Git   bool `long:"git" env:"TUBES_GIT" description:"commit every change to the state directory to the git repository containing it"`
+	Force bool `long:"force" description:"with --git, make changes even if the state directory has uncommitted changes"`
 
 	Up   Up   `command:"up" description:"Boot a new environment with the given name"`
+	Plan Plan `command:"plan" description:"Show the changes that up would make, without making them"`
 	Down Down `command:"down" description:"Tear down the named environment"`
 	Show Show `command:"show" description:"Show information about the named environment"`
+
+	Status Status `command:"status" description:"Compare the state directory with the live environment, failing on drift"`
+	Drift  Drift  `command:"drift" description:"Detect changes made to the stacks outside of CloudFormation"`
+	List   List   `command:"list" description:"List the environments with a state and the stacks in the AWS account, flagging orphaned stacks"`
+	GC     GC     `command:"gc" description:"Delete the stacks, access keys, Elastic IPs and key pairs left behind by environments that are gone"`
+
+	DeployDirector    DeployDirector    `command:"deploy-director" description:"Deploy the BOSH director with bosh-init, running on the NAT box"`
+	RotateCredentials RotateCredentials `command:"rotate-credentials" description:"Regenerate the director's passwords and AWS access keys"`
+
+	Unlock Unlock `command:"unlock" description:"Decrypt the secrets in the state directory, until lock runs"`
+	Lock   Lock   `command:"lock" description:"Encrypt any plaintext secrets in the state directory"`
+
+	History     History     `command:"history" description:"List past states of the state directory, with --git"`
+	ForceUnlock ForceUnlock `command:"force-unlock" description:"Remove the state lock left by a run that crashed"`
+
+	heldLock heldLock
 }
 
 type Up struct {
 	*CLIOptions `no-flag:"true"`
+	UpOptions
+}
+
+type Plan struct {
+	*CLIOptions `no-flag:"true"`
+	UpOptions
+}
+
+// UpOptions only take effect the first time up runs for an environment
+type UpOptions struct {
+	PrivateDirector   bool `long:"private-director" description:"don't give the BOSH director a public IP.  All access goes through the NAT box."`
+	NATGateway        bool `long:"nat-gateway" description:"route outbound traffic through a managed NAT gateway, and use a separate bastion instance for SSH"`
+	AvailabilityZones int  `long:"availability-zones" description:"number of availability zones to spread the Concourse VMs across, up to 3"`
+	InstanceProfile   bool `long:"instance-profile" description:"give the BOSH director an IAM instance profile, instead of long-lived access keys"`
 }
 
 type Down struct {
 	*CLIOptions `no-flag:"true"`
+
+	Retain []string `long:"retain" description:"when a stack is stuck in DELETE_FAILED, leave these resources behind, by logical ID, e.g. --retain VPC,BOSHSecurityGroup.  Repeatable."`
 }
 
 type Show struct {
@@ -29,6 +75,61 @@ type Show struct {
 	BoshIP          bool `long:"bosh-ip" description:"print the IP address of the BOSH director"`
 	BoshPassword    bool `long:"bosh-password" description:"print the admin password for the BOSH director"`
 	BoshEnvironment bool `long:"bosh-environment" description:"print the BOSH environment variables, suitable for sourcing in bash"`
+	BoshUUID        bool `long:"bosh-uuid" description:"print the UUID of the BOSH director, once it is deployed"`
+	IAMPolicy       bool `long:"iam-policy" description:"print the IAM policy of the BOSH director, as applied to the live stack"`
+
+	JSON    bool `long:"json" description:"print one JSON object with the values selected by the other flags, or with every value in the state directory and the live stack resources"`
+	Secrets bool `long:"secrets" description:"with --json and no other flags, include the secrets"`
+}
+
+type Status struct {
+	*CLIOptions `no-flag:"true"`
+
+	JSON bool `long:"json" description:"print the report as JSON"`
+}
+
+type Drift struct {
+	*CLIOptions `no-flag:"true"`
+
+	ShowFixes bool `long:"show-fixes" description:"also print how to undo each change by hand.  This changes nothing, since re-applying a template can't undo drift"`
+}
+
+type List struct {
+	*CLIOptions `no-flag:"true"`
+}
+
+type GC struct {
+	*CLIOptions `no-flag:"true"`
+
+	Yes bool `long:"yes" description:"delete without asking for confirmation"`
+}
+
+type DeployDirector struct {
+	*CLIOptions `no-flag:"true"`
+}
+
+type RotateCredentials struct {
+	*CLIOptions `no-flag:"true"`
+
+	Only []string `long:"only" description:"only rotate these components, e.g. --only admin,hm.  Repeatable.  Defaults to every component that can rotate live."`
+}
+
+type Unlock struct {
+	*CLIOptions `no-flag:"true"`
+}
+
+type Lock struct {
+	*CLIOptions `no-flag:"true"`
+}
+
+type History struct {
+	*CLIOptions `no-flag:"true"`
+
+	Diff string `long:"diff" description:"show the changes to the state directory since this git revision"`
+}
+
+type ForceUnlock struct {
+	*CLIOptions `no-flag:"true"`
 }
 
 type AWSConfig struct {
@@ -43,8 +144,19 @@ type AWSConfig struct {
 func New() *CLIOptions {
 	base := &CLIOptions{}
 	base.Up.CLIOptions = base
+	base.Plan.CLIOptions = base
 	base.Down.CLIOptions = base
 	base.Show.CLIOptions = base
+	base.Status.CLIOptions = base
+	base.Drift.CLIOptions = base
+	base.List.CLIOptions = base
+	base.GC.CLIOptions = base
+	base.DeployDirector.CLIOptions = base
+	base.RotateCredentials.CLIOptions = base
+	base.Unlock.CLIOptions = base
+	base.Lock.CLIOptions = base
+	base.History.CLIOptions = base
+	base.ForceUnlock.CLIOptions = base
 
 	return base
 }
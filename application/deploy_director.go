@@ -0,0 +1,223 @@
+package application
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/rosenhouse/tubes/lib/director"
+	"golang.org/x/net/context"
+)
+
+// DeployDirector runs bosh-init on the NAT box to deploy the BOSH director,
+// and stores the resulting director-state.json in the state directory.
+// It then checks that the director is up, and stores its UUID and other info.
+func (a *Application) DeployDirector(ctx context.Context, stackName string) error {
+	err := validateStackName(stackName)
+	if err != nil {
+		return err
+	}
+
+	sshKey, err := a.ConfigStore.Get("ssh-key")
+	if err != nil {
+		return err
+	}
+
+	natIP, err := a.ConfigStore.Get("nat-ip")
+	if err != nil {
+		return err
+	}
+
+	manifestYAML, err := a.ConfigStore.Get("director.yml")
+	if err != nil {
+		return err
+	}
+
+	directorState, err := a.getOptional("director-state.json")
+	if err != nil {
+		return err
+	}
+
+	files := map[string][]byte{
+		"director.yml": manifestYAML,
+		"ssh-key":      sshKey,
+	}
+	if directorState != nil {
+		files["director-state.json"] = directorState
+	}
+
+	options, err := a.loadUpOptions(UpOptions{})
+	if err != nil {
+		return err
+	}
+	if options.InstanceProfile {
+		files["bosh-init.env"], err = a.boshInitEnv(stackName)
+		if err != nil {
+			return err
+		}
+	}
+
+	a.Logger.Printf("Deploying BOSH director from the NAT box at %s\n", natIP)
+	output := &logWriter{logger: a.Logger}
+	newDirectorState, deployErr := a.DirectorDeployer.Deploy(ctx, string(natIP), sshKey, a.checkNATHostKey, files, output)
+	output.Flush()
+	if newDirectorState != nil {
+		err = a.ConfigStore.Set("director-state.json", newDirectorState)
+		if err != nil {
+			return err
+		}
+	}
+	if deployErr != nil {
+		return deployErr
+	}
+
+	err = a.discoverDirector()
+	if err != nil {
+		return err
+	}
+
+	a.Logger.Println("Finished")
+	return nil
+}
+
+// boshInitEnv gives bosh-init short-lived credentials for the director's
+// role, so it can create the director VM without the jumpbox having them
+func (a *Application) boshInitEnv(stackName string) ([]byte, error) {
+	resources, err := a.AWSClient.GetBaseStackResources(stackName + "-base")
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := a.AWSClient.AssumeRole(resources.DirectorRoleARN(), "tubes-bosh-init")
+	if err != nil {
+		return nil, fmt.Errorf("assuming the director role for bosh-init: %s", err)
+	}
+	return []byte(fmt.Sprintf("export AWS_ACCESS_KEY_ID='%s'\nexport AWS_SECRET_ACCESS_KEY='%s'\nexport AWS_SESSION_TOKEN='%s'\n",
+		credentials.AccessKey, credentials.SecretKey, credentials.SessionToken)), nil
+}
+
+// natHostKeyKey holds the NAT box's SSH host key, pinned on first connect
+const natHostKeyKey = "nat-host-key"
+
+// checkNATHostKey trusts the NAT box's host key the first time we connect and
+// pins it in the state directory, then insists on that key every time after
+func (a *Application) checkNATHostKey(hostKey []byte) error {
+	pinned, err := a.getOptional(natHostKeyKey)
+	if err != nil {
+		return err
+	}
+	if pinned == nil {
+		a.Logger.Printf("Pinning the NAT box host key %s", hostKey)
+		return a.ConfigStore.Set(natHostKeyKey, hostKey)
+	}
+	if !bytes.Equal(pinned, hostKey) {
+		return fmt.Errorf("NAT box host key does not match %s in the state directory; if the NAT box was replaced, delete that file and run again", natHostKeyKey)
+	}
+	return nil
+}
+
+const (
+	directorCertKey = "director-cert.pem"
+	directorKeyKey  = "director-key.pem"
+)
+
+// directorTarget says how to reach the director, trusting only the
+// certificate in the state directory.  A private director is reached through
+// an SSH tunnel to the NAT box, which stays open until close is called.
+func (a *Application) directorTarget(boshIP string, private bool) (target director.Target, close func(), err error) {
+	target = director.Target{Host: boshIP}
+	target.CACert, err = a.getOptional(directorCertKey)
+	if err != nil {
+		return director.Target{}, nil, err
+	}
+	if !private {
+		return target, func() {}, nil
+	}
+
+	sshKey, err := a.ConfigStore.Get("ssh-key")
+	if err != nil {
+		return director.Target{}, nil, err
+	}
+	natIP, err := a.ConfigStore.Get("nat-ip")
+	if err != nil {
+		return director.Target{}, nil, err
+	}
+	dial, tunnel, err := a.SSHTunneler.Tunnel(string(natIP), sshKey, a.checkNATHostKey)
+	if err != nil {
+		return director.Target{}, nil, fmt.Errorf("opening an SSH tunnel to the NAT box at %s: %s", natIP, err)
+	}
+	target.Dial = dial
+	return target, func() { tunnel.Close() }, nil
+}
+
+func (a *Application) discoverDirector() error {
+	options, err := a.loadUpOptions(UpOptions{})
+	if err != nil {
+		return err
+	}
+
+	boshIP, err := a.ConfigStore.Get("bosh-ip")
+	if err != nil {
+		return err
+	}
+
+	boshPassword, err := a.ConfigStore.Get("bosh-password")
+	if err != nil {
+		return err
+	}
+
+	if options.PrivateDirector {
+		a.Logger.Println("Checking the director through an SSH tunnel to the NAT box")
+	}
+	target, closeTarget, err := a.directorTarget(string(boshIP), options.PrivateDirector)
+	if err != nil {
+		return err
+	}
+	defer closeTarget()
+
+	info, err := a.DirectorClient.Info(target, "admin", string(boshPassword))
+	if err != nil {
+		return fmt.Errorf("director at %s is not healthy: %s", boshIP, err)
+	}
+	if info.UUID == "" {
+		return fmt.Errorf("director at %s did not report a UUID", boshIP)
+	}
+	a.Logger.Printf("Director %q is up: version %s, CPI %s\n", info.Name, info.Version, info.CPI)
+
+	for key, value := range map[string]string{
+		"director-uuid":    info.UUID,
+		"director-name":    info.Name,
+		"director-version": info.Version,
+		"director-cpi":     info.CPI,
+	} {
+		err = a.ConfigStore.Set(key, []byte(value))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// logWriter forwards each complete line written to it to the logger.  Flush
+// forwards whatever is left once the writing is done.
+type logWriter struct {
+	logger  logger
+	partial []byte
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		w.logger.Println(string(w.partial[:i]))
+		w.partial = w.partial[i+1:]
+	}
+}
+
+func (w *logWriter) Flush() {
+	if len(w.partial) > 0 {
+		w.logger.Println(string(w.partial))
+		w.partial = nil
+	}
+}
@@ -0,0 +1,320 @@
+package application_test
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/rosenhouse/tubes/lib/awsclient"
+	"github.com/rosenhouse/tubes/lib/director"
+)
+
+var _ = Describe("DeployDirector", func() {
+	BeforeEach(func() {
+		configStore.Values["ssh-key"] = []byte("some-ssh-key")
+		configStore.Values["nat-ip"] = []byte("some-nat-ip")
+		configStore.Values["director.yml"] = []byte("some-manifest")
+		configStore.Values["bosh-ip"] = []byte("some-bosh-ip")
+		configStore.Values["bosh-password"] = []byte("some-bosh-password")
+		configStore.Values["director-cert.pem"] = []byte("some-director-cert")
+
+		directorDeployer.DeployCall.Returns.State = []byte("some-director-state")
+		directorClient.InfoCall.Returns.Info = director.Info{
+			Name:    "some-director",
+			UUID:    "some-uuid",
+			Version: "some-version",
+			CPI:     "some-cpi",
+		}
+	})
+
+	It("should deploy from the NAT box using the SSH key", func() {
+		Expect(app.DeployDirector(ctx, stackName)).To(Succeed())
+
+		Expect(directorDeployer.DeployCall.Receives.Host).To(Equal("some-nat-ip"))
+		Expect(directorDeployer.DeployCall.Receives.PrivateKey).To(Equal([]byte("some-ssh-key")))
+		Expect(directorDeployer.DeployCall.Receives.Context).To(BeIdenticalTo(ctx))
+	})
+
+	It("should pin the NAT box host key on first connect", func() {
+		directorDeployer.DeployCall.HostKey = []byte("ssh-rsa some-host-key\n")
+
+		Expect(app.DeployDirector(ctx, stackName)).To(Succeed())
+
+		Expect(configStore.Values).To(HaveKeyWithValue("nat-host-key", []byte("ssh-rsa some-host-key\n")))
+		Expect(logBuffer).To(gbytes.Say("Pinning the NAT box host key ssh-rsa some-host-key"))
+	})
+
+	Context("when a NAT box host key is already pinned", func() {
+		BeforeEach(func() {
+			configStore.Values["nat-host-key"] = []byte("ssh-rsa some-host-key\n")
+		})
+
+		It("should accept the same key", func() {
+			directorDeployer.DeployCall.HostKey = []byte("ssh-rsa some-host-key\n")
+
+			Expect(app.DeployDirector(ctx, stackName)).To(Succeed())
+		})
+
+		It("should refuse a different key, before sending anything", func() {
+			directorDeployer.DeployCall.HostKey = []byte("ssh-rsa some-other-host-key\n")
+
+			Expect(app.DeployDirector(ctx, stackName)).To(MatchError(HavePrefix("NAT box host key does not match nat-host-key in the state directory")))
+			Expect(directorDeployer.DeployCall.Receives.Files).To(BeNil())
+			Expect(configStore.Values).To(HaveKeyWithValue("nat-host-key", []byte("ssh-rsa some-host-key\n")))
+		})
+	})
+
+	Context("when pinning the host key fails", func() {
+		It("should return the error", func() {
+			directorDeployer.DeployCall.HostKey = []byte("ssh-rsa some-host-key\n")
+			configStore.Errors["nat-host-key"] = errors.New("some error")
+
+			Expect(app.DeployDirector(ctx, stackName)).To(MatchError("some error"))
+		})
+	})
+
+	It("should send the manifest and the SSH key it refers to", func() {
+		Expect(app.DeployDirector(ctx, stackName)).To(Succeed())
+
+		Expect(directorDeployer.DeployCall.Receives.Files).To(Equal(map[string][]byte{
+			"director.yml": []byte("some-manifest"),
+			"ssh-key":      []byte("some-ssh-key"),
+		}))
+	})
+
+	Context("when the director uses an instance profile", func() {
+		BeforeEach(func() {
+			configStore.Values["up-options.yml"] = []byte("instance_profile: true\n")
+			awsClient.GetBaseStackResourcesCall.Returns.Resources = awsclient.BaseStackResources{
+				AccountID:               "123456789012",
+				DirectorInstanceProfile: "some-instance-profile",
+				DirectorRole:            "some-role",
+			}
+			awsClient.AssumeRoleCall.Returns.Credentials = awsclient.TemporaryCredentials{
+				AccessKey:    "some-access-key",
+				SecretKey:    "some-secret-key",
+				SessionToken: "some-session-token",
+			}
+		})
+
+		It("should send bosh-init short-lived credentials for the director's role", func() {
+			Expect(app.DeployDirector(ctx, stackName)).To(Succeed())
+
+			Expect(awsClient.GetBaseStackResourcesCall.Receives.StackName).To(Equal(stackName + "-base"))
+			Expect(awsClient.AssumeRoleCall.Receives.RoleARN).To(Equal("arn:aws:iam::123456789012:role/some-role"))
+			Expect(awsClient.AssumeRoleCall.Receives.SessionName).To(Equal("tubes-bosh-init"))
+			Expect(directorDeployer.DeployCall.Receives.Files).To(HaveKeyWithValue("bosh-init.env", []byte(
+				"export AWS_ACCESS_KEY_ID='some-access-key'\n"+
+					"export AWS_SECRET_ACCESS_KEY='some-secret-key'\n"+
+					"export AWS_SESSION_TOKEN='some-session-token'\n")))
+		})
+
+		Context("when getting the base stack resources fails", func() {
+			It("should return the error without deploying", func() {
+				awsClient.GetBaseStackResourcesCall.Returns.Error = errors.New("some error")
+
+				Expect(app.DeployDirector(ctx, stackName)).To(MatchError("some error"))
+				Expect(directorDeployer.DeployCall.Receives.Files).To(BeNil())
+			})
+		})
+
+		Context("when assuming the role fails", func() {
+			It("should return an error without deploying", func() {
+				awsClient.AssumeRoleCall.Returns.Error = errors.New("some error")
+
+				Expect(app.DeployDirector(ctx, stackName)).To(MatchError("assuming the director role for bosh-init: some error"))
+				Expect(directorDeployer.DeployCall.Receives.Files).To(BeNil())
+			})
+		})
+	})
+
+	It("should stream the deploy output to the logger, line by line", func() {
+		directorDeployer.DeployCall.Writes = "some output\nmore output\n"
+
+		Expect(app.DeployDirector(ctx, stackName)).To(Succeed())
+
+		Expect(logBuffer).To(gbytes.Say("some output\nmore output\n"))
+		Expect(logBuffer).To(gbytes.Say("Finished"))
+	})
+
+	It("should not drop a last line with no newline", func() {
+		directorDeployer.DeployCall.Writes = "some output\nno newline"
+
+		Expect(app.DeployDirector(ctx, stackName)).To(Succeed())
+
+		Expect(logBuffer).To(gbytes.Say("some output\nno newline\n"))
+		Expect(logBuffer).To(gbytes.Say("Finished"))
+	})
+
+	It("should store the director state", func() {
+		Expect(app.DeployDirector(ctx, stackName)).To(Succeed())
+
+		Expect(configStore.Values).To(HaveKeyWithValue("director-state.json", []byte("some-director-state")))
+	})
+
+	It("should check the director's info, as the admin user, trusting only its stored certificate", func() {
+		Expect(app.DeployDirector(ctx, stackName)).To(Succeed())
+
+		Expect(directorClient.InfoCall.Receives.Target.Host).To(Equal("some-bosh-ip"))
+		Expect(directorClient.InfoCall.Receives.Target.CACert).To(Equal([]byte("some-director-cert")))
+		Expect(directorClient.InfoCall.Receives.Target.Dial).To(BeNil())
+		Expect(sshTunneler.TunnelCallCount).To(Equal(0))
+		Expect(directorClient.InfoCall.Receives.Username).To(Equal("admin"))
+		Expect(directorClient.InfoCall.Receives.Password).To(Equal("some-bosh-password"))
+		Expect(logBuffer).To(gbytes.Say(`Director "some-director" is up: version some-version, CPI some-cpi`))
+	})
+
+	It("should store the director's UUID, name, version and CPI", func() {
+		Expect(app.DeployDirector(ctx, stackName)).To(Succeed())
+
+		Expect(configStore.Values).To(HaveKeyWithValue("director-uuid", []byte("some-uuid")))
+		Expect(configStore.Values).To(HaveKeyWithValue("director-name", []byte("some-director")))
+		Expect(configStore.Values).To(HaveKeyWithValue("director-version", []byte("some-version")))
+		Expect(configStore.Values).To(HaveKeyWithValue("director-cpi", []byte("some-cpi")))
+	})
+
+	Context("when the director is private", func() {
+		BeforeEach(func() {
+			configStore.Values["up-options.yml"] = []byte("private_director: true\n")
+		})
+
+		It("should check it through an SSH tunnel to the NAT box", func() {
+			Expect(app.DeployDirector(ctx, stackName)).To(Succeed())
+
+			Expect(logBuffer).To(gbytes.Say("Checking the director through an SSH tunnel to the NAT box"))
+			Expect(sshTunneler.TunnelCall.Receives.Host).To(Equal("some-nat-ip"))
+			Expect(sshTunneler.TunnelCall.Receives.PrivateKey).To(Equal([]byte("some-ssh-key")))
+			Expect(directorClient.InfoCall.Receives.Target.Host).To(Equal("some-bosh-ip"))
+			Expect(directorClient.InfoCall.Receives.Target.CACert).To(Equal([]byte("some-director-cert")))
+			Expect(directorClient.InfoCall.Receives.Target.Dial).NotTo(BeNil())
+			Expect(configStore.Values).To(HaveKeyWithValue("director-uuid", []byte("some-uuid")))
+			Expect(sshTunneler.Closed).To(BeTrue())
+		})
+
+		It("should insist on the pinned NAT box host key", func() {
+			configStore.Values["nat-host-key"] = []byte("some-host-key")
+			sshTunneler.TunnelCall.HostKey = []byte("some-other-host-key")
+
+			err := app.DeployDirector(ctx, stackName)
+			Expect(err).To(MatchError(ContainSubstring("NAT box host key does not match")))
+			Expect(directorClient.InfoCall.Receives.Target.Host).To(BeEmpty())
+		})
+
+		Context("when the tunnel can't be opened", func() {
+			It("should return an error", func() {
+				sshTunneler.TunnelCall.Returns.Error = errors.New("some error")
+
+				err := app.DeployDirector(ctx, stackName)
+				Expect(err).To(MatchError("opening an SSH tunnel to the NAT box at some-nat-ip: some error"))
+				Expect(directorClient.InfoCall.Receives.Target.Host).To(BeEmpty())
+			})
+		})
+	})
+
+	Context("when the director info cannot be fetched", func() {
+		It("should return an error", func() {
+			directorClient.InfoCall.Returns.Error = errors.New("some error")
+
+			Expect(app.DeployDirector(ctx, stackName)).To(MatchError("director at some-bosh-ip is not healthy: some error"))
+			Expect(configStore.Values).NotTo(HaveKey("director-uuid"))
+		})
+	})
+
+	Context("when the director does not report a UUID", func() {
+		It("should return an error", func() {
+			directorClient.InfoCall.Returns.Info.UUID = ""
+
+			Expect(app.DeployDirector(ctx, stackName)).To(MatchError("director at some-bosh-ip did not report a UUID"))
+		})
+	})
+
+	Context("when storing the director info fails", func() {
+		It("should return the error", func() {
+			configStore.Errors["director-uuid"] = errors.New("some error")
+
+			Expect(app.DeployDirector(ctx, stackName)).To(MatchError("some error"))
+		})
+	})
+
+	Context("when there is already a director state", func() {
+		It("should send it along too", func() {
+			configStore.Values["director-state.json"] = []byte("some-old-director-state")
+
+			Expect(app.DeployDirector(ctx, stackName)).To(Succeed())
+
+			Expect(directorDeployer.DeployCall.Receives.Files).To(HaveKeyWithValue("director-state.json", []byte("some-old-director-state")))
+		})
+	})
+
+	Context("when the name is invalid", func() {
+		It("should immediately error", func() {
+			Expect(app.DeployDirector(ctx, "invalid_name")).To(MatchError(ContainSubstring("invalid name")))
+			Expect(directorDeployer.DeployCall.Receives.Host).To(BeEmpty())
+		})
+	})
+
+	Context("when the deploy fails", func() {
+		BeforeEach(func() {
+			directorDeployer.DeployCall.Returns.Error = errors.New("some error")
+		})
+
+		It("should return the error", func() {
+			Expect(app.DeployDirector(ctx, stackName)).To(MatchError("some error"))
+		})
+
+		It("should still store whatever director state was left behind", func() {
+			app.DeployDirector(ctx, stackName)
+
+			Expect(configStore.Values).To(HaveKeyWithValue("director-state.json", []byte("some-director-state")))
+		})
+
+		It("should not check the director", func() {
+			app.DeployDirector(ctx, stackName)
+
+			Expect(directorClient.InfoCall.Receives.Target.Host).To(BeEmpty())
+		})
+	})
+
+	Context("when there is no director state after the deploy", func() {
+		It("should not store anything", func() {
+			directorDeployer.DeployCall.Returns.State = nil
+
+			Expect(app.DeployDirector(ctx, stackName)).To(Succeed())
+
+			Expect(configStore.Values).NotTo(HaveKey("director-state.json"))
+		})
+	})
+
+	Context("when the config store errors on the director state", func() {
+		It("should return the error", func() {
+			configStore.Errors["director-state.json"] = errors.New("some error")
+
+			Expect(app.DeployDirector(ctx, stackName)).To(MatchError("some error"))
+		})
+	})
+
+	for _, key := range []string{"bosh-ip", "bosh-password", "director-cert.pem"} {
+		key := key
+		Context("when reading "+key+" from the config store fails", func() {
+			It("should return the error", func() {
+				configStore.Errors[key] = errors.New("some error")
+
+				Expect(app.DeployDirector(ctx, stackName)).To(MatchError("some error"))
+				Expect(directorClient.InfoCall.Receives.Target.Host).To(BeEmpty())
+			})
+		})
+	}
+
+	for _, key := range []string{"ssh-key", "nat-ip", "director.yml"} {
+		key := key
+		Context("when reading "+key+" from the config store fails", func() {
+			It("should return the error", func() {
+				configStore.Errors[key] = errors.New("some error")
+
+				Expect(app.DeployDirector(ctx, stackName)).To(MatchError("some error"))
+				Expect(directorDeployer.DeployCall.Receives.Host).To(BeEmpty())
+			})
+		})
+	}
+})
@@ -1,57 +1,193 @@
 package application
 
-import "github.com/rosenhouse/tubes/lib/awsclient"
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rosenhouse/tubes/lib/awsclient"
+	"golang.org/x/net/context"
+)
+
+// Destroy tears down as much of the environment as it can, starting with the
+// VMs that BOSH created, since they keep the stacks from deleting.  It skips
+// stacks that are already gone, carries on past failures and reports them all
+// at the end, so it is safe to run again until everything is gone.  retainResources
+// are logical IDs of resources to leave behind when a stack is stuck in
+// DELETE_FAILED.  The state directory is only cleaned up once nothing failed.
+func (a *Application) Destroy(ctx context.Context, stackName string, retainResources []string) error {
+	failures := []string{}
+	fail := func(step string, err error) {
+		a.Logger.Printf("Failed %s: %s\n", step, err)
+		failures = append(failures, fmt.Sprintf("%s: %s", step, err))
+	}
+	stop := func(err error) error {
+		return downFailed(append(failures, err.Error()))
+	}
 
-func (a *Application) Destroy(stackName string) error {
 	a.Logger.Println("Inspecting stack")
-	resources, err := a.AWSClient.GetBaseStackResources(stackName + "-base")
+	baseStatus, err := a.AWSClient.StackStatus(stackName + "-base")
 	if err != nil {
-		return err
+		fail("inspecting the base stack", err)
+	} else if baseStatus == "" {
+		a.Logger.Println("Base stack is already gone")
+	} else {
+		if err := a.step(ctx, "deleting the BOSH VMs"); err != nil {
+			return stop(err)
+		}
+		resources, err := a.AWSClient.GetBaseStackResources(stackName + "-base")
+		if err != nil {
+			fail("inspecting the base stack resources", err)
+		} else {
+			a.deleteBOSHVMs(ctx, stackName, resources.VPCID, fail)
+			a.deleteAccessKeys(resources.BOSHUser, fail)
+		}
 	}
 
-	a.Logger.Println("Inspecting user")
-	accessKeys, err := a.AWSClient.ListAccessKeys(resources.BOSHUser)
+	concourseGone := false
+	concourseStatus, err := a.AWSClient.StackStatus(stackName + "-concourse")
 	if err != nil {
-		return err
+		fail("inspecting the Concourse stack", err)
+	} else if concourseStatus == "" {
+		a.Logger.Println("Concourse stack is already gone")
+		concourseGone = true
+	} else {
+		if err := a.step(ctx, "deleting the Concourse stack"); err != nil {
+			return stop(err)
+		}
+		a.Logger.Println("Deleting Concourse stack")
+		err = a.deleteStack(ctx, stackName+"-concourse", concourseStatus, retainResources)
+		if err != nil {
+			fail("deleting the Concourse stack", err)
+		} else {
+			concourseGone = true
+		}
 	}
 
-	a.Logger.Println("Deleting access keys")
-	for _, accessKey := range accessKeys {
-		err = a.AWSClient.DeleteAccessKey(resources.BOSHUser, accessKey)
+	if baseStatus != "" {
+		if concourseGone {
+			if err := a.step(ctx, "deleting the base stack"); err != nil {
+				return stop(err)
+			}
+			a.Logger.Println("Deleting base stack")
+			err = a.deleteStack(ctx, stackName+"-base", baseStatus, retainResources)
+			if err != nil {
+				fail("deleting the base stack", err)
+			}
+		} else {
+			fail("deleting the base stack", fmt.Errorf("the Concourse stack, which uses its VPC, is still there"))
+		}
+	}
+
+	if err := a.step(ctx, "deleting the keypair"); err != nil {
+		return stop(err)
+	}
+	a.Logger.Printf("Deleting keypair...")
+	err = a.AWSClient.DeleteKeyPair(stackName)
+	if err != nil {
+		fail("deleting the keypair", err)
+	}
+
+	if len(failures) > 0 {
+		return downFailed(failures)
+	}
+
+	a.Logger.Println("Cleaning up the state directory")
+	keys, err := a.ConfigStore.List()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		err = a.ConfigStore.Delete(key)
 		if err != nil {
 			return err
 		}
 	}
 
-	a.Logger.Println("Deleting Concourse stack")
-	err = a.AWSClient.DeleteStack(stackName + "-concourse")
+	a.Logger.Println("Finished")
+	return nil
+}
+
+func downFailed(failures []string) error {
+	return fmt.Errorf("down left the environment partly in place, keeping the state directory.  Run it again once these are fixed:\n  %s",
+		strings.Join(failures, "\n  "))
+}
+
+// deleteBOSHVMs terminates the director and the VMs it deployed, which the
+// stacks know nothing about but which keep the VPC from deleting.  The
+// director is named after the stack.
+func (a *Application) deleteBOSHVMs(ctx context.Context, stackName, vpcID string, fail func(string, error)) {
+	a.Logger.Println("Looking for VMs that BOSH created")
+	vms, err := a.AWSClient.ListBOSHVMs(vpcID, stackName)
 	if err != nil {
-		return err
+		fail("finding the BOSH VMs", err)
+		return
+	}
+
+	if len(vms.InstanceIDs)+len(vms.VolumeIDs)+len(vms.NetworkInterfaceIDs) == 0 {
+		a.Logger.Println("No BOSH VMs are running")
+		return
 	}
 
-	err = a.AWSClient.WaitForStack(stackName+"-concourse", awsclient.CloudFormationDeletePundit{})
+	a.Logger.Printf("Terminating %d BOSH VMs, then deleting %d volumes and %d network interfaces they leave behind\n",
+		len(vms.InstanceIDs), len(vms.VolumeIDs), len(vms.NetworkInterfaceIDs))
+	err = a.AWSClient.DeleteBOSHVMs(ctx, vms)
 	if err != nil {
-		return err
+		fail("deleting the BOSH VMs", err)
 	}
-	a.Logger.Printf("Delete complete")
+}
 
-	a.Logger.Println("Deleting base stack")
-	err = a.AWSClient.DeleteStack(stackName + "-base")
+// deleteAccessKeys deletes every access key of the BOSH user, since IAM won't
+// delete a user that still has some
+func (a *Application) deleteAccessKeys(userName string, fail func(string, error)) {
+	if userName == "" {
+		a.Logger.Println("Director uses an instance profile, so there are no access keys to delete")
+		return
+	}
+
+	a.Logger.Println("Inspecting user")
+	accessKeys, err := a.AWSClient.ListAccessKeys(userName)
 	if err != nil {
-		return err
+		fail("listing the access keys", err)
+		return
 	}
 
-	err = a.AWSClient.WaitForStack(stackName+"-base", awsclient.CloudFormationDeletePundit{})
+	a.Logger.Println("Deleting access keys")
+	for _, accessKey := range accessKeys {
+		err = a.AWSClient.DeleteAccessKey(userName, accessKey)
+		if err != nil {
+			fail("deleting access key "+accessKey, err)
+		}
+	}
+}
+
+// deleteStack deletes the stack and waits until it is gone.  Resources to
+// retain only apply once a delete has failed, and only those in the stack.
+func (a *Application) deleteStack(ctx context.Context, stackName, status string, retainResources []string) error {
+	var retain []string
+	if status == "DELETE_FAILED" && len(retainResources) > 0 {
+		resources, err := a.AWSClient.GetStackResources(stackName)
+		if err != nil {
+			return err
+		}
+		for _, logicalID := range retainResources {
+			if _, ok := resources[logicalID]; ok {
+				retain = append(retain, logicalID)
+			}
+		}
+		if len(retain) > 0 {
+			a.Logger.Printf("Retaining %s\n", strings.Join(retain, ", "))
+		}
+	}
+
+	err := a.AWSClient.DeleteStack(stackName, retain...)
 	if err != nil {
 		return err
 	}
-	a.Logger.Printf("Delete complete")
-	a.Logger.Printf("Deleting keypair...")
-	err = a.AWSClient.DeleteKeyPair(stackName)
+
+	err = a.AWSClient.WaitForStack(ctx, stackName, awsclient.CloudFormationDeletePundit{})
 	if err != nil {
 		return err
 	}
-
-	a.Logger.Println("Finished")
+	a.Logger.Printf("Delete complete")
 	return nil
 }
@@ -12,17 +12,69 @@ import (
 )
 
 var _ = Describe("Destroy", func() {
+	BeforeEach(func() {
+		awsClient.GetBaseStackResourcesCall.Returns.Resources.BOSHUser = "some-iam-user"
+		awsClient.GetBaseStackResourcesCall.Returns.Resources.VPCID = "some-vpc-id"
+		awsClient.StackStatusCalls = make([]mocks.StackStatusCall, 2)
+		awsClient.StackStatusCalls[0].Returns.Status = "CREATE_COMPLETE"
+		awsClient.StackStatusCalls[1].Returns.Status = "UPDATE_COMPLETE"
+		configStore.Values["ssh-key"] = []byte("some-key")
+	})
+
+	It("should check which stacks exist", func() {
+		Expect(app.Destroy(ctx, stackName, nil)).To(Succeed())
+
+		Expect(awsClient.StackStatusCalls[0].Receives.StackName).To(Equal(stackName + "-base"))
+		Expect(awsClient.StackStatusCalls[1].Receives.StackName).To(Equal(stackName + "-concourse"))
+	})
+
 	It("should get the stack resources to discover the BOSH user", func() {
-		Expect(app.Destroy(stackName)).To(Succeed())
+		Expect(app.Destroy(ctx, stackName, nil)).To(Succeed())
 
 		Expect(awsClient.GetBaseStackResourcesCall.Receives.StackName).To(Equal(stackName + "-base"))
 	})
 
+	It("should terminate the VMs that BOSH created in the VPC", func() {
+		awsClient.ListBOSHVMsCall.Returns.VMs = awsclient.BOSHVMs{
+			InstanceIDs:         []string{"i-director", "i-web"},
+			VolumeIDs:           []string{"vol-persistent"},
+			NetworkInterfaceIDs: []string{},
+		}
+
+		Expect(app.Destroy(ctx, stackName, nil)).To(Succeed())
+
+		Expect(awsClient.ListBOSHVMsCall.Receives.VPCID).To(Equal("some-vpc-id"))
+		Expect(awsClient.ListBOSHVMsCall.Receives.DirectorName).To(Equal(stackName))
+		Expect(awsClient.DeleteBOSHVMsCall.Receives.VMs).To(Equal(awsClient.ListBOSHVMsCall.Returns.VMs))
+		Expect(awsClient.DeleteBOSHVMsCall.Receives.Context).To(BeIdenticalTo(ctx))
+		Expect(logBuffer).To(gbytes.Say("Terminating 2 BOSH VMs, then deleting 1 volumes and 0 network interfaces they leave behind"))
+		Expect(logBuffer).To(gbytes.Say("Deleting Concourse stack"))
+	})
+
+	It("should delete the volumes and network interfaces left detached, even with no BOSH VMs running", func() {
+		awsClient.ListBOSHVMsCall.Returns.VMs = awsclient.BOSHVMs{
+			VolumeIDs:           []string{"vol-detached"},
+			NetworkInterfaceIDs: []string{"eni-detached"},
+		}
+
+		Expect(app.Destroy(ctx, stackName, nil)).To(Succeed())
+
+		Expect(awsClient.DeleteBOSHVMsCall.Receives.VMs).To(Equal(awsClient.ListBOSHVMsCall.Returns.VMs))
+		Expect(logBuffer).To(gbytes.Say("Terminating 0 BOSH VMs, then deleting 1 volumes and 1 network interfaces they leave behind"))
+	})
+
+	It("should skip terminating when no BOSH VMs are running", func() {
+		Expect(app.Destroy(ctx, stackName, nil)).To(Succeed())
+
+		Expect(logBuffer).To(gbytes.Say("No BOSH VMs are running"))
+		Expect(awsClient.DeleteBOSHVMsCall.Receives.VMs.InstanceIDs).To(BeNil())
+	})
+
 	It("should delete the user's access keys", func() {
 		awsClient.GetBaseStackResourcesCall.Returns.Resources.BOSHUser = "some-iam-user"
 		awsClient.ListAccessKeysCall.Returns.AccessKeys = []string{"some-access-key"}
 
-		Expect(app.Destroy(stackName)).To(Succeed())
+		Expect(app.Destroy(ctx, stackName, nil)).To(Succeed())
 
 		Expect(awsClient.ListAccessKeysCall.Receives.UserName).To(Equal("some-iam-user"))
 		Expect(awsClient.DeleteAccessKeyCall.Receives.UserName).To(Equal("some-iam-user"))
@@ -30,7 +82,7 @@ var _ = Describe("Destroy", func() {
 	})
 
 	It("should delete the Concourse stack and the base stack", func() {
-		Expect(app.Destroy(stackName)).To(Succeed())
+		Expect(app.Destroy(ctx, stackName, nil)).To(Succeed())
 
 		Expect(logBuffer).To(gbytes.Say("Inspecting stack"))
 		Expect(logBuffer).To(gbytes.Say("Inspecting user"))
@@ -45,84 +97,271 @@ var _ = Describe("Destroy", func() {
 		Expect(logBuffer).To(gbytes.Say("Finished"))
 	})
 	It("should wait for the Concourse stack to be fully deleted", func() {
-		Expect(app.Destroy(stackName)).To(Succeed())
+		Expect(app.Destroy(ctx, stackName, nil)).To(Succeed())
 
 		Expect(awsClient.WaitForStackCalls[0].Receives.StackName).To(Equal(stackName + "-concourse"))
 		Expect(awsClient.WaitForStackCalls[0].Receives.Pundit).To(Equal(awsclient.CloudFormationDeletePundit{}))
 	})
 
 	It("should wait for the base stack to be fully deleted", func() {
-		Expect(app.Destroy(stackName)).To(Succeed())
+		Expect(app.Destroy(ctx, stackName, nil)).To(Succeed())
 
 		Expect(awsClient.WaitForStackCalls[1].Receives.StackName).To(Equal(stackName + "-base"))
 		Expect(awsClient.WaitForStackCalls[1].Receives.Pundit).To(Equal(awsclient.CloudFormationDeletePundit{}))
 	})
 
 	It("should delete the ssh keypair", func() {
-		Expect(app.Destroy(stackName)).To(Succeed())
+		Expect(app.Destroy(ctx, stackName, nil)).To(Succeed())
 
 		Expect(awsClient.DeleteKeyPairCall.Receives.StackName).To(Equal(stackName))
 	})
 
-	Context("when inspecting the stack fails", func() {
-		It("should immediately return the error", func() {
-			awsClient.GetBaseStackResourcesCall.Returns.Error = errors.New("some error")
+	It("should clean up the state directory, once the resources are gone", func() {
+		configStore.Values["director.yml"] = []byte("some-manifest")
 
-			Expect(app.Destroy(stackName)).To(MatchError("some error"))
-		})
+		Expect(app.Destroy(ctx, stackName, nil)).To(Succeed())
+
+		Expect(logBuffer).To(gbytes.Say("Deleting keypair"))
+		Expect(logBuffer).To(gbytes.Say("Cleaning up the state directory"))
+		Expect(configStore.Values).To(BeEmpty())
 	})
 
-	Context("when getting the user's access keys fails", func() {
-		It("should immediately return the error", func() {
-			awsClient.ListAccessKeysCall.Returns.Error = errors.New("some error")
+	Context("when the director uses an instance profile instead of a user", func() {
+		It("should have no access keys to delete", func() {
+			awsClient.GetBaseStackResourcesCall.Returns.Resources.BOSHUser = ""
+			awsClient.GetBaseStackResourcesCall.Returns.Resources.DirectorInstanceProfile = "some-instance-profile"
+
+			Expect(app.Destroy(ctx, stackName, nil)).To(Succeed())
 
-			Expect(app.Destroy(stackName)).To(MatchError("some error"))
+			Expect(awsClient.ListAccessKeysCall.Receives.UserName).To(BeEmpty())
+			Expect(awsClient.DeleteAccessKeyCall.Receives.UserName).To(BeEmpty())
+			Expect(logBuffer).To(gbytes.Say("no access keys to delete"))
+			Expect(awsClient.DeleteStackCalls[1].Receives.StackName).To(Equal(stackName + "-base"))
 		})
 	})
 
-	Context("when deleting the user's access keys fails", func() {
-		It("should immediately return the error", func() {
-			awsClient.ListAccessKeysCall.Returns.AccessKeys = []string{"some-key"}
-			awsClient.DeleteAccessKeyCall.Returns.Error = errors.New("some error")
+	Describe("partial environments", func() {
+		Context("when both stacks are already gone", func() {
+			BeforeEach(func() {
+				awsClient.StackStatusCalls[0].Returns.Status = ""
+				awsClient.StackStatusCalls[1].Returns.Status = ""
+			})
 
-			Expect(app.Destroy(stackName)).To(MatchError("some error"))
+			It("should still delete the keypair and clean up the state directory", func() {
+				Expect(app.Destroy(ctx, stackName, nil)).To(Succeed())
+
+				Expect(logBuffer).To(gbytes.Say("Base stack is already gone"))
+				Expect(logBuffer).To(gbytes.Say("Concourse stack is already gone"))
+				Expect(awsClient.GetBaseStackResourcesCall.Receives.StackName).To(BeEmpty())
+				Expect(awsClient.DeleteStackCallCount).To(Equal(0))
+				Expect(awsClient.DeleteKeyPairCall.Receives.StackName).To(Equal(stackName))
+				Expect(configStore.Values).To(BeEmpty())
+			})
 		})
-	})
 
-	Context("when deleting the Concourse stack errors", func() {
-		It("should immediately return the error", func() {
-			awsClient.DeleteStackCalls = make([]mocks.DeleteStackCall, 1)
-			awsClient.DeleteStackCalls[0].Returns.Error = errors.New("some error")
+		Context("when the Concourse stack was never created", func() {
+			It("should delete the base stack", func() {
+				awsClient.StackStatusCalls[1].Returns.Status = ""
 
-			Expect(app.Destroy(stackName)).To(MatchError("some error"))
-			Expect(awsClient.WaitForStackCalls).To(BeEmpty())
+				Expect(app.Destroy(ctx, stackName, nil)).To(Succeed())
+
+				Expect(awsClient.DeleteStackCalls).To(HaveLen(1))
+				Expect(awsClient.DeleteStackCalls[0].Receives.StackName).To(Equal(stackName + "-base"))
+			})
 		})
-	})
 
-	Context("when deleting the base stack errors", func() {
-		It("should immediately return the error", func() {
-			awsClient.DeleteStackCalls = make([]mocks.DeleteStackCall, 2)
-			awsClient.DeleteStackCalls[1].Returns.Error = errors.New("some error")
+		Context("when the base stack is already gone", func() {
+			It("should delete the Concourse stack, without looking for access keys", func() {
+				awsClient.StackStatusCalls[0].Returns.Status = ""
+
+				Expect(app.Destroy(ctx, stackName, nil)).To(Succeed())
 
-			Expect(app.Destroy(stackName)).To(MatchError("some error"))
-			Expect(awsClient.WaitForStackCalls).To(HaveLen(1))
+				Expect(awsClient.GetBaseStackResourcesCall.Receives.StackName).To(BeEmpty())
+				Expect(awsClient.ListBOSHVMsCall.Receives.VPCID).To(BeEmpty())
+				Expect(awsClient.DeleteStackCalls).To(HaveLen(1))
+				Expect(awsClient.DeleteStackCalls[0].Receives.StackName).To(Equal(stackName + "-concourse"))
+			})
 		})
 	})
 
-	Context("when waiting for the base stack errors", func() {
-		It("should return the error", func() {
-			awsClient.WaitForStackCalls = make([]mocks.WaitForStackCall, 1)
-			awsClient.WaitForStackCalls[0].Returns.Error = errors.New("some error")
+	Describe("stacks stuck in DELETE_FAILED", func() {
+		BeforeEach(func() {
+			awsClient.StackStatusCalls[0].Returns.Status = "DELETE_FAILED"
+			awsClient.GetStackResourcesCalls = make([]mocks.GetStackResourcesCall, 1)
+			awsClient.GetStackResourcesCalls[0].Returns.Resources = map[string]string{
+				"VPC":              "some-vpc-id",
+				"BOSHDirectorUser": "some-iam-user",
+			}
+		})
+
+		It("should retain the chosen resources that are in the stack", func() {
+			Expect(app.Destroy(ctx, stackName, []string{"VPC", "LoadBalancer"})).To(Succeed())
+
+			Expect(awsClient.GetStackResourcesCalls[0].Receives.StackName).To(Equal(stackName + "-base"))
+			Expect(awsClient.DeleteStackCalls[1].Receives.StackName).To(Equal(stackName + "-base"))
+			Expect(awsClient.DeleteStackCalls[1].Receives.RetainResources).To(Equal([]string{"VPC"}))
+			Expect(logBuffer).To(gbytes.Say("Retaining VPC"))
+		})
+
+		It("should not retain anything from stacks that haven't failed to delete", func() {
+			Expect(app.Destroy(ctx, stackName, []string{"VPC", "LoadBalancer"})).To(Succeed())
+
+			Expect(awsClient.GetStackResourcesCallCount).To(Equal(1))
+			Expect(awsClient.DeleteStackCalls[0].Receives.RetainResources).To(BeEmpty())
+		})
+
+		It("should retry the delete without retaining anything, unless asked", func() {
+			Expect(app.Destroy(ctx, stackName, nil)).To(Succeed())
+
+			Expect(awsClient.GetStackResourcesCallCount).To(Equal(0))
+			Expect(awsClient.DeleteStackCalls[1].Receives.RetainResources).To(BeEmpty())
+		})
+
+		Context("when getting the stack resources fails", func() {
+			It("should report it", func() {
+				awsClient.GetStackResourcesCalls[0].Returns.Error = errors.New("some error")
 
-			Expect(app.Destroy(stackName)).To(MatchError("some error"))
+				Expect(app.Destroy(ctx, stackName, []string{"VPC"})).To(MatchError(ContainSubstring("deleting the base stack: some error")))
+				Expect(awsClient.DeleteStackCalls).To(HaveLen(1))
+			})
 		})
 	})
 
-	Context("when deleting a keypair fails", func() {
-		It("should immediately return the error", func() {
-			awsClient.DeleteKeyPairCall.Returns.Error = errors.New("some error")
+	Describe("failures", func() {
+		It("should report every failure at the end, and keep the state directory", func() {
+			awsClient.ListAccessKeysCall.Returns.Error = errors.New("some error")
+			awsClient.DeleteKeyPairCall.Returns.Error = errors.New("other error")
+
+			err := app.Destroy(ctx, stackName, nil)
+			Expect(err).To(MatchError(ContainSubstring("listing the access keys: some error")))
+			Expect(err).To(MatchError(ContainSubstring("deleting the keypair: other error")))
+			Expect(err).To(MatchError(ContainSubstring("keeping the state directory")))
+
+			Expect(logBuffer).To(gbytes.Say("Failed listing the access keys: some error"))
+			Expect(configStore.Values).To(HaveKey("ssh-key"))
+			Expect(logBuffer).NotTo(gbytes.Say("Finished"))
+		})
+
+		Context("when inspecting the base stack fails", func() {
+			It("should carry on with the Concourse stack and the keypair", func() {
+				awsClient.StackStatusCalls[0].Returns.Error = errors.New("some error")
+
+				Expect(app.Destroy(ctx, stackName, nil)).To(MatchError(ContainSubstring("inspecting the base stack: some error")))
+				Expect(awsClient.DeleteStackCalls).To(HaveLen(1))
+				Expect(awsClient.DeleteStackCalls[0].Receives.StackName).To(Equal(stackName + "-concourse"))
+				Expect(awsClient.DeleteKeyPairCall.Receives.StackName).To(Equal(stackName))
+			})
+		})
+
+		Context("when inspecting the Concourse stack fails", func() {
+			It("should not delete the base stack", func() {
+				awsClient.StackStatusCalls[1].Returns.Error = errors.New("some error")
+
+				err := app.Destroy(ctx, stackName, nil)
+				Expect(err).To(MatchError(ContainSubstring("inspecting the Concourse stack: some error")))
+				Expect(err).To(MatchError(ContainSubstring("the Concourse stack, which uses its VPC, is still there")))
+				Expect(awsClient.DeleteStackCallCount).To(Equal(0))
+			})
+		})
+
+		Context("when getting the base stack resources fails", func() {
+			It("should still delete both stacks", func() {
+				awsClient.GetBaseStackResourcesCall.Returns.Error = errors.New("some error")
+
+				Expect(app.Destroy(ctx, stackName, nil)).To(MatchError(ContainSubstring("inspecting the base stack resources: some error")))
+				Expect(awsClient.DeleteStackCalls).To(HaveLen(2))
+			})
+		})
+
+		Context("when finding the BOSH VMs fails", func() {
+			It("should carry on with the access keys and the stacks", func() {
+				awsClient.ListBOSHVMsCall.Returns.Error = errors.New("some error")
+
+				Expect(app.Destroy(ctx, stackName, nil)).To(MatchError(ContainSubstring("finding the BOSH VMs: some error")))
+				Expect(awsClient.ListAccessKeysCall.Receives.UserName).To(Equal("some-iam-user"))
+				Expect(awsClient.DeleteStackCalls).To(HaveLen(2))
+			})
+		})
+
+		Context("when deleting the BOSH VMs fails", func() {
+			It("should report it and keep the state directory", func() {
+				awsClient.ListBOSHVMsCall.Returns.VMs.InstanceIDs = []string{"i-director"}
+				awsClient.DeleteBOSHVMsCall.Returns.Error = errors.New("some error")
+
+				Expect(app.Destroy(ctx, stackName, nil)).To(MatchError(ContainSubstring("deleting the BOSH VMs: some error")))
+				Expect(configStore.Values).To(HaveKey("ssh-key"))
+			})
+		})
+
+		Context("when deleting one of the access keys fails", func() {
+			It("should carry on with the rest", func() {
+				awsClient.ListAccessKeysCall.Returns.AccessKeys = []string{"some-key", "other-key"}
+				awsClient.DeleteAccessKeyCall.Returns.Error = errors.New("some error")
+
+				err := app.Destroy(ctx, stackName, nil)
+				Expect(err).To(MatchError(ContainSubstring("deleting access key some-key: some error")))
+				Expect(err).To(MatchError(ContainSubstring("deleting access key other-key: some error")))
+				Expect(awsClient.DeleteStackCalls).To(HaveLen(2))
+			})
+		})
+
+		Context("when deleting the Concourse stack errors", func() {
+			It("should not wait for it, or delete the base stack, but still delete the keypair", func() {
+				awsClient.DeleteStackCalls = make([]mocks.DeleteStackCall, 1)
+				awsClient.DeleteStackCalls[0].Returns.Error = errors.New("some error")
+
+				err := app.Destroy(ctx, stackName, nil)
+				Expect(err).To(MatchError(ContainSubstring("deleting the Concourse stack: some error")))
+				Expect(err).To(MatchError(ContainSubstring("deleting the base stack: the Concourse stack, which uses its VPC, is still there")))
+				Expect(awsClient.WaitForStackCalls).To(BeEmpty())
+				Expect(awsClient.DeleteStackCallCount).To(Equal(1))
+				Expect(awsClient.DeleteKeyPairCall.Receives.StackName).To(Equal(stackName))
+			})
+		})
+
+		Context("when waiting for the Concourse stack errors", func() {
+			It("should report it", func() {
+				awsClient.WaitForStackCalls = make([]mocks.WaitForStackCall, 1)
+				awsClient.WaitForStackCalls[0].Returns.Error = errors.New("some error")
+
+				Expect(app.Destroy(ctx, stackName, nil)).To(MatchError(ContainSubstring("deleting the Concourse stack: some error")))
+				Expect(awsClient.DeleteStackCallCount).To(Equal(1))
+			})
+		})
+
+		Context("when deleting the base stack errors", func() {
+			It("should still delete the keypair", func() {
+				awsClient.DeleteStackCalls = make([]mocks.DeleteStackCall, 2)
+				awsClient.DeleteStackCalls[1].Returns.Error = errors.New("some error")
+
+				Expect(app.Destroy(ctx, stackName, nil)).To(MatchError(ContainSubstring("deleting the base stack: some error")))
+				Expect(awsClient.WaitForStackCalls).To(HaveLen(1))
+				Expect(awsClient.DeleteKeyPairCall.Receives.StackName).To(Equal(stackName))
+			})
+		})
+
+		Context("when deleting the keypair fails", func() {
+			It("should report it", func() {
+				awsClient.DeleteKeyPairCall.Returns.Error = errors.New("some error")
+
+				Expect(app.Destroy(ctx, stackName, nil)).To(MatchError(ContainSubstring("deleting the keypair: some error")))
+			})
+		})
+
+		Context("when listing the state directory fails", func() {
+			It("should return the error", func() {
+				configStore.ListError = errors.New("some error")
+
+				Expect(app.Destroy(ctx, stackName, nil)).To(MatchError("some error"))
+			})
+		})
+
+		Context("when deleting from the state directory fails", func() {
+			It("should return the error", func() {
+				configStore.Errors["ssh-key"] = errors.New("some error")
 
-			Expect(app.Destroy(stackName)).To(MatchError("some error"))
+				Expect(app.Destroy(ctx, stackName, nil)).To(MatchError("some error"))
+			})
 		})
 	})
 })
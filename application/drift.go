@@ -0,0 +1,117 @@
+package application
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/rosenhouse/tubes/lib/awsclient"
+	"golang.org/x/net/context"
+)
+
+// Drift runs CloudFormation drift detection on both stacks, and prints every
+// resource that was changed outside of CloudFormation.  With showFixes, it
+// also prints how to undo each change by hand: re-applying an unchanged
+// template is a no-op for CloudFormation, so it can't undo drift.  It errors
+// if anything has drifted.
+func (a *Application) Drift(ctx context.Context, stackName string, showFixes bool) error {
+	err := validateStackName(stackName)
+	if err != nil {
+		return err
+	}
+
+	drifts, err := a.detectDrift(ctx, stackName)
+	if err != nil {
+		return err
+	}
+	err = writeDrifts(a.ResultWriter, drifts)
+	if err != nil {
+		return err
+	}
+	if !hasDrift(drifts) {
+		return nil
+	}
+	if !showFixes {
+		return fmt.Errorf("the stacks have drifted from their templates")
+	}
+
+	err = writeFixes(a.ResultWriter, drifts)
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("the stacks have drifted from their templates, so fix them by hand")
+}
+
+func (a *Application) detectDrift(ctx context.Context, stackName string) ([]awsclient.StackDrift, error) {
+	drifts := []awsclient.StackDrift{}
+	for _, suffix := range []string{"base", "concourse"} {
+		a.Logger.Printf("Detecting drift of %s stack\n", suffix)
+		drift, err := a.AWSClient.DetectStackDrift(ctx, stackName+"-"+suffix)
+		if err != nil {
+			return nil, err
+		}
+		drifts = append(drifts, drift)
+	}
+	return drifts, nil
+}
+
+func hasDrift(drifts []awsclient.StackDrift) bool {
+	for _, drift := range drifts {
+		if drift.Status == "DRIFTED" {
+			return true
+		}
+	}
+	return false
+}
+
+func writeDrifts(w io.Writer, drifts []awsclient.StackDrift) error {
+	lines := []string{}
+	for _, drift := range drifts {
+		lines = append(lines, fmt.Sprintf("%s: %s", drift.StackName, drift.Status))
+		for _, resource := range drift.Resources {
+			lines = append(lines, fmt.Sprintf("  %-8s %s (%s) %s", resource.Status, resource.LogicalID, resource.ResourceType, resource.PhysicalID))
+			for _, difference := range resource.Differences {
+				lines = append(lines, fmt.Sprintf("    %-9s %s: expected %s, actual %s", difference.DifferenceType, difference.PropertyPath, difference.ExpectedValue, difference.ActualValue))
+			}
+		}
+	}
+
+	for _, line := range lines {
+		_, err := fmt.Fprintln(w, line)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFixes prints how to undo each drifted property by hand
+func writeFixes(w io.Writer, drifts []awsclient.StackDrift) error {
+	lines := []string{"To fix by hand:"}
+	for _, drift := range drifts {
+		for _, resource := range drift.Resources {
+			name := fmt.Sprintf("%s (%s)", resource.LogicalID, resource.PhysicalID)
+			if resource.Status == "DELETED" {
+				lines = append(lines, fmt.Sprintf("  %s of %s was deleted: run down and up to recreate it", resource.LogicalID, drift.StackName))
+				continue
+			}
+			for _, difference := range resource.Differences {
+				switch difference.DifferenceType {
+				case "ADD":
+					lines = append(lines, fmt.Sprintf("  remove %s from %s", difference.PropertyPath, name))
+				case "REMOVE":
+					lines = append(lines, fmt.Sprintf("  add %s back to %s, as %s", difference.PropertyPath, name, difference.ExpectedValue))
+				default:
+					lines = append(lines, fmt.Sprintf("  set %s of %s back to %s", difference.PropertyPath, name, difference.ExpectedValue))
+				}
+			}
+		}
+	}
+
+	for _, line := range lines {
+		_, err := fmt.Fprintln(w, line)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
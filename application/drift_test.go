@@ -0,0 +1,157 @@
+package application_test
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/rosenhouse/tubes/lib/awsclient"
+	"github.com/rosenhouse/tubes/mocks"
+)
+
+var _ = Describe("Drift", func() {
+	var modifiedGroup, deletedELB awsclient.ResourceDrift
+
+	BeforeEach(func() {
+		modifiedGroup = awsclient.ResourceDrift{
+			LogicalID:    "BOSHSecurityGroup",
+			PhysicalID:   "sg-1234",
+			ResourceType: "AWS::EC2::SecurityGroup",
+			Status:       "MODIFIED",
+			Differences: []awsclient.PropertyDifference{{
+				PropertyPath:   "/SecurityGroupIngress/2",
+				ExpectedValue:  "null",
+				ActualValue:    `{"CidrIp":"0.0.0.0/0"}`,
+				DifferenceType: "ADD",
+			}},
+		}
+		deletedELB = awsclient.ResourceDrift{
+			LogicalID:    "ConcourseELB",
+			PhysicalID:   "some-elb",
+			ResourceType: "AWS::ElasticLoadBalancing::LoadBalancer",
+			Status:       "DELETED",
+		}
+
+		awsClient.DetectStackDriftCalls = make([]mocks.DetectStackDriftCall, 4)
+		awsClient.DetectStackDriftCalls[0].Returns.Drift = awsclient.StackDrift{
+			StackName: stackName + "-base",
+			Status:    "DRIFTED",
+			Resources: []awsclient.ResourceDrift{modifiedGroup},
+		}
+		awsClient.DetectStackDriftCalls[1].Returns.Drift = awsclient.StackDrift{
+			StackName: stackName + "-concourse",
+			Status:    "IN_SYNC",
+		}
+		awsClient.DetectStackDriftCalls[2].Returns.Drift = awsclient.StackDrift{
+			StackName: stackName + "-base",
+			Status:    "IN_SYNC",
+		}
+		awsClient.DetectStackDriftCalls[3].Returns.Drift = awsclient.StackDrift{
+			StackName: stackName + "-concourse",
+			Status:    "IN_SYNC",
+		}
+	})
+
+	It("should detect drift on both stacks", func() {
+		app.Drift(ctx, stackName, false)
+
+		Expect(awsClient.DetectStackDriftCallCount).To(Equal(2))
+		Expect(awsClient.DetectStackDriftCalls[0].Receives.StackName).To(Equal(stackName + "-base"))
+		Expect(awsClient.DetectStackDriftCalls[1].Receives.StackName).To(Equal(stackName + "-concourse"))
+	})
+
+	It("should print each drifted resource with its property differences, and return an error", func() {
+		Expect(app.Drift(ctx, stackName, false)).To(MatchError("the stacks have drifted from their templates"))
+
+		Expect(resultBuffer).To(gbytes.Say(stackName + `-base: DRIFTED\n`))
+		Expect(resultBuffer).To(gbytes.Say(`  MODIFIED BOSHSecurityGroup \(AWS::EC2::SecurityGroup\) sg-1234\n`))
+		Expect(resultBuffer).To(gbytes.Say(`    ADD\s+/SecurityGroupIngress/2: expected null, actual {"CidrIp":"0.0.0.0/0"}\n`))
+		Expect(resultBuffer).To(gbytes.Say(stackName + `-concourse: IN_SYNC\n`))
+	})
+
+	It("should not change the stacks", func() {
+		app.Drift(ctx, stackName, false)
+
+		Expect(awsClient.UpsertStackCallCount).To(Equal(0))
+	})
+
+	Context("when both stacks are in sync", func() {
+		It("should succeed", func() {
+			awsClient.DetectStackDriftCalls[0].Returns.Drift.Status = "IN_SYNC"
+			awsClient.DetectStackDriftCalls[0].Returns.Drift.Resources = nil
+
+			Expect(app.Drift(ctx, stackName, false)).To(Succeed())
+			Expect(resultBuffer).To(gbytes.Say(stackName + `-base: IN_SYNC\n`))
+		})
+	})
+
+	Describe("with --show-fixes", func() {
+		BeforeEach(func() {
+			awsClient.DetectStackDriftCalls[1].Returns.Drift.Status = "DRIFTED"
+			awsClient.DetectStackDriftCalls[1].Returns.Drift.Resources = []awsclient.ResourceDrift{deletedELB}
+		})
+
+		It("should print how to undo each change by hand, and return an error", func() {
+			Expect(app.Drift(ctx, stackName, true)).To(MatchError("the stacks have drifted from their templates, so fix them by hand"))
+
+			Expect(resultBuffer).To(gbytes.Say(stackName + `-base: DRIFTED\n`))
+			Expect(resultBuffer).To(gbytes.Say(`To fix by hand:\n`))
+			Expect(resultBuffer).To(gbytes.Say(`  remove /SecurityGroupIngress/2 from BOSHSecurityGroup \(sg-1234\)\n`))
+			Expect(resultBuffer).To(gbytes.Say(`  ConcourseELB of ` + stackName + `-concourse was deleted: run down and up to recreate it\n`))
+		})
+
+		It("should describe properties that were changed or removed", func() {
+			modifiedGroup.Differences = []awsclient.PropertyDifference{
+				{PropertyPath: "/GroupDescription", ExpectedValue: "some description", ActualValue: "other", DifferenceType: "NOT_EQUAL"},
+				{PropertyPath: "/Tags/0", ExpectedValue: `{"Key":"Name"}`, ActualValue: "null", DifferenceType: "REMOVE"},
+			}
+			awsClient.DetectStackDriftCalls[0].Returns.Drift.Resources = []awsclient.ResourceDrift{modifiedGroup}
+
+			app.Drift(ctx, stackName, true)
+
+			Expect(resultBuffer).To(gbytes.Say(`  set /GroupDescription of BOSHSecurityGroup \(sg-1234\) back to some description\n`))
+			Expect(resultBuffer).To(gbytes.Say(`  add /Tags/0 back to BOSHSecurityGroup \(sg-1234\), as {"Key":"Name"}\n`))
+		})
+
+		It("should not change the stacks, since re-applying an unchanged template can't undo drift", func() {
+			app.Drift(ctx, stackName, true)
+
+			Expect(awsClient.UpsertStackCallCount).To(Equal(0))
+			Expect(awsClient.DetectStackDriftCallCount).To(Equal(2))
+		})
+
+		Context("when nothing has drifted", func() {
+			It("should succeed without printing fixes", func() {
+				awsClient.DetectStackDriftCalls[0].Returns.Drift = awsClient.DetectStackDriftCalls[2].Returns.Drift
+				awsClient.DetectStackDriftCalls[1].Returns.Drift = awsClient.DetectStackDriftCalls[3].Returns.Drift
+
+				Expect(app.Drift(ctx, stackName, true)).To(Succeed())
+				Expect(resultBuffer.Contents()).NotTo(ContainSubstring("To fix by hand"))
+			})
+		})
+	})
+
+	Context("when the name is invalid", func() {
+		It("should immediately error", func() {
+			Expect(app.Drift(ctx, "invalid_name", false)).To(MatchError(ContainSubstring("invalid name")))
+			Expect(awsClient.DetectStackDriftCallCount).To(Equal(0))
+		})
+	})
+
+	Context("when detecting drift errors", func() {
+		It("should return the error", func() {
+			awsClient.DetectStackDriftCalls[1].Returns.Error = errors.New("some error")
+
+			Expect(app.Drift(ctx, stackName, false)).To(MatchError("some error"))
+		})
+	})
+
+	Context("when writing the result errors", func() {
+		It("should return the error", func() {
+			app.ResultWriter = &erroringWriter{}
+
+			Expect(app.Drift(ctx, stackName, false)).To(MatchError("write failed"))
+		})
+	})
+})
@@ -0,0 +1,256 @@
+package application
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+// DefaultSealedKeys are the config store keys holding secrets
+var DefaultSealedKeys = []string{
+	"ssh-key",
+	"bosh-password",
+	"bosh-environment",
+	"director.yml",
+	"director-credentials.yml",
+	"director-access-key-id",
+	"director-secret-access-key",
+	"director-key.pem",
+}
+
+// sealedHeader starts every sealed value, so that sealed and plaintext
+// values can live side by side, e.g. while the store is unlocked
+var sealedHeader = []byte("tubes-secretbox-v1\n")
+
+const (
+	saltLength  = 16
+	nonceLength = 24
+	keyLength   = 32
+
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// EncryptedConfigStore seals the values of the SealedKeys with NaCl secretbox,
+// using a key derived from the Passphrase with scrypt.  Other keys pass
+// through to the underlying Store unchanged.
+type EncryptedConfigStore struct {
+	Store      configStore
+	Passphrase []byte
+	SealedKeys []string
+
+	salt        []byte
+	derivedKeys map[string]*[keyLength]byte
+}
+
+func (s *EncryptedConfigStore) isSealedKey(key string) bool {
+	return containsString(s.SealedKeys, key)
+}
+
+func isSealed(value []byte) bool {
+	return bytes.HasPrefix(value, sealedHeader)
+}
+
+func (s *EncryptedConfigStore) deriveKey(salt []byte) (*[keyLength]byte, error) {
+	if len(s.Passphrase) == 0 {
+		return nil, fmt.Errorf("the state directory is encrypted, set TUBES_PASSPHRASE or --passphrase-file")
+	}
+
+	if s.derivedKeys == nil {
+		s.derivedKeys = map[string]*[keyLength]byte{}
+	}
+	if key, ok := s.derivedKeys[string(salt)]; ok {
+		return key, nil
+	}
+
+	keyBytes, err := scrypt.Key(s.Passphrase, salt, scryptN, scryptR, scryptP, keyLength)
+	if err != nil {
+		return nil, err // not tested
+	}
+	key := new([keyLength]byte)
+	copy(key[:], keyBytes)
+	s.derivedKeys[string(salt)] = key
+	return key, nil
+}
+
+func (s *EncryptedConfigStore) seal(value []byte) ([]byte, error) {
+	if s.salt == nil {
+		salt := make([]byte, saltLength)
+		_, err := rand.Read(salt)
+		if err != nil {
+			return nil, err // not tested
+		}
+		s.salt = salt
+	}
+	key, err := s.deriveKey(s.salt)
+	if err != nil {
+		return nil, err
+	}
+
+	var nonce [nonceLength]byte
+	_, err = rand.Read(nonce[:])
+	if err != nil {
+		return nil, err // not tested
+	}
+
+	payload := append(append([]byte{}, s.salt...), nonce[:]...)
+	payload = secretbox.Seal(payload, value, &nonce, key)
+
+	sealed := append([]byte{}, sealedHeader...)
+	sealed = append(sealed, []byte(base64.StdEncoding.EncodeToString(payload))...)
+	return append(sealed, '\n'), nil
+}
+
+func (s *EncryptedConfigStore) open(key string, sealed []byte) ([]byte, error) {
+	payload, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sealed[len(sealedHeader):])))
+	if err != nil {
+		return nil, fmt.Errorf("%s is corrupt: %s", key, err)
+	}
+	if len(payload) < saltLength+nonceLength+secretbox.Overhead {
+		return nil, fmt.Errorf("%s is corrupt: too short", key)
+	}
+
+	salt := payload[:saltLength]
+	var nonce [nonceLength]byte
+	copy(nonce[:], payload[saltLength:saltLength+nonceLength])
+
+	secretKey, err := s.deriveKey(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	value, ok := secretbox.Open(nil, payload[saltLength+nonceLength:], &nonce, secretKey)
+	if !ok {
+		return nil, fmt.Errorf("unable to decrypt %s, is the passphrase right?", key)
+	}
+	return value, nil
+}
+
+// Get returns the plaintext value, decrypting it if it is sealed
+func (s *EncryptedConfigStore) Get(key string) ([]byte, error) {
+	value, err := s.Store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if !isSealed(value) {
+		return value, nil
+	}
+	return s.open(key, value)
+}
+
+// Set seals the value of a sealed key, when there is a passphrase.  Without
+// one, it refuses to overwrite a sealed value with plaintext.
+func (s *EncryptedConfigStore) Set(key string, value []byte) error {
+	if !s.isSealedKey(key) {
+		return s.Store.Set(key, value)
+	}
+
+	if len(s.Passphrase) == 0 {
+		existing, err := s.getExisting(key)
+		if err != nil {
+			return err
+		}
+		if isSealed(existing) {
+			return fmt.Errorf("%s is encrypted, set TUBES_PASSPHRASE or --passphrase-file", key)
+		}
+		return s.Store.Set(key, value)
+	}
+
+	existing, err := s.getExisting(key)
+	if err != nil {
+		return err
+	}
+	if isSealed(existing) {
+		// resealing uses a fresh nonce, so skip unchanged values to keep them stable
+		plaintext, err := s.open(key, existing)
+		if err == nil && bytes.Equal(plaintext, value) {
+			return nil
+		}
+	}
+
+	sealed, err := s.seal(value)
+	if err != nil {
+		return err
+	}
+	return s.Store.Set(key, sealed)
+}
+
+func (s *EncryptedConfigStore) Delete(key string) error {
+	return s.Store.Delete(key)
+}
+
+func (s *EncryptedConfigStore) List() ([]string, error) {
+	return s.Store.List()
+}
+
+func (s *EncryptedConfigStore) IsEmpty() (bool, error) {
+	return s.Store.IsEmpty()
+}
+
+// Unlock writes the sealed keys back as plaintext, returning the keys it wrote
+func (s *EncryptedConfigStore) Unlock() ([]string, error) {
+	unlocked := []string{}
+	for _, key := range s.SealedKeys {
+		value, err := s.getExisting(key)
+		if err != nil {
+			return nil, err
+		}
+		if !isSealed(value) {
+			continue
+		}
+
+		plaintext, err := s.open(key, value)
+		if err != nil {
+			return nil, err
+		}
+		err = s.Store.Set(key, plaintext)
+		if err != nil {
+			return nil, err
+		}
+		unlocked = append(unlocked, key)
+	}
+	return unlocked, nil
+}
+
+// Lock seals any sealed keys that are plaintext, returning the keys it sealed
+func (s *EncryptedConfigStore) Lock() ([]string, error) {
+	if len(s.Passphrase) == 0 {
+		return nil, fmt.Errorf("missing passphrase, set TUBES_PASSPHRASE or --passphrase-file")
+	}
+
+	locked := []string{}
+	for _, key := range s.SealedKeys {
+		value, err := s.getExisting(key)
+		if err != nil {
+			return nil, err
+		}
+		if value == nil || isSealed(value) {
+			continue
+		}
+
+		err = s.Set(key, value)
+		if err != nil {
+			return nil, err
+		}
+		locked = append(locked, key)
+	}
+	return locked, nil
+}
+
+// getExisting reads a raw value from the underlying store, treating a missing key as empty
+func (s *EncryptedConfigStore) getExisting(key string) ([]byte, error) {
+	value, err := s.Store.Get(key)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return value, nil
+}
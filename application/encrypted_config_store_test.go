@@ -0,0 +1,158 @@
+package application_test
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rosenhouse/tubes/application"
+	"github.com/rosenhouse/tubes/mocks"
+)
+
+var _ = Describe("Encrypted Config Store", func() {
+	var (
+		underlying *mocks.FunctionalConfigStore
+		store      *application.EncryptedConfigStore
+	)
+
+	BeforeEach(func() {
+		underlying = mocks.NewFunctionalConfigStore()
+		store = &application.EncryptedConfigStore{
+			Store:      underlying,
+			Passphrase: []byte("some-passphrase"),
+			SealedKeys: []string{"some-secret", "other-secret"},
+		}
+	})
+
+	It("should seal the values of the sealed keys, and open them again", func() {
+		Expect(store.Set("some-secret", []byte("some-plaintext"))).To(Succeed())
+
+		Expect(underlying.Values["some-secret"]).To(HavePrefix("tubes-secretbox-v1\n"))
+		Expect(string(underlying.Values["some-secret"])).NotTo(ContainSubstring("some-plaintext"))
+
+		Expect(store.Get("some-secret")).To(Equal([]byte("some-plaintext")))
+	})
+
+	It("should leave a sealed value as it is when setting it to the same plaintext", func() {
+		Expect(store.Set("some-secret", []byte("some-plaintext"))).To(Succeed())
+		sealed := underlying.Values["some-secret"]
+
+		Expect(store.Set("some-secret", []byte("some-plaintext"))).To(Succeed())
+		Expect(underlying.Values["some-secret"]).To(Equal(sealed))
+
+		Expect(store.Set("some-secret", []byte("new-plaintext"))).To(Succeed())
+		Expect(underlying.Values["some-secret"]).NotTo(Equal(sealed))
+	})
+
+	It("should open values sealed by another store with the same passphrase", func() {
+		Expect(store.Set("some-secret", []byte("some-plaintext"))).To(Succeed())
+
+		other := &application.EncryptedConfigStore{
+			Store:      underlying,
+			Passphrase: []byte("some-passphrase"),
+		}
+		Expect(other.Get("some-secret")).To(Equal([]byte("some-plaintext")))
+	})
+
+	It("should pass other keys through unchanged", func() {
+		Expect(store.Set("bosh-ip", []byte("some-ip"))).To(Succeed())
+
+		Expect(underlying.Values).To(HaveKeyWithValue("bosh-ip", []byte("some-ip")))
+		Expect(store.Get("bosh-ip")).To(Equal([]byte("some-ip")))
+	})
+
+	It("should return plaintext values of sealed keys as they are", func() {
+		underlying.Values["some-secret"] = []byte("some-plaintext")
+
+		Expect(store.Get("some-secret")).To(Equal([]byte("some-plaintext")))
+	})
+
+	It("should delegate IsEmpty", func() {
+		Expect(store.IsEmpty()).To(BeTrue())
+		underlying.Values["anything"] = []byte("something")
+		Expect(store.IsEmpty()).To(BeFalse())
+	})
+
+	Context("when the passphrase is wrong", func() {
+		It("should return an error", func() {
+			Expect(store.Set("some-secret", []byte("some-plaintext"))).To(Succeed())
+			store = &application.EncryptedConfigStore{Store: underlying, Passphrase: []byte("wrong")}
+
+			_, err := store.Get("some-secret")
+			Expect(err).To(MatchError("unable to decrypt some-secret, is the passphrase right?"))
+		})
+	})
+
+	Context("when there is no passphrase", func() {
+		BeforeEach(func() {
+			Expect(store.Set("some-secret", []byte("some-plaintext"))).To(Succeed())
+			store = &application.EncryptedConfigStore{Store: underlying, SealedKeys: []string{"some-secret", "other-secret"}}
+		})
+
+		It("should store plaintext", func() {
+			Expect(store.Set("other-secret", []byte("other-plaintext"))).To(Succeed())
+			Expect(underlying.Values).To(HaveKeyWithValue("other-secret", []byte("other-plaintext")))
+		})
+
+		It("should not be able to read sealed values", func() {
+			_, err := store.Get("some-secret")
+			Expect(err).To(MatchError(ContainSubstring("set TUBES_PASSPHRASE")))
+		})
+
+		It("should refuse to overwrite sealed values with plaintext", func() {
+			err := store.Set("some-secret", []byte("new-plaintext"))
+			Expect(err).To(MatchError(ContainSubstring("some-secret is encrypted")))
+			Expect(underlying.Values["some-secret"]).To(HavePrefix("tubes-secretbox-v1\n"))
+		})
+
+		It("should refuse to lock", func() {
+			_, err := store.Lock()
+			Expect(err).To(MatchError(ContainSubstring("missing passphrase")))
+		})
+	})
+
+	Context("when a sealed value is corrupt", func() {
+		It("should return an error", func() {
+			underlying.Values["some-secret"] = []byte("tubes-secretbox-v1\nnot base64!")
+			_, err := store.Get("some-secret")
+			Expect(err).To(MatchError(HavePrefix("some-secret is corrupt")))
+
+			underlying.Values["some-secret"] = []byte("tubes-secretbox-v1\nYWJj\n")
+			_, err = store.Get("some-secret")
+			Expect(err).To(MatchError("some-secret is corrupt: too short"))
+		})
+	})
+
+	Describe("Unlock and Lock", func() {
+		BeforeEach(func() {
+			Expect(store.Set("some-secret", []byte("some-plaintext"))).To(Succeed())
+			underlying.Values["bosh-ip"] = []byte("some-ip")
+		})
+
+		It("should write the sealed keys as plaintext, and seal them again", func() {
+			Expect(store.Unlock()).To(Equal([]string{"some-secret"}))
+			Expect(underlying.Values).To(HaveKeyWithValue("some-secret", []byte("some-plaintext")))
+
+			Expect(store.Unlock()).To(BeEmpty())
+
+			Expect(store.Lock()).To(Equal([]string{"some-secret"}))
+			Expect(underlying.Values["some-secret"]).To(HavePrefix("tubes-secretbox-v1\n"))
+			Expect(store.Get("some-secret")).To(Equal([]byte("some-plaintext")))
+
+			Expect(store.Lock()).To(BeEmpty())
+			Expect(underlying.Values).To(HaveKeyWithValue("bosh-ip", []byte("some-ip")))
+		})
+
+		Context("when the underlying store errors", func() {
+			It("should return the error", func() {
+				underlying.Errors["some-secret"] = errors.New("some error")
+
+				_, err := store.Unlock()
+				Expect(err).To(MatchError("some error"))
+
+				_, err = store.Lock()
+				Expect(err).To(MatchError("some error"))
+			})
+		})
+	})
+})
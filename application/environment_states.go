@@ -0,0 +1,183 @@
+package application
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LocalEnvironmentStates are the state directories under Dir, one per
+// environment and named after it, e.g. ./environments
+type LocalEnvironmentStates struct {
+	Dir string
+}
+
+func (s LocalEnvironmentStates) Kind() string {
+	return "local"
+}
+
+func (s LocalEnvironmentStates) stores() (map[string]*FilesystemConfigStore, error) {
+	entries, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	stores := map[string]*FilesystemConfigStore{}
+	for _, entry := range entries {
+		if entry.Name() == stateManifestKey || entry.Name() == stateLockKey {
+			return nil, errSingleEnvironmentState(s.Dir)
+		}
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			stores[entry.Name()] = &FilesystemConfigStore{RootDir: filepath.Join(s.Dir, entry.Name())}
+		}
+	}
+	return stores, nil
+}
+
+// Names returns the environments whose state directory holds anything
+func (s LocalEnvironmentStates) Names() ([]string, error) {
+	stores, err := s.stores()
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for name, store := range stores {
+		empty, err := store.IsEmpty()
+		if err != nil {
+			return nil, err
+		}
+		if !empty {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Locks returns the locks held on any environment's state, by environment
+func (s LocalEnvironmentStates) Locks() (map[string]*StateLock, error) {
+	stores, err := s.stores()
+	if err != nil {
+		return nil, err
+	}
+
+	locks := map[string]*StateLock{}
+	for name, store := range stores {
+		lock, err := store.readLock()
+		if err != nil {
+			return nil, err
+		}
+		if lock != nil {
+			locks[name] = lock
+		}
+	}
+	return locks, nil
+}
+
+// S3EnvironmentStates are the states under Prefix in Bucket, one per
+// environment and named after it, e.g. s3://my-bucket/environments
+type S3EnvironmentStates struct {
+	Client objectStore
+	Bucket string
+	Prefix string
+}
+
+func (s S3EnvironmentStates) Kind() string {
+	return "s3"
+}
+
+// keys returns the keys of each environment's state, by environment
+func (s S3EnvironmentStates) keys() (map[string][]string, error) {
+	prefix := ""
+	if s.Prefix != "" {
+		prefix = s.Prefix + "/"
+	}
+	objectKeys, err := s.Client.ListObjects(s.Bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string][]string{}
+	for _, objectKey := range objectKeys {
+		parts := strings.SplitN(strings.TrimPrefix(objectKey, prefix), "/", 2)
+		if len(parts) < 2 {
+			if parts[0] == stateManifestKey || parts[0] == stateLockKey {
+				return nil, errSingleEnvironmentState(fmt.Sprintf("s3://%s/%s", s.Bucket, s.Prefix))
+			}
+			continue
+		}
+		keys[parts[0]] = append(keys[parts[0]], parts[1])
+	}
+	return keys, nil
+}
+
+// Names returns the environments with any state under the prefix
+func (s S3EnvironmentStates) Names() ([]string, error) {
+	keys, err := s.keys()
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for name, stateKeys := range keys {
+		if len(stateKeys) > 1 || stateKeys[0] != stateLockKey {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Locks returns the locks held on any environment's state, by environment
+func (s S3EnvironmentStates) Locks() (map[string]*StateLock, error) {
+	keys, err := s.keys()
+	if err != nil {
+		return nil, err
+	}
+
+	locks := map[string]*StateLock{}
+	for name, stateKeys := range keys {
+		if !containsString(stateKeys, stateLockKey) {
+			continue
+		}
+		store := &S3ConfigStore{Client: s.Client, Bucket: s.Bucket, Prefix: path.Join(s.Prefix, name)}
+		lock, err := store.readLock()
+		if err != nil {
+			return nil, err
+		}
+		if lock != nil {
+			locks[name] = lock
+		}
+	}
+	return locks, nil
+}
+
+func errSingleEnvironmentState(location string) error {
+	return fmt.Errorf("%s holds the state of one environment.  Point list and gc at the location holding the state of every environment instead", location)
+}
+
+// checkUnlocked errors if a run holds the lock on any environment's state
+func checkUnlocked(states environmentStates) error {
+	locks, err := states.Locks()
+	if err != nil {
+		return err
+	}
+
+	names := []string{}
+	for name := range locks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return nil
+	}
+	return fmt.Errorf("the state of %s is locked by %s.  Wait for that run to finish, or remove the lock with force-unlock", names[0], locks[names[0]])
+}
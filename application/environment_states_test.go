@@ -0,0 +1,107 @@
+package application_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rosenhouse/tubes/application"
+	"github.com/rosenhouse/tubes/mocks"
+)
+
+var _ = Describe("LocalEnvironmentStates", func() {
+	var (
+		environmentsDir string
+		states          application.LocalEnvironmentStates
+	)
+
+	writeFile := func(name, key, value string) {
+		Expect(os.MkdirAll(filepath.Join(environmentsDir, name), 0700)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(environmentsDir, name, key), []byte(value), 0600)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		tempDir, err := ioutil.TempDir("", "tubes-unit-test-")
+		Expect(err).NotTo(HaveOccurred())
+		environmentsDir = filepath.Join(tempDir, "environments")
+		states = application.LocalEnvironmentStates{Dir: environmentsDir}
+
+		writeFile("beta", "bosh-ip", "some-value")
+		writeFile("alpha", "tubes.lock", `{"owner":"someone","command":"up"}`)
+		writeFile(".git", "HEAD", "some-ref")
+		Expect(os.MkdirAll(filepath.Join(environmentsDir, "empty"), 0700)).To(Succeed())
+	})
+
+	It("should name the environments with a non-empty state directory", func() {
+		Expect(states.Names()).To(Equal([]string{"alpha", "beta"}))
+	})
+
+	It("should return the locks, by environment", func() {
+		locks, err := states.Locks()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(locks).To(HaveLen(1))
+		Expect(locks["alpha"].Owner).To(Equal("someone"))
+	})
+
+	Context("when the directory is missing", func() {
+		It("should find no environments", func() {
+			states.Dir = filepath.Join(environmentsDir, "missing")
+
+			Expect(states.Names()).To(BeEmpty())
+			Expect(states.Locks()).To(BeEmpty())
+		})
+	})
+
+	Context("when the directory holds the state of one environment", func() {
+		It("should return an error", func() {
+			states.Dir = filepath.Join(environmentsDir, "beta")
+			writeFile("beta", "tubes-state.yml", "some-state")
+
+			_, err := states.Names()
+			Expect(err).To(MatchError(ContainSubstring("holds the state of one environment")))
+			_, err = states.Locks()
+			Expect(err).To(MatchError(ContainSubstring("holds the state of one environment")))
+		})
+	})
+})
+
+var _ = Describe("S3EnvironmentStates", func() {
+	var (
+		objectStore *mocks.FunctionalObjectStore
+		states      application.S3EnvironmentStates
+	)
+
+	BeforeEach(func() {
+		objectStore = mocks.NewFunctionalObjectStore()
+		objectStore.Objects["some-bucket/environments/beta/bosh-ip"] = []byte("some-value")
+		objectStore.Objects["some-bucket/environments/alpha/tubes.lock"] = []byte(`{"owner":"someone","command":"up"}`)
+		objectStore.Objects["some-bucket/environments/alpha/bosh-ip"] = []byte("some-value")
+		objectStore.Objects["some-bucket/environments/gone/tubes.lock"] = []byte(`{"owner":"someone else","command":"down"}`)
+		objectStore.Objects["some-bucket/elsewhere/delta/bosh-ip"] = []byte("some-value")
+		states = application.S3EnvironmentStates{Client: objectStore, Bucket: "some-bucket", Prefix: "environments"}
+	})
+
+	It("should name the environments with any state besides a lock", func() {
+		Expect(states.Names()).To(Equal([]string{"alpha", "beta"}))
+	})
+
+	It("should return the locks, by environment", func() {
+		locks, err := states.Locks()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(locks).To(HaveLen(2))
+		Expect(locks["alpha"].Owner).To(Equal("someone"))
+		Expect(locks["gone"].Owner).To(Equal("someone else"))
+	})
+
+	Context("when the prefix holds the state of one environment", func() {
+		It("should return an error", func() {
+			states.Prefix = "environments/beta"
+			objectStore.Objects["some-bucket/environments/beta/tubes-state.yml"] = []byte("some-state")
+
+			_, err := states.Names()
+			Expect(err).To(MatchError("s3://some-bucket/environments/beta holds the state of one environment.  Point list and gc at the location holding the state of every environment instead"))
+		})
+	})
+})
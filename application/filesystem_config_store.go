@@ -1,14 +1,25 @@
 package application
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
+// lockMarkerPrefix starts the name of the marker a run creates while taking
+// over a stale lock, followed by the ID of that lock
+const lockMarkerPrefix = "." + stateLockKey + "."
+
 type FilesystemConfigStore struct {
 	RootDir string
+
+	// Owner identifies this run in the lock, e.g. user@host (pid 123)
+	Owner string
+
+	heldLock *StateLock
 }
 
 func (s *FilesystemConfigStore) getFilePath(key string) (string, error) {
@@ -45,6 +56,8 @@ const (
 	fileCreationBits = 0600
 )
 
+// Set writes to a temporary file and renames it into place, so that a crash
+// never leaves a value half written
 func (s *FilesystemConfigStore) Set(key string, value []byte) error {
 	filePath, err := s.getFilePath(key)
 	if err != nil {
@@ -56,18 +69,263 @@ func (s *FilesystemConfigStore) Set(key string, value []byte) error {
 		return err
 	}
 
-	err = ioutil.WriteFile(filePath, value, fileCreationBits)
+	tempFile, err := ioutil.TempFile(filepath.Dir(filePath), "."+filepath.Base(filePath)+".tmp")
 	if err != nil {
 		return err
 	}
+	tempPath := tempFile.Name()
+
+	_, err = tempFile.Write(value)
+	if err == nil {
+		err = tempFile.Sync()
+	}
+	closeErr := tempFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tempPath, fileCreationBits)
+	}
+	if err == nil {
+		err = os.Rename(tempPath, filePath)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
 	return nil
 }
 
+// Delete removes the value, if there is one
+func (s *FilesystemConfigStore) Delete(key string) error {
+	filePath, err := s.getFilePath(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// List returns the keys of every value in the store.  Hidden files, like
+// temporary files or a .git directory, and the lock are not values.
+func (s *FilesystemConfigStore) List() ([]string, error) {
+	keys := []string{}
+	err := filepath.Walk(s.RootDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relativePath, err := filepath.Rel(s.RootDir, filePath)
+		if err != nil {
+			return err // not tested
+		}
+		if relativePath == "." {
+			return nil
+		}
+
+		if strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		key := filepath.ToSlash(relativePath)
+		if info.IsDir() || key == stateLockKey {
+			return nil
+		}
+
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
 func (s *FilesystemConfigStore) IsEmpty() (bool, error) {
-	fs, err := ioutil.ReadDir(s.RootDir)
+	keys, err := s.List()
 	if err != nil {
 		return false, err
 	}
 
-	return len(fs) == 0, nil
+	return len(keys) == 0, nil
+}
+
+func (s *FilesystemConfigStore) readLock() (*StateLock, error) {
+	lockJSON, err := s.Get(stateLockKey)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return parseStateLock(lockJSON)
+}
+
+// AcquireLock creates the lock file for the command, failing if another run
+// holds it.  A lock left by a run that is gone from this host is replaced.
+func (s *FilesystemConfigStore) AcquireLock(command string) error {
+	lockPath, err := s.getFilePath(stateLockKey)
+	if err != nil {
+		return err
+	}
+
+	lock, err := newStateLock(s.Owner, command)
+	if err != nil {
+		return err
+	}
+	lockJSON, err := json.Marshal(lock)
+	if err != nil {
+		return err // not tested
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		lockFile, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fileCreationBits)
+		if err == nil {
+			_, err = lockFile.Write(lockJSON)
+			closeErr := lockFile.Close()
+			if err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(lockPath)
+				return err
+			}
+			s.heldLock = lock
+			return nil
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+
+		existing, err := s.readLock()
+		if err != nil {
+			return err
+		}
+		if existing == nil {
+			continue
+		}
+		if !existing.isStale() {
+			return errStateLocked(existing)
+		}
+
+		err = s.removeStaleLock(lockPath, existing.ID, lockJSON)
+		if err != nil {
+			return err
+		}
+	}
+
+	return errLockRace
+}
+
+// removeStaleLock removes the lock with the given ID, which was judged stale.
+// Several runs may judge the same lock stale at once, so each first claims the
+// takeover with a marker file named after that ID, which only one can create,
+// and then checks that the lock is still the stale one before removing it.
+// Nobody else removes a lock that a live run holds, so the lock can't change
+// between that check and the removal.  The marker holds the new lock, so that
+// one left by a run that died mid-takeover can be told apart, and is only
+// removed by force-unlock.
+func (s *FilesystemConfigStore) removeStaleLock(lockPath, staleID string, lockJSON []byte) error {
+	markerPath := filepath.Join(filepath.Dir(lockPath), lockMarkerPrefix+staleID)
+	marker, err := os.OpenFile(markerPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fileCreationBits)
+	if err != nil {
+		if !os.IsExist(err) {
+			return err
+		}
+		// another run is taking it over, unless it died doing so
+		takerJSON, err := ioutil.ReadFile(markerPath)
+		if err != nil {
+			return nil
+		}
+		taker, err := parseStateLock(takerJSON)
+		if err == nil && taker.isStale() {
+			return fmt.Errorf("a run that is gone left %s while taking over a stale lock.  Remove it with force-unlock", markerPath)
+		}
+		return nil
+	}
+	defer os.Remove(markerPath)
+	_, err = marker.Write(lockJSON)
+	closeErr := marker.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	current, err := s.readLock()
+	if err != nil {
+		return err
+	}
+	if current == nil || current.ID != staleID {
+		return nil // already taken over
+	}
+
+	err = os.Remove(lockPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ReleaseLock removes the lock file this run holds, if any
+func (s *FilesystemConfigStore) ReleaseLock() error {
+	if s.heldLock == nil {
+		return nil
+	}
+
+	current, err := s.readLock()
+	if err != nil {
+		return err
+	}
+	if current == nil || current.ID != s.heldLock.ID {
+		return errLockTakenOver(s.heldLock)
+	}
+
+	err = s.Delete(stateLockKey)
+	if err != nil {
+		return err
+	}
+	s.heldLock = nil
+	return nil
+}
+
+// ForceUnlock removes whatever lock is held, returning it, or nil if there was none.
+// It also removes the markers of runs that died taking over a stale lock.
+func (s *FilesystemConfigStore) ForceUnlock() (*StateLock, error) {
+	lockPath, err := s.getFilePath(stateLockKey)
+	if err != nil {
+		return nil, err
+	}
+	markerPaths, err := filepath.Glob(filepath.Join(filepath.Dir(lockPath), lockMarkerPrefix+"*"))
+	if err != nil {
+		return nil, err // not tested
+	}
+	for _, markerPath := range markerPaths {
+		err = os.Remove(markerPath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	existing, err := s.readLock()
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return nil, nil
+	}
+
+	err = s.Delete(stateLockKey)
+	if err != nil {
+		return nil, err
+	}
+	return existing, nil
 }
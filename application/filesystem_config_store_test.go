@@ -1,10 +1,12 @@
 package application_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"os/exec"
 	"path/filepath"
 
 	. "github.com/onsi/ginkgo"
@@ -111,4 +113,191 @@ var _ = Describe("Filesystem Config Store", func() {
 			Expect(err).To(BeAssignableToTypeOf(&os.PathError{}))
 		})
 	})
+
+	Describe("writing values", func() {
+		It("should replace existing values whole, leaving no temporary files", func() {
+			store := application.FilesystemConfigStore{RootDir: tempDir}
+
+			Expect(store.Set("some-key", []byte("some longer original value"))).To(Succeed())
+			Expect(store.Set("some-key", []byte("short"))).To(Succeed())
+
+			Expect(store.Get("some-key")).To(Equal([]byte("short")))
+			entries, err := ioutil.ReadDir(tempDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].Mode().Perm()).To(Equal(os.FileMode(0600)))
+		})
+	})
+
+	Describe("listing and deleting values", func() {
+		It("should list the keys, skipping hidden files and the lock", func() {
+			store := application.FilesystemConfigStore{RootDir: tempDir}
+			Expect(store.Set("some/nested/key", []byte("a"))).To(Succeed())
+			Expect(store.Set("some-key", []byte("b"))).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(tempDir, ".git", "objects"), 0700)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(tempDir, ".some-key.tmp123"), []byte("c"), 0600)).To(Succeed())
+			Expect(store.AcquireLock("up")).To(Succeed())
+
+			Expect(store.List()).To(Equal([]string{"some-key", "some/nested/key"}))
+			Expect(store.IsEmpty()).To(BeFalse())
+
+			Expect(store.Delete("some-key")).To(Succeed())
+			Expect(store.Delete("some/nested/key")).To(Succeed())
+			Expect(store.List()).To(BeEmpty())
+			Expect(store.IsEmpty()).To(BeTrue())
+		})
+
+		It("should not mind deleting a missing key", func() {
+			store := application.FilesystemConfigStore{RootDir: tempDir}
+			Expect(store.Delete("nope")).To(Succeed())
+		})
+	})
+
+	Describe("the state lock", func() {
+		var (
+			store    *application.FilesystemConfigStore
+			lockPath string
+		)
+
+		BeforeEach(func() {
+			store = &application.FilesystemConfigStore{RootDir: tempDir, Owner: "some-user@some-host (pid 123)"}
+			lockPath = filepath.Join(tempDir, "tubes.lock")
+		})
+
+		writeLock := func(lock application.StateLock) {
+			lockJSON, err := json.Marshal(lock)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ioutil.WriteFile(lockPath, lockJSON, 0600)).To(Succeed())
+		}
+
+		It("should record the owner, host and PID in a lock file, and remove it on release", func() {
+			Expect(store.AcquireLock("up")).To(Succeed())
+
+			lockJSON, err := ioutil.ReadFile(lockPath)
+			Expect(err).NotTo(HaveOccurred())
+			var lock application.StateLock
+			Expect(json.Unmarshal(lockJSON, &lock)).To(Succeed())
+			Expect(lock.Owner).To(Equal("some-user@some-host (pid 123)"))
+			Expect(lock.Command).To(Equal("up"))
+			Expect(lock.PID).To(Equal(os.Getpid()))
+			hostName, _ := os.Hostname()
+			Expect(lock.Host).To(Equal(hostName))
+
+			Expect(store.ReleaseLock()).To(Succeed())
+			_, err = os.Stat(lockPath)
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It("should refuse a second run while the first holds the lock", func() {
+			Expect(store.AcquireLock("up")).To(Succeed())
+
+			other := &application.FilesystemConfigStore{RootDir: tempDir, Owner: "other-run"}
+			err := other.AcquireLock("down")
+			Expect(err).To(MatchError(ContainSubstring("locked by some-user@some-host (pid 123), running up since")))
+		})
+
+		It("should refuse a lock held on another host, since it can't tell if that run is gone", func() {
+			writeLock(application.StateLock{ID: "some-id", Owner: "someone", Command: "up", Host: "some-other-host", PID: 1})
+
+			Expect(store.AcquireLock("up")).To(MatchError(ContainSubstring("locked by someone")))
+		})
+
+		It("should replace a stale lock left by a process that is gone from this host", func() {
+			exited := exec.Command("true")
+			Expect(exited.Run()).To(Succeed())
+			hostName, _ := os.Hostname()
+			writeLock(application.StateLock{ID: "some-id", Owner: "someone", Command: "up", Host: hostName, PID: exited.Process.Pid})
+
+			Expect(store.AcquireLock("down")).To(Succeed())
+			Expect(ioutil.ReadFile(lockPath)).To(ContainSubstring(`"command":"down"`))
+		})
+
+		It("should let only one of several runs take over the same stale lock", func() {
+			exited := exec.Command("true")
+			Expect(exited.Run()).To(Succeed())
+			hostName, _ := os.Hostname()
+			writeLock(application.StateLock{ID: "some-id", Owner: "someone", Command: "up", Host: hostName, PID: exited.Process.Pid})
+
+			const runs = 20
+			results := make(chan string, runs)
+			for i := 0; i < runs; i++ {
+				go func(command string) {
+					run := &application.FilesystemConfigStore{RootDir: tempDir, Owner: command}
+					if run.AcquireLock(command) == nil {
+						results <- command
+					} else {
+						results <- ""
+					}
+				}(fmt.Sprintf("run-%d", i))
+			}
+
+			winners := []string{}
+			for i := 0; i < runs; i++ {
+				if winner := <-results; winner != "" {
+					winners = append(winners, winner)
+				}
+			}
+			Expect(winners).To(HaveLen(1))
+			Expect(ioutil.ReadFile(lockPath)).To(ContainSubstring(fmt.Sprintf(`"command":"%s"`, winners[0])))
+		})
+
+		It("should not remove a lock that was taken over while it checked for a stale one", func() {
+			exited := exec.Command("true")
+			Expect(exited.Run()).To(Succeed())
+			hostName, _ := os.Hostname()
+			writeLock(application.StateLock{ID: "some-id", Owner: "someone", Command: "up", Host: hostName, PID: exited.Process.Pid})
+			Expect(ioutil.WriteFile(filepath.Join(tempDir, ".tubes.lock.some-id"), nil, 0600)).To(Succeed())
+
+			Expect(store.AcquireLock("down")).To(MatchError(ContainSubstring("at the same time")))
+			Expect(ioutil.ReadFile(lockPath)).To(ContainSubstring("some-id"))
+		})
+
+		Context("when a run died while taking over a stale lock", func() {
+			var markerPath string
+
+			BeforeEach(func() {
+				exited := exec.Command("true")
+				Expect(exited.Run()).To(Succeed())
+				hostName, _ := os.Hostname()
+				writeLock(application.StateLock{ID: "some-id", Owner: "someone", Command: "up", Host: hostName, PID: exited.Process.Pid})
+
+				takerJSON, err := json.Marshal(application.StateLock{ID: "taker-id", Owner: "someone-else", Command: "down", Host: hostName, PID: exited.Process.Pid})
+				Expect(err).NotTo(HaveOccurred())
+				markerPath = filepath.Join(tempDir, ".tubes.lock.some-id")
+				Expect(ioutil.WriteFile(markerPath, takerJSON, 0600)).To(Succeed())
+			})
+
+			It("should say to force-unlock, rather than that another run raced it", func() {
+				err := store.AcquireLock("down")
+				Expect(err).To(MatchError(ContainSubstring("left " + markerPath + " while taking over a stale lock")))
+				Expect(err).To(MatchError(ContainSubstring("force-unlock")))
+			})
+
+			It("should remove the marker along with the lock when forced open", func() {
+				_, err := store.ForceUnlock()
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = os.Stat(markerPath)
+				Expect(os.IsNotExist(err)).To(BeTrue())
+				Expect(store.AcquireLock("up")).To(Succeed())
+			})
+		})
+
+		It("should let the lock be forced open", func() {
+			writeLock(application.StateLock{ID: "some-id", Owner: "someone", Command: "up", Host: "some-other-host", PID: 1})
+
+			lock, err := store.ForceUnlock()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lock.Owner).To(Equal("someone"))
+			Expect(store.AcquireLock("up")).To(Succeed())
+		})
+
+		It("should not remove a lock that was taken over", func() {
+			Expect(store.AcquireLock("up")).To(Succeed())
+			writeLock(application.StateLock{ID: "other-id", Owner: "someone", Command: "down"})
+
+			Expect(store.ReleaseLock()).To(MatchError(ContainSubstring("taken over while up ran")))
+			Expect(ioutil.ReadFile(lockPath)).To(ContainSubstring("other-id"))
+		})
+	})
 })
@@ -0,0 +1,27 @@
+package application
+
+import "fmt"
+
+// ForceUnlock removes the state lock, e.g. after a run crashed holding it
+func (a *Application) ForceUnlock(stackName string) error {
+	err := validateStackName(stackName)
+	if err != nil {
+		return err
+	}
+
+	if a.StateLocker == nil {
+		return fmt.Errorf("the state store doesn't support locking")
+	}
+
+	lock, err := a.StateLocker.ForceUnlock()
+	if err != nil {
+		return err
+	}
+	if lock == nil {
+		a.Logger.Println("The state isn't locked")
+		return nil
+	}
+
+	a.Logger.Printf("Removed the state lock held by %s\n", lock)
+	return nil
+}
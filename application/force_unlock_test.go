@@ -0,0 +1,49 @@
+package application_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/rosenhouse/tubes/application"
+	"github.com/rosenhouse/tubes/mocks"
+)
+
+var _ = Describe("ForceUnlock", func() {
+	var remoteState *application.S3ConfigStore
+
+	BeforeEach(func() {
+		remoteState = &application.S3ConfigStore{
+			Client: mocks.NewFunctionalObjectStore(),
+			Bucket: "some-bucket",
+			Owner:  "some-user@some-host (pid 123)",
+		}
+		app.StateLocker = remoteState
+	})
+
+	It("should remove the lock, saying who held it", func() {
+		Expect(remoteState.AcquireLock("up")).To(Succeed())
+
+		Expect(app.ForceUnlock(stackName)).To(Succeed())
+		Expect(logBuffer).To(gbytes.Say(`Removed the state lock held by some-user@some-host \(pid 123\), running up since`))
+
+		Expect(remoteState.AcquireLock("down")).To(Succeed())
+	})
+
+	It("should say when the state isn't locked", func() {
+		Expect(app.ForceUnlock(stackName)).To(Succeed())
+		Expect(logBuffer).To(gbytes.Say("The state isn't locked"))
+	})
+
+	Context("when the state store doesn't support locking", func() {
+		It("should return an error", func() {
+			app.StateLocker = nil
+			Expect(app.ForceUnlock(stackName)).To(MatchError(ContainSubstring("doesn't support locking")))
+		})
+	})
+
+	Context("when the name is invalid", func() {
+		It("should immediately error", func() {
+			Expect(app.ForceUnlock("invalid_name")).To(MatchError(ContainSubstring("invalid name")))
+		})
+	})
+})
@@ -0,0 +1,253 @@
+package application
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rosenhouse/tubes/lib/awsclient"
+	"golang.org/x/net/context"
+)
+
+type orphan struct {
+	kind        string
+	name        string
+	environment string
+	reason      string
+	delete      func() error
+}
+
+// GC finds the resources left behind by failed up and down runs, for
+// environments that are gone: stacks stuck in DELETE_FAILED, the access keys
+// of their BOSH users, unassociated Elastic IPs and key pairs.  It prints them,
+// and deletes them once confirmed on Input, or straight away with yes.
+//
+// An environment is gone when it has no state in EnvironmentStates, and no
+// stacks that aren't DELETE_FAILED.  For one with a state, down cleans up
+// instead.  GC refuses to run while any environment's state is locked, since
+// that run may be creating the resources it would find.
+func (a *Application) GC(ctx context.Context, yes bool) error {
+	err := checkUnlocked(a.EnvironmentStates)
+	if err != nil {
+		return err
+	}
+
+	orphans, err := a.findOrphans(ctx)
+	if err != nil {
+		return err
+	}
+	if len(orphans) == 0 {
+		_, err = fmt.Fprintln(a.ResultWriter, "No orphaned resources found")
+		return err
+	}
+
+	table := tabwriter.NewWriter(a.ResultWriter, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(table, "KIND\tNAME\tENVIRONMENT\tREASON")
+	for _, o := range orphans {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", o.kind, o.name, o.environment, o.reason)
+	}
+	err = table.Flush()
+	if err != nil {
+		return err
+	}
+
+	if !yes {
+		confirmed, err := a.confirm(fmt.Sprintf("Delete these %d resources? Type yes to confirm:", len(orphans)))
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			return fmt.Errorf("not confirmed, so nothing was deleted")
+		}
+
+		// a run may have started while waiting for confirmation
+		err = checkUnlocked(a.EnvironmentStates)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, o := range orphans {
+		a.Logger.Printf("Deleting %s %s\n", o.kind, o.name)
+		err = o.delete()
+		if err != nil {
+			return err
+		}
+	}
+	a.Logger.Println("Finished")
+	return nil
+}
+
+func (a *Application) confirm(prompt string) (bool, error) {
+	a.Logger.Println(prompt)
+	answer, err := bufio.NewReader(a.Input).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	return strings.TrimSpace(answer) == "yes", nil
+}
+
+// findOrphans returns the orphaned resources in the order they can be deleted:
+// the access keys and Elastic IPs that keep a stack from deleting come first
+func (a *Application) findOrphans(ctx context.Context) ([]orphan, error) {
+	stateNames, err := a.EnvironmentStates.Names()
+	if err != nil {
+		return nil, err
+	}
+	stacks, err := a.AWSClient.ListEnvironmentStacks()
+	if err != nil {
+		return nil, err
+	}
+	keyNames, err := a.AWSClient.ListKeyPairs()
+	if err != nil {
+		return nil, err
+	}
+	elasticIPs, err := a.AWSClient.ListElasticIPs()
+	if err != nil {
+		return nil, err
+	}
+
+	hasState := map[string]bool{}
+	for _, name := range stateNames {
+		hasState[name] = true
+	}
+	inUse := map[string]bool{}
+	for name := range hasState {
+		inUse[name] = true
+	}
+	for _, stack := range stacks {
+		if stack.Status != "DELETE_FAILED" {
+			inUse[stack.Environment] = true
+		}
+	}
+
+	// tubes names key pairs after environments, so only those of environments
+	// that once had a stack tagged by tubes are considered.  Deleted stacks
+	// are only looked up for the key pairs that live stacks don't explain.
+	created := map[string]bool{}
+	for _, stack := range stacks {
+		created[stack.Environment] = true
+	}
+	unexplained := []string{}
+	for _, keyName := range keyNames {
+		if !created[keyName] && !inUse[keyName] {
+			unexplained = append(unexplained, keyName)
+		}
+	}
+	deletedStacks, err := a.AWSClient.ListDeletedBaseStacks(unexplained)
+	if err != nil {
+		return nil, err
+	}
+	for _, stack := range deletedStacks {
+		created[stack.Environment] = true
+	}
+
+	var accessKeys, ips, keyPairs, failedStacks []orphan
+
+	// concourse stacks come before base stacks, like in down
+	sort.Sort(byEnvironmentAndRole(stacks))
+	for _, stack := range stacks {
+		stack := stack
+		if stack.Status != "DELETE_FAILED" {
+			continue
+		}
+		if inUse[stack.Environment] {
+			if hasState[stack.Environment] {
+				a.Logger.Printf("Skipping %s, since %s has a state, so run down for it instead\n", stack.StackName, stack.Environment)
+			}
+			continue
+		}
+
+		failedStacks = append(failedStacks, orphan{
+			kind:        "stack",
+			name:        stack.StackName,
+			environment: stack.Environment,
+			reason:      "DELETE_FAILED",
+			delete: func() error {
+				err := a.AWSClient.DeleteStack(stack.StackName)
+				if err != nil {
+					return err
+				}
+				return a.AWSClient.WaitForStack(ctx, stack.StackName, awsclient.CloudFormationDeletePundit{})
+			},
+		})
+
+		if stack.Role != "base" {
+			continue
+		}
+		resources, err := a.AWSClient.GetStackResources(stack.StackName)
+		if err != nil {
+			return nil, err
+		}
+		userName := resources["BOSHDirectorUser"]
+		if userName == "" {
+			continue
+		}
+		keys, err := a.AWSClient.ListAccessKeys(userName)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			key := key
+			accessKeys = append(accessKeys, orphan{
+				kind:        "access key",
+				name:        key,
+				environment: stack.Environment,
+				reason:      fmt.Sprintf("belongs to %s, which keeps %s from deleting", userName, stack.StackName),
+				delete: func() error {
+					return a.AWSClient.DeleteAccessKey(userName, key)
+				},
+			})
+		}
+	}
+
+	for _, ip := range elasticIPs {
+		ip := ip
+		if inUse[ip.Environment] || ip.Associated {
+			continue
+		}
+		ips = append(ips, orphan{
+			kind:        "elastic IP",
+			name:        ip.PublicIP,
+			environment: ip.Environment,
+			reason:      "unassociated, and its environment is gone",
+			delete: func() error {
+				return a.AWSClient.ReleaseElasticIP(ip.AllocationID)
+			},
+		})
+	}
+
+	for _, keyName := range keyNames {
+		keyName := keyName
+		if !created[keyName] || inUse[keyName] {
+			continue
+		}
+		keyPairs = append(keyPairs, orphan{
+			kind:        "key pair",
+			name:        keyName,
+			environment: keyName,
+			reason:      "its environment is gone",
+			delete: func() error {
+				return a.AWSClient.DeleteKeyPair(keyName)
+			},
+		})
+	}
+
+	orphans := append(accessKeys, ips...)
+	orphans = append(orphans, keyPairs...)
+	return append(orphans, failedStacks...), nil
+}
+
+type byEnvironmentAndRole []awsclient.EnvironmentStack
+
+func (s byEnvironmentAndRole) Len() int      { return len(s) }
+func (s byEnvironmentAndRole) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byEnvironmentAndRole) Less(i, j int) bool {
+	if s[i].Environment != s[j].Environment {
+		return s[i].Environment < s[j].Environment
+	}
+	return s[i].Role > s[j].Role
+}
@@ -0,0 +1,305 @@
+package application_test
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/rosenhouse/tubes/application"
+	"github.com/rosenhouse/tubes/lib/awsclient"
+	"github.com/rosenhouse/tubes/mocks"
+)
+
+// lockingReader takes a state lock as the user answers, like a run that starts meanwhile
+type lockingReader struct {
+	lockPath string
+	answer   io.Reader
+}
+
+func (r *lockingReader) Read(p []byte) (int, error) {
+	err := ioutil.WriteFile(r.lockPath, []byte(`{"owner":"someone","command":"up"}`), 0600)
+	if err != nil {
+		return 0, err
+	}
+	return r.answer.Read(p)
+}
+
+var _ = Describe("GC", func() {
+	var environmentsDir string
+
+	BeforeEach(func() {
+		tempDir, err := ioutil.TempDir("", "tubes-unit-test-")
+		Expect(err).NotTo(HaveOccurred())
+		environmentsDir = filepath.Join(tempDir, "environments")
+		Expect(os.MkdirAll(filepath.Join(environmentsDir, "alpha"), 0700)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(environmentsDir, "alpha", "bosh-ip"), []byte("some-value"), 0600)).To(Succeed())
+		app.EnvironmentStates = application.LocalEnvironmentStates{Dir: environmentsDir}
+
+		awsClient.ListEnvironmentStacksCall.Returns.Stacks = []awsclient.EnvironmentStack{
+			{StackName: "alpha-base", Environment: "alpha", Role: "base", Status: "UPDATE_COMPLETE"},
+			{StackName: "beta-base", Environment: "beta", Role: "base", Status: "CREATE_COMPLETE"},
+			{StackName: "gone-base", Environment: "gone", Role: "base", Status: "DELETE_FAILED"},
+			{StackName: "gone-concourse", Environment: "gone", Role: "concourse", Status: "DELETE_FAILED"},
+		}
+		awsClient.ListDeletedBaseStacksCall.Returns.Stacks = []awsclient.EnvironmentStack{
+			{StackName: "old-base", Environment: "old", Role: "base", Status: "DELETE_COMPLETE"},
+		}
+		awsClient.ListKeyPairsCall.Returns.KeyNames = []string{"alpha", "beta", "gone", "old", "something"}
+		awsClient.ListElasticIPsCall.Returns.ElasticIPs = []awsclient.ElasticIP{
+			{AllocationID: "eipalloc-alpha", PublicIP: "1.1.1.1", Environment: "alpha"},
+			{AllocationID: "eipalloc-gone", PublicIP: "2.2.2.2", Environment: "gone"},
+			{AllocationID: "eipalloc-busy", PublicIP: "3.3.3.3", Environment: "gone", Associated: true},
+		}
+		awsClient.GetStackResourcesCalls = make([]mocks.GetStackResourcesCall, 1)
+		awsClient.GetStackResourcesCalls[0].Returns.Resources = map[string]string{"BOSHDirectorUser": "gone-bosh-user"}
+		awsClient.ListAccessKeysCall.Returns.AccessKeys = []string{"some-access-key"}
+
+		app.Input = strings.NewReader("yes\n")
+	})
+
+	It("should report the orphaned resources, in the order they are deleted", func() {
+		Expect(app.GC(ctx, false)).To(Succeed())
+
+		Expect(resultBuffer).To(gbytes.Say(`KIND\s+NAME\s+ENVIRONMENT\s+REASON\n`))
+		Expect(resultBuffer).To(gbytes.Say(`access key\s+some-access-key\s+gone\s+belongs to gone-bosh-user, which keeps gone-base from deleting\n`))
+		Expect(resultBuffer).To(gbytes.Say(`elastic IP\s+2.2.2.2\s+gone\s+unassociated, and its environment is gone\n`))
+		Expect(resultBuffer).To(gbytes.Say(`key pair\s+gone\s+gone\s+its environment is gone\n`))
+		Expect(resultBuffer).To(gbytes.Say(`key pair\s+old\s+old\s+its environment is gone\n`))
+		Expect(resultBuffer).To(gbytes.Say(`stack\s+gone-concourse\s+gone\s+DELETE_FAILED\n`))
+		Expect(resultBuffer).To(gbytes.Say(`stack\s+gone-base\s+gone\s+DELETE_FAILED\n`))
+	})
+
+	It("should only look up the deleted stacks of key pairs that live stacks don't explain", func() {
+		Expect(app.GC(ctx, false)).To(Succeed())
+
+		Expect(awsClient.ListDeletedBaseStacksCall.Receives.Environments).To(Equal([]string{"old", "something"}))
+	})
+
+	It("should leave alone what environments with state or live stacks use, and resources tubes didn't name", func() {
+		Expect(app.GC(ctx, false)).To(Succeed())
+
+		contents := string(resultBuffer.Contents())
+		Expect(contents).NotTo(ContainSubstring("alpha"))
+		Expect(contents).NotTo(ContainSubstring("beta"))
+		Expect(contents).NotTo(ContainSubstring("something"))
+		Expect(contents).NotTo(ContainSubstring("3.3.3.3"))
+	})
+
+	It("should find the access keys through the BOSH user of the failed base stack", func() {
+		Expect(app.GC(ctx, false)).To(Succeed())
+
+		Expect(awsClient.GetStackResourcesCallCount).To(Equal(1))
+		Expect(awsClient.GetStackResourcesCalls[0].Receives.StackName).To(Equal("gone-base"))
+		Expect(awsClient.ListAccessKeysCall.Receives.UserName).To(Equal("gone-bosh-user"))
+	})
+
+	It("should delete them once confirmed", func() {
+		Expect(app.GC(ctx, false)).To(Succeed())
+
+		Expect(logBuffer).To(gbytes.Say("Delete these 6 resources\\? Type yes to confirm"))
+		Expect(awsClient.DeleteAccessKeyCall.Receives.UserName).To(Equal("gone-bosh-user"))
+		Expect(awsClient.DeleteAccessKeyCall.Receives.AccessKey).To(Equal("some-access-key"))
+		Expect(awsClient.ReleaseElasticIPCall.Receives.AllocationID).To(Equal("eipalloc-gone"))
+		Expect(awsClient.DeleteKeyPairCall.Receives.StackName).To(Equal("old"))
+
+		Expect(awsClient.DeleteStackCalls).To(HaveLen(2))
+		Expect(awsClient.DeleteStackCalls[0].Receives.StackName).To(Equal("gone-concourse"))
+		Expect(awsClient.DeleteStackCalls[1].Receives.StackName).To(Equal("gone-base"))
+		Expect(awsClient.WaitForStackCalls[1].Receives.StackName).To(Equal("gone-base"))
+		Expect(awsClient.WaitForStackCalls[1].Receives.Pundit).To(Equal(awsclient.CloudFormationDeletePundit{}))
+	})
+
+	Context("when the failed environment still has a state directory", func() {
+		BeforeEach(func() {
+			Expect(os.MkdirAll(filepath.Join(environmentsDir, "gone"), 0700)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(environmentsDir, "gone", "bosh-ip"), []byte("some-value"), 0600)).To(Succeed())
+		})
+
+		It("should leave it for down", func() {
+			Expect(app.GC(ctx, false)).To(Succeed())
+
+			Expect(resultBuffer.Contents()).NotTo(ContainSubstring("gone-base"))
+			Expect(resultBuffer.Contents()).NotTo(ContainSubstring("2.2.2.2"))
+			Expect(logBuffer).To(gbytes.Say("Skipping gone-concourse, since gone has a state, so run down for it instead"))
+			Expect(awsClient.GetStackResourcesCallCount).To(Equal(0))
+		})
+	})
+
+	Context("when the state of an environment is locked", func() {
+		BeforeEach(func() {
+			Expect(ioutil.WriteFile(filepath.Join(environmentsDir, "alpha", "tubes.lock"), []byte(`{"owner":"someone","command":"up"}`), 0600)).To(Succeed())
+		})
+
+		It("should refuse to run, since that run may be creating resources", func() {
+			Expect(app.GC(ctx, true)).To(MatchError(ContainSubstring("the state of alpha is locked by someone, running up")))
+			Expect(resultBuffer.Contents()).To(BeEmpty())
+			Expect(awsClient.DeleteStackCallCount).To(Equal(0))
+		})
+	})
+
+	Context("when a run takes a lock while waiting for confirmation", func() {
+		It("should delete nothing", func() {
+			app.Input = &lockingReader{
+				lockPath: filepath.Join(environmentsDir, "alpha", "tubes.lock"),
+				answer:   strings.NewReader("yes\n"),
+			}
+
+			Expect(app.GC(ctx, false)).To(MatchError(ContainSubstring("the state of alpha is locked")))
+			Expect(awsClient.DeleteStackCallCount).To(Equal(0))
+			Expect(awsClient.DeleteKeyPairCall.Receives.StackName).To(BeEmpty())
+		})
+	})
+
+	Context("when the state is kept in S3", func() {
+		var objectStore *mocks.FunctionalObjectStore
+
+		BeforeEach(func() {
+			objectStore = mocks.NewFunctionalObjectStore()
+			objectStore.Objects["some-bucket/environments/alpha/bosh-ip"] = []byte("some-value")
+			objectStore.Objects["some-bucket/environments/gone/tubes.lock"] = []byte(`{"owner":"someone","command":"up"}`)
+			app.EnvironmentStates = application.S3EnvironmentStates{Client: objectStore, Bucket: "some-bucket", Prefix: "environments"}
+		})
+
+		It("should find the states and locks there", func() {
+			Expect(app.GC(ctx, true)).To(MatchError(ContainSubstring("the state of gone is locked by someone")))
+
+			delete(objectStore.Objects, "some-bucket/environments/gone/tubes.lock")
+			Expect(app.GC(ctx, true)).To(Succeed())
+			Expect(resultBuffer.Contents()).NotTo(ContainSubstring("alpha"))
+			Expect(resultBuffer.Contents()).To(ContainSubstring("gone-base"))
+		})
+	})
+
+	Context("when the user doesn't confirm", func() {
+		It("should delete nothing", func() {
+			app.Input = strings.NewReader("no\n")
+
+			Expect(app.GC(ctx, false)).To(MatchError("not confirmed, so nothing was deleted"))
+			Expect(awsClient.DeleteStackCallCount).To(Equal(0))
+			Expect(awsClient.DeleteKeyPairCall.Receives.StackName).To(BeEmpty())
+		})
+
+		It("should treat the end of the input as no", func() {
+			app.Input = strings.NewReader("")
+
+			Expect(app.GC(ctx, false)).To(MatchError("not confirmed, so nothing was deleted"))
+		})
+	})
+
+	Context("when told yes up front", func() {
+		It("should not ask", func() {
+			app.Input = strings.NewReader("")
+
+			Expect(app.GC(ctx, true)).To(Succeed())
+			Expect(logBuffer.Contents()).NotTo(ContainSubstring("confirm"))
+			Expect(awsClient.DeleteStackCallCount).To(Equal(2))
+		})
+	})
+
+	Context("when there is nothing to collect", func() {
+		It("should say so", func() {
+			awsClient.ListEnvironmentStacksCall.Returns.Stacks = nil
+			awsClient.ListDeletedBaseStacksCall.Returns.Stacks = nil
+			awsClient.ListElasticIPsCall.Returns.ElasticIPs = nil
+
+			Expect(app.GC(ctx, false)).To(Succeed())
+			Expect(resultBuffer).To(gbytes.Say("No orphaned resources found\n"))
+		})
+	})
+
+	Context("when the failed base stack's director uses an instance profile", func() {
+		It("should not look for access keys", func() {
+			awsClient.GetStackResourcesCalls[0].Returns.Resources = map[string]string{}
+
+			Expect(app.GC(ctx, true)).To(Succeed())
+			Expect(awsClient.ListAccessKeysCall.Receives.UserName).To(BeEmpty())
+		})
+	})
+
+	Context("when listing the stacks errors", func() {
+		It("should return the error", func() {
+			awsClient.ListEnvironmentStacksCall.Returns.Error = errors.New("some error")
+			Expect(app.GC(ctx, false)).To(MatchError("some error"))
+		})
+	})
+
+	Context("when listing the deleted stacks errors", func() {
+		It("should return the error", func() {
+			awsClient.ListDeletedBaseStacksCall.Returns.Error = errors.New("some error")
+			Expect(app.GC(ctx, false)).To(MatchError("some error"))
+		})
+	})
+
+	Context("when listing the key pairs errors", func() {
+		It("should return the error", func() {
+			awsClient.ListKeyPairsCall.Returns.Error = errors.New("some error")
+			Expect(app.GC(ctx, false)).To(MatchError("some error"))
+		})
+	})
+
+	Context("when listing the Elastic IPs errors", func() {
+		It("should return the error", func() {
+			awsClient.ListElasticIPsCall.Returns.Error = errors.New("some error")
+			Expect(app.GC(ctx, false)).To(MatchError("some error"))
+		})
+	})
+
+	Context("when getting the stack resources errors", func() {
+		It("should return the error", func() {
+			awsClient.GetStackResourcesCalls[0].Returns.Error = errors.New("some error")
+			Expect(app.GC(ctx, false)).To(MatchError("some error"))
+		})
+	})
+
+	Context("when listing the access keys errors", func() {
+		It("should return the error", func() {
+			awsClient.ListAccessKeysCall.Returns.Error = errors.New("some error")
+			Expect(app.GC(ctx, false)).To(MatchError("some error"))
+		})
+	})
+
+	Context("when deleting an access key errors", func() {
+		It("should stop before deleting the rest", func() {
+			awsClient.DeleteAccessKeyCall.Returns.Error = errors.New("some error")
+
+			Expect(app.GC(ctx, true)).To(MatchError("some error"))
+			Expect(awsClient.DeleteStackCallCount).To(Equal(0))
+		})
+	})
+
+	Context("when releasing an Elastic IP errors", func() {
+		It("should return the error", func() {
+			awsClient.ReleaseElasticIPCall.Returns.Error = errors.New("some error")
+			Expect(app.GC(ctx, true)).To(MatchError("some error"))
+		})
+	})
+
+	Context("when deleting a stack errors", func() {
+		It("should return the error", func() {
+			awsClient.DeleteStackCalls = make([]mocks.DeleteStackCall, 1)
+			awsClient.DeleteStackCalls[0].Returns.Error = errors.New("some error")
+			Expect(app.GC(ctx, true)).To(MatchError("some error"))
+		})
+	})
+
+	Context("when waiting for a stack to delete errors", func() {
+		It("should return the error", func() {
+			awsClient.WaitForStackCalls = make([]mocks.WaitForStackCall, 1)
+			awsClient.WaitForStackCalls[0].Returns.Error = errors.New("some error")
+			Expect(app.GC(ctx, true)).To(MatchError("some error"))
+		})
+	})
+
+	Context("when writing the report errors", func() {
+		It("should return the error", func() {
+			app.ResultWriter = &erroringWriter{}
+			Expect(app.GC(ctx, true)).To(MatchError("write failed"))
+		})
+	})
+})
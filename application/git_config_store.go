@@ -0,0 +1,165 @@
+package application
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// GitConfigStore commits every change the underlying Store makes to the git
+// repository containing RootDir, the state directory
+type GitConfigStore struct {
+	Store   configStore
+	RootDir string
+
+	// Command names the tubes command in commit messages
+	Command string
+
+	// Force allows changes when the state directory has uncommitted changes
+	Force bool
+
+	checkedClean bool
+}
+
+func (s *GitConfigStore) git(args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = s.RootDir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %s: %s", args[0], err, strings.TrimSpace(string(output)))
+	}
+	return output, nil
+}
+
+func (s *GitConfigStore) uncommitted(paths ...string) ([]byte, error) {
+	return s.git(append([]string{"status", "--porcelain", "--untracked-files=all", "--"}, paths...)...)
+}
+
+// EnsureClean errors if the state directory has uncommitted changes, unless forced
+func (s *GitConfigStore) EnsureClean() error {
+	if s.checkedClean || s.Force {
+		return nil
+	}
+
+	// the lock file is expected while a command runs
+	status, err := s.uncommitted(".", ":(exclude)"+stateLockKey)
+	if err != nil {
+		return err
+	}
+	if len(status) > 0 {
+		return fmt.Errorf("the state directory has uncommitted changes, commit them or use --force:\n%s", status)
+	}
+
+	s.checkedClean = true
+	return nil
+}
+
+func (s *GitConfigStore) commit(message string, keys ...string) error {
+	paths := []string{}
+	for _, key := range keys {
+		paths = append(paths, filepath.FromSlash(key))
+	}
+
+	status, err := s.uncommitted(paths...)
+	if err != nil {
+		return err
+	}
+	if len(status) == 0 {
+		return nil
+	}
+
+	_, err = s.git(append([]string{"add", "--"}, paths...)...)
+	if err != nil {
+		return err
+	}
+	_, err = s.git(append([]string{"commit", "--quiet", "-m", message, "--"}, paths...)...)
+	return err
+}
+
+func (s *GitConfigStore) Get(key string) ([]byte, error) {
+	return s.Store.Get(key)
+}
+
+// Set refuses to run on a dirty state directory, unless forced, and commits the new value
+func (s *GitConfigStore) Set(key string, value []byte) error {
+	err := s.EnsureClean()
+	if err != nil {
+		return err
+	}
+
+	err = s.Store.Set(key, value)
+	if err != nil {
+		return err
+	}
+
+	return s.commit(fmt.Sprintf("tubes %s: set %s", s.Command, key), key)
+}
+
+// Delete refuses to run on a dirty state directory, unless forced, and commits the removal
+func (s *GitConfigStore) Delete(key string) error {
+	err := s.EnsureClean()
+	if err != nil {
+		return err
+	}
+
+	err = s.Store.Delete(key)
+	if err != nil {
+		return err
+	}
+
+	return s.commit(fmt.Sprintf("tubes %s: delete %s", s.Command, key), key)
+}
+
+func (s *GitConfigStore) List() ([]string, error) {
+	return s.Store.List()
+}
+
+func (s *GitConfigStore) IsEmpty() (bool, error) {
+	return s.Store.IsEmpty()
+}
+
+func (s *GitConfigStore) lockingStore() (lockingConfigStore, error) {
+	store, ok := s.Store.(lockingConfigStore)
+	if !ok {
+		return nil, fmt.Errorf("the state directory store doesn't support encryption")
+	}
+	return store, nil
+}
+
+// Unlock never commits, so that plaintext stays out of the repository
+func (s *GitConfigStore) Unlock() ([]string, error) {
+	store, err := s.lockingStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.Unlock()
+}
+
+// Lock commits the newly sealed values, since they differ from the committed ones
+func (s *GitConfigStore) Lock() ([]string, error) {
+	store, err := s.lockingStore()
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := store.Lock()
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return keys, nil
+	}
+
+	return keys, s.commit(fmt.Sprintf("tubes %s: seal %s", s.Command, strings.Join(keys, ", ")), keys...)
+}
+
+// History lists the commits that changed the state directory, newest first
+func (s *GitConfigStore) History() ([]byte, error) {
+	return s.git("log", "--format=%h %ad %s", "--date=iso", "--", ".")
+}
+
+// Diff shows how the state directory changed since the given revision
+func (s *GitConfigStore) Diff(revision string) ([]byte, error) {
+	return s.git("diff", revision, "--", ".")
+}
@@ -0,0 +1,161 @@
+package application_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rosenhouse/tubes/application"
+)
+
+var _ = Describe("Git Config Store", func() {
+	var (
+		repoDir  string
+		stateDir string
+		store    *application.GitConfigStore
+	)
+
+	git := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repoDir
+		output, err := cmd.CombinedOutput()
+		Expect(err).NotTo(HaveOccurred(), string(output))
+		return string(output)
+	}
+
+	BeforeEach(func() {
+		var err error
+		repoDir, err = ioutil.TempDir("", "tubes-unit-test-")
+		Expect(err).NotTo(HaveOccurred())
+		git("init", "--quiet")
+		git("config", "user.name", "some-user")
+		git("config", "user.email", "some-user@example.com")
+
+		stateDir = filepath.Join(repoDir, "environments", "some-env")
+		Expect(os.MkdirAll(stateDir, 0700)).To(Succeed())
+
+		store = &application.GitConfigStore{
+			Store:   &application.FilesystemConfigStore{RootDir: stateDir},
+			RootDir: stateDir,
+			Command: "up",
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(repoDir)).To(Succeed())
+	})
+
+	It("should commit each change, naming the command and key", func() {
+		Expect(store.Set("bosh-ip", []byte("some-ip"))).To(Succeed())
+		Expect(store.Set("nat-ip", []byte("some-nat-ip"))).To(Succeed())
+
+		Expect(git("log", "--format=%s")).To(Equal("tubes up: set nat-ip\ntubes up: set bosh-ip\n"))
+		Expect(git("status", "--porcelain")).To(BeEmpty())
+		Expect(store.Get("bosh-ip")).To(Equal([]byte("some-ip")))
+	})
+
+	It("should not commit when the value is unchanged", func() {
+		Expect(store.Set("bosh-ip", []byte("some-ip"))).To(Succeed())
+		Expect(store.Set("bosh-ip", []byte("some-ip"))).To(Succeed())
+
+		Expect(strings.Count(git("log", "--format=%s"), "\n")).To(Equal(1))
+	})
+
+	It("should only commit files in the state directory", func() {
+		Expect(ioutil.WriteFile(filepath.Join(repoDir, "other-file"), []byte("other"), 0600)).To(Succeed())
+		git("add", "other-file")
+
+		Expect(store.Set("bosh-ip", []byte("some-ip"))).To(Succeed())
+		Expect(git("status", "--porcelain")).To(Equal("A  other-file\n"))
+	})
+
+	It("should commit deletions", func() {
+		Expect(store.Set("bosh-ip", []byte("some-ip"))).To(Succeed())
+		store.Command = "down"
+		Expect(store.Delete("bosh-ip")).To(Succeed())
+
+		Expect(git("log", "--format=%s")).To(HavePrefix("tubes down: delete bosh-ip\n"))
+		Expect(git("status", "--porcelain")).To(BeEmpty())
+		Expect(store.List()).To(BeEmpty())
+	})
+
+	It("should not count the lock file as an uncommitted change", func() {
+		Expect(ioutil.WriteFile(filepath.Join(stateDir, "tubes.lock"), []byte("{}"), 0600)).To(Succeed())
+
+		Expect(store.EnsureClean()).To(Succeed())
+	})
+
+	It("should list the history and diff past states", func() {
+		Expect(store.Set("bosh-ip", []byte("old-ip"))).To(Succeed())
+		firstRevision := strings.TrimSpace(git("rev-parse", "HEAD"))
+		Expect(store.Set("bosh-ip", []byte("new-ip"))).To(Succeed())
+
+		history, err := store.History()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(history)).To(MatchRegexp(`(?m)^[0-9a-f]+ .* tubes up: set bosh-ip\n[0-9a-f]+ .* tubes up: set bosh-ip\n$`))
+
+		diff, err := store.Diff(firstRevision)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(diff)).To(ContainSubstring("-old-ip"))
+		Expect(string(diff)).To(ContainSubstring("+new-ip"))
+	})
+
+	Context("when the state directory has uncommitted changes", func() {
+		BeforeEach(func() {
+			Expect(ioutil.WriteFile(filepath.Join(stateDir, "stray"), []byte("stray"), 0600)).To(Succeed())
+		})
+
+		It("should refuse to change anything", func() {
+			err := store.Set("bosh-ip", []byte("some-ip"))
+			Expect(err).To(MatchError(ContainSubstring("uncommitted changes")))
+
+			_, err = os.Stat(filepath.Join(stateDir, "bosh-ip"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It("should go ahead when forced, leaving the other changes uncommitted", func() {
+			store.Force = true
+
+			Expect(store.Set("bosh-ip", []byte("some-ip"))).To(Succeed())
+			Expect(git("status", "--porcelain")).To(ContainSubstring("stray"))
+			Expect(git("log", "--format=%s")).To(Equal("tubes up: set bosh-ip\n"))
+		})
+	})
+
+	Describe("Lock and Unlock", func() {
+		BeforeEach(func() {
+			store.Store = &application.EncryptedConfigStore{
+				Store:      &application.FilesystemConfigStore{RootDir: stateDir},
+				Passphrase: []byte("some-passphrase"),
+				SealedKeys: []string{"bosh-password"},
+			}
+			Expect(store.Set("bosh-password", []byte("some-password"))).To(Succeed())
+		})
+
+		It("should not commit the plaintext, and commit the newly sealed values", func() {
+			store.Command = "unlock"
+			Expect(store.Unlock()).To(Equal([]string{"bosh-password"}))
+			Expect(git("log", "-p")).NotTo(ContainSubstring("some-password"))
+
+			store.Command = "lock"
+			Expect(store.Lock()).To(Equal([]string{"bosh-password"}))
+			Expect(git("log", "--format=%s")).To(HavePrefix("tubes lock: seal bosh-password\n"))
+			Expect(git("log", "-p")).NotTo(ContainSubstring("some-password"))
+			Expect(git("status", "--porcelain")).To(BeEmpty())
+		})
+	})
+
+	Context("when the state directory is not in a git repository", func() {
+		It("should return an error", func() {
+			notARepo, err := ioutil.TempDir("", "tubes-unit-test-")
+			Expect(err).NotTo(HaveOccurred())
+			store.RootDir = notARepo
+
+			Expect(store.Set("bosh-ip", []byte("some-ip"))).To(MatchError(ContainSubstring("not a git repository")))
+		})
+	})
+})
@@ -0,0 +1,36 @@
+package application
+
+import (
+	"fmt"
+	"strings"
+)
+
+// History lists the past states of the state directory, or with a revision,
+// shows how it changed since then
+func (a *Application) History(stackName string, diffRevision string) error {
+	err := validateStackName(stackName)
+	if err != nil {
+		return err
+	}
+
+	store, ok := a.ConfigStore.(historyConfigStore)
+	if !ok {
+		return fmt.Errorf("the state directory isn't tracked in git, use --git")
+	}
+
+	var output []byte
+	if diffRevision == "" {
+		output, err = store.History()
+	} else {
+		if strings.HasPrefix(diffRevision, "-") {
+			return fmt.Errorf("invalid revision %q", diffRevision)
+		}
+		output, err = store.Diff(diffRevision)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = a.ResultWriter.Write(output)
+	return err
+}
@@ -0,0 +1,65 @@
+package application_test
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/rosenhouse/tubes/mocks"
+)
+
+var _ = Describe("History", func() {
+	var historyStore *mocks.HistoryConfigStore
+
+	BeforeEach(func() {
+		historyStore = &mocks.HistoryConfigStore{FunctionalConfigStore: configStore}
+		app.ConfigStore = historyStore
+	})
+
+	It("should print the history of the state directory", func() {
+		historyStore.HistoryCall.Returns.Output = []byte("abc1234 some-date tubes up: set director.yml\n")
+
+		Expect(app.History(stackName, "")).To(Succeed())
+		Expect(resultBuffer).To(gbytes.Say("abc1234 some-date tubes up: set director.yml\n"))
+	})
+
+	It("should print the changes since a revision", func() {
+		historyStore.DiffCall.Returns.Output = []byte("some-diff")
+
+		Expect(app.History(stackName, "abc1234")).To(Succeed())
+		Expect(historyStore.DiffCall.Receives.Revision).To(Equal("abc1234"))
+		Expect(resultBuffer).To(gbytes.Say("some-diff"))
+	})
+
+	Context("when the revision looks like a flag", func() {
+		It("should return an error", func() {
+			Expect(app.History(stackName, "--output=/tmp/x")).To(MatchError(ContainSubstring("invalid revision")))
+			Expect(historyStore.DiffCall.Receives.Revision).To(BeEmpty())
+		})
+	})
+
+	Context("when git errors", func() {
+		It("should return the error", func() {
+			historyStore.HistoryCall.Returns.Error = errors.New("some error")
+			Expect(app.History(stackName, "")).To(MatchError("some error"))
+
+			historyStore.DiffCall.Returns.Error = errors.New("some other error")
+			Expect(app.History(stackName, "HEAD")).To(MatchError("some other error"))
+		})
+	})
+
+	Context("when the state directory isn't tracked in git", func() {
+		It("should return an error", func() {
+			app.ConfigStore = configStore
+
+			Expect(app.History(stackName, "")).To(MatchError(ContainSubstring("use --git")))
+		})
+	})
+
+	Context("when the name is invalid", func() {
+		It("should immediately error", func() {
+			Expect(app.History("invalid_name", "")).To(MatchError(ContainSubstring("invalid name")))
+		})
+	})
+})
@@ -0,0 +1,72 @@
+package application
+
+import (
+	"fmt"
+	"sort"
+	"text/tabwriter"
+)
+
+type environmentListing struct {
+	hasState bool
+	stacks   map[string]string // role to status
+	owner    string
+}
+
+// List prints every environment with a state in EnvironmentStates, or with
+// stacks in the AWS account, side by side.  Stacks that tubes created but that
+// have no state there are flagged as orphans.
+func (a *Application) List() error {
+	listings := map[string]*environmentListing{}
+	listing := func(name string) *environmentListing {
+		if listings[name] == nil {
+			listings[name] = &environmentListing{stacks: map[string]string{}}
+		}
+		return listings[name]
+	}
+
+	names, err := a.EnvironmentStates.Names()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		listing(name).hasState = true
+	}
+
+	stacks, err := a.AWSClient.ListEnvironmentStacks()
+	if err != nil {
+		return err
+	}
+	for _, stack := range stacks {
+		l := listing(stack.Environment)
+		l.stacks[stack.Role] = stack.Status
+		if l.owner == "" {
+			l.owner = stack.Owner
+		}
+	}
+
+	names = []string{}
+	for name := range listings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	table := tabwriter.NewWriter(a.ResultWriter, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(table, "ENVIRONMENT\tSTATE\tBASE STACK\tCONCOURSE STACK\tOWNER\t")
+	for _, name := range names {
+		l := listings[name]
+		state, note := a.EnvironmentStates.Kind(), ""
+		if !l.hasState {
+			state, note = "-", "orphan: no state"
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n", name, state,
+			orDash(l.stacks["base"]), orDash(l.stacks["concourse"]), orDash(l.owner), note)
+	}
+	return table.Flush()
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,98 @@
+package application_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/rosenhouse/tubes/application"
+	"github.com/rosenhouse/tubes/lib/awsclient"
+	"github.com/rosenhouse/tubes/mocks"
+)
+
+var _ = Describe("List", func() {
+	var environmentsDir string
+
+	writeState := func(name, key string) {
+		Expect(os.MkdirAll(filepath.Join(environmentsDir, name), 0700)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(environmentsDir, name, key), []byte("some-value"), 0600)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		tempDir, err := ioutil.TempDir("", "tubes-unit-test-")
+		Expect(err).NotTo(HaveOccurred())
+		environmentsDir = filepath.Join(tempDir, "environments")
+
+		app.EnvironmentStates = application.LocalEnvironmentStates{Dir: environmentsDir}
+
+		writeState("alpha", "bosh-ip")
+		writeState("gamma", "ssh-key")
+		Expect(os.MkdirAll(filepath.Join(environmentsDir, "empty"), 0700)).To(Succeed())
+
+		awsClient.ListEnvironmentStacksCall.Returns.Stacks = []awsclient.EnvironmentStack{
+			{StackName: "alpha-base", Environment: "alpha", Role: "base", Owner: "alice@laptop", Status: "UPDATE_COMPLETE"},
+			{StackName: "alpha-concourse", Environment: "alpha", Role: "concourse", Owner: "alice@laptop", Status: "CREATE_COMPLETE"},
+			{StackName: "beta-base", Environment: "beta", Role: "base", Owner: "bob@desktop", Status: "CREATE_COMPLETE"},
+		}
+	})
+
+	It("should list the state directories and the stacks side by side, sorted by name", func() {
+		Expect(app.List()).To(Succeed())
+
+		Expect(resultBuffer).To(gbytes.Say(`ENVIRONMENT\s+STATE\s+BASE STACK\s+CONCOURSE STACK\s+OWNER\s*\n`))
+		Expect(resultBuffer).To(gbytes.Say(`alpha\s+local\s+UPDATE_COMPLETE\s+CREATE_COMPLETE\s+alice@laptop\s*\n`))
+		Expect(resultBuffer).To(gbytes.Say(`beta\s+-\s+CREATE_COMPLETE\s+-\s+bob@desktop\s+orphan: no state\n`))
+		Expect(resultBuffer).To(gbytes.Say(`gamma\s+local\s+-\s+-\s+-\s*\n`))
+	})
+
+	It("should skip empty state directories", func() {
+		Expect(app.List()).To(Succeed())
+
+		Expect(resultBuffer.Contents()).NotTo(ContainSubstring("empty"))
+	})
+
+	Context("when there are no state directories", func() {
+		It("should list just the stacks", func() {
+			app.EnvironmentStates = application.LocalEnvironmentStates{Dir: filepath.Join(environmentsDir, "missing")}
+
+			Expect(app.List()).To(Succeed())
+
+			Expect(resultBuffer).To(gbytes.Say(`alpha\s+-\s+UPDATE_COMPLETE\s+CREATE_COMPLETE\s+alice@laptop\s+orphan`))
+		})
+	})
+
+	Context("when the state is kept in S3", func() {
+		It("should list the environments under the prefix", func() {
+			objectStore := mocks.NewFunctionalObjectStore()
+			objectStore.Objects["some-bucket/environments/alpha/bosh-ip"] = []byte("some-value")
+			app.EnvironmentStates = application.S3EnvironmentStates{Client: objectStore, Bucket: "some-bucket", Prefix: "environments"}
+
+			Expect(app.List()).To(Succeed())
+
+			Expect(resultBuffer).To(gbytes.Say(`alpha\s+s3\s+UPDATE_COMPLETE\s+CREATE_COMPLETE\s+alice@laptop\s*\n`))
+			Expect(resultBuffer).To(gbytes.Say(`beta\s+-\s+CREATE_COMPLETE`))
+		})
+	})
+
+	Context("when listing the stacks errors", func() {
+		It("should return the error", func() {
+			awsClient.ListEnvironmentStacksCall.Returns.Error = errors.New("some error")
+
+			Expect(app.List()).To(MatchError("some error"))
+		})
+	})
+
+	Context("when the environments directory can't be read", func() {
+		It("should return the error", func() {
+			Expect(ioutil.WriteFile(filepath.Join(environmentsDir, "not-a-dir"), nil, 0600)).To(Succeed())
+
+			app.EnvironmentStates = application.LocalEnvironmentStates{Dir: filepath.Join(environmentsDir, "not-a-dir")}
+
+			Expect(app.List()).To(HaveOccurred())
+		})
+	})
+})
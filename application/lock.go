@@ -0,0 +1,61 @@
+package application
+
+import (
+	"fmt"
+	"strings"
+)
+
+func (a *Application) lockingStore(stackName string) (lockingConfigStore, error) {
+	err := validateStackName(stackName)
+	if err != nil {
+		return nil, err
+	}
+
+	store, ok := a.ConfigStore.(lockingConfigStore)
+	if !ok {
+		return nil, fmt.Errorf("the state directory store doesn't support encryption")
+	}
+	return store, nil
+}
+
+// Unlock writes the encrypted values in the state directory back as
+// plaintext, e.g. to source bosh-environment.  Run Lock when done.
+func (a *Application) Unlock(stackName string) error {
+	store, err := a.lockingStore(stackName)
+	if err != nil {
+		return err
+	}
+
+	keys, err := store.Unlock()
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		a.Logger.Println("Nothing to unlock")
+		return nil
+	}
+
+	a.Logger.Printf("Unlocked %s\n", strings.Join(keys, ", "))
+	a.Logger.Printf("These are plaintext until you run: tubes -n %s lock\n", stackName)
+	return nil
+}
+
+// Lock encrypts any plaintext secrets in the state directory
+func (a *Application) Lock(stackName string) error {
+	store, err := a.lockingStore(stackName)
+	if err != nil {
+		return err
+	}
+
+	keys, err := store.Lock()
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		a.Logger.Println("Nothing to lock")
+		return nil
+	}
+
+	a.Logger.Printf("Locked %s\n", strings.Join(keys, ", "))
+	return nil
+}
@@ -0,0 +1,51 @@
+package application_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/rosenhouse/tubes/application"
+)
+
+var _ = Describe("Lock and Unlock", func() {
+	BeforeEach(func() {
+		app.ConfigStore = &application.EncryptedConfigStore{
+			Store:      configStore,
+			Passphrase: []byte("some-passphrase"),
+			SealedKeys: []string{"bosh-password"},
+		}
+		Expect(app.ConfigStore.Set("bosh-password", []byte("some-password"))).To(Succeed())
+	})
+
+	It("should unlock the secrets, and lock them again", func() {
+		Expect(app.Unlock(stackName)).To(Succeed())
+		Expect(configStore.Values).To(HaveKeyWithValue("bosh-password", []byte("some-password")))
+		Expect(logBuffer).To(gbytes.Say("Unlocked bosh-password"))
+		Expect(logBuffer).To(gbytes.Say("plaintext until you run: tubes -n " + stackName + " lock"))
+
+		Expect(app.Lock(stackName)).To(Succeed())
+		Expect(configStore.Values["bosh-password"]).To(HavePrefix("tubes-secretbox-v1\n"))
+		Expect(logBuffer).To(gbytes.Say("Locked bosh-password"))
+	})
+
+	It("should say when there is nothing to do", func() {
+		Expect(app.Lock(stackName)).To(Succeed())
+		Expect(logBuffer).To(gbytes.Say("Nothing to lock"))
+	})
+
+	Context("when the config store doesn't support encryption", func() {
+		It("should return an error", func() {
+			app.ConfigStore = configStore
+
+			Expect(app.Unlock(stackName)).To(MatchError(ContainSubstring("doesn't support encryption")))
+			Expect(app.Lock(stackName)).To(MatchError(ContainSubstring("doesn't support encryption")))
+		})
+	})
+
+	Context("when the name is invalid", func() {
+		It("should immediately error", func() {
+			Expect(app.Unlock("invalid_name")).To(MatchError(ContainSubstring("invalid name")))
+			Expect(app.Lock("invalid_name")).To(MatchError(ContainSubstring("invalid name")))
+		})
+	})
+})
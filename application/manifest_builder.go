@@ -2,7 +2,6 @@ package application
 
 import (
 	"fmt"
-	"net"
 
 	"github.com/rosenhouse/tubes/lib/awsclient"
 	"github.com/rosenhouse/tubes/lib/director"
@@ -46,16 +45,6 @@ func (b *ManifestBuilder) getLatestSoftware() (director.Software, error) {
 	return config, nil
 }
 
-func (b *ManifestBuilder) getInternalIP(subnetCIDR string) (string, error) {
-	ip, _, err := net.ParseCIDR(subnetCIDR)
-	if err != nil {
-		return "", err
-	}
-	ip = ip.To4()
-	ip = director.IncrementIP(ip, 6)
-	return ip.To4().String(), nil
-}
-
 func (b *ManifestBuilder) getAWSNetwork(resources awsclient.BaseStackResources) director.AWSNetwork {
 	return director.AWSNetwork{
 		AvailabilityZone: resources.AvailabilityZone,
@@ -66,32 +55,43 @@ func (b *ManifestBuilder) getAWSNetwork(resources awsclient.BaseStackResources)
 	}
 }
 
-func (b *ManifestBuilder) Build(stackName string, resources awsclient.BaseStackResources, accessKey, secretKey string) ([]byte, string, error) {
-	if accessKey == "" {
-		return nil, "", fmt.Errorf("missing access key")
-	}
-	if secretKey == "" {
-		return nil, "", fmt.Errorf("missing secret key")
+// Build renders the bosh-init manifest for the director.  Credentials are
+// generated unless existing ones are provided.  The access keys are only
+// needed when the base stack has no instance profile for the director.
+// The director is named after the environment, so that down can tell its
+// disks from those of other environments in the same zones.
+func (b *ManifestBuilder) Build(stackName string, resources awsclient.BaseStackResources, accessKey, secretKey string, credentials director.Credentials, ssl director.SSL) ([]byte, director.Credentials, error) {
+	if resources.DirectorInstanceProfile == "" {
+		if accessKey == "" {
+			return nil, credentials, fmt.Errorf("missing access key")
+		}
+		if secretKey == "" {
+			return nil, credentials, fmt.Errorf("missing secret key")
+		}
 	}
 
-	config := director.DirectorConfig{}
+	config := director.DirectorConfig{Name: stackName}
 
 	var err error
 	config.Software, err = b.getLatestSoftware()
 	if err != nil {
-		return nil, "", err
+		return nil, credentials, err
 	}
 
-	err = b.CredentialsGenerator.Fill(&config.Credentials)
-	if err != nil {
-		return nil, "", err
+	config.Credentials = credentials
+	if config.Credentials == (director.Credentials{}) {
+		err = b.CredentialsGenerator.Fill(&config.Credentials)
+		if err != nil {
+			return nil, credentials, err
+		}
 	}
 
-	config.InternalIP, err = b.getInternalIP(resources.BOSHSubnetCIDR)
+	config.InternalIP, err = director.InternalIP(resources.BOSHSubnetCIDR)
 	if err != nil {
-		return nil, "", err
+		return nil, credentials, err
 	}
 
+	config.SSL = ssl
 	config.AWSNetwork = b.getAWSNetwork(resources)
 	config.AWSSSHKey.Name = stackName
 	config.AWSSSHKey.Path = "./ssh-key"
@@ -99,12 +99,13 @@ func (b *ManifestBuilder) Build(stackName string, resources awsclient.BaseStackR
 		Region:          resources.AWSRegion,
 		AccessKeyID:     accessKey,
 		SecretAccessKey: secretKey,
+		InstanceProfile: resources.DirectorInstanceProfile,
 	}
 
 	manifest, err := b.DirectorManifestGenerator.Generate(config)
 	if err != nil {
-		return nil, "", err
+		return nil, credentials, err
 	}
 
-	return []byte(manifest.String()), config.Credentials.Admin, nil
+	return []byte(manifest.String()), config.Credentials, nil
 }
@@ -21,6 +21,8 @@ var _ = Describe("ManifestBuilder", func() {
 		baseStackResources        awsclient.BaseStackResources
 		stackName                 string
 		accessKey, secretKey      string
+		existingCredentials       director.Credentials
+		ssl                       director.SSL
 
 		manifestBuilder *application.ManifestBuilder
 	)
@@ -41,6 +43,8 @@ var _ = Describe("ManifestBuilder", func() {
 		stackName = fmt.Sprintf("some-stack-name-%x", rand.Int31())
 		accessKey = fmt.Sprintf("some-access-key-%x", rand.Int31())
 		secretKey = fmt.Sprintf("some-secret-key-%x", rand.Int31())
+		existingCredentials = director.Credentials{}
+		ssl = director.SSL{Cert: "some-cert", Key: "some-key"}
 
 		manifestBuilder = &application.ManifestBuilder{
 			DirectorManifestGenerator: directorManifestGenerator,
@@ -65,7 +69,7 @@ var _ = Describe("ManifestBuilder", func() {
 
 	Describe("configuring the software artifacts", func() {
 		It("should discover the latest software", func() {
-			_, _, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey)
+			_, _, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey, existingCredentials, ssl)
 			Expect(err).NotTo(HaveOccurred())
 
 			Expect(boshioClient.LatestStemcellCall.Receives.StemcellName).To(Equal("bosh-aws-xen-hvm-ubuntu-trusty-go_agent"))
@@ -74,7 +78,7 @@ var _ = Describe("ManifestBuilder", func() {
 		})
 
 		It("should pass the resulting software config to the director manifest generator", func() {
-			_, _, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey)
+			_, _, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey, existingCredentials, ssl)
 			Expect(err).NotTo(HaveOccurred())
 
 			software := directorManifestGenerator.GenerateCall.Receives.Config.Software
@@ -95,17 +99,17 @@ var _ = Describe("ManifestBuilder", func() {
 		Context("when the boshio client errors", func() {
 			It("should return stemcell errors", func() {
 				boshioClient.LatestStemcellCall.Returns.Error = errors.New("some error")
-				_, _, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey)
+				_, _, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey, existingCredentials, ssl)
 				Expect(err).To(MatchError("some error"))
 			})
 			It("should return aws cpi release errors", func() {
 				boshioClient.LatestReleaseCalls[0].Returns.Error = errors.New("some error")
-				_, _, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey)
+				_, _, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey, existingCredentials, ssl)
 				Expect(err).To(MatchError("some error"))
 			})
 			It("should return bosh director release errors", func() {
 				boshioClient.LatestReleaseCalls[1].Returns.Error = errors.New("some error")
-				_, _, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey)
+				_, _, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey, existingCredentials, ssl)
 				Expect(err).To(MatchError("some error"))
 			})
 		})
@@ -113,33 +117,60 @@ var _ = Describe("ManifestBuilder", func() {
 
 	Describe("configuring bosh director credentials", func() {
 		It("should generate new credentials", func() {
-			_, _, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey)
+			_, _, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey, existingCredentials, ssl)
 
 			Expect(err).NotTo(HaveOccurred())
 			credentials := directorManifestGenerator.GenerateCall.Receives.Config.Credentials
 
 			Expect(credentials.MBus).To(Equal("some-MBus-password"))
 		})
-		It("should return the admin password to the caller", func() {
-			_, password, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey)
+		It("should return the credentials to the caller", func() {
+			_, credentials, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey, existingCredentials, ssl)
 
 			Expect(err).NotTo(HaveOccurred())
-			Expect(password).To(Equal("some-admin-password"))
+			Expect(credentials.Admin).To(Equal("some-admin-password"))
+			Expect(credentials.MBus).To(Equal("some-MBus-password"))
+		})
+		Context("when existing credentials are provided", func() {
+			BeforeEach(func() {
+				existingCredentials = director.Credentials{
+					MBus:  "some-existing-MBus-password",
+					Admin: "some-existing-admin-password",
+				}
+				credentialsGenerator.FillCallback = func(toFill interface{}) error {
+					return errors.New("should not generate credentials")
+				}
+			})
+
+			It("should reuse them instead of generating new ones", func() {
+				_, credentials, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey, existingCredentials, ssl)
+
+				Expect(err).NotTo(HaveOccurred())
+				Expect(credentials).To(Equal(existingCredentials))
+				Expect(directorManifestGenerator.GenerateCall.Receives.Config.Credentials).To(Equal(existingCredentials))
+			})
 		})
 		Context("when the credential generation fails", func() {
 			It("should return the error", func() {
 				credentialsGenerator.FillCallback = func(toFill interface{}) error {
 					return errors.New("filler error (ha ha)")
 				}
-				_, _, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey)
+				_, _, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey, existingCredentials, ssl)
 				Expect(err).To(MatchError("filler error (ha ha)"))
 			})
 		})
 	})
 
+	It("should give the director its TLS certificate", func() {
+		_, _, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey, existingCredentials, ssl)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(directorManifestGenerator.GenerateCall.Receives.Config.SSL).To(Equal(ssl))
+	})
+
 	Describe("configuring IPs and IDs", func() {
 		It("should set the internal IP of the director to the CIDR base address + 6", func() {
-			_, _, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey)
+			_, _, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey, existingCredentials, ssl)
 			Expect(err).NotTo(HaveOccurred())
 
 			internalIP := directorManifestGenerator.GenerateCall.Receives.Config.InternalIP
@@ -147,14 +178,14 @@ var _ = Describe("ManifestBuilder", func() {
 		})
 		It("should work even with weird subnet sizes", func() {
 			baseStackResources.BOSHSubnetCIDR = "10.0.0.128/25"
-			_, _, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey)
+			_, _, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey, existingCredentials, ssl)
 			Expect(err).NotTo(HaveOccurred())
 
 			internalIP := directorManifestGenerator.GenerateCall.Receives.Config.InternalIP
 			Expect(internalIP).To(Equal("10.0.0.134"))
 		})
 		It("should set the network config for AWS", func() {
-			_, _, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey)
+			_, _, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey, existingCredentials, ssl)
 			Expect(err).NotTo(HaveOccurred())
 
 			awsConfig := directorManifestGenerator.GenerateCall.Receives.Config.AWSNetwork
@@ -168,7 +199,7 @@ var _ = Describe("ManifestBuilder", func() {
 		Context("when the subnet CIDR is malformed", func() {
 			It("should reeturn the error", func() {
 				baseStackResources.BOSHSubnetCIDR = "invalid-cidr"
-				_, _, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey)
+				_, _, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey, existingCredentials, ssl)
 				Expect(err).To(MatchError("invalid CIDR address: invalid-cidr"))
 			})
 		})
@@ -176,15 +207,21 @@ var _ = Describe("ManifestBuilder", func() {
 
 	Describe("configuring aws credentials", func() {
 		It("should assume the ssh key name and path based on the stack name", func() {
-			_, _, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey)
+			_, _, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey, existingCredentials, ssl)
 			Expect(err).NotTo(HaveOccurred())
 
 			awsSSHKey := directorManifestGenerator.GenerateCall.Receives.Config.AWSSSHKey
 			Expect(awsSSHKey.Name).To(Equal(stackName))
 			Expect(awsSSHKey.Path).To(Equal("./ssh-key"))
 		})
+		It("should name the director after the stack", func() {
+			_, _, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey, existingCredentials, ssl)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(directorManifestGenerator.GenerateCall.Receives.Config.Name).To(Equal(stackName))
+		})
 		It("should set the region, access key and secret key", func() {
-			_, _, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey)
+			_, _, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey, existingCredentials, ssl)
 			Expect(err).NotTo(HaveOccurred())
 
 			awsCredentials := directorManifestGenerator.GenerateCall.Receives.Config.AWSCredentials
@@ -197,19 +234,34 @@ var _ = Describe("ManifestBuilder", func() {
 
 		Context("when the access key or secret key are empty", func() {
 			It("should error", func() {
-				_, _, err := manifestBuilder.Build(stackName, baseStackResources, "", secretKey)
+				_, _, err := manifestBuilder.Build(stackName, baseStackResources, "", secretKey, existingCredentials, ssl)
 				Expect(err).To(MatchError("missing access key"))
 
-				_, _, err = manifestBuilder.Build(stackName, baseStackResources, accessKey, "")
+				_, _, err = manifestBuilder.Build(stackName, baseStackResources, accessKey, "", existingCredentials, ssl)
 				Expect(err).To(MatchError("missing secret key"))
 			})
 		})
+
+		Context("when the base stack has an instance profile for the director", func() {
+			It("should use the profile, without needing access keys", func() {
+				baseStackResources.DirectorInstanceProfile = "some-instance-profile"
+
+				_, _, err := manifestBuilder.Build(stackName, baseStackResources, "", "", existingCredentials, ssl)
+				Expect(err).NotTo(HaveOccurred())
+
+				awsCredentials := directorManifestGenerator.GenerateCall.Receives.Config.AWSCredentials
+				Expect(awsCredentials).To(Equal(director.AWSCredentials{
+					Region:          "some-region",
+					InstanceProfile: "some-instance-profile",
+				}))
+			})
+		})
 	})
 
 	Describe("assembling the config into YAML", func() {
 		It("should return the generated manifest as YAML bytes", func() {
 			directorManifestGenerator.GenerateCall.Returns.Manifest.Name = "some-deployment-name"
-			yamlBytes, _, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey)
+			yamlBytes, _, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey, existingCredentials, ssl)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(yamlBytes).To(ContainSubstring("name: some-deployment-name"))
 		})
@@ -217,7 +269,7 @@ var _ = Describe("ManifestBuilder", func() {
 		Context("when generating the manifest errors", func() {
 			It("should return the error", func() {
 				directorManifestGenerator.GenerateCall.Returns.Error = errors.New("missing subnet")
-				_, _, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey)
+				_, _, err := manifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey, existingCredentials, ssl)
 				Expect(err).To(MatchError("missing subnet"))
 			})
 		})
@@ -0,0 +1,95 @@
+package application
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/rosenhouse/tubes/lib/awsclient"
+	"golang.org/x/net/context"
+)
+
+// Plan prints the changes that Boot would make to each stack, without making them
+func (a *Application) Plan(ctx context.Context, stackName string, requestedOptions UpOptions) error {
+	err := validateStackName(stackName)
+	if err != nil {
+		return err
+	}
+
+	options, err := a.loadUpOptions(requestedOptions)
+	if err != nil {
+		return err
+	}
+
+	baseParameters, err := a.baseStackParameters(stackName)
+	if err != nil {
+		return err
+	}
+
+	a.Logger.Println("Planning changes to base stack")
+	basePlan, err := a.AWSClient.PlanStack(ctx, stackName+"-base", awsclient.NewBaseStackTemplate(options.baseStackOptions()).String(), baseParameters)
+	if err != nil {
+		return err
+	}
+
+	var concourseParameters map[string]string
+	if basePlan.NewStack {
+		concourseParameters = concourseStackParameters(awsclient.BaseStackResources{}, options)
+		for key := range concourseParameters {
+			concourseParameters[key] = fmt.Sprintf("(from %s-base)", stackName)
+		}
+	} else {
+		baseStackResources, err := a.AWSClient.GetBaseStackResources(stackName + "-base")
+		if err != nil {
+			return err
+		}
+		concourseParameters = concourseStackParameters(baseStackResources, options)
+	}
+
+	a.Logger.Println("Planning changes to Concourse stack")
+	concoursePlan, err := a.AWSClient.PlanStack(ctx, stackName+"-concourse", awsclient.NewConcourseStackTemplate(options.concourseStackOptions()).String(), concourseParameters)
+	if err != nil {
+		return err
+	}
+
+	err = writePlan(a.ResultWriter, basePlan, baseParameters)
+	if err != nil {
+		return err
+	}
+	return writePlan(a.ResultWriter, concoursePlan, concourseParameters)
+}
+
+func writePlan(w io.Writer, plan awsclient.StackPlan, parameters map[string]string) error {
+	operation := "update"
+	if plan.NewStack {
+		operation = "create"
+	}
+	lines := []string{fmt.Sprintf("%s (%s)", plan.StackName, operation)}
+
+	lines = append(lines, "  Parameters:")
+	keys := []string{}
+	for key := range parameters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		lines = append(lines, fmt.Sprintf("    %s: %s", key, parameters[key]))
+	}
+
+	if len(plan.Changes) == 0 {
+		lines = append(lines, "  No changes")
+	} else {
+		lines = append(lines, "  Changes:")
+		for _, change := range plan.Changes {
+			lines = append(lines, fmt.Sprintf("    %-8s %s (%s)", change.Action, change.LogicalID, change.ResourceType))
+		}
+	}
+
+	for _, line := range lines {
+		_, err := fmt.Fprintln(w, line)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package application_test
+
+import (
+	"errors"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/rosenhouse/tubes/lib/awsclient"
+	"github.com/rosenhouse/tubes/mocks"
+)
+
+var _ = Describe("Plan", func() {
+	BeforeEach(func() {
+		awsClient.GetLatestNATBoxAMIIDCall.Returns.AMIID = "some-nat-box-ami-id"
+		awsClient.GetBaseStackResourcesCall.Returns.Resources =
+			awsclient.BaseStackResources{
+				NATInstanceID:    "some-nat-box-instance-id",
+				VPCID:            "some-vpc-id",
+				BOSHSubnetID:     "some-bosh-subnet-id",
+				AvailabilityZone: "some-availability-zone",
+			}
+
+		awsClient.PlanStackCalls = make([]mocks.PlanStackCall, 2)
+		awsClient.PlanStackCalls[0].Returns.Plan = awsclient.StackPlan{
+			StackName: stackName + "-base",
+			Changes: []awsclient.StackChange{
+				{Action: "Add", LogicalID: "SomeSubnet", ResourceType: "AWS::EC2::Subnet"},
+				{Action: "Replace", LogicalID: "NATInstance", ResourceType: "AWS::EC2::Instance"},
+			},
+		}
+		awsClient.PlanStackCalls[1].Returns.Plan = awsclient.StackPlan{
+			StackName: stackName + "-concourse",
+		}
+	})
+
+	It("should plan the base stack with the parameters that up would use", func() {
+		Expect(app.Plan(ctx, stackName, upOptions)).To(Succeed())
+
+		Expect(awsClient.PlanStackCalls[0].Receives.StackName).To(Equal(stackName + "-base"))
+		Expect(awsClient.PlanStackCalls[0].Receives.Template).To(Equal(awsclient.BaseStackTemplate.String()))
+		Expect(awsClient.PlanStackCalls[0].Receives.Parameters).To(Equal(map[string]string{
+			"NATInstanceAMI": "some-nat-box-ami-id",
+			"KeyName":        stackName,
+		}))
+	})
+
+	Context("when the director is private", func() {
+		It("should plan the private variant of the base stack", func() {
+			upOptions.PrivateDirector = true
+
+			Expect(app.Plan(ctx, stackName, upOptions)).To(Succeed())
+
+			Expect(awsClient.PlanStackCalls[0].Receives.Template).To(Equal(
+				awsclient.NewBaseStackTemplate(awsclient.BaseStackOptions{PrivateDirector: true}).String()))
+		})
+
+		It("should use the options recorded in the state directory", func() {
+			configStore.Values["up-options.yml"] = []byte("private_director: true\n")
+
+			Expect(app.Plan(ctx, stackName, upOptions)).To(Succeed())
+
+			Expect(awsClient.PlanStackCalls[0].Receives.Template).To(Equal(
+				awsclient.NewBaseStackTemplate(awsclient.BaseStackOptions{PrivateDirector: true}).String()))
+		})
+
+		It("should not record the options", func() {
+			upOptions.PrivateDirector = true
+
+			Expect(app.Plan(ctx, stackName, upOptions)).To(Succeed())
+
+			Expect(configStore.Values).NotTo(HaveKey("up-options.yml"))
+		})
+	})
+
+	Context("when using a NAT gateway", func() {
+		It("should plan both stacks in NAT gateway mode", func() {
+			upOptions.NATGateway = true
+			awsClient.GetBaseStackResourcesCall.Returns.Resources.NATGatewayID = "some-nat-gateway-id"
+
+			Expect(app.Plan(ctx, stackName, upOptions)).To(Succeed())
+
+			Expect(awsClient.PlanStackCalls[0].Receives.Template).To(Equal(
+				awsclient.NewBaseStackTemplate(awsclient.BaseStackOptions{NATGateway: true}).String()))
+			Expect(awsClient.PlanStackCalls[1].Receives.Template).To(Equal(
+				awsclient.NewConcourseStackTemplate(awsclient.ConcourseStackOptions{NATGateway: true}).String()))
+			Expect(awsClient.PlanStackCalls[1].Receives.Parameters).To(HaveKeyWithValue("NATGateway", "some-nat-gateway-id"))
+			Expect(awsClient.PlanStackCalls[1].Receives.Parameters).NotTo(HaveKey("NATInstance"))
+		})
+	})
+
+	Context("when spanning several availability zones", func() {
+		BeforeEach(func() {
+			upOptions.AvailabilityZones = 2
+		})
+
+		It("should plan the Concourse stack with the subnets of each zone", func() {
+			awsClient.GetBaseStackResourcesCall.Returns.Resources.Zones = []awsclient.Zone{
+				{AvailabilityZone: "some-availability-zone", PublicSubnetID: "some-bosh-subnet-id"},
+				{AvailabilityZone: "some-other-availability-zone", PublicSubnetID: "some-other-public-subnet-id"},
+			}
+
+			Expect(app.Plan(ctx, stackName, upOptions)).To(Succeed())
+
+			Expect(awsClient.PlanStackCalls[1].Receives.Template).To(Equal(
+				awsclient.NewConcourseStackTemplate(awsclient.ConcourseStackOptions{AvailabilityZones: 2}).String()))
+			Expect(awsClient.PlanStackCalls[1].Receives.Parameters).To(HaveKeyWithValue("AvailabilityZoneZ2", "some-other-availability-zone"))
+			Expect(awsClient.PlanStackCalls[1].Receives.Parameters).To(HaveKeyWithValue("PubliclyRoutableSubnetIDZ2", "some-other-public-subnet-id"))
+		})
+
+		Context("when the base stack does not exist yet", func() {
+			It("should show that those parameters will come from the base stack too", func() {
+				awsClient.PlanStackCalls[0].Returns.Plan.NewStack = true
+
+				Expect(app.Plan(ctx, stackName, upOptions)).To(Succeed())
+
+				Expect(awsClient.PlanStackCalls[1].Receives.Parameters).To(HaveKeyWithValue("PubliclyRoutableSubnetIDZ2", fmt.Sprintf("(from %s-base)", stackName)))
+			})
+		})
+	})
+
+	It("should plan the concourse stack using the resources of the base stack", func() {
+		Expect(app.Plan(ctx, stackName, upOptions)).To(Succeed())
+
+		Expect(awsClient.GetBaseStackResourcesCall.Receives.StackName).To(Equal(stackName + "-base"))
+		Expect(awsClient.PlanStackCalls[1].Receives.StackName).To(Equal(stackName + "-concourse"))
+		Expect(awsClient.PlanStackCalls[1].Receives.Template).To(Equal(awsclient.ConcourseStackTemplate.String()))
+		Expect(awsClient.PlanStackCalls[1].Receives.Parameters).To(Equal(map[string]string{
+			"VPCID":                    "some-vpc-id",
+			"NATInstance":              "some-nat-box-instance-id",
+			"PubliclyRoutableSubnetID": "some-bosh-subnet-id",
+			"AvailabilityZone":         "some-availability-zone",
+		}))
+	})
+
+	It("should print the parameters and changes for each stack", func() {
+		Expect(app.Plan(ctx, stackName, upOptions)).To(Succeed())
+
+		Expect(resultBuffer).To(gbytes.Say(fmt.Sprintf(`%s-base \(update\)`, stackName)))
+		Expect(resultBuffer).To(gbytes.Say(`KeyName: ` + stackName))
+		Expect(resultBuffer).To(gbytes.Say(`NATInstanceAMI: some-nat-box-ami-id`))
+		Expect(resultBuffer).To(gbytes.Say(`Add +SomeSubnet \(AWS::EC2::Subnet\)`))
+		Expect(resultBuffer).To(gbytes.Say(`Replace +NATInstance \(AWS::EC2::Instance\)`))
+		Expect(resultBuffer).To(gbytes.Say(fmt.Sprintf(`%s-concourse \(update\)`, stackName)))
+		Expect(resultBuffer).To(gbytes.Say(`VPCID: some-vpc-id`))
+		Expect(resultBuffer).To(gbytes.Say(`No changes`))
+	})
+
+	It("should not change anything", func() {
+		Expect(app.Plan(ctx, stackName, upOptions)).To(Succeed())
+
+		Expect(awsClient.UpsertStackCallCount).To(Equal(0))
+		Expect(awsClient.CreateKeyPairCall.Receives.StackName).To(BeEmpty())
+		Expect(configStore.Values).To(BeEmpty())
+	})
+
+	Context("when the configStore records the NAT box AMI", func() {
+		It("should plan with that AMI", func() {
+			configStore.Values["nat-ami"] = []byte("some-pinned-ami-id")
+
+			Expect(app.Plan(ctx, stackName, upOptions)).To(Succeed())
+			Expect(awsClient.PlanStackCalls[0].Receives.Parameters).To(HaveKeyWithValue("NATInstanceAMI", "some-pinned-ami-id"))
+		})
+	})
+
+	Context("when the base stack does not exist yet", func() {
+		BeforeEach(func() {
+			awsClient.PlanStackCalls[0].Returns.Plan.NewStack = true
+			awsClient.PlanStackCalls[1].Returns.Plan.NewStack = true
+		})
+
+		It("should not look up base stack resources", func() {
+			Expect(app.Plan(ctx, stackName, upOptions)).To(Succeed())
+
+			Expect(awsClient.GetBaseStackResourcesCall.Receives.StackName).To(BeEmpty())
+		})
+
+		It("should show where the concourse parameters will come from", func() {
+			Expect(app.Plan(ctx, stackName, upOptions)).To(Succeed())
+
+			Expect(awsClient.PlanStackCalls[1].Receives.Parameters).To(HaveKeyWithValue("VPCID", fmt.Sprintf("(from %s-base)", stackName)))
+			Expect(resultBuffer).To(gbytes.Say(fmt.Sprintf(`%s-base \(create\)`, stackName)))
+			Expect(resultBuffer).To(gbytes.Say(fmt.Sprintf(`%s-concourse \(create\)`, stackName)))
+		})
+	})
+
+	Context("when the name is invalid", func() {
+		It("should immediately error", func() {
+			Expect(app.Plan(ctx, "invalid_name", upOptions)).To(MatchError(ContainSubstring("invalid name")))
+			Expect(awsClient.PlanStackCallCount).To(Equal(0))
+		})
+	})
+
+	Context("when getting the latest NAT AMI errors", func() {
+		It("should return the error", func() {
+			awsClient.GetLatestNATBoxAMIIDCall.Returns.Error = errors.New("some error")
+
+			Expect(app.Plan(ctx, stackName, upOptions)).To(MatchError("some error"))
+		})
+	})
+
+	Context("when planning the base stack errors", func() {
+		It("should return the error", func() {
+			awsClient.PlanStackCalls[0].Returns.Error = errors.New("some error")
+
+			Expect(app.Plan(ctx, stackName, upOptions)).To(MatchError("some error"))
+		})
+	})
+
+	Context("when getting the base stack resources errors", func() {
+		It("should return the error", func() {
+			awsClient.GetBaseStackResourcesCall.Returns.Error = errors.New("some error")
+
+			Expect(app.Plan(ctx, stackName, upOptions)).To(MatchError("some error"))
+		})
+	})
+
+	Context("when planning the concourse stack errors", func() {
+		It("should return the error", func() {
+			awsClient.PlanStackCalls[1].Returns.Error = errors.New("some error")
+
+			Expect(app.Plan(ctx, stackName, upOptions)).To(MatchError("some error"))
+		})
+	})
+
+	Context("when writing the result errors", func() {
+		It("should return the error", func() {
+			app.ResultWriter = &erroringWriter{}
+
+			Expect(app.Plan(ctx, stackName, upOptions)).To(MatchError("write failed"))
+		})
+	})
+})
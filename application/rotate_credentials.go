@@ -0,0 +1,257 @@
+package application
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rosenhouse/tubes/lib/director"
+)
+
+// awsKeysComponent names the director's AWS access keys, alongside the
+// components of director.Credentials
+const awsKeysComponent = "aws"
+
+// credentialFields maps each component to its field in the credentials, by its YAML name
+func credentialFields(credentials *director.Credentials) map[string]*string {
+	return map[string]*string{
+		"mbus":               &credentials.MBus,
+		"nats":               &credentials.NATS,
+		"redis":              &credentials.Redis,
+		"postgres":           &credentials.Postgres,
+		"registry":           &credentials.Registry,
+		"blobstore_director": &credentials.BlobstoreDirector,
+		"blobstore_agent":    &credentials.BlobstoreAgent,
+		"hm":                 &credentials.HM,
+		"admin":              &credentials.Admin,
+	}
+}
+
+// fixedComponents can't rotate live: the agent on every VM the director has
+// deployed holds these, so changing them would orphan those VMs
+var fixedComponents = map[string]string{
+	"nats":            "every VM's agent connects to NATS with it",
+	"registry":        "every VM's agent reads its settings from the registry with it",
+	"blobstore_agent": "every VM's agent fetches packages from the blobstore with it",
+}
+
+// RotateCredentials regenerates the director's passwords and AWS access keys,
+// and re-renders the files that contain them.  Only the named components are
+// rotated, or all that can rotate live when none are named.
+func (a *Application) RotateCredentials(stackName string, only []string) error {
+	err := validateStackName(stackName)
+	if err != nil {
+		return err
+	}
+
+	options, err := a.loadUpOptions(UpOptions{})
+	if err != nil {
+		return err
+	}
+
+	components, err := selectComponents(only, options)
+	if err != nil {
+		return err
+	}
+
+	credentials, err := a.loadDirectorCredentials()
+	if err != nil {
+		return err
+	}
+	if credentials == (director.Credentials{}) {
+		return fmt.Errorf("no director credentials in the state directory, run up first")
+	}
+
+	fresh := director.Credentials{}
+	err = a.CredentialsGenerator.Fill(&fresh)
+	if err != nil {
+		return err
+	}
+	freshFields := credentialFields(&fresh)
+	fields := credentialFields(&credentials)
+	for _, component := range components {
+		if component != awsKeysComponent {
+			*fields[component] = *freshFields[component]
+		}
+	}
+
+	a.Logger.Println("Retrieving resource ids")
+	baseStackResources, err := a.AWSClient.GetBaseStackResources(stackName + "-base")
+	if err != nil {
+		return err
+	}
+
+	var accessKey, secretKey string
+	if !options.InstanceProfile {
+		if containsString(components, awsKeysComponent) {
+			accessKey, secretKey, err = a.rotateAccessKey(baseStackResources.BOSHUser)
+		} else {
+			accessKey, secretKey, err = a.ensureAccessKey(baseStackResources.BOSHUser, true)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	boshIP, err := a.ConfigStore.Get("bosh-ip")
+	if err != nil {
+		return err
+	}
+	ssl, err := a.ensureDirectorCertificate(string(boshIP), true)
+	if err != nil {
+		return err
+	}
+
+	a.Logger.Println("Re-rendering the BOSH init manifest")
+	manifestYAML, credentials, err := a.ManifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey, credentials, ssl)
+	if err != nil {
+		return err
+	}
+
+	err = a.storeDirectorCredentials(credentials)
+	if err != nil {
+		return err
+	}
+
+	err = a.ConfigStore.Set("director.yml", manifestYAML)
+	if err != nil {
+		return err
+	}
+
+	err = a.ConfigStore.Set("bosh-password", []byte(credentials.Admin))
+	if err != nil {
+		return err
+	}
+
+	natIP, err := a.ConfigStore.Get("nat-ip")
+	if err != nil {
+		return err
+	}
+	err = a.storeBoshEnvironment(string(boshIP), string(natIP), credentials.Admin, options)
+	if err != nil {
+		return err
+	}
+
+	a.Logger.Println("Finished")
+	return a.writeRotationReport(stackName, components)
+}
+
+// selectComponents validates the requested components, defaulting to every
+// component that can rotate live
+func selectComponents(only []string, options UpOptions) ([]string, error) {
+	known := []string{}
+	for component := range credentialFields(&director.Credentials{}) {
+		known = append(known, component)
+	}
+	if !options.InstanceProfile {
+		known = append(known, awsKeysComponent)
+	}
+	sort.Strings(known)
+
+	if len(only) == 0 {
+		components := []string{}
+		for _, component := range known {
+			if _, fixed := fixedComponents[component]; !fixed {
+				components = append(components, component)
+			}
+		}
+		return components, nil
+	}
+
+	components := []string{}
+	for _, component := range only {
+		if reason, fixed := fixedComponents[component]; fixed {
+			return nil, fmt.Errorf("%s can't be rotated live: %s", component, reason)
+		}
+		if component == awsKeysComponent && options.InstanceProfile {
+			return nil, fmt.Errorf("the director uses an instance profile, so there are no access keys to rotate")
+		}
+		if !containsString(known, component) {
+			return nil, fmt.Errorf("unknown component %q, expected one of: %s", component, strings.Join(known, ", "))
+		}
+		if !containsString(components, component) {
+			components = append(components, component)
+		}
+	}
+	sort.Strings(components)
+	return components, nil
+}
+
+// rotateAccessKey creates a new access key for the user, stores it, and then
+// deletes every other key the user has
+func (a *Application) rotateAccessKey(userName string) (string, string, error) {
+	oldKeys, err := a.AWSClient.ListAccessKeys(userName)
+	if err != nil {
+		return "", "", err
+	}
+
+	a.Logger.Println("Creating a new access key")
+	newAccessKey, newSecretKey, err := a.AWSClient.CreateAccessKey(userName)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = a.ConfigStore.Set("director-access-key-id", []byte(newAccessKey))
+	if err != nil {
+		return "", "", err
+	}
+	err = a.ConfigStore.Set("director-secret-access-key", []byte(newSecretKey))
+	if err != nil {
+		return "", "", err
+	}
+
+	a.Logger.Println("Deleting old access keys")
+	for _, oldKey := range oldKeys {
+		if oldKey == newAccessKey {
+			continue
+		}
+		err = a.AWSClient.DeleteAccessKey(userName, oldKey)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	return newAccessKey, newSecretKey, nil
+}
+
+func (a *Application) writeRotationReport(stackName string, components []string) error {
+	lines := []string{"Rotated:"}
+	for _, component := range components {
+		lines = append(lines, "  "+component)
+	}
+
+	kept := []string{}
+	for component := range fixedComponents {
+		kept = append(kept, component)
+	}
+	sort.Strings(kept)
+	lines = append(lines, "Kept, since they can't rotate live:")
+	for _, component := range kept {
+		lines = append(lines, fmt.Sprintf("  %s (%s)", component, fixedComponents[component]))
+	}
+
+	lines = append(lines,
+		"Needs a redeploy:",
+		fmt.Sprintf("  director, for %s.  Run: tubes -n %s deploy-director", strings.Join(components, ", "), stackName),
+	)
+	if containsString(components, awsKeysComponent) {
+		lines = append(lines, "  The old access keys are already deleted, so the director can't reach AWS until then.")
+	}
+
+	for _, line := range lines {
+		_, err := fmt.Fprintln(a.ResultWriter, line)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
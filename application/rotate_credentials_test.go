@@ -0,0 +1,266 @@
+package application_test
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/rosenhouse/tubes/lib/awsclient"
+	"github.com/rosenhouse/tubes/lib/director"
+	"gopkg.in/yaml.v2"
+)
+
+var _ = Describe("RotateCredentials", func() {
+	oldCredentials := director.Credentials{
+		MBus:              "old-mbus",
+		NATS:              "old-nats",
+		Redis:             "old-redis",
+		Postgres:          "old-postgres",
+		Registry:          "old-registry",
+		BlobstoreDirector: "old-blobstore-director",
+		BlobstoreAgent:    "old-blobstore-agent",
+		HM:                "old-hm",
+		Admin:             "old-admin",
+	}
+
+	BeforeEach(func() {
+		credentialsYAML, err := yaml.Marshal(oldCredentials)
+		Expect(err).NotTo(HaveOccurred())
+		configStore.Values["director-credentials.yml"] = credentialsYAML
+		configStore.Values["director-access-key-id"] = []byte("old-access-key")
+		configStore.Values["director-secret-access-key"] = []byte("old-secret-key")
+		configStore.Values["bosh-ip"] = []byte("some-bosh-ip")
+		configStore.Values["nat-ip"] = []byte("some-nat-ip")
+		configStore.Values["director-cert.pem"] = []byte("some-cert")
+		configStore.Values["director-key.pem"] = []byte("some-key")
+
+		credentialsGenerator.FillCallback = func(toFill interface{}) error {
+			*toFill.(*director.Credentials) = director.Credentials{
+				MBus:              "new-mbus",
+				NATS:              "new-nats",
+				Redis:             "new-redis",
+				Postgres:          "new-postgres",
+				Registry:          "new-registry",
+				BlobstoreDirector: "new-blobstore-director",
+				BlobstoreAgent:    "new-blobstore-agent",
+				HM:                "new-hm",
+				Admin:             "new-admin",
+			}
+			return nil
+		}
+
+		awsClient.GetBaseStackResourcesCall.Returns.Resources = awsclient.BaseStackResources{
+			BOSHUser: "some-bosh-user",
+		}
+		awsClient.ListAccessKeysCall.Returns.AccessKeys = []string{"old-access-key"}
+		awsClient.CreateAccessKeyCall.Returns.AccessKey = "new-access-key"
+		awsClient.CreateAccessKeyCall.Returns.SecretKey = "new-secret-key"
+
+		manifestBuilder.BuildCall.Returns.ManifestYAML = []byte("some-new-manifest")
+		manifestBuilder.BuildCall.Returns.Credentials = director.Credentials{
+			NATS:  "old-nats",
+			Admin: "new-admin",
+		}
+	})
+
+	It("should regenerate the passwords that can rotate live, and keep the others", func() {
+		Expect(app.RotateCredentials(stackName, nil)).To(Succeed())
+
+		Expect(manifestBuilder.BuildCall.Receives.Credentials).To(Equal(director.Credentials{
+			MBus:              "new-mbus",
+			NATS:              "old-nats",
+			Redis:             "new-redis",
+			Postgres:          "new-postgres",
+			Registry:          "old-registry",
+			BlobstoreDirector: "new-blobstore-director",
+			BlobstoreAgent:    "old-blobstore-agent",
+			HM:                "new-hm",
+			Admin:             "new-admin",
+		}))
+	})
+
+	It("should store the new credentials and re-render the files that contain them", func() {
+		Expect(app.RotateCredentials(stackName, nil)).To(Succeed())
+
+		var stored director.Credentials
+		Expect(yaml.Unmarshal(configStore.Values["director-credentials.yml"], &stored)).To(Succeed())
+		Expect(stored.Admin).To(Equal("new-admin"))
+		Expect(stored.NATS).To(Equal("old-nats"))
+
+		Expect(configStore.Values).To(HaveKeyWithValue("director.yml", []byte("some-new-manifest")))
+		Expect(configStore.Values).To(HaveKeyWithValue("bosh-password", []byte("new-admin")))
+		Expect(string(configStore.Values["bosh-environment"])).To(ContainSubstring(`export BOSH_PASSWORD="new-admin"`))
+		Expect(string(configStore.Values["bosh-environment"])).To(ContainSubstring(`export BOSH_TARGET="some-bosh-ip"`))
+		Expect(string(configStore.Values["bosh-environment"])).To(ContainSubstring(`export NAT_IP="some-nat-ip"`))
+	})
+
+	It("should create a new access key for the BOSH user and delete the old ones", func() {
+		Expect(app.RotateCredentials(stackName, nil)).To(Succeed())
+
+		Expect(awsClient.GetBaseStackResourcesCall.Receives.StackName).To(Equal(stackName + "-base"))
+		Expect(awsClient.CreateAccessKeyCall.Receives.UserName).To(Equal("some-bosh-user"))
+		Expect(awsClient.DeleteAccessKeyCall.Receives.UserName).To(Equal("some-bosh-user"))
+		Expect(awsClient.DeleteAccessKeyCall.Receives.AccessKey).To(Equal("old-access-key"))
+
+		Expect(configStore.Values).To(HaveKeyWithValue("director-access-key-id", []byte("new-access-key")))
+		Expect(configStore.Values).To(HaveKeyWithValue("director-secret-access-key", []byte("new-secret-key")))
+		Expect(manifestBuilder.BuildCall.Receives.AccessKey).To(Equal("new-access-key"))
+		Expect(manifestBuilder.BuildCall.Receives.SecretKey).To(Equal("new-secret-key"))
+	})
+
+	It("should report what was rotated, and that the director needs a redeploy", func() {
+		Expect(app.RotateCredentials(stackName, nil)).To(Succeed())
+
+		Expect(resultBuffer).To(gbytes.Say("Rotated:\n  admin\n  aws\n  blobstore_director\n  hm\n  mbus\n  postgres\n  redis\n"))
+		Expect(resultBuffer).To(gbytes.Say("Kept, since they can't rotate live:\n  blobstore_agent .*\n  nats .*\n  registry .*\n"))
+		Expect(resultBuffer).To(gbytes.Say("Needs a redeploy:\n  director, for admin, aws, .*Run: tubes -n " + stackName + " deploy-director"))
+	})
+
+	Context("when only some components are requested", func() {
+		It("should only rotate those", func() {
+			Expect(app.RotateCredentials(stackName, []string{"hm", "admin"})).To(Succeed())
+
+			expected := oldCredentials
+			expected.HM = "new-hm"
+			expected.Admin = "new-admin"
+			Expect(manifestBuilder.BuildCall.Receives.Credentials).To(Equal(expected))
+			Expect(resultBuffer).To(gbytes.Say("Rotated:\n  admin\n  hm\n"))
+		})
+
+		It("should keep the existing access key unless aws is requested", func() {
+			Expect(app.RotateCredentials(stackName, []string{"admin"})).To(Succeed())
+
+			Expect(awsClient.CreateAccessKeyCall.Receives.UserName).To(BeEmpty())
+			Expect(awsClient.DeleteAccessKeyCall.Receives.AccessKey).To(BeEmpty())
+			Expect(manifestBuilder.BuildCall.Receives.AccessKey).To(Equal("old-access-key"))
+		})
+
+		It("should rotate only the access key when aws is requested", func() {
+			Expect(app.RotateCredentials(stackName, []string{"aws"})).To(Succeed())
+
+			Expect(manifestBuilder.BuildCall.Receives.Credentials).To(Equal(oldCredentials))
+			Expect(manifestBuilder.BuildCall.Receives.AccessKey).To(Equal("new-access-key"))
+			Expect(resultBuffer).To(gbytes.Say("The old access keys are already deleted"))
+		})
+
+		Context("when a component can't rotate live", func() {
+			It("should refuse, before changing anything", func() {
+				err := app.RotateCredentials(stackName, []string{"admin", "nats"})
+				Expect(err).To(MatchError(HavePrefix("nats can't be rotated live")))
+
+				Expect(awsClient.CreateAccessKeyCall.Receives.UserName).To(BeEmpty())
+				Expect(configStore.Values).NotTo(HaveKey("director.yml"))
+			})
+		})
+
+		Context("when a component is unknown", func() {
+			It("should return an error listing the known ones", func() {
+				err := app.RotateCredentials(stackName, []string{"nope"})
+				Expect(err).To(MatchError(ContainSubstring(`unknown component "nope", expected one of: admin, aws,`)))
+			})
+		})
+	})
+
+	Context("when the director uses an instance profile", func() {
+		BeforeEach(func() {
+			configStore.Values["up-options.yml"] = []byte("instance_profile: true\n")
+			awsClient.GetBaseStackResourcesCall.Returns.Resources = awsclient.BaseStackResources{
+				DirectorInstanceProfile: "some-instance-profile",
+			}
+		})
+
+		It("should have no access keys to rotate", func() {
+			Expect(app.RotateCredentials(stackName, nil)).To(Succeed())
+
+			Expect(awsClient.ListAccessKeysCall.Receives.UserName).To(BeEmpty())
+			Expect(awsClient.CreateAccessKeyCall.Receives.UserName).To(BeEmpty())
+			Expect(manifestBuilder.BuildCall.Receives.AccessKey).To(BeEmpty())
+			Expect(resultBuffer).NotTo(gbytes.Say("aws"))
+		})
+
+		It("should refuse to rotate aws", func() {
+			Expect(app.RotateCredentials(stackName, []string{"aws"})).To(MatchError(ContainSubstring("no access keys to rotate")))
+		})
+	})
+
+	Context("when there are no director credentials yet", func() {
+		It("should return an error", func() {
+			delete(configStore.Values, "director-credentials.yml")
+
+			Expect(app.RotateCredentials(stackName, nil)).To(MatchError(ContainSubstring("run up first")))
+		})
+	})
+
+	Context("when the name is invalid", func() {
+		It("should immediately error", func() {
+			Expect(app.RotateCredentials("invalid_name", nil)).To(MatchError(ContainSubstring("invalid name")))
+		})
+	})
+
+	Context("when generating credentials fails", func() {
+		It("should return the error", func() {
+			credentialsGenerator.FillCallback = func(interface{}) error { return errors.New("some error") }
+
+			Expect(app.RotateCredentials(stackName, nil)).To(MatchError("some error"))
+		})
+	})
+
+	Context("when creating the access key fails", func() {
+		It("should return the error, without deleting the old keys", func() {
+			awsClient.CreateAccessKeyCall.Returns.Error = errors.New("some error")
+
+			Expect(app.RotateCredentials(stackName, nil)).To(MatchError("some error"))
+			Expect(awsClient.DeleteAccessKeyCall.Receives.AccessKey).To(BeEmpty())
+		})
+	})
+
+	Context("when deleting an old access key fails", func() {
+		It("should return the error, having stored the new key", func() {
+			awsClient.DeleteAccessKeyCall.Returns.Error = errors.New("some error")
+
+			Expect(app.RotateCredentials(stackName, nil)).To(MatchError("some error"))
+			Expect(configStore.Values).To(HaveKeyWithValue("director-access-key-id", []byte("new-access-key")))
+		})
+	})
+
+	It("should keep the director's TLS certificate", func() {
+		Expect(app.RotateCredentials(stackName, nil)).To(Succeed())
+
+		Expect(certificateGenerator.GenerateCallCount).To(Equal(0))
+		Expect(manifestBuilder.BuildCall.Receives.SSL).To(Equal(director.SSL{Cert: "some-cert", Key: "some-key"}))
+	})
+
+	Context("when the state directory has no director certificate yet", func() {
+		It("should generate one for the director's IP", func() {
+			delete(configStore.Values, "director-cert.pem")
+			certificateGenerator.GenerateCall.Returns.Cert = []byte("some-new-cert")
+			certificateGenerator.GenerateCall.Returns.Key = []byte("some-new-key")
+
+			Expect(app.RotateCredentials(stackName, nil)).To(Succeed())
+
+			Expect(certificateGenerator.GenerateCall.Receives.IPs).To(Equal([]string{"some-bosh-ip"}))
+			Expect(configStore.Values).To(HaveKeyWithValue("director-cert.pem", []byte("some-new-cert")))
+			Expect(manifestBuilder.BuildCall.Receives.SSL).To(Equal(director.SSL{Cert: "some-new-cert", Key: "some-new-key"}))
+		})
+	})
+
+	Context("when building the manifest fails", func() {
+		It("should return the error", func() {
+			manifestBuilder.BuildCall.Returns.Error = errors.New("some error")
+
+			Expect(app.RotateCredentials(stackName, nil)).To(MatchError("some error"))
+		})
+	})
+
+	for _, key := range []string{"director-credentials.yml", "director.yml", "bosh-password", "bosh-ip", "nat-ip", "bosh-environment"} {
+		key := key
+		Context("when the config store errors on "+key, func() {
+			It("should return the error", func() {
+				configStore.Errors[key] = errors.New("some error")
+
+				Expect(app.RotateCredentials(stackName, nil)).To(MatchError("some error"))
+			})
+		})
+	}
+})
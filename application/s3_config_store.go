@@ -0,0 +1,164 @@
+package application
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// S3ConfigStore keeps the state as objects under a prefix in an S3 bucket,
+// so that several people can operate the same environment
+type S3ConfigStore struct {
+	Client objectStore
+	Bucket string
+	Prefix string
+
+	// Owner identifies this run in the lock, e.g. user@host (pid 123)
+	Owner string
+
+	// SettleDelay is how long AcquireLock waits before reading its lock back
+	SettleDelay time.Duration
+
+	heldLock *StateLock
+}
+
+func (s *S3ConfigStore) objectKey(key string) string {
+	return path.Join(s.Prefix, key)
+}
+
+func (s *S3ConfigStore) Get(key string) ([]byte, error) {
+	return s.Client.GetObject(s.Bucket, s.objectKey(key))
+}
+
+func (s *S3ConfigStore) Set(key string, value []byte) error {
+	return s.Client.PutObject(s.Bucket, s.objectKey(key), value)
+}
+
+func (s *S3ConfigStore) Delete(key string) error {
+	return s.Client.DeleteObject(s.Bucket, s.objectKey(key))
+}
+
+// List returns the keys of every value in the store, not counting the lock
+func (s *S3ConfigStore) List() ([]string, error) {
+	prefix := ""
+	if s.Prefix != "" {
+		prefix = s.Prefix + "/"
+	}
+	objectKeys, err := s.Client.ListObjects(s.Bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	for _, objectKey := range objectKeys {
+		key := strings.TrimPrefix(objectKey, prefix)
+		if key != stateLockKey {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (s *S3ConfigStore) IsEmpty() (bool, error) {
+	keys, err := s.List()
+	if err != nil {
+		return false, err
+	}
+	return len(keys) == 0, nil
+}
+
+func (s *S3ConfigStore) readLock() (*StateLock, error) {
+	lockJSON, err := s.Get(stateLockKey)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return parseStateLock(lockJSON)
+}
+
+// AcquireLock takes the advisory lock for the command, failing if someone else holds it.
+// S3 has no conditional writes, so the lock is best-effort only.  It waits
+// SettleDelay after writing the lock before reading it back, so that of two
+// runs that both found no lock, only the one whose write landed last carries
+// on.  A run slower than SettleDelay between finding no lock and writing its
+// own, or a stale read from S3, can still leave both thinking they hold it.
+func (s *S3ConfigStore) AcquireLock(command string) error {
+	existing, err := s.readLock()
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return errStateLocked(existing)
+	}
+
+	lock, err := newStateLock(s.Owner, command)
+	if err != nil {
+		return err
+	}
+	lockJSON, err := json.Marshal(lock)
+	if err != nil {
+		return err // not tested
+	}
+	err = s.Set(stateLockKey, lockJSON)
+	if err != nil {
+		return err
+	}
+
+	// give a run that found no lock just before we wrote ours time to
+	// overwrite it, then see whose write landed last
+	time.Sleep(s.SettleDelay)
+	winner, err := s.readLock()
+	if err != nil {
+		return err
+	}
+	if winner == nil || winner.ID != lock.ID {
+		return errLockRace
+	}
+
+	s.heldLock = lock
+	return nil
+}
+
+// ReleaseLock removes the lock this run holds, if any
+func (s *S3ConfigStore) ReleaseLock() error {
+	if s.heldLock == nil {
+		return nil
+	}
+
+	current, err := s.readLock()
+	if err != nil {
+		return err
+	}
+	if current == nil || current.ID != s.heldLock.ID {
+		return errLockTakenOver(s.heldLock)
+	}
+
+	err = s.Client.DeleteObject(s.Bucket, s.objectKey(stateLockKey))
+	if err != nil {
+		return err
+	}
+	s.heldLock = nil
+	return nil
+}
+
+// ForceUnlock removes whatever lock is held, returning it, or nil if there was none
+func (s *S3ConfigStore) ForceUnlock() (*StateLock, error) {
+	existing, err := s.readLock()
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return nil, nil
+	}
+
+	err = s.Client.DeleteObject(s.Bucket, s.objectKey(stateLockKey))
+	if err != nil {
+		return nil, err
+	}
+	return existing, nil
+}
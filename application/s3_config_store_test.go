@@ -0,0 +1,199 @@
+package application_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rosenhouse/tubes/application"
+	"github.com/rosenhouse/tubes/mocks"
+)
+
+var _ = Describe("S3 Config Store", func() {
+	var (
+		objectStore *mocks.FunctionalObjectStore
+		store       *application.S3ConfigStore
+	)
+
+	BeforeEach(func() {
+		objectStore = mocks.NewFunctionalObjectStore()
+		store = &application.S3ConfigStore{
+			Client: objectStore,
+			Bucket: "some-bucket",
+			Prefix: "environments/some-env",
+			Owner:  "some-user@some-host (pid 123)",
+		}
+	})
+
+	It("should store values as objects under the prefix", func() {
+		Expect(store.IsEmpty()).To(BeTrue())
+
+		Expect(store.Set("some/key", []byte("some-value"))).To(Succeed())
+		Expect(objectStore.Objects).To(HaveKeyWithValue("some-bucket/environments/some-env/some/key", []byte("some-value")))
+
+		Expect(store.Get("some/key")).To(Equal([]byte("some-value")))
+		Expect(store.IsEmpty()).To(BeFalse())
+	})
+
+	It("should ignore objects outside the prefix, and the lock, when checking if it is empty", func() {
+		objectStore.Objects["some-bucket/environments/some-env-2/key"] = []byte("other")
+		Expect(store.AcquireLock("up")).To(Succeed())
+
+		Expect(store.IsEmpty()).To(BeTrue())
+	})
+
+	It("should list and delete values", func() {
+		Expect(store.Set("some/key", []byte("some-value"))).To(Succeed())
+		Expect(store.Set("other-key", []byte("other-value"))).To(Succeed())
+		Expect(store.AcquireLock("down")).To(Succeed())
+
+		Expect(store.List()).To(Equal([]string{"other-key", "some/key"}))
+
+		Expect(store.Delete("some/key")).To(Succeed())
+		Expect(store.List()).To(Equal([]string{"other-key"}))
+		Expect(objectStore.Objects).To(HaveKey("some-bucket/environments/some-env/tubes.lock"))
+	})
+
+	Context("when a key is missing", func() {
+		It("should return an error that satisfies os.IsNotExist", func() {
+			_, err := store.Get("nope")
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+
+	Context("when listing errors", func() {
+		It("should return the error", func() {
+			objectStore.ListError = errors.New("some error")
+			_, err := store.IsEmpty()
+			Expect(err).To(MatchError("some error"))
+		})
+	})
+
+	Describe("the state lock", func() {
+		const lockKey = "some-bucket/environments/some-env/tubes.lock"
+
+		It("should record the owner, command and time, and remove it on release", func() {
+			Expect(store.AcquireLock("up")).To(Succeed())
+
+			var lock application.StateLock
+			Expect(json.Unmarshal(objectStore.Objects[lockKey], &lock)).To(Succeed())
+			Expect(lock.Owner).To(Equal("some-user@some-host (pid 123)"))
+			Expect(lock.Command).To(Equal("up"))
+			Expect(lock.Acquired).To(BeTemporally("~", time.Now(), time.Minute))
+			Expect(lock.ID).NotTo(BeEmpty())
+
+			Expect(store.ReleaseLock()).To(Succeed())
+			Expect(objectStore.Objects).NotTo(HaveKey(lockKey))
+		})
+
+		It("should do nothing on release when it holds no lock", func() {
+			Expect(store.ReleaseLock()).To(Succeed())
+		})
+
+		Context("when someone else holds the lock", func() {
+			BeforeEach(func() {
+				other := &application.S3ConfigStore{Client: objectStore, Bucket: "some-bucket", Prefix: "environments/some-env", Owner: "someone-else@other-host (pid 456)"}
+				Expect(other.AcquireLock("down")).To(Succeed())
+			})
+
+			It("should refuse, naming the owner", func() {
+				err := store.AcquireLock("up")
+				Expect(err).To(MatchError(ContainSubstring("locked by someone-else@other-host (pid 456), running down since")))
+				Expect(err).To(MatchError(ContainSubstring("force-unlock")))
+			})
+
+			It("should let the lock be forced open", func() {
+				lock, err := store.ForceUnlock()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(lock.Owner).To(Equal("someone-else@other-host (pid 456)"))
+				Expect(objectStore.Objects).NotTo(HaveKey(lockKey))
+
+				Expect(store.AcquireLock("up")).To(Succeed())
+			})
+		})
+
+		Context("when another run writes its lock while this one settles", func() {
+			It("should back off, leaving the other run's lock in place", func() {
+				store.Client = &racingObjectStore{FunctionalObjectStore: objectStore, lockKey: lockKey}
+
+				Expect(store.AcquireLock("up")).To(MatchError("another run took the state lock at the same time, try again"))
+
+				var lock application.StateLock
+				Expect(json.Unmarshal(objectStore.Objects[lockKey], &lock)).To(Succeed())
+				Expect(lock.Owner).To(Equal("someone-else"))
+			})
+		})
+
+		It("should wait for the settle delay before reading its lock back", func() {
+			store.SettleDelay = 50 * time.Millisecond
+
+			start := time.Now()
+			Expect(store.AcquireLock("up")).To(Succeed())
+			Expect(time.Since(start)).To(BeNumerically(">=", 50*time.Millisecond))
+		})
+
+		Context("when the lock is taken over while the command runs", func() {
+			It("should leave the new lock alone, and return an error on release", func() {
+				Expect(store.AcquireLock("up")).To(Succeed())
+
+				_, err := store.ForceUnlock()
+				Expect(err).NotTo(HaveOccurred())
+				other := &application.S3ConfigStore{Client: objectStore, Bucket: "some-bucket", Prefix: "environments/some-env", Owner: "someone-else"}
+				Expect(other.AcquireLock("down")).To(Succeed())
+
+				Expect(store.ReleaseLock()).To(MatchError(ContainSubstring("taken over while up ran")))
+				Expect(objectStore.Objects).To(HaveKey(lockKey))
+			})
+		})
+
+		Context("when there is no lock to force open", func() {
+			It("should return nil", func() {
+				Expect(store.ForceUnlock()).To(BeNil())
+			})
+		})
+
+		Context("when the lock is corrupt", func() {
+			It("should return an error", func() {
+				objectStore.Objects[lockKey] = []byte("{")
+				Expect(store.AcquireLock("up")).To(MatchError(ContainSubstring("reading the state lock")))
+			})
+		})
+
+		Context("when the object store errors", func() {
+			It("should return the error", func() {
+				objectStore.Errors[lockKey] = errors.New("some error")
+				Expect(store.AcquireLock("up")).To(MatchError("some error"))
+
+				_, err := store.ForceUnlock()
+				Expect(err).To(MatchError("some error"))
+			})
+
+			It("should return errors deleting the lock", func() {
+				Expect(store.AcquireLock("up")).To(Succeed())
+				objectStore.DeleteError = errors.New("some error")
+
+				Expect(store.ReleaseLock()).To(MatchError("some error"))
+			})
+		})
+	})
+})
+
+// racingObjectStore lets another run, which also found no lock, write its own
+// right after the lock is first written
+type racingObjectStore struct {
+	*mocks.FunctionalObjectStore
+	lockKey string
+	raced   bool
+}
+
+func (s *racingObjectStore) PutObject(bucket, key string, value []byte) error {
+	err := s.FunctionalObjectStore.PutObject(bucket, key, value)
+	if err != nil || s.raced || bucket+"/"+key != s.lockKey {
+		return err
+	}
+	s.raced = true
+	return s.FunctionalObjectStore.PutObject(bucket, key, []byte(`{"owner":"someone-else","command":"down","id":"some-other-id"}`))
+}
@@ -1,18 +1,67 @@
 package application
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/rosenhouse/tubes/lib/awsclient"
+)
 
 type ShowOptions struct {
 	SSHKey          bool
 	BoshIP          bool
 	BoshPassword    bool
 	BoshEnvironment bool
+	BoshUUID        bool
+	IAMPolicy       bool
+
+	// JSON prints one object with the selected values, or with every value
+	// and the live stack resources when none are selected
+	JSON bool
+
+	// Secrets includes the secrets in the JSON when no values are selected
+	Secrets bool
+}
+
+// ShowResult is the JSON that show prints
+type ShowResult struct {
+	Name      string                       `json:"name"`
+	State     map[string]string            `json:"state"`
+	Stacks    map[string]map[string]string `json:"stacks,omitempty"`
+	IAMPolicy *awsclient.PolicyDocument    `json:"iam_policy,omitempty"`
+}
+
+// selectedKeys maps the selectors to the state keys they show
+func (o ShowOptions) selectedKeys() []string {
+	keys := []string{}
+	for key, selected := range map[string]bool{
+		"ssh-key":          o.SSHKey,
+		"bosh-ip":          o.BoshIP,
+		"bosh-password":    o.BoshPassword,
+		"bosh-environment": o.BoshEnvironment,
+		"director-uuid":    o.BoshUUID,
+	} {
+		if selected {
+			keys = append(keys, key)
+		}
+	}
+	return keys
 }
 
 func (a *Application) Show(stackName string, options ShowOptions) error {
+	if options.JSON {
+		return a.showJSON(stackName, options)
+	}
+	if options.Secrets {
+		return fmt.Errorf("--secrets only applies to --json")
+	}
+
 	if (options == ShowOptions{}) {
 		return fmt.Errorf("set at least one flag")
 	}
+	if selected := len(options.selectedKeys()); selected > 1 || (selected == 1 && options.IAMPolicy) {
+		return fmt.Errorf("set one flag at a time, or add --json to show several")
+	}
 
 	if options.SSHKey {
 		val, err := a.ConfigStore.Get("ssh-key")
@@ -60,5 +109,117 @@ func (a *Application) Show(stackName string, options ShowOptions) error {
 			return err
 		}
 	}
+
+	if options.BoshUUID {
+		val, err := a.ConfigStore.Get("director-uuid")
+		if err != nil {
+			return err
+		}
+		_, err = a.ResultWriter.Write(val)
+		if err != nil {
+			return err
+		}
+	}
+
+	if options.IAMPolicy {
+		policy, err := a.directorPolicy(stackName)
+		if err != nil {
+			return err
+		}
+		_, err = a.ResultWriter.Write([]byte(policy.String() + "\n"))
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// directorPolicy is the IAM policy of the director, as IAM sees it, with the
+// region, account, VPC and role of the live base stack filled in
+func (a *Application) directorPolicy(stackName string) (awsclient.PolicyDocument, error) {
+	resources, err := a.AWSClient.GetBaseStackResources(stackName + "-base")
+	if err != nil {
+		return awsclient.PolicyDocument{}, err
+	}
+
+	policy := awsclient.DirectorPolicy
+	values := map[string]string{
+		"AWS::Region":    resources.AWSRegion,
+		"AWS::AccountId": resources.AccountID,
+		"VPC":            resources.VPCID,
+	}
+	if resources.DirectorInstanceProfile != "" {
+		policy = awsclient.DirectorRolePolicy
+		values["BOSHDirectorRole.Arn"] = resources.DirectorRoleARN()
+	}
+	return policy.Resolve(values)
+}
+
+func (a *Application) showJSON(stackName string, options ShowOptions) error {
+	result := ShowResult{
+		Name:  stackName,
+		State: map[string]string{},
+	}
+
+	keys := options.selectedKeys()
+	if len(keys) == 0 && !options.IAMPolicy {
+		allKeys, err := a.ConfigStore.List()
+		if err != nil {
+			return err
+		}
+		for _, key := range allKeys {
+			if options.Secrets || !containsString(DefaultSealedKeys, key) {
+				keys = append(keys, key)
+			}
+		}
+
+		result.Stacks, err = a.liveStackResources(stackName)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, key := range keys {
+		value, err := a.ConfigStore.Get(key)
+		if err != nil {
+			return err
+		}
+		result.State[key] = string(value)
+	}
+
+	if options.IAMPolicy {
+		policy, err := a.directorPolicy(stackName)
+		if err != nil {
+			return err
+		}
+		result.IAMPolicy = &policy
+	}
+
+	resultJSON, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err // not tested
+	}
+	_, err = a.ResultWriter.Write(append(resultJSON, '\n'))
+	return err
+}
+
+// liveStackResources returns the resources of each stack that exists, by logical ID
+func (a *Application) liveStackResources(stackName string) (map[string]map[string]string, error) {
+	stacks := map[string]map[string]string{}
+	for _, suffix := range []string{"base", "concourse"} {
+		exists, err := a.AWSClient.StackExists(stackName + "-" + suffix)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+
+		resources, err := a.AWSClient.GetStackResources(stackName + "-" + suffix)
+		if err != nil {
+			return nil, err
+		}
+		stacks[suffix] = resources
+	}
+	return stacks, nil
+}
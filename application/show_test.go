@@ -1,11 +1,14 @@
 package application_test
 
 import (
+	"encoding/json"
 	"errors"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/rosenhouse/tubes/application"
+	"github.com/rosenhouse/tubes/lib/awsclient"
+	"github.com/rosenhouse/tubes/mocks"
 )
 
 type erroringWriter struct{}
@@ -18,12 +21,30 @@ var _ = Describe("Show", func() {
 
 	var options application.ShowOptions
 
+	BeforeEach(func() {
+		options = application.ShowOptions{}
+	})
+
 	Context("when all options are empty", func() {
 		It("should return a friendly error", func() {
 			Expect(app.Show(stackName, application.ShowOptions{})).To(MatchError("set at least one flag"))
 		})
 	})
 
+	Context("when several values are selected without the JSON option", func() {
+		It("should refuse, rather than run them together", func() {
+			configStore.Values["bosh-ip"] = []byte("some ip address")
+			configStore.Values["bosh-password"] = []byte("some password")
+
+			err := app.Show(stackName, application.ShowOptions{BoshIP: true, BoshPassword: true})
+			Expect(err).To(MatchError("set one flag at a time, or add --json to show several"))
+			Expect(resultBuffer.Contents()).To(BeEmpty())
+
+			err = app.Show(stackName, application.ShowOptions{BoshUUID: true, IAMPolicy: true})
+			Expect(err).To(MatchError("set one flag at a time, or add --json to show several"))
+		})
+	})
+
 	Context("when the SSH key option is set", func() {
 		BeforeEach(func() { options.SSHKey = true })
 
@@ -100,9 +121,208 @@ var _ = Describe("Show", func() {
 		})
 	})
 
+	Context("when the BOSH UUID option is set", func() {
+		BeforeEach(func() { options.BoshUUID = true })
+
+		It("should print the director UUID to the result writer", func() {
+			configStore.Values["director-uuid"] = []byte("some-uuid")
+
+			Expect(app.Show(stackName, options)).To(Succeed())
+
+			Expect(resultBuffer.Contents()).To(Equal([]byte("some-uuid")))
+		})
+
+		Context("when the config store get errors", func() {
+			It("should return the error", func() {
+				configStore.Errors["director-uuid"] = errors.New("some error")
+				Expect(app.Show(stackName, options)).To(MatchError("some error"))
+			})
+		})
+	})
+
+	Context("when the IAM policy option is set", func() {
+		BeforeEach(func() {
+			options = application.ShowOptions{IAMPolicy: true}
+			awsClient.GetBaseStackResourcesCall.Returns.Resources = awsclient.BaseStackResources{
+				AWSRegion: "some-region",
+				AccountID: "123456789012",
+				VPCID:     "vpc-12345",
+				BOSHUser:  "some-bosh-user",
+			}
+		})
+
+		It("should print the director's IAM policy as IAM sees it, with the values of the live stack", func() {
+			Expect(app.Show(stackName, options)).To(Succeed())
+
+			Expect(awsClient.GetBaseStackResourcesCall.Receives.StackName).To(Equal(stackName + "-base"))
+			expected, err := awsclient.DirectorPolicy.Resolve(map[string]string{
+				"AWS::Region":    "some-region",
+				"AWS::AccountId": "123456789012",
+				"VPC":            "vpc-12345",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resultBuffer.Contents()).To(MatchJSON(expected.String()))
+			Expect(string(resultBuffer.Contents())).To(HaveSuffix("}\n"))
+			Expect(string(resultBuffer.Contents())).NotTo(ContainSubstring("iam:PassRole"))
+		})
+
+		Context("when the director has an instance profile", func() {
+			It("should let it pass only its own role", func() {
+				awsClient.GetBaseStackResourcesCall.Returns.Resources.BOSHUser = ""
+				awsClient.GetBaseStackResourcesCall.Returns.Resources.DirectorInstanceProfile = "some-instance-profile"
+				awsClient.GetBaseStackResourcesCall.Returns.Resources.DirectorRole = "some-role"
+
+				Expect(app.Show(stackName, options)).To(Succeed())
+
+				Expect(string(resultBuffer.Contents())).To(ContainSubstring("iam:PassRole"))
+				Expect(string(resultBuffer.Contents())).To(ContainSubstring(`"arn:aws:iam::123456789012:role/some-role"`))
+			})
+		})
+
+		It("should not need anything from the state directory", func() {
+			configStore.Errors["ssh-key"] = errors.New("some error")
+
+			Expect(app.Show(stackName, options)).To(Succeed())
+		})
+
+		Context("when the base stack resources can't be read", func() {
+			It("should return the error", func() {
+				awsClient.GetBaseStackResourcesCall.Returns.Error = errors.New("some error")
+
+				Expect(app.Show(stackName, options)).To(MatchError("some error"))
+			})
+		})
+	})
+
+	Context("when the JSON option is set", func() {
+		var result application.ShowResult
+
+		BeforeEach(func() {
+			options = application.ShowOptions{JSON: true}
+			result = application.ShowResult{}
+
+			configStore.Values["bosh-ip"] = []byte("some-bosh-ip")
+			configStore.Values["director-uuid"] = []byte("some-uuid")
+			configStore.Values["bosh-password"] = []byte("some-password")
+			configStore.Values["ssh-key"] = []byte("some pem block")
+
+			awsClient.StackExistsCall.Returns.Exists = true
+			awsClient.GetStackResourcesCalls = make([]mocks.GetStackResourcesCall, 2)
+			awsClient.GetStackResourcesCalls[0].Returns.Resources = map[string]string{"VPC": "some-vpc-id"}
+			awsClient.GetStackResourcesCalls[1].Returns.Resources = map[string]string{"ConcourseSubnet": "some-subnet-id"}
+		})
+
+		It("should print every value that isn't a secret, and the live stack resources", func() {
+			Expect(app.Show(stackName, options)).To(Succeed())
+			Expect(json.Unmarshal(resultBuffer.Contents(), &result)).To(Succeed())
+
+			Expect(result.Name).To(Equal(stackName))
+			Expect(result.State).To(Equal(map[string]string{
+				"bosh-ip":       "some-bosh-ip",
+				"director-uuid": "some-uuid",
+			}))
+			Expect(result.Stacks).To(Equal(map[string]map[string]string{
+				"base":      {"VPC": "some-vpc-id"},
+				"concourse": {"ConcourseSubnet": "some-subnet-id"},
+			}))
+			Expect(awsClient.GetStackResourcesCalls[0].Receives.StackName).To(Equal(stackName + "-base"))
+			Expect(awsClient.GetStackResourcesCalls[1].Receives.StackName).To(Equal(stackName + "-concourse"))
+			Expect(result.IAMPolicy).To(BeNil())
+		})
+
+		It("should include the secrets only when asked for", func() {
+			options.Secrets = true
+
+			Expect(app.Show(stackName, options)).To(Succeed())
+			Expect(json.Unmarshal(resultBuffer.Contents(), &result)).To(Succeed())
+
+			Expect(result.State).To(HaveKeyWithValue("bosh-password", "some-password"))
+			Expect(result.State).To(HaveKeyWithValue("ssh-key", "some pem block"))
+		})
+
+		Context("when values are selected", func() {
+			It("should print only those, secret or not, without the stacks", func() {
+				options.BoshIP = true
+				options.BoshPassword = true
+
+				Expect(app.Show(stackName, options)).To(Succeed())
+				Expect(json.Unmarshal(resultBuffer.Contents(), &result)).To(Succeed())
+
+				Expect(result.State).To(Equal(map[string]string{
+					"bosh-ip":       "some-bosh-ip",
+					"bosh-password": "some-password",
+				}))
+				Expect(result.Stacks).To(BeNil())
+				Expect(awsClient.GetStackResourcesCallCount).To(Equal(0))
+			})
+
+			It("should include the IAM policy when selected", func() {
+				options.IAMPolicy = true
+				awsClient.GetBaseStackResourcesCall.Returns.Resources = awsclient.BaseStackResources{
+					AWSRegion: "some-region",
+					AccountID: "123456789012",
+					VPCID:     "vpc-12345",
+				}
+
+				Expect(app.Show(stackName, options)).To(Succeed())
+				Expect(json.Unmarshal(resultBuffer.Contents(), &result)).To(Succeed())
+
+				Expect(result.State).To(BeEmpty())
+				Expect(result.IAMPolicy.String()).To(ContainSubstring(`"arn:aws:ec2:some-region:123456789012:vpc/vpc-12345"`))
+				Expect(result.IAMPolicy.String()).NotTo(ContainSubstring("Fn::Join"))
+			})
+		})
+
+		Context("when the stacks don't exist", func() {
+			It("should leave them out", func() {
+				awsClient.StackExistsCall.Returns.Exists = false
+
+				Expect(app.Show(stackName, options)).To(Succeed())
+				Expect(json.Unmarshal(resultBuffer.Contents(), &result)).To(Succeed())
+
+				Expect(result.Stacks).To(BeNil())
+				Expect(resultBuffer.Contents()).NotTo(ContainSubstring("stacks"))
+			})
+		})
+
+		Context("when listing the config store errors", func() {
+			It("should return the error", func() {
+				configStore.ListError = errors.New("some error")
+				Expect(app.Show(stackName, options)).To(MatchError("some error"))
+			})
+		})
+
+		Context("when getting a value errors", func() {
+			It("should return the error", func() {
+				configStore.Errors["bosh-ip"] = errors.New("some error")
+				Expect(app.Show(stackName, options)).To(MatchError("some error"))
+			})
+		})
+
+		Context("when checking for a stack errors", func() {
+			It("should return the error", func() {
+				awsClient.StackExistsCall.Returns.Error = errors.New("some error")
+				Expect(app.Show(stackName, options)).To(MatchError("some error"))
+			})
+		})
+
+		Context("when getting the stack resources errors", func() {
+			It("should return the error", func() {
+				awsClient.GetStackResourcesCalls[1].Returns.Error = errors.New("some error")
+				Expect(app.Show(stackName, options)).To(MatchError("some error"))
+			})
+		})
+	})
+
+	Context("when the secrets option is set without JSON", func() {
+		It("should return an error", func() {
+			Expect(app.Show(stackName, application.ShowOptions{BoshIP: true, Secrets: true})).To(MatchError("--secrets only applies to --json"))
+		})
+	})
+
 	Context("when writing the result errors", func() {
 		It("should return the error", func() {
-			options.SSHKey = true
+			options = application.ShowOptions{SSHKey: true}
 			app.ResultWriter = &erroringWriter{}
 			Expect(app.Show(stackName, options)).To(MatchError("write failed"))
 		})
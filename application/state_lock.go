@@ -0,0 +1,85 @@
+package application
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// stateLockKey holds the advisory lock, alongside the state
+const stateLockKey = "tubes.lock"
+
+// StateLock records who is running a command that changes the state
+type StateLock struct {
+	ID       string    `json:"id"`
+	Owner    string    `json:"owner"`
+	Command  string    `json:"command"`
+	Acquired time.Time `json:"acquired"`
+	Host     string    `json:"host,omitempty"`
+	PID      int       `json:"pid,omitempty"`
+}
+
+func (l StateLock) String() string {
+	return fmt.Sprintf("%s, running %s since %s", l.Owner, l.Command, l.Acquired.Format(time.RFC3339))
+}
+
+func newStateLock(owner, command string) (*StateLock, error) {
+	idBytes := make([]byte, 16)
+	_, err := rand.Read(idBytes)
+	if err != nil {
+		return nil, err // not tested
+	}
+
+	hostName, err := os.Hostname()
+	if err != nil {
+		return nil, err // not tested
+	}
+
+	return &StateLock{
+		ID:       fmt.Sprintf("%x", idBytes),
+		Owner:    owner,
+		Command:  command,
+		Acquired: time.Now().UTC(),
+		Host:     hostName,
+		PID:      os.Getpid(),
+	}, nil
+}
+
+func parseStateLock(lockJSON []byte) (*StateLock, error) {
+	lock := &StateLock{}
+	err := json.Unmarshal(lockJSON, lock)
+	if err != nil {
+		return nil, fmt.Errorf("reading the state lock: %s", err)
+	}
+	return lock, nil
+}
+
+// isStale is true when the lock's process is known to be gone, which can only
+// be known on the host that took it
+func (l StateLock) isStale() bool {
+	hostName, err := os.Hostname()
+	if err != nil || l.Host != hostName || l.PID == 0 {
+		return false
+	}
+
+	process, err := os.FindProcess(l.PID)
+	if err != nil {
+		return true
+	}
+	err = process.Signal(syscall.Signal(0))
+	return err != nil && err != syscall.EPERM
+}
+
+var errLockRace = errors.New("another run took the state lock at the same time, try again")
+
+func errStateLocked(holder *StateLock) error {
+	return fmt.Errorf("the state is locked by %s.  If that run is gone, remove the lock with force-unlock", holder)
+}
+
+func errLockTakenOver(held *StateLock) error {
+	return fmt.Errorf("the state lock was removed or taken over while %s ran", held.Command)
+}
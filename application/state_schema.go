@@ -0,0 +1,172 @@
+package application
+
+import (
+	"fmt"
+
+	"github.com/rosenhouse/tubes/lib/director"
+	"gopkg.in/yaml.v2"
+)
+
+// Version is the version of tubes.  Releases set it with the linker flag
+// -X github.com/rosenhouse/tubes/application.Version=1.2.3
+var Version = "dev"
+
+// StateSchemaVersion is the layout of the state directory that this tubes
+// reads and writes.  Bump it, and add a migration, when the layout changes.
+const StateSchemaVersion = 2
+
+const stateManifestKey = "tubes-state.yml"
+
+// StateManifest records which layout the state directory has, and which tubes last changed it
+type StateManifest struct {
+	SchemaVersion int    `yaml:"schema_version"`
+	TubesVersion  string `yaml:"tubes_version"`
+}
+
+type stateMigration struct {
+	description string
+	migrate     func(*Application) error
+}
+
+// stateMigrations[i] migrates schema version i+1 to i+2.  Version 1 is any
+// state directory from before the manifest.
+var stateMigrations = []stateMigration{
+	{"record the director credentials and options that used to be implicit", migrateImplicitState},
+}
+
+// loadStateManifest returns the manifest, or nil if the state directory is empty
+func (a *Application) loadStateManifest() (*StateManifest, error) {
+	manifestYAML, err := a.getOptional(stateManifestKey)
+	if err != nil {
+		return nil, err
+	}
+	if manifestYAML == nil {
+		empty, err := a.ConfigStore.IsEmpty()
+		if err != nil {
+			return nil, err
+		}
+		if empty {
+			return nil, nil
+		}
+		return &StateManifest{SchemaVersion: 1}, nil
+	}
+
+	manifest := &StateManifest{}
+	err = yaml.Unmarshal(manifestYAML, manifest)
+	if err != nil {
+		return nil, fmt.Errorf("malformed %s in state directory: %s", stateManifestKey, err)
+	}
+	if manifest.SchemaVersion < 1 {
+		return nil, fmt.Errorf("malformed %s in state directory: missing schema_version", stateManifestKey)
+	}
+	if manifest.SchemaVersion > StateSchemaVersion {
+		return nil, fmt.Errorf("the state directory has schema version %d, from tubes %s, but this is tubes %s which only knows up to version %d.  Upgrade tubes.",
+			manifest.SchemaVersion, manifest.TubesVersion, Version, StateSchemaVersion)
+	}
+	return manifest, nil
+}
+
+func (a *Application) storeStateManifest(schemaVersion int) error {
+	manifestYAML, err := yaml.Marshal(StateManifest{
+		SchemaVersion: schemaVersion,
+		TubesVersion:  Version,
+	})
+	if err != nil {
+		return err // not tested
+	}
+	return a.ConfigStore.Set(stateManifestKey, manifestYAML)
+}
+
+// CheckStateSchema errors if the state directory is newer than this tubes understands
+func (a *Application) CheckStateSchema() error {
+	_, err := a.loadStateManifest()
+	return err
+}
+
+// MigrateState brings an older state directory up to the current schema
+// version, and records this tubes version in the manifest.  An empty state
+// directory is left alone.
+func (a *Application) MigrateState() error {
+	manifest, err := a.loadStateManifest()
+	if err != nil {
+		return err
+	}
+	if manifest == nil {
+		return nil
+	}
+
+	for version := manifest.SchemaVersion; version < StateSchemaVersion; version++ {
+		migration := stateMigrations[version-1]
+		a.Logger.Printf("Migrating the state directory to version %d: %s\n", version+1, migration.description)
+		err = migration.migrate(a)
+		if err != nil {
+			return fmt.Errorf("migrating the state directory to version %d: %s", version+1, err)
+		}
+
+		// record each step, so that a failed migration resumes where it stopped
+		err = a.storeStateManifest(version + 1)
+		if err != nil {
+			return err
+		}
+	}
+
+	if manifest.SchemaVersion == StateSchemaVersion && manifest.TubesVersion == Version {
+		return nil
+	}
+	return a.storeStateManifest(StateSchemaVersion)
+}
+
+// migrateImplicitState records what older versions of tubes only kept inside
+// director.yml, or assumed, so that up doesn't replace the passwords of a
+// running director or change the options of an existing environment
+func migrateImplicitState(a *Application) error {
+	optionsYAML, err := a.getOptional("up-options.yml")
+	if err != nil {
+		return err
+	}
+	if optionsYAML == nil {
+		err = a.storeUpOptions(UpOptions{})
+		if err != nil {
+			return err
+		}
+	}
+
+	manifestYAML, err := a.getOptional("director.yml")
+	if err != nil {
+		return err
+	}
+	if manifestYAML == nil {
+		return nil
+	}
+	credentials, awsCredentials, err := director.ParseCredentials(manifestYAML)
+	if err != nil {
+		return err
+	}
+
+	stored, err := a.loadDirectorCredentials()
+	if err != nil {
+		return err
+	}
+	if stored == (director.Credentials{}) {
+		err = a.storeDirectorCredentials(credentials)
+		if err != nil {
+			return err
+		}
+	}
+
+	if awsCredentials.AccessKeyID == "" {
+		return nil
+	}
+	accessKey, err := a.getOptional("director-access-key-id")
+	if err != nil {
+		return err
+	}
+	if accessKey != nil {
+		return nil
+	}
+	err = a.ConfigStore.Set("director-access-key-id", []byte(awsCredentials.AccessKeyID))
+	if err != nil {
+		return err
+	}
+	return a.ConfigStore.Set("director-secret-access-key", []byte(awsCredentials.SecretAccessKey))
+}
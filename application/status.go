@@ -0,0 +1,262 @@
+package application
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rosenhouse/tubes/lib/awsclient"
+	"github.com/rosenhouse/tubes/lib/director"
+)
+
+// StatusCheck is one comparison of the state directory with reality
+type StatusCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+}
+
+// StatusReport is what status prints
+type StatusReport struct {
+	Name   string        `json:"name"`
+	Drift  bool          `json:"drift"`
+	Checks []StatusCheck `json:"checks"`
+}
+
+func (r *StatusReport) add(name string, ok bool, format string, v ...interface{}) {
+	r.Checks = append(r.Checks, StatusCheck{
+		Name:   name,
+		OK:     ok,
+		Detail: fmt.Sprintf(format, v...),
+	})
+	if !ok {
+		r.Drift = true
+	}
+}
+
+// Status compares the state directory with the live environment and prints
+// the result as a table, or as JSON.  It errors if anything has drifted.
+func (a *Application) Status(stackName string, asJSON bool) error {
+	err := validateStackName(stackName)
+	if err != nil {
+		return err
+	}
+
+	options, err := a.loadUpOptions(UpOptions{})
+	if err != nil {
+		return err
+	}
+
+	report := &StatusReport{Name: stackName}
+	err = a.checkStatus(stackName, options, report)
+	if err != nil {
+		return err
+	}
+
+	err = a.writeStatusReport(report, asJSON)
+	if err != nil {
+		return err
+	}
+	if report.Drift {
+		return fmt.Errorf("the environment has drifted from the state directory")
+	}
+	return nil
+}
+
+func (a *Application) checkStatus(stackName string, options UpOptions, report *StatusReport) error {
+	baseStackExists := false
+	pundit := awsclient.CloudFormationUpsertPundit{}
+	for _, suffix := range []string{"base", "concourse"} {
+		status, err := a.AWSClient.StackStatus(stackName + "-" + suffix)
+		if err != nil {
+			return err
+		}
+		if status == "" {
+			report.add(suffix+" stack", false, "missing")
+			continue
+		}
+		report.add(suffix+" stack", pundit.IsHealthy(status) && pundit.IsComplete(status), status)
+		if suffix == "base" {
+			baseStackExists = true
+		}
+	}
+	if !baseStackExists {
+		return nil
+	}
+
+	resources, err := a.AWSClient.GetBaseStackResources(stackName + "-base")
+	if err != nil {
+		return err
+	}
+
+	err = a.checkInstances(report, resources)
+	if err != nil {
+		return err
+	}
+
+	err = a.checkNATGateways(report, resources)
+	if err != nil {
+		return err
+	}
+
+	expectedBoshIP := resources.BOSHElasticIP
+	if options.PrivateDirector {
+		expectedBoshIP, err = director.InternalIP(resources.BOSHSubnetCIDR)
+		if err != nil {
+			return err
+		}
+	}
+	boshIP, err := a.checkStoredValue(report, "bosh-ip", expectedBoshIP)
+	if err != nil {
+		return err
+	}
+	_, err = a.checkStoredValue(report, "nat-ip", resources.BastionElasticIP)
+	if err != nil {
+		return err
+	}
+
+	if !options.InstanceProfile {
+		err = a.checkAccessKeys(report, resources.BOSHUser)
+		if err != nil {
+			return err
+		}
+	}
+
+	return a.checkDirector(report, boshIP, options.PrivateDirector)
+}
+
+// checkInstances reports any NAT instance, in any zone, that isn't running,
+// or the bastion instead when the environment uses NAT gateways
+func (a *Application) checkInstances(report *StatusReport, resources awsclient.BaseStackResources) error {
+	if resources.NATInstanceID == "" {
+		state, err := a.AWSClient.InstanceState(resources.BastionInstanceID)
+		if err != nil {
+			return err
+		}
+		report.add("bastion instance", state == "running", "%s is %s", resources.BastionInstanceID, state)
+		return nil
+	}
+
+	zones := resources.Zones
+	if len(zones) == 0 {
+		zones = []awsclient.Zone{{NATInstanceID: resources.NATInstanceID}}
+	}
+
+	for i, zone := range zones {
+		if zone.NATInstanceID == "" {
+			continue
+		}
+		state, err := a.AWSClient.InstanceState(zone.NATInstanceID)
+		if err != nil {
+			return err
+		}
+		report.add(strings.TrimSpace("nat instance "+awsclient.ZoneSuffix(i)), state == "running", "%s is %s", zone.NATInstanceID, state)
+	}
+	return nil
+}
+
+// checkNATGateways reports any NAT gateway, in any zone, that isn't available
+func (a *Application) checkNATGateways(report *StatusReport, resources awsclient.BaseStackResources) error {
+	zones := resources.Zones
+	if len(zones) == 0 {
+		zones = []awsclient.Zone{{NATGatewayID: resources.NATGatewayID}}
+	}
+
+	for i, zone := range zones {
+		if zone.NATGatewayID == "" {
+			continue
+		}
+		state, err := a.AWSClient.NATGatewayState(zone.NATGatewayID)
+		if err != nil {
+			return err
+		}
+		report.add(strings.TrimSpace("nat gateway "+awsclient.ZoneSuffix(i)), state == "available", "%s is %s", zone.NATGatewayID, state)
+	}
+	return nil
+}
+
+// checkStoredValue compares a value in the state directory with the live one, returning the stored value
+func (a *Application) checkStoredValue(report *StatusReport, key, actual string) (string, error) {
+	stored, err := a.getOptional(key)
+	if err != nil {
+		return "", err
+	}
+	if string(stored) != actual {
+		report.add(key, false, "the state directory has %q, but the stack has %q", stored, actual)
+	} else {
+		report.add(key, true, "%s", actual)
+	}
+	return string(stored), nil
+}
+
+func (a *Application) checkAccessKeys(report *StatusReport, userName string) error {
+	accessKeys, err := a.AWSClient.ListAccessKeys(userName)
+	if err != nil {
+		return err
+	}
+	storedKey, err := a.getOptional("director-access-key-id")
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !containsString(accessKeys, string(storedKey)):
+		report.add("access keys", false, "%s has %d, but not the one in the state directory", userName, len(accessKeys))
+	case len(accessKeys) != 1:
+		report.add("access keys", false, "%s has %d, expected 1", userName, len(accessKeys))
+	default:
+		report.add("access keys", true, "%s has 1", userName)
+	}
+	return nil
+}
+
+func (a *Application) checkDirector(report *StatusReport, boshIP string, private bool) error {
+	password, err := a.getOptional("bosh-password")
+	if err != nil {
+		return err
+	}
+	storedUUID, err := a.getOptional("director-uuid")
+	if err != nil {
+		return err
+	}
+
+	target, closeTarget, err := a.directorTarget(boshIP, private)
+	if err != nil {
+		return err
+	}
+	defer closeTarget()
+
+	info, err := a.DirectorClient.Info(target, "admin", string(password))
+	switch {
+	case err != nil:
+		report.add("director", false, "not answering at %s: %s", boshIP, err)
+	case storedUUID != nil && info.UUID != string(storedUUID):
+		report.add("director", false, "answering at %s as %s, but the state directory has %s", boshIP, info.UUID, storedUUID)
+	default:
+		report.add("director", true, "%q version %s answering at %s", info.Name, info.Version, boshIP)
+	}
+	return nil
+}
+
+func (a *Application) writeStatusReport(report *StatusReport, asJSON bool) error {
+	if asJSON {
+		reportJSON, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err // not tested
+		}
+		_, err = a.ResultWriter.Write(append(reportJSON, '\n'))
+		return err
+	}
+
+	table := tabwriter.NewWriter(a.ResultWriter, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(table, "CHECK\tSTATUS\tDETAIL")
+	for _, check := range report.Checks {
+		status := "ok"
+		if !check.OK {
+			status = "DRIFT"
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\n", check.Name, status, check.Detail)
+	}
+	return table.Flush()
+}
@@ -0,0 +1,338 @@
+package application_test
+
+import (
+	"encoding/json"
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/rosenhouse/tubes/application"
+	"github.com/rosenhouse/tubes/lib/awsclient"
+	"github.com/rosenhouse/tubes/lib/director"
+	"github.com/rosenhouse/tubes/mocks"
+)
+
+var _ = Describe("Status", func() {
+	var report application.StatusReport
+
+	checks := func() map[string]application.StatusCheck {
+		byName := map[string]application.StatusCheck{}
+		for _, check := range report.Checks {
+			byName[check.Name] = check
+		}
+		return byName
+	}
+
+	BeforeEach(func() {
+		report = application.StatusReport{}
+
+		configStore.Values["bosh-ip"] = []byte("some-bosh-ip")
+		configStore.Values["nat-ip"] = []byte("some-nat-ip")
+		configStore.Values["bosh-password"] = []byte("some-password")
+		configStore.Values["director-uuid"] = []byte("some-uuid")
+		configStore.Values["director-access-key-id"] = []byte("some-access-key")
+		configStore.Values["director-cert.pem"] = []byte("some-cert")
+
+		awsClient.StackStatusCalls = make([]mocks.StackStatusCall, 2)
+		awsClient.StackStatusCalls[0].Returns.Status = "UPDATE_COMPLETE"
+		awsClient.StackStatusCalls[1].Returns.Status = "CREATE_COMPLETE"
+		awsClient.GetBaseStackResourcesCall.Returns.Resources = awsclient.BaseStackResources{
+			BOSHUser:         "some-bosh-user",
+			BOSHElasticIP:    "some-bosh-ip",
+			BOSHSubnetCIDR:   "10.0.0.0/24",
+			NATInstanceID:    "some-nat-instance-id",
+			BastionElasticIP: "some-nat-ip",
+		}
+		awsClient.InstanceStateCalls = make([]mocks.InstanceStateCall, 3)
+		for i := range awsClient.InstanceStateCalls {
+			awsClient.InstanceStateCalls[i].Returns.State = "running"
+		}
+		awsClient.ListAccessKeysCall.Returns.AccessKeys = []string{"some-access-key"}
+		directorClient.InfoCall.Returns.Info = director.Info{
+			Name:    "some-director",
+			UUID:    "some-uuid",
+			Version: "some-version",
+		}
+	})
+
+	Context("when everything matches", func() {
+		It("should print a table of the checks", func() {
+			Expect(app.Status(stackName, false)).To(Succeed())
+
+			Expect(resultBuffer).To(gbytes.Say(`CHECK\s+STATUS\s+DETAIL\n`))
+			Expect(resultBuffer).To(gbytes.Say(`base stack\s+ok\s+UPDATE_COMPLETE\n`))
+			Expect(resultBuffer).To(gbytes.Say(`concourse stack\s+ok\s+CREATE_COMPLETE\n`))
+			Expect(resultBuffer).To(gbytes.Say(`nat instance\s+ok\s+some-nat-instance-id is running\n`))
+			Expect(resultBuffer).To(gbytes.Say(`bosh-ip\s+ok\s+some-bosh-ip\n`))
+			Expect(resultBuffer).To(gbytes.Say(`nat-ip\s+ok\s+some-nat-ip\n`))
+			Expect(resultBuffer).To(gbytes.Say(`access keys\s+ok\s+some-bosh-user has 1\n`))
+			Expect(resultBuffer).To(gbytes.Say(`director\s+ok\s+"some-director" version some-version answering at some-bosh-ip\n`))
+		})
+
+		It("should check the stacks, instance, user and director of the environment", func() {
+			Expect(app.Status(stackName, false)).To(Succeed())
+
+			Expect(awsClient.StackStatusCalls[0].Receives.StackName).To(Equal(stackName + "-base"))
+			Expect(awsClient.StackStatusCalls[1].Receives.StackName).To(Equal(stackName + "-concourse"))
+			Expect(awsClient.GetBaseStackResourcesCall.Receives.StackName).To(Equal(stackName + "-base"))
+			Expect(awsClient.InstanceStateCalls[0].Receives.InstanceID).To(Equal("some-nat-instance-id"))
+			Expect(awsClient.ListAccessKeysCall.Receives.UserName).To(Equal("some-bosh-user"))
+			Expect(directorClient.InfoCall.Receives.Target.Host).To(Equal("some-bosh-ip"))
+			Expect(directorClient.InfoCall.Receives.Target.CACert).To(Equal([]byte("some-cert")))
+			Expect(directorClient.InfoCall.Receives.Username).To(Equal("admin"))
+			Expect(directorClient.InfoCall.Receives.Password).To(Equal("some-password"))
+		})
+
+		It("should print JSON when asked", func() {
+			Expect(app.Status(stackName, true)).To(Succeed())
+
+			Expect(json.Unmarshal(resultBuffer.Contents(), &report)).To(Succeed())
+			Expect(report.Name).To(Equal(stackName))
+			Expect(report.Drift).To(BeFalse())
+			Expect(report.Checks).To(HaveLen(7))
+			Expect(report.Checks[0]).To(Equal(application.StatusCheck{Name: "base stack", OK: true, Detail: "UPDATE_COMPLETE"}))
+		})
+	})
+
+	Context("when something has drifted", func() {
+		It("should report it and return an error", func() {
+			awsClient.InstanceStateCalls[0].Returns.State = "stopped"
+
+			Expect(app.Status(stackName, false)).To(MatchError("the environment has drifted from the state directory"))
+			Expect(resultBuffer).To(gbytes.Say(`nat instance\s+DRIFT\s+some-nat-instance-id is stopped\n`))
+		})
+
+		It("should still print the JSON", func() {
+			awsClient.StackStatusCalls[1].Returns.Status = "UPDATE_ROLLBACK_COMPLETE"
+
+			Expect(app.Status(stackName, true)).To(HaveOccurred())
+			Expect(json.Unmarshal(resultBuffer.Contents(), &report)).To(Succeed())
+			Expect(report.Drift).To(BeTrue())
+			Expect(checks()["concourse stack"].OK).To(BeFalse())
+		})
+	})
+
+	Describe("the checks", func() {
+		JustBeforeEach(func() {
+			app.Status(stackName, true)
+			Expect(json.Unmarshal(resultBuffer.Contents(), &report)).To(Succeed())
+		})
+
+		Context("when the base stack is missing", func() {
+			BeforeEach(func() {
+				awsClient.StackStatusCalls[0].Returns.Status = ""
+			})
+
+			It("should only report the stacks", func() {
+				Expect(report.Checks).To(HaveLen(2))
+				Expect(checks()["base stack"]).To(Equal(application.StatusCheck{Name: "base stack", OK: false, Detail: "missing"}))
+				Expect(awsClient.GetBaseStackResourcesCall.Receives.StackName).To(BeEmpty())
+			})
+		})
+
+		Context("when the elastic IPs differ from the state directory", func() {
+			BeforeEach(func() {
+				awsClient.GetBaseStackResourcesCall.Returns.Resources.BOSHElasticIP = "other-bosh-ip"
+				awsClient.GetBaseStackResourcesCall.Returns.Resources.BastionElasticIP = "other-nat-ip"
+			})
+
+			It("should report both", func() {
+				Expect(checks()["bosh-ip"].OK).To(BeFalse())
+				Expect(checks()["bosh-ip"].Detail).To(Equal(`the state directory has "some-bosh-ip", but the stack has "other-bosh-ip"`))
+				Expect(checks()["nat-ip"].OK).To(BeFalse())
+			})
+		})
+
+		Context("when the BOSH user has more than one access key", func() {
+			BeforeEach(func() {
+				awsClient.ListAccessKeysCall.Returns.AccessKeys = []string{"some-access-key", "other-access-key"}
+			})
+
+			It("should report it", func() {
+				Expect(checks()["access keys"]).To(Equal(application.StatusCheck{Name: "access keys", OK: false, Detail: "some-bosh-user has 2, expected 1"}))
+			})
+		})
+
+		Context("when the BOSH user doesn't have the stored access key", func() {
+			BeforeEach(func() {
+				awsClient.ListAccessKeysCall.Returns.AccessKeys = []string{"other-access-key"}
+			})
+
+			It("should report it", func() {
+				Expect(checks()["access keys"].Detail).To(Equal("some-bosh-user has 1, but not the one in the state directory"))
+			})
+		})
+
+		Context("when the director doesn't answer", func() {
+			BeforeEach(func() {
+				directorClient.InfoCall.Returns.Error = errors.New("connection refused")
+			})
+
+			It("should report it", func() {
+				Expect(checks()["director"]).To(Equal(application.StatusCheck{Name: "director", OK: false, Detail: "not answering at some-bosh-ip: connection refused"}))
+			})
+		})
+
+		Context("when a different director answers", func() {
+			BeforeEach(func() {
+				directorClient.InfoCall.Returns.Info.UUID = "other-uuid"
+			})
+
+			It("should report it", func() {
+				Expect(checks()["director"].OK).To(BeFalse())
+				Expect(checks()["director"].Detail).To(Equal("answering at some-bosh-ip as other-uuid, but the state directory has some-uuid"))
+			})
+		})
+
+		Context("when a NAT instance in another zone is stopped", func() {
+			BeforeEach(func() {
+				awsClient.GetBaseStackResourcesCall.Returns.Resources.Zones = []awsclient.Zone{
+					{NATInstanceID: "some-nat-instance-id"},
+					{NATInstanceID: "other-nat-instance-id"},
+				}
+				awsClient.InstanceStateCalls[1].Returns.State = "stopped"
+			})
+
+			It("should report each zone's", func() {
+				Expect(checks()["nat instance"].OK).To(BeTrue())
+				Expect(checks()["nat instance Z2"].OK).To(BeFalse())
+				Expect(checks()["nat instance Z2"].Detail).To(Equal("other-nat-instance-id is stopped"))
+				Expect(awsClient.InstanceStateCalls[1].Receives.InstanceID).To(Equal("other-nat-instance-id"))
+				Expect(report.Drift).To(BeTrue())
+			})
+		})
+
+		Context("when the environment uses a NAT gateway", func() {
+			BeforeEach(func() {
+				awsClient.GetBaseStackResourcesCall.Returns.Resources.NATInstanceID = ""
+				awsClient.GetBaseStackResourcesCall.Returns.Resources.BastionInstanceID = "some-bastion-instance-id"
+			})
+
+			It("should check the bastion instance instead", func() {
+				Expect(checks()["bastion instance"].OK).To(BeTrue())
+				Expect(awsClient.InstanceStateCalls[0].Receives.InstanceID).To(Equal("some-bastion-instance-id"))
+			})
+
+			Context("when the NAT gateway is available", func() {
+				BeforeEach(func() {
+					awsClient.GetBaseStackResourcesCall.Returns.Resources.NATGatewayID = "some-nat-gateway-id"
+					awsClient.NATGatewayStateCall.Returns.State = "available"
+				})
+
+				It("should report it", func() {
+					Expect(checks()["nat gateway"].OK).To(BeTrue())
+					Expect(checks()["nat gateway"].Detail).To(Equal("some-nat-gateway-id is available"))
+					Expect(awsClient.NATGatewayStateCall.Receives.NATGatewayID).To(Equal("some-nat-gateway-id"))
+				})
+			})
+
+			Context("when a NAT gateway in another zone isn't available", func() {
+				BeforeEach(func() {
+					awsClient.GetBaseStackResourcesCall.Returns.Resources.NATGatewayID = "some-nat-gateway-id"
+					awsClient.GetBaseStackResourcesCall.Returns.Resources.Zones = []awsclient.Zone{
+						{NATGatewayID: "some-nat-gateway-id"},
+						{NATGatewayID: "other-nat-gateway-id"},
+					}
+					awsClient.NATGatewayStateCall.Returns.State = "failed"
+				})
+
+				It("should report each zone's", func() {
+					Expect(checks()["nat gateway"].OK).To(BeFalse())
+					Expect(checks()["nat gateway Z2"].OK).To(BeFalse())
+					Expect(checks()["nat gateway Z2"].Detail).To(Equal("other-nat-gateway-id is failed"))
+					Expect(report.Drift).To(BeTrue())
+				})
+			})
+		})
+
+		Context("when the director is private", func() {
+			BeforeEach(func() {
+				configStore.Values["up-options.yml"] = []byte("private_director: true\n")
+				configStore.Values["bosh-ip"] = []byte("10.0.0.6")
+			})
+
+			It("should expect its internal IP, and reach it through an SSH tunnel to the NAT box", func() {
+				configStore.Values["ssh-key"] = []byte("some-ssh-key")
+
+				Expect(checks()["bosh-ip"].OK).To(BeTrue())
+				Expect(checks()["director"].OK).To(BeTrue())
+				Expect(sshTunneler.TunnelCall.Receives.Host).To(Equal("some-nat-ip"))
+				Expect(sshTunneler.TunnelCall.Receives.PrivateKey).To(Equal([]byte("some-ssh-key")))
+				Expect(directorClient.InfoCall.Receives.Target.Host).To(Equal("10.0.0.6"))
+				Expect(directorClient.InfoCall.Receives.Target.Dial).NotTo(BeNil())
+				Expect(sshTunneler.Closed).To(BeTrue())
+			})
+		})
+
+		Context("when the director uses an instance profile", func() {
+			BeforeEach(func() {
+				configStore.Values["up-options.yml"] = []byte("instance_profile: true\n")
+			})
+
+			It("should not check access keys", func() {
+				Expect(checks()).NotTo(HaveKey("access keys"))
+				Expect(awsClient.ListAccessKeysCall.Receives.UserName).To(BeEmpty())
+			})
+		})
+	})
+
+	Context("when the name is invalid", func() {
+		It("should immediately error", func() {
+			Expect(app.Status("invalid_name", false)).To(MatchError(ContainSubstring("invalid name")))
+		})
+	})
+
+	Context("when getting a stack status fails", func() {
+		It("should return the error", func() {
+			awsClient.StackStatusCalls[1].Returns.Error = errors.New("some error")
+			Expect(app.Status(stackName, false)).To(MatchError("some error"))
+		})
+	})
+
+	Context("when getting the base stack resources fails", func() {
+		It("should return the error", func() {
+			awsClient.GetBaseStackResourcesCall.Returns.Error = errors.New("some error")
+			Expect(app.Status(stackName, false)).To(MatchError("some error"))
+		})
+	})
+
+	Context("when getting the instance state fails", func() {
+		It("should return the error", func() {
+			awsClient.InstanceStateCalls[0].Returns.Error = errors.New("some error")
+			Expect(app.Status(stackName, false)).To(MatchError("some error"))
+		})
+	})
+
+	Context("when getting the NAT gateway state fails", func() {
+		It("should return the error", func() {
+			awsClient.GetBaseStackResourcesCall.Returns.Resources.NATGatewayID = "some-nat-gateway-id"
+			awsClient.NATGatewayStateCall.Returns.Error = errors.New("some error")
+			Expect(app.Status(stackName, false)).To(MatchError("some error"))
+		})
+	})
+
+	Context("when listing the access keys fails", func() {
+		It("should return the error", func() {
+			awsClient.ListAccessKeysCall.Returns.Error = errors.New("some error")
+			Expect(app.Status(stackName, false)).To(MatchError("some error"))
+		})
+	})
+
+	for _, key := range []string{"up-options.yml", "bosh-ip", "nat-ip", "director-access-key-id", "bosh-password", "director-uuid"} {
+		key := key
+		Context("when the config store errors on "+key, func() {
+			It("should return the error", func() {
+				configStore.Errors[key] = errors.New("some error")
+				Expect(app.Status(stackName, false)).To(MatchError("some error"))
+			})
+		})
+	}
+
+	Context("when writing the result errors", func() {
+		It("should return the error", func() {
+			app.ResultWriter = &erroringWriter{}
+			Expect(app.Status(stackName, true)).To(MatchError("write failed"))
+		})
+	})
+})
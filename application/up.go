@@ -6,54 +6,103 @@ import (
 	"strings"
 
 	"github.com/rosenhouse/tubes/lib/awsclient"
+	"github.com/rosenhouse/tubes/lib/director"
+	"golang.org/x/net/context"
+	"gopkg.in/yaml.v2"
 )
 
 const StackNamePattern = `^[a-zA-Z][-a-zA-Z0-9]*$`
 
-func (a *Application) Boot(stackName string) error {
+func validateStackName(stackName string) error {
 	regex := regexp.MustCompile(StackNamePattern)
 	if !regex.MatchString(stackName) {
 		return fmt.Errorf("invalid name: must match pattern %s", StackNamePattern)
 	}
+	return nil
+}
+
+func (a *Application) Boot(ctx context.Context, stackName string, requestedOptions UpOptions) error {
+	err := validateStackName(stackName)
+	if err != nil {
+		return err
+	}
+
+	options, err := a.loadUpOptions(requestedOptions)
+	if err != nil {
+		return err
+	}
 
 	emptyConfigStore, err := a.ConfigStore.IsEmpty()
 	if err != nil {
 		return err
 	}
-	if !emptyConfigStore {
-		return fmt.Errorf("state directory must be empty")
+
+	baseStackExists, err := a.AWSClient.StackExists(stackName + "-base")
+	if err != nil {
+		return err
+	}
+	if emptyConfigStore && baseStackExists {
+		return fmt.Errorf("state directory is empty but stack %q already exists", stackName+"-base")
 	}
 
-	a.Logger.Printf("Creating keypair...")
-	pemBytes, err := a.AWSClient.CreateKeyPair(stackName)
+	checkpoint, err := a.loadCheckpoint()
 	if err != nil {
 		return err
 	}
+	if checkpoint != nil && checkpoint.Command == "up" {
+		a.Logger.Printf("Resuming after up was interrupted while %s, at %s\n", checkpoint.Step, checkpoint.Time)
+	} else if checkpoint != nil {
+		a.Logger.Printf("%s was interrupted while %s, at %s.  Booting again, recreating whatever it deleted\n", checkpoint.Command, checkpoint.Step, checkpoint.Time)
+	}
 
-	err = a.ConfigStore.Set("ssh-key", []byte(pemBytes))
+	err = a.storeStateManifest(StateSchemaVersion)
 	if err != nil {
 		return err
 	}
 
-	a.Logger.Println("Looking for latest AWS NAT box AMI...")
-	natInstanceAMI, err := a.AWSClient.GetLatestNATBoxAMIID()
+	err = a.storeUpOptions(options)
 	if err != nil {
 		return err
 	}
-	a.Logger.Printf("Latest NAT box AMI is %q\n", natInstanceAMI)
 
-	parameters := map[string]string{
-		"NATInstanceAMI": natInstanceAMI,
-		"KeyName":        stackName,
+	err = a.step(ctx, "creating the keypair")
+	if err != nil {
+		return err
+	}
+
+	err = a.ensureKeyPair(stackName)
+	if err != nil {
+		return err
+	}
+
+	parameters, err := a.baseStackParameters(stackName)
+	if err != nil {
+		return err
+	}
+
+	err = a.ConfigStore.Set("nat-ami", []byte(parameters["NATInstanceAMI"]))
+	if err != nil {
+		return err
 	}
-	templateJSON := awsclient.BaseStackTemplate.String()
+
+	err = a.step(ctx, "upserting the base stack")
+	if err != nil {
+		return err
+	}
+
+	templateJSON := awsclient.NewBaseStackTemplate(options.baseStackOptions()).String()
 	a.Logger.Println("Upserting base stack.  Check CloudFormation console for details.")
 	err = a.AWSClient.UpsertStack(stackName+"-base", templateJSON, parameters)
 	if err != nil {
 		return err
 	}
 
-	err = a.AWSClient.WaitForStack(stackName+"-base", awsclient.CloudFormationUpsertPundit{})
+	err = a.step(ctx, "waiting for the base stack")
+	if err != nil {
+		return err
+	}
+
+	err = a.AWSClient.WaitForStack(ctx, stackName+"-base", awsclient.CloudFormationUpsertPundit{})
 	if err != nil {
 		return err
 	}
@@ -65,35 +114,70 @@ func (a *Application) Boot(stackName string) error {
 		return err
 	}
 
-	err = a.ConfigStore.Set("bosh-ip", []byte(baseStackResources.BOSHElasticIP))
+	boshIP := baseStackResources.BOSHElasticIP
+	if options.PrivateDirector {
+		boshIP, err = director.InternalIP(baseStackResources.BOSHSubnetCIDR)
+		if err != nil {
+			return err
+		}
+	}
+	err = a.reconcile("bosh-ip", boshIP, baseStackExists)
+	if err != nil {
+		return err
+	}
+
+	err = a.reconcile("nat-ip", baseStackResources.BastionElasticIP, baseStackExists)
 	if err != nil {
 		return err
 	}
 
-	err = a.ConfigStore.Set("nat-ip", []byte(baseStackResources.NATElasticIP))
+	if !baseStackExists {
+		// a new NAT box has a new host key, so forget any pinned for an old one
+		err = a.ConfigStore.Delete(natHostKeyKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = a.step(ctx, "generating the BOSH init manifest")
 	if err != nil {
 		return err
 	}
 
 	a.Logger.Println("Generating BOSH init manifest")
 
-	accessKey, secretKey, err := a.AWSClient.CreateAccessKey(baseStackResources.BOSHUser)
+	var accessKey, secretKey string
+	if options.InstanceProfile {
+		a.Logger.Println("Using the director instance profile instead of access keys")
+	} else {
+		accessKey, secretKey, err = a.ensureAccessKey(baseStackResources.BOSHUser, baseStackExists)
+		if err != nil {
+			return err
+		}
+	}
+
+	credentials, err := a.loadDirectorCredentials()
 	if err != nil {
 		return err
 	}
 
-	manifestYAML, boshPassword, err := a.ManifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey)
+	ssl, err := a.ensureDirectorCertificate(boshIP, baseStackExists)
 	if err != nil {
 		return err
 	}
 
-	boshEnvLines := []string{
-		fmt.Sprintf(`export BOSH_TARGET="%s"`, baseStackResources.BOSHElasticIP),
-		fmt.Sprintf(`export BOSH_USER="%s"`, "admin"),
-		fmt.Sprintf(`export BOSH_PASSWORD="%s"`, boshPassword),
-		fmt.Sprintf(`export NAT_IP="%s"`, baseStackResources.NATElasticIP),
+	manifestYAML, credentials, err := a.ManifestBuilder.Build(stackName, baseStackResources, accessKey, secretKey, credentials, ssl)
+	if err != nil {
+		return err
 	}
-	err = a.ConfigStore.Set("bosh-environment", []byte(strings.Join(boshEnvLines, "\n")))
+
+	err = a.storeDirectorCredentials(credentials)
+	if err != nil {
+		return err
+	}
+
+	boshPassword := credentials.Admin
+	err = a.storeBoshEnvironment(boshIP, baseStackResources.BastionElasticIP, boshPassword, options)
 	if err != nil {
 		return err
 	}
@@ -108,20 +192,24 @@ func (a *Application) Boot(stackName string) error {
 		return err
 	}
 
-	concourseTemplateJSON := awsclient.ConcourseStackTemplate.String()
+	err = a.step(ctx, "upserting the Concourse stack")
+	if err != nil {
+		return err
+	}
+
+	concourseTemplateJSON := awsclient.NewConcourseStackTemplate(options.concourseStackOptions()).String()
 	a.Logger.Println("Upserting Concourse stack.  Check CloudFormation console for details.")
-	err = a.AWSClient.UpsertStack(
-		stackName+"-concourse", concourseTemplateJSON, map[string]string{
-			"VPCID":                    baseStackResources.VPCID,
-			"NATInstance":              baseStackResources.NATInstanceID,
-			"PubliclyRoutableSubnetID": baseStackResources.BOSHSubnetID,
-			"AvailabilityZone":         baseStackResources.AvailabilityZone,
-		})
+	err = a.AWSClient.UpsertStack(stackName+"-concourse", concourseTemplateJSON, concourseStackParameters(baseStackResources, options))
+	if err != nil {
+		return err
+	}
+
+	err = a.step(ctx, "waiting for the Concourse stack")
 	if err != nil {
 		return err
 	}
 
-	err = a.AWSClient.WaitForStack(stackName+"-concourse", awsclient.CloudFormationUpsertPundit{})
+	err = a.AWSClient.WaitForStack(ctx, stackName+"-concourse", awsclient.CloudFormationUpsertPundit{})
 	if err != nil {
 		return err
 	}
@@ -129,12 +217,31 @@ func (a *Application) Boot(stackName string) error {
 	a.Logger.Println("Retrieving resource ids")
 	concourseStackResources, err := a.AWSClient.GetStackResources(stackName + "-concourse")
 	if err != nil {
-		panic(err)
+		return err
+	}
+
+	err = a.step(ctx, "generating the Concourse cloud config")
+	if err != nil {
+		return err
 	}
 
 	a.Logger.Println("Generating the concourse cloud config")
 
-	concourseCloudConfig, err := a.CloudConfigGenerator.Generate(concourseStackResources)
+	cloudConfigResources := map[string]string{
+		"AvailabilityZone":    baseStackResources.AvailabilityZone,
+		"ConcourseSubnetCIDR": awsclient.DefaultConcourseSubnetCIDR,
+		"VPCCIDR":             awsclient.DefaultVPCCIDR,
+	}
+	for i := 1; i < len(baseStackResources.Zones) && i < options.zoneCount(); i++ {
+		suffix := awsclient.ZoneSuffix(i)
+		cloudConfigResources["AvailabilityZone"+suffix] = baseStackResources.Zones[i].AvailabilityZone
+		cloudConfigResources["ConcourseSubnetCIDR"+suffix] = awsclient.DefaultConcourseSubnetCIDRs[i]
+	}
+	for key, value := range concourseStackResources {
+		cloudConfigResources[key] = value
+	}
+
+	concourseCloudConfig, err := a.CloudConfigGenerator.Generate(cloudConfigResources)
 	if err != nil {
 		return err
 	}
@@ -144,6 +251,254 @@ func (a *Application) Boot(stackName string) error {
 		return err
 	}
 
+	if checkpoint != nil {
+		err = a.ConfigStore.Delete(checkpointKey)
+		if err != nil {
+			return err
+		}
+	}
+
 	a.Logger.Println("Finished")
 	return nil
 }
+
+// storeBoshEnvironment writes the BOSH environment variables, suitable for sourcing in bash
+func (a *Application) storeBoshEnvironment(boshIP, natIP, boshPassword string, options UpOptions) error {
+	boshEnvLines := []string{
+		fmt.Sprintf(`export BOSH_TARGET="%s"`, boshIP),
+		fmt.Sprintf(`export BOSH_USER="%s"`, "admin"),
+		fmt.Sprintf(`export BOSH_PASSWORD="%s"`, boshPassword),
+		fmt.Sprintf(`export NAT_IP="%s"`, natIP),
+	}
+	if options.PrivateDirector {
+		boshEnvLines = append(boshEnvLines,
+			"# the director is only reachable through the NAT box, so first open a SOCKS5 proxy with",
+			`#   ssh -f -N -D 5000 -i ssh-key ec2-user@$NAT_IP`,
+			`export BOSH_ALL_PROXY="socks5://localhost:5000"`,
+		)
+	}
+	return a.ConfigStore.Set("bosh-environment", []byte(strings.Join(boshEnvLines, "\n")))
+}
+
+// ensureDirectorCertificate reuses the director's TLS certificate from the
+// state directory, or generates a self-signed one for its IP.  tubes trusts
+// only this certificate when it talks to the director.
+func (a *Application) ensureDirectorCertificate(boshIP string, reuse bool) (director.SSL, error) {
+	cert, err := a.getOptional(directorCertKey)
+	if err != nil {
+		return director.SSL{}, err
+	}
+	key, err := a.getOptional(directorKeyKey)
+	if err != nil {
+		return director.SSL{}, err
+	}
+	if reuse && cert != nil && key != nil {
+		return director.SSL{Cert: string(cert), Key: string(key)}, nil
+	}
+
+	a.Logger.Println("Generating a TLS certificate for the director")
+	cert, key, err = a.CertificateGenerator.Generate("bosh-director", []string{boshIP})
+	if err != nil {
+		return director.SSL{}, err
+	}
+	err = a.ConfigStore.Set(directorKeyKey, key)
+	if err != nil {
+		return director.SSL{}, err
+	}
+	err = a.ConfigStore.Set(directorCertKey, cert)
+	if err != nil {
+		return director.SSL{}, err
+	}
+	return director.SSL{Cert: string(cert), Key: string(key)}, nil
+}
+
+// ensureKeyPair reuses the SSH key in the state directory, re-importing it if
+// the keypair is gone from AWS.  A new keypair is only created when neither exist.
+func (a *Application) ensureKeyPair(stackName string) error {
+	pemBytes, err := a.getOptional("ssh-key")
+	if err != nil {
+		return err
+	}
+
+	keyPairExists, err := a.AWSClient.KeyPairExists(stackName)
+	if err != nil {
+		return err
+	}
+
+	if pemBytes != nil {
+		if keyPairExists {
+			a.Logger.Printf("Reusing existing keypair")
+			return nil
+		}
+		a.Logger.Printf("Importing keypair from state directory...")
+		return a.AWSClient.ImportKeyPair(stackName, pemBytes)
+	}
+
+	if keyPairExists {
+		return fmt.Errorf("state directory has no ssh-key but keypair %q already exists", stackName)
+	}
+
+	a.Logger.Printf("Creating keypair...")
+	pemString, err := a.AWSClient.CreateKeyPair(stackName)
+	if err != nil {
+		return err
+	}
+
+	return a.ConfigStore.Set("ssh-key", []byte(pemString))
+}
+
+// baseStackParameters are the parameters Boot passes to the base stack
+func (a *Application) baseStackParameters(stackName string) (map[string]string, error) {
+	natInstanceAMI, err := a.getNATInstanceAMI()
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"NATInstanceAMI": natInstanceAMI,
+		"KeyName":        stackName,
+	}, nil
+}
+
+// concourseStackParameters are the parameters Boot passes to the Concourse stack
+func concourseStackParameters(baseStackResources awsclient.BaseStackResources, options UpOptions) map[string]string {
+	parameters := map[string]string{
+		"VPCID":                    baseStackResources.VPCID,
+		"PubliclyRoutableSubnetID": baseStackResources.BOSHSubnetID,
+		"AvailabilityZone":         baseStackResources.AvailabilityZone,
+	}
+	if options.NATGateway {
+		parameters["NATGateway"] = baseStackResources.NATGatewayID
+	} else {
+		parameters["NATInstance"] = baseStackResources.NATInstanceID
+	}
+	for i := 1; i < options.zoneCount(); i++ {
+		var zone awsclient.Zone
+		if i < len(baseStackResources.Zones) {
+			zone = baseStackResources.Zones[i]
+		}
+		suffix := awsclient.ZoneSuffix(i)
+		parameters["AvailabilityZone"+suffix] = zone.AvailabilityZone
+		parameters["PubliclyRoutableSubnetID"+suffix] = zone.PublicSubnetID
+		if options.NATGateway {
+			parameters["NATGateway"+suffix] = zone.NATGatewayID
+		} else {
+			parameters["NATInstance"+suffix] = zone.NATInstanceID
+		}
+	}
+	return parameters
+}
+
+// getNATInstanceAMI returns the NAT box AMI pinned in the state directory, so that
+// re-running up doesn't replace the NAT instance whenever Amazon publishes a new image
+func (a *Application) getNATInstanceAMI() (string, error) {
+	natInstanceAMI, err := a.getOptional("nat-ami")
+	if err != nil {
+		return "", err
+	}
+	if natInstanceAMI != nil {
+		return string(natInstanceAMI), nil
+	}
+
+	a.Logger.Println("Looking for latest AWS NAT box AMI...")
+	latestAMI, err := a.AWSClient.GetLatestNATBoxAMIID()
+	if err != nil {
+		return "", err
+	}
+	a.Logger.Printf("Latest NAT box AMI is %q\n", latestAMI)
+
+	return latestAMI, nil
+}
+
+// reconcile stores a value discovered from the cloud.  If the stack already
+// existed, the value must match anything previously recorded.
+func (a *Application) reconcile(key, actual string, stackExisted bool) error {
+	stored, err := a.getOptional(key)
+	if err != nil {
+		return err
+	}
+	if stackExisted && stored != nil && string(stored) != actual {
+		return fmt.Errorf("state directory does not match cloud resources: %s is %q in the state directory but %q on AWS", key, stored, actual)
+	}
+	return a.ConfigStore.Set(key, []byte(actual))
+}
+
+// ensureAccessKey reuses the director access key from the state directory when
+// the BOSH user still has it, and otherwise creates a new one for a fresh stack.
+// Any other key the user has was leaked by an earlier run that stopped before
+// recording it, so it is deleted rather than left to use up the user's key limit.
+func (a *Application) ensureAccessKey(userName string, stackExisted bool) (string, string, error) {
+	accessKey, err := a.getOptional("director-access-key-id")
+	if err != nil {
+		return "", "", err
+	}
+	secretKey, err := a.getOptional("director-secret-access-key")
+	if err != nil {
+		return "", "", err
+	}
+	recorded := accessKey != nil && secretKey != nil
+
+	existingKeys, err := a.AWSClient.ListAccessKeys(userName)
+	if err != nil {
+		return "", "", err
+	}
+
+	found := false
+	for _, existingKey := range existingKeys {
+		if recorded && existingKey == string(accessKey) {
+			found = true
+		}
+	}
+	if recorded && !found && stackExisted {
+		return "", "", fmt.Errorf("state directory does not match cloud resources: access key %q not found for user %q", accessKey, userName)
+	}
+
+	for _, existingKey := range existingKeys {
+		if recorded && existingKey == string(accessKey) {
+			continue
+		}
+		a.Logger.Printf("Deleting access key %q, which is not recorded in the state directory\n", existingKey)
+		err = a.AWSClient.DeleteAccessKey(userName, existingKey)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	if found {
+		return string(accessKey), string(secretKey), nil
+	}
+
+	newAccessKey, newSecretKey, err := a.AWSClient.CreateAccessKey(userName)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = a.ConfigStore.Set("director-access-key-id", []byte(newAccessKey))
+	if err != nil {
+		return "", "", err
+	}
+	err = a.ConfigStore.Set("director-secret-access-key", []byte(newSecretKey))
+	if err != nil {
+		return "", "", err
+	}
+
+	return newAccessKey, newSecretKey, nil
+}
+
+func (a *Application) loadDirectorCredentials() (director.Credentials, error) {
+	var credentials director.Credentials
+	credentialsYAML, err := a.getOptional("director-credentials.yml")
+	if err != nil {
+		return credentials, err
+	}
+	err = yaml.Unmarshal(credentialsYAML, &credentials)
+	return credentials, err
+}
+
+func (a *Application) storeDirectorCredentials(credentials director.Credentials) error {
+	credentialsYAML, err := yaml.Marshal(credentials)
+	if err != nil {
+		return err // not tested
+	}
+	return a.ConfigStore.Set("director-credentials.yml", credentialsYAML)
+}
@@ -0,0 +1,79 @@
+package application
+
+import (
+	"fmt"
+
+	"github.com/rosenhouse/tubes/lib/awsclient"
+	"gopkg.in/yaml.v2"
+)
+
+// UpOptions select variants of the environment.  The options of the first
+// up are recorded in the state directory, and every later command uses them.
+type UpOptions struct {
+	PrivateDirector bool `yaml:"private_director"`
+	NATGateway      bool `yaml:"nat_gateway"`
+
+	// AvailabilityZones is the number of zones to span.  Zero means one.
+	AvailabilityZones int `yaml:"availability_zones"`
+
+	// InstanceProfile gives the director an IAM instance profile instead of access keys
+	InstanceProfile bool `yaml:"instance_profile"`
+}
+
+func (o UpOptions) zoneCount() int {
+	if o.AvailabilityZones < 1 {
+		return 1
+	}
+	return o.AvailabilityZones
+}
+
+func (o UpOptions) baseStackOptions() awsclient.BaseStackOptions {
+	return awsclient.BaseStackOptions{
+		PrivateDirector:   o.PrivateDirector,
+		NATGateway:        o.NATGateway,
+		AvailabilityZones: o.AvailabilityZones,
+		InstanceProfile:   o.InstanceProfile,
+	}
+}
+
+func (o UpOptions) concourseStackOptions() awsclient.ConcourseStackOptions {
+	return awsclient.ConcourseStackOptions{
+		NATGateway:        o.NATGateway,
+		AvailabilityZones: o.AvailabilityZones,
+	}
+}
+
+// loadUpOptions returns the options recorded in the state directory, or the
+// requested ones if none are recorded.  Recorded options can't be changed.
+func (a *Application) loadUpOptions(requested UpOptions) (UpOptions, error) {
+	if requested.AvailabilityZones < 0 || requested.AvailabilityZones > awsclient.MaxAvailabilityZones {
+		return UpOptions{}, fmt.Errorf("availability zones must be between 1 and %d", awsclient.MaxAvailabilityZones)
+	}
+
+	optionsYAML, err := a.getOptional("up-options.yml")
+	if err != nil {
+		return UpOptions{}, err
+	}
+	if optionsYAML == nil {
+		return requested, nil
+	}
+
+	var recorded UpOptions
+	err = yaml.Unmarshal(optionsYAML, &recorded)
+	if err != nil {
+		return UpOptions{}, fmt.Errorf("malformed up-options.yml in state directory: %s", err)
+	}
+
+	if requested != (UpOptions{}) && requested != recorded {
+		return UpOptions{}, fmt.Errorf("options %+v differ from %+v in the state directory, and cannot be changed for an existing environment", requested, recorded)
+	}
+	return recorded, nil
+}
+
+func (a *Application) storeUpOptions(options UpOptions) error {
+	optionsYAML, err := yaml.Marshal(options)
+	if err != nil {
+		return err // not tested
+	}
+	return a.ConfigStore.Set("up-options.yml", optionsYAML)
+}
@@ -9,6 +9,8 @@ import (
 	"github.com/onsi/gomega/gbytes"
 	"github.com/rosenhouse/tubes/application"
 	"github.com/rosenhouse/tubes/lib/awsclient"
+	"github.com/rosenhouse/tubes/lib/director"
+	. "github.com/rosenhouse/tubes/lib/matchers"
 	"github.com/rosenhouse/tubes/mocks"
 )
 
@@ -17,14 +19,16 @@ var _ = Describe("Up", func() {
 		awsClient.GetLatestNATBoxAMIIDCall.Returns.AMIID = "some-nat-box-ami-id"
 		awsClient.GetBaseStackResourcesCall.Returns.Resources =
 			awsclient.BaseStackResources{
-				AccountID:        "ping pong",
-				BOSHUser:         "some-bosh-user",
-				NATInstanceID:    "some-nat-box-instance-id",
-				NATElasticIP:     "some-nat-box-elastic-ip",
-				VPCID:            "some-vpc-id",
-				BOSHSubnetID:     "some-bosh-subnet-id",
-				BOSHElasticIP:    "some-elastic-ip",
-				AvailabilityZone: "some-availability-zone",
+				AccountID:         "ping pong",
+				BOSHUser:          "some-bosh-user",
+				NATInstanceID:     "some-nat-box-instance-id",
+				NATElasticIP:      "some-nat-box-elastic-ip",
+				BastionInstanceID: "some-nat-box-instance-id",
+				BastionElasticIP:  "some-nat-box-elastic-ip",
+				VPCID:             "some-vpc-id",
+				BOSHSubnetID:      "some-bosh-subnet-id",
+				BOSHElasticIP:     "some-elastic-ip",
+				AvailabilityZone:  "some-availability-zone",
 			}
 		awsClient.CreateAccessKeyCall.Returns.AccessKey = "some-access-key"
 		awsClient.CreateAccessKeyCall.Returns.SecretKey = "some-secret-key"
@@ -35,19 +39,24 @@ var _ = Describe("Up", func() {
 			"ConcourseSubnet":        "some-concourse-subnet-id",
 			"LoadBalancer":           "some-concourse-elb",
 		}
-		manifestBuilder.BuildCall.Returns.AdminPassword = "some-bosh-password"
+		manifestBuilder.BuildCall.Returns.Credentials = director.Credentials{
+			Admin: "some-bosh-password",
+			HM:    "some-hm-password",
+		}
 		cloudConfigGenerator.GenerateCall.Returns.Bytes = []byte("some-cloud-config")
+		certificateGenerator.GenerateCall.Returns.Cert = []byte("some-cert")
+		certificateGenerator.GenerateCall.Returns.Key = []byte("some-key")
 	})
 
 	It("should create a new ssh keypair", func() {
-		Expect(app.Boot(stackName)).To(Succeed())
+		Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
 
 		Expect(awsClient.CreateKeyPairCall.Receives.StackName).To(Equal(stackName))
 	})
 
 	It("should store the ssh keypair in the config store", func() {
 		awsClient.CreateKeyPairCall.Returns.KeyPair = "some pem bytes"
-		Expect(app.Boot(stackName)).To(Succeed())
+		Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
 
 		Expect(configStore.Values).To(HaveKeyWithValue(
 			"ssh-key",
@@ -55,7 +64,7 @@ var _ = Describe("Up", func() {
 	})
 
 	It("should boot the base stack using the latest NAT ID", func() {
-		Expect(app.Boot(stackName)).To(Succeed())
+		Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
 
 		Expect(logBuffer).To(gbytes.Say("Creating keypair"))
 		Expect(logBuffer).To(gbytes.Say("Looking for latest AWS NAT box AMI..."))
@@ -74,20 +83,26 @@ var _ = Describe("Up", func() {
 		}))
 	})
 
+	It("should record the NAT box AMI in the config store", func() {
+		Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+		Expect(configStore.Values).To(HaveKeyWithValue("nat-ami", []byte("some-nat-box-ami-id")))
+	})
+
 	It("should wait for the base stack to boot", func() {
-		Expect(app.Boot(stackName)).To(Succeed())
+		Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
 
 		Expect(awsClient.WaitForStackCalls[0].Receives.StackName).To(Equal(stackName + "-base"))
 		Expect(awsClient.WaitForStackCalls[0].Receives.Pundit).To(Equal(awsclient.CloudFormationUpsertPundit{}))
 	})
 
 	It("should get the base stack resources", func() {
-		Expect(app.Boot(stackName)).To(Succeed())
+		Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
 		Expect(awsClient.GetBaseStackResourcesCall.Receives.StackName).To(Equal(stackName + "-base"))
 	})
 
 	It("should store the BOSH IP and NAT box IP in the config store", func() {
-		Expect(app.Boot(stackName)).To(Succeed())
+		Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
 
 		Expect(configStore.Values).To(HaveKeyWithValue(
 			"bosh-ip",
@@ -99,12 +114,56 @@ var _ = Describe("Up", func() {
 	})
 
 	It("should create an access key for the BOSH user", func() {
-		Expect(app.Boot(stackName)).To(Succeed())
+		Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
 		Expect(awsClient.CreateAccessKeyCall.Receives.UserName).To(Equal("some-bosh-user"))
 	})
 
+	Context("when the BOSH user has an access key that the state directory does not record", func() {
+		It("should delete it before creating a new one", func() {
+			awsClient.ListAccessKeysCall.Returns.AccessKeys = []string{"some-leaked-key"}
+
+			Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+			Expect(awsClient.ListAccessKeysCall.Receives.UserName).To(Equal("some-bosh-user"))
+			Expect(awsClient.DeleteAccessKeyCall.Receives.UserName).To(Equal("some-bosh-user"))
+			Expect(awsClient.DeleteAccessKeyCall.Receives.AccessKey).To(Equal("some-leaked-key"))
+			Expect(logBuffer).To(gbytes.Say(`Deleting access key "some-leaked-key", which is not recorded in the state directory`))
+			Expect(awsClient.CreateAccessKeyCall.Receives.UserName).To(Equal("some-bosh-user"))
+		})
+
+		Context("when deleting it fails", func() {
+			It("should return the error without creating another key", func() {
+				awsClient.ListAccessKeysCall.Returns.AccessKeys = []string{"some-leaked-key"}
+				awsClient.DeleteAccessKeyCall.Returns.Error = errors.New("some error")
+
+				Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError("some error"))
+				Expect(awsClient.CreateAccessKeyCall.Receives.UserName).To(BeEmpty())
+			})
+		})
+	})
+
+	It("should store the new access key in the config store", func() {
+		Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+		Expect(configStore.Values).To(HaveKeyWithValue("director-access-key-id", []byte("some-access-key")))
+		Expect(configStore.Values).To(HaveKeyWithValue("director-secret-access-key", []byte("some-secret-key")))
+	})
+
+	It("should let the manifest builder generate fresh director credentials", func() {
+		Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+		Expect(manifestBuilder.BuildCall.Receives.Credentials).To(Equal(director.Credentials{}))
+	})
+
+	It("should store the director credentials", func() {
+		Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+		Expect(configStore.Values["director-credentials.yml"]).To(ContainSubstring("admin: some-bosh-password"))
+		Expect(configStore.Values["director-credentials.yml"]).To(ContainSubstring("hm: some-hm-password"))
+	})
+
 	It("should provide the stack resources to the BOSH deployment manifest builder", func() {
-		Expect(app.Boot(stackName)).To(Succeed())
+		Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
 
 		Expect(manifestBuilder.BuildCall.Receives.StackName).To(Equal(stackName))
 		Expect(manifestBuilder.BuildCall.Receives.Resources.AccountID).To(Equal("ping pong"))
@@ -113,10 +172,28 @@ var _ = Describe("Up", func() {
 		Expect(manifestBuilder.BuildCall.Receives.SecretKey).To(Equal("some-secret-key"))
 	})
 
+	It("should generate a TLS certificate for the director's IP, and give it to the manifest builder", func() {
+		Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+		Expect(certificateGenerator.GenerateCall.Receives.IPs).To(Equal([]string{"some-elastic-ip"}))
+		Expect(configStore.Values).To(HaveKeyWithValue("director-cert.pem", []byte("some-cert")))
+		Expect(configStore.Values).To(HaveKeyWithValue("director-key.pem", []byte("some-key")))
+		Expect(manifestBuilder.BuildCall.Receives.SSL).To(Equal(director.SSL{Cert: "some-cert", Key: "some-key"}))
+	})
+
+	Context("when generating the certificate fails", func() {
+		It("should return the error", func() {
+			certificateGenerator.GenerateCall.Returns.Error = errors.New("some error")
+
+			Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError("some error"))
+			Expect(manifestBuilder.BuildCall.Receives.StackName).To(BeEmpty())
+		})
+	})
+
 	It("should store the BOSH deployment manifest", func() {
 		manifestBuilder.BuildCall.Returns.ManifestYAML = []byte("some-manifest-bytes")
 
-		Expect(app.Boot(stackName)).To(Succeed())
+		Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
 
 		Expect(configStore.Values).To(HaveKeyWithValue(
 			"director.yml",
@@ -125,7 +202,7 @@ var _ = Describe("Up", func() {
 	})
 
 	It("should store the BOSH password", func() {
-		Expect(app.Boot(stackName)).To(Succeed())
+		Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
 
 		Expect(configStore.Values).To(HaveKeyWithValue(
 			"bosh-password",
@@ -134,7 +211,7 @@ var _ = Describe("Up", func() {
 	})
 
 	It("should store a BOSH environment file", func() {
-		Expect(app.Boot(stackName)).To(Succeed())
+		Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
 
 		Expect(configStore.Values).To(HaveKeyWithValue(
 			"bosh-environment",
@@ -145,7 +222,7 @@ export NAT_IP="some-nat-box-elastic-ip"`)))
 	})
 
 	It("should upsert the Concourse cloudformation stack", func() {
-		Expect(app.Boot(stackName)).To(Succeed())
+		Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
 
 		Expect(logBuffer).To(gbytes.Say("Upserting base stack.  Check CloudFormation console for details."))
 		Expect(logBuffer).To(gbytes.Say("Stack update complete"))
@@ -167,7 +244,7 @@ export NAT_IP="some-nat-box-elastic-ip"`)))
 	})
 
 	It("should wait for the Concourse stack to boot", func() {
-		Expect(app.Boot(stackName)).To(Succeed())
+		Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
 
 		Expect(awsClient.WaitForStackCallCount).To(Equal(2))
 		Expect(awsClient.WaitForStackCalls[1].Receives.StackName).To(Equal(stackName + "-concourse"))
@@ -175,28 +252,491 @@ export NAT_IP="some-nat-box-elastic-ip"`)))
 	})
 
 	It("should get the Concourse stack resources", func() {
-		Expect(app.Boot(stackName)).To(Succeed())
+		Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
 		Expect(awsClient.GetStackResourcesCalls[0].Receives.StackName).To(Equal(stackName + "-concourse"))
 	})
 
 	It("should generate the cloud config for concourse and store it", func() {
-		Expect(app.Boot(stackName)).To(Succeed())
-		Expect(cloudConfigGenerator.GenerateCall.Receives.Resources).To(Equal(awsClient.GetStackResourcesCalls[0].Returns.Resources))
+		Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+		Expect(cloudConfigGenerator.GenerateCall.Receives.Resources).To(Equal(map[string]string{
+			"ConcourseSecurityGroup": "some-concourse-security-group-id",
+			"ConcourseSubnet":        "some-concourse-subnet-id",
+			"LoadBalancer":           "some-concourse-elb",
+			"AvailabilityZone":       "some-availability-zone",
+			"ConcourseSubnetCIDR":    "10.0.16.0/24",
+			"VPCCIDR":                "10.0.0.0/16",
+		}))
 		Expect(configStore.Values["cloud-config.yml"]).To(Equal([]byte("some-cloud-config")))
 	})
 
 	Context("when the stackName contains invalid characters", func() {
 		It("should immediately error", func() {
-			Expect(app.Boot("invalid_name")).To(MatchError(fmt.Sprintf("invalid name: must match pattern %s", application.StackNamePattern)))
+			Expect(app.Boot(ctx, "invalid_name", upOptions)).To(MatchError(fmt.Sprintf("invalid name: must match pattern %s", application.StackNamePattern)))
 			Expect(logBuffer.Contents()).To(BeEmpty())
 		})
 	})
 
-	Context("when the configStore is non-empty", func() {
+	Context("when the configStore is empty but the base stack already exists", func() {
 		It("should immediately error", func() {
+			awsClient.StackExistsCall.Returns.Exists = true
+
+			Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError(fmt.Sprintf("state directory is empty but stack %q already exists", stackName+"-base")))
+			Expect(awsClient.StackExistsCall.Receives.StackName).To(Equal(stackName + "-base"))
+			Expect(awsClient.CreateKeyPairCall.Receives.StackName).To(BeEmpty())
+		})
+	})
+
+	Context("when the configStore contains unrelated data and there are no cloud resources", func() {
+		It("should boot a new environment", func() {
 			configStore.Values["anything"] = []byte("hello")
 
-			Expect(app.Boot(stackName)).To(MatchError("state directory must be empty"))
+			Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+			Expect(awsClient.CreateKeyPairCall.Receives.StackName).To(Equal(stackName))
+			Expect(awsClient.UpsertStackCallCount).To(Equal(2))
+		})
+	})
+
+	Context("when the configStore already contains an ssh key", func() {
+		BeforeEach(func() {
+			configStore.Values["ssh-key"] = []byte("some existing pem bytes")
+		})
+
+		Context("when the keypair exists on AWS", func() {
+			It("should reuse the keypair", func() {
+				awsClient.KeyPairExistsCall.Returns.Exists = true
+
+				Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+				Expect(logBuffer).To(gbytes.Say("Reusing existing keypair"))
+				Expect(awsClient.KeyPairExistsCall.Receives.StackName).To(Equal(stackName))
+				Expect(awsClient.CreateKeyPairCall.Receives.StackName).To(BeEmpty())
+				Expect(awsClient.ImportKeyPairCall.Receives.StackName).To(BeEmpty())
+				Expect(configStore.Values["ssh-key"]).To(Equal([]byte("some existing pem bytes")))
+			})
+		})
+
+		Context("when the keypair is missing from AWS", func() {
+			It("should import the key from the state directory", func() {
+				Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+				Expect(logBuffer).To(gbytes.Say("Importing keypair from state directory"))
+				Expect(awsClient.ImportKeyPairCall.Receives.StackName).To(Equal(stackName))
+				Expect(awsClient.ImportKeyPairCall.Receives.PEMBytes).To(Equal([]byte("some existing pem bytes")))
+				Expect(awsClient.CreateKeyPairCall.Receives.StackName).To(BeEmpty())
+			})
+
+			Context("when importing fails", func() {
+				It("should return the error", func() {
+					awsClient.ImportKeyPairCall.Returns.Error = errors.New("some error")
+
+					Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError("some error"))
+					Expect(awsClient.UpsertStackCalls).To(BeEmpty())
+				})
+			})
+		})
+	})
+
+	Context("when the configStore has no ssh key but the keypair exists on AWS", func() {
+		It("should return an error", func() {
+			configStore.Values["anything"] = []byte("hello")
+			awsClient.KeyPairExistsCall.Returns.Exists = true
+
+			Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError(fmt.Sprintf("state directory has no ssh-key but keypair %q already exists", stackName)))
+			Expect(awsClient.CreateKeyPairCall.Receives.StackName).To(BeEmpty())
+			Expect(awsClient.UpsertStackCalls).To(BeEmpty())
+		})
+	})
+
+	Context("when the configStore already records the NAT box AMI", func() {
+		It("should reuse that AMI rather than looking up the latest", func() {
+			configStore.Values["nat-ami"] = []byte("some-pinned-ami-id")
+			awsClient.GetLatestNATBoxAMIIDCall.Returns.Error = errors.New("should not be called")
+
+			Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+			Expect(logBuffer.Contents()).NotTo(ContainSubstring("Looking for latest AWS NAT box AMI"))
+			Expect(awsClient.UpsertStackCalls[0].Receives.Parameters).To(HaveKeyWithValue("NATInstanceAMI", "some-pinned-ami-id"))
+		})
+	})
+
+	Context("when the base stack already exists", func() {
+		BeforeEach(func() {
+			configStore.Values["ssh-key"] = []byte("some existing pem bytes")
+			awsClient.KeyPairExistsCall.Returns.Exists = true
+			awsClient.StackExistsCall.Returns.Exists = true
+		})
+
+		It("should upsert the existing stacks", func() {
+			Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+			Expect(awsClient.UpsertStackCalls[0].Receives.StackName).To(Equal(stackName + "-base"))
+			Expect(awsClient.UpsertStackCalls[1].Receives.StackName).To(Equal(stackName + "-concourse"))
+		})
+
+		Context("when the recorded BOSH IP does not match the stack", func() {
+			It("should return an error", func() {
+				configStore.Values["bosh-ip"] = []byte("some-other-ip")
+
+				Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError(`state directory does not match cloud resources: bosh-ip is "some-other-ip" in the state directory but "some-elastic-ip" on AWS`))
+				Expect(manifestBuilder.BuildCall.Receives.StackName).To(BeEmpty())
+			})
+		})
+
+		Context("when the recorded NAT IP does not match the stack", func() {
+			It("should return an error", func() {
+				configStore.Values["nat-ip"] = []byte("some-other-ip")
+
+				Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError(ContainSubstring("nat-ip is \"some-other-ip\"")))
+			})
+		})
+
+		Context("when the state directory has an access key", func() {
+			BeforeEach(func() {
+				configStore.Values["director-access-key-id"] = []byte("some-existing-access-key")
+				configStore.Values["director-secret-access-key"] = []byte("some-existing-secret-key")
+			})
+
+			Context("when the BOSH user still has that access key", func() {
+				It("should reuse it", func() {
+					awsClient.ListAccessKeysCall.Returns.AccessKeys = []string{"some-existing-access-key"}
+
+					Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+					Expect(awsClient.ListAccessKeysCall.Receives.UserName).To(Equal("some-bosh-user"))
+					Expect(awsClient.CreateAccessKeyCall.Receives.UserName).To(BeEmpty())
+					Expect(awsClient.DeleteAccessKeyCall.Receives.AccessKey).To(BeEmpty())
+					Expect(manifestBuilder.BuildCall.Receives.AccessKey).To(Equal("some-existing-access-key"))
+					Expect(manifestBuilder.BuildCall.Receives.SecretKey).To(Equal("some-existing-secret-key"))
+				})
+
+				It("should delete any other key the user has", func() {
+					awsClient.ListAccessKeysCall.Returns.AccessKeys = []string{"some-other-key", "some-existing-access-key"}
+
+					Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+					Expect(awsClient.DeleteAccessKeyCall.Receives.AccessKey).To(Equal("some-other-key"))
+					Expect(manifestBuilder.BuildCall.Receives.AccessKey).To(Equal("some-existing-access-key"))
+				})
+			})
+
+			Context("when the BOSH user no longer has that access key", func() {
+				It("should return an error", func() {
+					awsClient.ListAccessKeysCall.Returns.AccessKeys = []string{"some-other-key"}
+
+					Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError(`state directory does not match cloud resources: access key "some-existing-access-key" not found for user "some-bosh-user"`))
+					Expect(awsClient.CreateAccessKeyCall.Receives.UserName).To(BeEmpty())
+					Expect(awsClient.DeleteAccessKeyCall.Receives.AccessKey).To(BeEmpty())
+				})
+			})
+
+			Context("when listing access keys fails", func() {
+				It("should return the error", func() {
+					awsClient.ListAccessKeysCall.Returns.Error = errors.New("some error")
+
+					Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError("some error"))
+				})
+			})
+		})
+
+		It("should keep the pinned NAT box host key", func() {
+			configStore.Values["nat-host-key"] = []byte("some-host-key")
+
+			Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+			Expect(configStore.Values).To(HaveKeyWithValue("nat-host-key", []byte("some-host-key")))
+		})
+
+		It("should keep the director's TLS certificate", func() {
+			configStore.Values["director-cert.pem"] = []byte("some-existing-cert")
+			configStore.Values["director-key.pem"] = []byte("some-existing-key")
+
+			Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+			Expect(certificateGenerator.GenerateCallCount).To(Equal(0))
+			Expect(manifestBuilder.BuildCall.Receives.SSL).To(Equal(director.SSL{Cert: "some-existing-cert", Key: "some-existing-key"}))
+		})
+
+		Context("when the state directory has director credentials", func() {
+			It("should reuse them", func() {
+				configStore.Values["director-credentials.yml"] = []byte("admin: some-existing-admin-password\nnats: some-existing-nats-password\n")
+
+				Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+				Expect(manifestBuilder.BuildCall.Receives.Credentials).To(Equal(director.Credentials{
+					Admin: "some-existing-admin-password",
+					NATS:  "some-existing-nats-password",
+				}))
+			})
+		})
+	})
+
+	Context("when the state directory has content but the cloud resources are gone", func() {
+		BeforeEach(func() {
+			configStore.Values["ssh-key"] = []byte("some existing pem bytes")
+			configStore.Values["bosh-ip"] = []byte("some-old-ip")
+			configStore.Values["nat-ip"] = []byte("some-old-nat-ip")
+			configStore.Values["director-access-key-id"] = []byte("some-old-access-key")
+			configStore.Values["director-secret-access-key"] = []byte("some-old-secret-key")
+		})
+
+		It("should re-create the resources and update the state directory", func() {
+			Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+			Expect(awsClient.ImportKeyPairCall.Receives.StackName).To(Equal(stackName))
+			Expect(configStore.Values).To(HaveKeyWithValue("bosh-ip", []byte("some-elastic-ip")))
+			Expect(configStore.Values).To(HaveKeyWithValue("nat-ip", []byte("some-nat-box-elastic-ip")))
+			Expect(configStore.Values).To(HaveKeyWithValue("director-access-key-id", []byte("some-access-key")))
+			Expect(configStore.Values).To(HaveKeyWithValue("director-secret-access-key", []byte("some-secret-key")))
+		})
+
+		It("should replace the certificate of the old director", func() {
+			configStore.Values["director-cert.pem"] = []byte("some-old-cert")
+			configStore.Values["director-key.pem"] = []byte("some-old-key")
+
+			Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+			Expect(configStore.Values).To(HaveKeyWithValue("director-cert.pem", []byte("some-cert")))
+			Expect(configStore.Values).To(HaveKeyWithValue("director-key.pem", []byte("some-key")))
+		})
+
+		It("should forget the host key pinned for the old NAT box", func() {
+			configStore.Values["nat-host-key"] = []byte("some-old-host-key")
+
+			Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+			Expect(configStore.Values).NotTo(HaveKey("nat-host-key"))
+		})
+	})
+
+	It("should record the schema version in the state directory", func() {
+		Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+		Expect(configStore.Values["tubes-state.yml"]).To(MatchYAML(fmt.Sprintf("schema_version: %d\ntubes_version: %s\n", application.StateSchemaVersion, application.Version)))
+	})
+
+	It("should record the up options in the state directory", func() {
+		Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+		Expect(configStore.Values["up-options.yml"]).To(MatchYAML("private_director: false\nnat_gateway: false\navailability_zones: 0\ninstance_profile: false\n"))
+	})
+
+	Context("when the director is private", func() {
+		BeforeEach(func() {
+			upOptions.PrivateDirector = true
+			awsClient.GetBaseStackResourcesCall.Returns.Resources.BOSHElasticIP = ""
+			awsClient.GetBaseStackResourcesCall.Returns.Resources.BOSHSubnetCIDR = "10.0.0.0/24"
+		})
+
+		It("should boot the base stack without a public director IP", func() {
+			Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+			Expect(awsClient.UpsertStackCalls[0].Receives.Template).To(Equal(
+				awsclient.NewBaseStackTemplate(awsclient.BaseStackOptions{PrivateDirector: true}).String()))
+		})
+
+		It("should store the internal IP of the director", func() {
+			Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+			Expect(configStore.Values).To(HaveKeyWithValue("bosh-ip", []byte("10.0.0.6")))
+		})
+
+		It("should store a BOSH environment that goes through a SOCKS5 proxy on the NAT box", func() {
+			Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+			Expect(configStore.Values).To(HaveKeyWithValue(
+				"bosh-environment",
+				[]byte(`export BOSH_TARGET="10.0.0.6"
+export BOSH_USER="admin"
+export BOSH_PASSWORD="some-bosh-password"
+export NAT_IP="some-nat-box-elastic-ip"
+# the director is only reachable through the NAT box, so first open a SOCKS5 proxy with
+#   ssh -f -N -D 5000 -i ssh-key ec2-user@$NAT_IP
+export BOSH_ALL_PROXY="socks5://localhost:5000"`)))
+		})
+
+		It("should be recorded in the state directory", func() {
+			Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+			Expect(configStore.Values["up-options.yml"]).To(MatchYAML("private_director: true\nnat_gateway: false\navailability_zones: 0\ninstance_profile: false\n"))
+		})
+
+		Context("when it was recorded by an earlier run", func() {
+			It("should stay private, even without the option", func() {
+				configStore.Values["up-options.yml"] = []byte("private_director: true\n")
+
+				Expect(app.Boot(ctx, stackName, application.UpOptions{})).To(Succeed())
+
+				Expect(awsClient.UpsertStackCalls[0].Receives.Template).To(Equal(
+					awsclient.NewBaseStackTemplate(awsclient.BaseStackOptions{PrivateDirector: true}).String()))
+			})
+		})
+
+		Context("when the BOSH subnet CIDR is malformed", func() {
+			It("should return an error", func() {
+				awsClient.GetBaseStackResourcesCall.Returns.Resources.BOSHSubnetCIDR = "nope"
+
+				Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError(ContainSubstring("invalid CIDR address")))
+			})
+		})
+	})
+
+	Context("when using a NAT gateway", func() {
+		BeforeEach(func() {
+			upOptions.NATGateway = true
+			awsClient.GetBaseStackResourcesCall.Returns.Resources.NATInstanceID = ""
+			awsClient.GetBaseStackResourcesCall.Returns.Resources.NATElasticIP = ""
+			awsClient.GetBaseStackResourcesCall.Returns.Resources.NATGatewayID = "some-nat-gateway-id"
+			awsClient.GetBaseStackResourcesCall.Returns.Resources.BastionInstanceID = "some-bastion-instance-id"
+			awsClient.GetBaseStackResourcesCall.Returns.Resources.BastionElasticIP = "some-bastion-elastic-ip"
+		})
+
+		It("should boot both stacks in NAT gateway mode", func() {
+			Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+			Expect(awsClient.UpsertStackCalls[0].Receives.Template).To(Equal(
+				awsclient.NewBaseStackTemplate(awsclient.BaseStackOptions{NATGateway: true}).String()))
+			Expect(awsClient.UpsertStackCalls[1].Receives.Template).To(Equal(
+				awsclient.NewConcourseStackTemplate(awsclient.ConcourseStackOptions{NATGateway: true}).String()))
+			Expect(awsClient.UpsertStackCalls[1].Receives.Parameters).To(Equal(map[string]string{
+				"VPCID":                    "some-vpc-id",
+				"NATGateway":               "some-nat-gateway-id",
+				"PubliclyRoutableSubnetID": "some-bosh-subnet-id",
+				"AvailabilityZone":         "some-availability-zone",
+			}))
+		})
+
+		It("should SSH through the bastion", func() {
+			Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+			Expect(configStore.Values).To(HaveKeyWithValue("nat-ip", []byte("some-bastion-elastic-ip")))
+			Expect(string(configStore.Values["bosh-environment"])).To(ContainSubstring(`export NAT_IP="some-bastion-elastic-ip"`))
+		})
+	})
+
+	Context("when spanning several availability zones", func() {
+		BeforeEach(func() {
+			upOptions.AvailabilityZones = 2
+			awsClient.GetBaseStackResourcesCall.Returns.Resources.Zones = []awsclient.Zone{
+				{AvailabilityZone: "some-availability-zone", PublicSubnetID: "some-bosh-subnet-id", PrivateSubnetID: "some-private-subnet-id"},
+				{AvailabilityZone: "some-other-availability-zone", PublicSubnetID: "some-other-public-subnet-id", PrivateSubnetID: "some-other-private-subnet-id", NATInstanceID: "some-other-nat-box-instance-id"},
+			}
+			awsClient.GetStackResourcesCalls[0].Returns.Resources["ConcourseSubnetZ2"] = "some-other-concourse-subnet-id"
+		})
+
+		It("should boot both stacks across the zones", func() {
+			Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+			Expect(awsClient.UpsertStackCalls[0].Receives.Template).To(Equal(
+				awsclient.NewBaseStackTemplate(awsclient.BaseStackOptions{AvailabilityZones: 2}).String()))
+			Expect(awsClient.UpsertStackCalls[1].Receives.Template).To(Equal(
+				awsclient.NewConcourseStackTemplate(awsclient.ConcourseStackOptions{AvailabilityZones: 2}).String()))
+			Expect(awsClient.UpsertStackCalls[1].Receives.Parameters).To(Equal(map[string]string{
+				"VPCID":                      "some-vpc-id",
+				"NATInstance":                "some-nat-box-instance-id",
+				"PubliclyRoutableSubnetID":   "some-bosh-subnet-id",
+				"AvailabilityZone":           "some-availability-zone",
+				"PubliclyRoutableSubnetIDZ2": "some-other-public-subnet-id",
+				"AvailabilityZoneZ2":         "some-other-availability-zone",
+				"NATInstanceZ2":              "some-other-nat-box-instance-id",
+			}))
+		})
+
+		It("should generate a cloud config with a subnet in each zone", func() {
+			Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+			Expect(cloudConfigGenerator.GenerateCall.Receives.Resources).To(HaveKeyWithValue("ConcourseSubnetZ2", "some-other-concourse-subnet-id"))
+			Expect(cloudConfigGenerator.GenerateCall.Receives.Resources).To(HaveKeyWithValue("AvailabilityZoneZ2", "some-other-availability-zone"))
+			Expect(cloudConfigGenerator.GenerateCall.Receives.Resources).To(HaveKeyWithValue("ConcourseSubnetCIDRZ2", "10.0.17.0/24"))
+		})
+
+		It("should be recorded in the state directory", func() {
+			Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+			Expect(configStore.Values["up-options.yml"]).To(MatchYAML("private_director: false\nnat_gateway: false\navailability_zones: 2\ninstance_profile: false\n"))
+		})
+
+		Context("when asked for more zones than are supported", func() {
+			It("should immediately error", func() {
+				upOptions.AvailabilityZones = 4
+
+				Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError("availability zones must be between 1 and 3"))
+				Expect(awsClient.UpsertStackCalls).To(BeEmpty())
+			})
+		})
+	})
+
+	Context("when using an instance profile for the director", func() {
+		BeforeEach(func() {
+			upOptions.InstanceProfile = true
+			awsClient.GetBaseStackResourcesCall.Returns.Resources.BOSHUser = ""
+			awsClient.GetBaseStackResourcesCall.Returns.Resources.DirectorInstanceProfile = "some-instance-profile"
+		})
+
+		It("should boot the base stack with a role and instance profile", func() {
+			Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+			Expect(awsClient.UpsertStackCalls[0].Receives.Template).To(Equal(
+				awsclient.NewBaseStackTemplate(awsclient.BaseStackOptions{InstanceProfile: true}).String()))
+		})
+
+		It("should not create or store any access keys", func() {
+			Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+			Expect(awsClient.CreateAccessKeyCall.Receives.UserName).To(BeEmpty())
+			Expect(configStore.Values).NotTo(HaveKey("director-access-key-id"))
+			Expect(configStore.Values).NotTo(HaveKey("director-secret-access-key"))
+			Expect(logBuffer).To(gbytes.Say("Using the director instance profile instead of access keys"))
+		})
+
+		It("should build the director manifest without access keys", func() {
+			Expect(app.Boot(ctx, stackName, upOptions)).To(Succeed())
+
+			Expect(manifestBuilder.BuildCall.Receives.AccessKey).To(BeEmpty())
+			Expect(manifestBuilder.BuildCall.Receives.SecretKey).To(BeEmpty())
+			Expect(manifestBuilder.BuildCall.Receives.Resources.DirectorInstanceProfile).To(Equal("some-instance-profile"))
+		})
+	})
+
+	Context("when the state directory records different up options", func() {
+		It("should refuse to change them", func() {
+			configStore.Values["up-options.yml"] = []byte("private_director: false\n")
+			upOptions.PrivateDirector = true
+
+			Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError(ContainSubstring("cannot be changed for an existing environment")))
+			Expect(awsClient.UpsertStackCalls).To(BeEmpty())
+		})
+	})
+
+	Context("when the recorded up options are malformed", func() {
+		It("should return an error", func() {
+			configStore.Values["up-options.yml"] = []byte("%%%")
+
+			Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError(HavePrefix("malformed up-options.yml in state directory")))
+		})
+	})
+
+	Context("when storing the up options fails", func() {
+		It("should return the error", func() {
+			configStore.Errors["up-options.yml"] = errors.New("some error")
+
+			Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError("some error"))
+		})
+	})
+
+	Context("when checking for an existing base stack fails", func() {
+		It("should immediately return the error", func() {
+			awsClient.StackExistsCall.Returns.Error = errors.New("some error")
+
+			Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError("some error"))
+			Expect(awsClient.CreateKeyPairCall.Receives.StackName).To(BeEmpty())
+		})
+	})
+
+	Context("when checking for an existing keypair fails", func() {
+		It("should immediately return the error", func() {
+			awsClient.KeyPairExistsCall.Returns.Error = errors.New("some error")
+
+			Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError("some error"))
 			Expect(awsClient.CreateKeyPairCall.Receives.StackName).To(BeEmpty())
 		})
 	})
@@ -205,7 +745,7 @@ export NAT_IP="some-nat-box-elastic-ip"`)))
 		It("should immediately error", func() {
 			configStore.IsEmptyError = errors.New("whatever")
 
-			Expect(app.Boot(stackName)).To(MatchError("whatever"))
+			Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError("whatever"))
 			Expect(awsClient.CreateKeyPairCall.Receives.StackName).To(BeEmpty())
 		})
 	})
@@ -214,7 +754,7 @@ export NAT_IP="some-nat-box-elastic-ip"`)))
 		It("should immediately return the error", func() {
 			awsClient.GetLatestNATBoxAMIIDCall.Returns.Error = errors.New("some error")
 
-			Expect(app.Boot(stackName)).To(MatchError("some error"))
+			Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError("some error"))
 			Expect(awsClient.UpsertStackCalls).To(HaveLen(0))
 		})
 	})
@@ -223,7 +763,7 @@ export NAT_IP="some-nat-box-elastic-ip"`)))
 		It("should immediately return the error", func() {
 			awsClient.CreateKeyPairCall.Returns.Error = errors.New("some error")
 
-			Expect(app.Boot(stackName)).To(MatchError("some error"))
+			Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError("some error"))
 			Expect(awsClient.UpsertStackCalls).To(HaveLen(0))
 			Expect(logBuffer.Contents()).NotTo(ContainSubstring("Looking for latest AWS NAT box AMI"))
 			Expect(logBuffer.Contents()).NotTo(ContainSubstring("Finished"))
@@ -234,7 +774,7 @@ export NAT_IP="some-nat-box-elastic-ip"`)))
 		It("should return an error", func() {
 			configStore.Errors["ssh-key"] = errors.New("some error")
 
-			Expect(app.Boot(stackName)).To(MatchError("some error"))
+			Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError("some error"))
 			Expect(logBuffer.Contents()).NotTo(ContainSubstring("Upserting base stack"))
 			Expect(logBuffer.Contents()).NotTo(ContainSubstring("Finished"))
 		})
@@ -245,7 +785,7 @@ export NAT_IP="some-nat-box-elastic-ip"`)))
 			awsClient.UpsertStackCalls = make([]mocks.UpsertStackCall, 1)
 			awsClient.UpsertStackCalls[0].Returns.Error = errors.New("some error")
 
-			Expect(app.Boot(stackName)).To(MatchError("some error"))
+			Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError("some error"))
 			Expect(awsClient.WaitForStackCalls).To(BeEmpty())
 			Expect(logBuffer.Contents()).NotTo(ContainSubstring("Stack update complete"))
 			Expect(logBuffer.Contents()).NotTo(ContainSubstring("Finished"))
@@ -257,7 +797,7 @@ export NAT_IP="some-nat-box-elastic-ip"`)))
 			awsClient.WaitForStackCalls = make([]mocks.WaitForStackCall, 1)
 			awsClient.WaitForStackCalls[0].Returns.Error = errors.New("some error")
 
-			Expect(app.Boot(stackName)).To(MatchError("some error"))
+			Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError("some error"))
 
 			Expect(logBuffer.Contents()).NotTo(ContainSubstring("Stack update complete"))
 			Expect(logBuffer.Contents()).NotTo(ContainSubstring("Finished"))
@@ -268,7 +808,7 @@ export NAT_IP="some-nat-box-elastic-ip"`)))
 		It("should return the error", func() {
 			awsClient.GetBaseStackResourcesCall.Returns.Error = errors.New("boom")
 
-			Expect(app.Boot(stackName)).To(MatchError("boom"))
+			Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError("boom"))
 		})
 	})
 
@@ -276,7 +816,7 @@ export NAT_IP="some-nat-box-elastic-ip"`)))
 		It("should return an error", func() {
 			configStore.Errors["bosh-ip"] = errors.New("some error")
 
-			Expect(app.Boot(stackName)).To(MatchError("some error"))
+			Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError("some error"))
 			Expect(logBuffer.Contents()).NotTo(ContainSubstring("Generating BOSH init manifest"))
 			Expect(logBuffer.Contents()).NotTo(ContainSubstring("Finished"))
 		})
@@ -286,7 +826,34 @@ export NAT_IP="some-nat-box-elastic-ip"`)))
 		It("should return the error", func() {
 			awsClient.CreateAccessKeyCall.Returns.Error = errors.New("boom")
 
-			Expect(app.Boot(stackName)).To(MatchError("boom"))
+			Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError("boom"))
+		})
+	})
+
+	Context("when storing the access key fails", func() {
+		It("should return an error", func() {
+			configStore.Errors["director-secret-access-key"] = errors.New("some error")
+
+			Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError("some error"))
+			Expect(manifestBuilder.BuildCall.Receives.StackName).To(BeEmpty())
+		})
+	})
+
+	Context("when the stored director credentials are malformed", func() {
+		It("should return an error", func() {
+			configStore.Values["director-credentials.yml"] = []byte("not: [valid")
+
+			Expect(app.Boot(ctx, stackName, upOptions)).To(HaveOccurred())
+			Expect(manifestBuilder.BuildCall.Receives.StackName).To(BeEmpty())
+		})
+	})
+
+	Context("when storing the director credentials fails", func() {
+		It("should return an error", func() {
+			configStore.Errors["director-credentials.yml"] = errors.New("some error")
+
+			Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError("some error"))
+			Expect(configStore.Values).NotTo(HaveKey("director.yml"))
 		})
 	})
 
@@ -294,7 +861,7 @@ export NAT_IP="some-nat-box-elastic-ip"`)))
 		It("should return the error", func() {
 			manifestBuilder.BuildCall.Returns.Error = errors.New("some error")
 
-			Expect(app.Boot(stackName)).To(MatchError("some error"))
+			Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError("some error"))
 		})
 	})
 
@@ -302,7 +869,7 @@ export NAT_IP="some-nat-box-elastic-ip"`)))
 		It("should return an error", func() {
 			configStore.Errors["director.yml"] = errors.New("some error")
 
-			Expect(app.Boot(stackName)).To(MatchError("some error"))
+			Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError("some error"))
 			Expect(logBuffer.Contents()).NotTo(ContainSubstring("Downloading the concourse manifest"))
 		})
 	})
@@ -311,7 +878,7 @@ export NAT_IP="some-nat-box-elastic-ip"`)))
 		It("should return an error", func() {
 			configStore.Errors["bosh-password"] = errors.New("some error")
 
-			Expect(app.Boot(stackName)).To(MatchError("some error"))
+			Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError("some error"))
 			Expect(logBuffer.Contents()).NotTo(ContainSubstring("Downloading the concourse manifest"))
 		})
 	})
@@ -320,7 +887,7 @@ export NAT_IP="some-nat-box-elastic-ip"`)))
 		It("should return an error", func() {
 			configStore.Errors["bosh-environment"] = errors.New("some error")
 
-			Expect(app.Boot(stackName)).To(MatchError("some error"))
+			Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError("some error"))
 			Expect(logBuffer.Contents()).NotTo(ContainSubstring("Downloading the concourse manifest"))
 		})
 	})
@@ -330,7 +897,7 @@ export NAT_IP="some-nat-box-elastic-ip"`)))
 			awsClient.UpsertStackCalls = make([]mocks.UpsertStackCall, 2)
 			awsClient.UpsertStackCalls[1].Returns.Error = errors.New("some error")
 
-			Expect(app.Boot(stackName)).To(MatchError("some error"))
+			Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError("some error"))
 			Expect(logBuffer.Contents()).NotTo(ContainSubstring("Finished"))
 		})
 	})
@@ -340,7 +907,7 @@ export NAT_IP="some-nat-box-elastic-ip"`)))
 			awsClient.WaitForStackCalls = make([]mocks.WaitForStackCall, 2)
 			awsClient.WaitForStackCalls[1].Returns.Error = errors.New("some error")
 
-			Expect(app.Boot(stackName)).To(MatchError("some error"))
+			Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError("some error"))
 
 			Expect(logBuffer.Contents()).NotTo(ContainSubstring("Finished"))
 		})
@@ -350,7 +917,7 @@ export NAT_IP="some-nat-box-elastic-ip"`)))
 		It("should return an error", func() {
 			cloudConfigGenerator.GenerateCall.Returns.Error = errors.New("potato")
 
-			Expect(app.Boot(stackName)).To(MatchError("potato"))
+			Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError("potato"))
 			Expect(logBuffer.Contents()).NotTo(ContainSubstring("potato"))
 		})
 	})
@@ -359,7 +926,7 @@ export NAT_IP="some-nat-box-elastic-ip"`)))
 		It("should return an error", func() {
 			configStore.Errors["cloud-config.yml"] = errors.New("some-error")
 
-			Expect(app.Boot(stackName)).To(MatchError("some-error"))
+			Expect(app.Boot(ctx, stackName, upOptions)).To(MatchError("some-error"))
 			Expect(logBuffer.Contents()).NotTo(ContainSubstring("Finished"))
 		})
 	})
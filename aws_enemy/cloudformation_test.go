@@ -139,6 +139,19 @@ var _ = Describe("CloudFormation error cases", func() {
 		})
 	})
 
+	Describe("DetectStackDrift", func() {
+		Context("when the stack does not exist", func() {
+			It("returns a ValidationError error", func() {
+				_, err := cloudformationClient.DetectStackDrift(&cloudformation.DetectStackDriftInput{
+					StackName: aws.String(stackName),
+				})
+				Expect(err).To(HaveOccurred())
+				expectedErrorResp := cfErrors.DetectStackDrift_StackMissingError(stackName)
+				Expect(err).To(MatchErrorResponse(expectedErrorResp))
+			})
+		})
+	})
+
 	Describe("DeleteStack", func() {
 		Context("when the stack does not exist", func() {
 			It("succeeds", func() {
@@ -149,4 +162,18 @@ var _ = Describe("CloudFormation error cases", func() {
 			})
 		})
 	})
+
+	Describe("DescribeChangeSet", func() {
+		Context("when the change set does not exist", func() {
+			It("returns a ChangeSetNotFound error", func() {
+				_, err := cloudformationClient.DescribeChangeSet(&cloudformation.DescribeChangeSetInput{
+					StackName:     aws.String(stackName),
+					ChangeSetName: aws.String("some-change-set"),
+				})
+				Expect(err).To(HaveOccurred())
+				expectedErrorResp := cfErrors.DescribeChangeSet_NotFoundError("some-change-set")
+				Expect(err).To(MatchErrorResponse(expectedErrorResp))
+			})
+		})
+	})
 })
@@ -39,6 +39,19 @@ var _ = Describe("EC2 Key Pairs", func() {
 		})
 	})
 
+	Describe("DescribeKeyPairs", func() {
+		Context("when the keypair does not exist", func() {
+			It("returns an InvalidKeyPair.NotFound error", func() {
+				_, err := ec2Client.DescribeKeyPairs(&ec2.DescribeKeyPairsInput{
+					KeyNames: []*string{aws.String(keyName)},
+				})
+				Expect(err).To(HaveOccurred())
+				expectedErrorResp := ec2Errors.DescribeKeyPairs_NotFoundError(keyName)
+				Expect(err).To(MatchErrorResponse(expectedErrorResp))
+			})
+		})
+	})
+
 	Describe("CreateKeyPair", func() {
 		Context("when the keypair already exists", func() {
 			It("returns an InvalidKeyPair.Duplicate error", func() {
@@ -59,3 +72,21 @@ var _ = Describe("EC2 Key Pairs", func() {
 		})
 	})
 })
+
+var _ = Describe("EC2 Elastic IPs", func() {
+	var ec2Errors aws_enemy.EC2
+
+	Describe("ReleaseAddress", func() {
+		Context("when the allocation does not exist", func() {
+			It("returns an InvalidAllocationID.NotFound error", func() {
+				allocationID := fmt.Sprintf("eipalloc-%08x", rand.Int31())
+				_, err := ec2Client.ReleaseAddress(&ec2.ReleaseAddressInput{
+					AllocationId: aws.String(allocationID),
+				})
+				Expect(err).To(HaveOccurred())
+				expectedErrorResp := ec2Errors.ReleaseAddress_NotFoundError(allocationID)
+				Expect(err).To(MatchErrorResponse(expectedErrorResp))
+			})
+		})
+	})
+})
@@ -17,6 +17,22 @@ func (EC2) CreateKeyPair_AlreadyExistsError(keypairName string) *awsfaker.ErrorR
 	}
 }
 
+func (EC2) DescribeKeyPairs_NotFoundError(keypairName string) *awsfaker.ErrorResponse {
+	return &awsfaker.ErrorResponse{
+		HTTPStatusCode:  http.StatusBadRequest,
+		AWSErrorCode:    "InvalidKeyPair.NotFound",
+		AWSErrorMessage: fmt.Sprintf("The key pair '%s' does not exist", keypairName),
+	}
+}
+
+func (EC2) ReleaseAddress_NotFoundError(allocationID string) *awsfaker.ErrorResponse {
+	return &awsfaker.ErrorResponse{
+		HTTPStatusCode:  http.StatusBadRequest,
+		AWSErrorCode:    "InvalidAllocationID.NotFound",
+		AWSErrorMessage: fmt.Sprintf("The allocation ID '%s' does not exist", allocationID),
+	}
+}
+
 type CloudFormation struct{}
 
 func (CloudFormation) UpdateStack_StackMissingError(stackName string) *awsfaker.ErrorResponse {
@@ -58,3 +74,19 @@ func (CloudFormation) DescribeStackResources_StackMissingError(stackName string)
 		AWSErrorMessage: fmt.Sprintf("Stack with id %s does not exist", stackName),
 	}
 }
+
+func (CloudFormation) DescribeChangeSet_NotFoundError(changeSetName string) *awsfaker.ErrorResponse {
+	return &awsfaker.ErrorResponse{
+		HTTPStatusCode:  http.StatusNotFound,
+		AWSErrorCode:    "ChangeSetNotFound",
+		AWSErrorMessage: fmt.Sprintf("ChangeSet [%s] does not exist", changeSetName),
+	}
+}
+
+func (CloudFormation) DetectStackDrift_StackMissingError(stackName string) *awsfaker.ErrorResponse {
+	return &awsfaker.ErrorResponse{
+		HTTPStatusCode:  http.StatusBadRequest,
+		AWSErrorCode:    "ValidationError",
+		AWSErrorMessage: fmt.Sprintf("Stack with id %s does not exist", stackName),
+	}
+}
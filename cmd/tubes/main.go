@@ -3,18 +3,52 @@ package main
 import (
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/jessevdk/go-flags"
 	"github.com/rosenhouse/tubes/application/commands"
+	"golang.org/x/net/context"
 )
 
+// interruptedExitCode is what shells report for a process stopped by SIGINT
+const interruptedExitCode = 130
+
+// cancelOnSignal cancels the context on the first SIGINT or SIGTERM, so the
+// command can stop at the next safe point, and on the second releases the
+// state lock and exits at once
+func cancelOnSignal(cancel context.CancelFunc, releaseLock func() error) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		fmt.Fprintf(os.Stderr, "Received %s, stopping at the next safe point.  Send it again to quit right away.\n", sig)
+		cancel()
+
+		<-signals
+		if err := releaseLock(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to release the state lock: %s\n", err)
+		}
+		os.Exit(interruptedExitCode)
+	}()
+}
+
 func main() {
 	commands := commands.New()
 	parser := flags.NewParser(commands, flags.HelpFlag|flags.PassDoubleDash)
 
-	_, err := parser.Parse()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelOnSignal(cancel, commands.ReleaseLock)
+
+	args, err := parser.Parse()
+	if err == nil {
+		err = commands.Execute(ctx, parser.Active.Name, args)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err)
+		if ctx.Err() != nil {
+			os.Exit(interruptedExitCode)
+		}
 		if ferr, ok := err.(*flags.Error); ok && ferr.Type != flags.ErrHelp {
 			parser.WriteHelp(os.Stderr)
 		}
@@ -0,0 +1,237 @@
+package integration_test
+
+import (
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"golang.org/x/crypto/ssh"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+
+	"github.com/rosenhouse/tubes/integration"
+	"github.com/rosenhouse/tubes/lib/sshfake"
+)
+
+const fakeBoshInitScript = `#!/bin/sh
+echo "bosh-init deploying $2"
+if [ -n "$AWS_SESSION_TOKEN" ]; then echo "using session token $AWS_SESSION_TOKEN"; fi
+echo '{"director_id": "some-director-id"}' > director-state.json
+echo "Finished deploying"
+`
+
+var _ = Describe("Deploy director action", func() {
+	var (
+		stackName  string
+		envVars    map[string]string
+		workingDir string
+		stateDir   string
+		homeDir    string
+		binDir     string
+		fakeAWS    *integration.FakeAWS
+		sshServer  *sshfake.Server
+		start      func(args ...string) *gexec.Session
+
+		manifestServer *httptest.Server
+		boshIOServer   *httptest.Server
+		directorServer *httptest.Server
+		generatedCert  []byte
+	)
+
+	const NormalTimeout = "5s"
+
+	BeforeEach(func() {
+		stackName = fmt.Sprintf("tubes-acceptance-test-%x", rand.Int())
+		var err error
+		workingDir, err = ioutil.TempDir("", "tubes-acceptance-test")
+		Expect(err).NotTo(HaveOccurred())
+		stateDir = filepath.Join(workingDir, "environments", stackName)
+
+		logger := integration.NewAWSCallLogger(GinkgoWriter)
+		fakeAWS = integration.NewFakeAWS(logger)
+
+		concourseManifestTemplate, err := ioutil.ReadFile("fixtures/concourse-template.yml")
+		Expect(err).NotTo(HaveOccurred())
+		manifestServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(concourseManifestTemplate)
+		}))
+
+		boshIOServer = httptest.NewServer(&integration.FakeBoshIO{})
+
+		envVars = map[string]string{
+			"AWS_DEFAULT_REGION":                    "us-west-2",
+			"AWS_ACCESS_KEY_ID":                     "some-access-key-id",
+			"AWS_SECRET_ACCESS_KEY":                 "some-secret-access-key",
+			"TUBES_AWS_ENDPOINTS":                   fakeAWS.EndpointOverridesEnvVar(),
+			"TUBES_CONCOURSE_MANIFEST_TEMPLATE_URL": manifestServer.URL + "/concourse-template.yml",
+			"TUBES_BOSH_IO_URL":                     boshIOServer.URL,
+		}
+
+		start = buildStarter(&workingDir, envVars)
+
+		session := start("-n", stackName, "up")
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+
+		By("pointing the state directory at a local SSH server instead of the NAT box")
+		Expect(ioutil.WriteFile(filepath.Join(stateDir, "nat-ip"), []byte("127.0.0.1"), 0600)).To(Succeed())
+
+		sshKeyPEM, err := ioutil.ReadFile(filepath.Join(stateDir, "ssh-key"))
+		Expect(err).NotTo(HaveOccurred())
+		signer, err := ssh.ParsePrivateKey(sshKeyPEM)
+		Expect(err).NotTo(HaveOccurred())
+
+		homeDir, err = ioutil.TempDir("", "tubes-integration-ssh-home")
+		Expect(err).NotTo(HaveOccurred())
+		binDir, err = ioutil.TempDir("", "tubes-integration-ssh-bin")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ioutil.WriteFile(filepath.Join(binDir, "bosh-init"), []byte(fakeBoshInitScript), 0755)).To(Succeed())
+
+		sshServer, err = sshfake.NewServer(homeDir, binDir, signer.PublicKey())
+		Expect(err).NotTo(HaveOccurred())
+		envVars["TUBES_SSH_PORT"] = strconv.Itoa(sshServer.Port())
+
+		By("standing in for the director API with a local TLS server")
+		boshPassword := string(mustReadFile(filepath.Join(stateDir, "bosh-password")))
+		directorServer = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			username, password, ok := r.BasicAuth()
+			if r.URL.Path != "/info" || !ok || username != "admin" || password != boshPassword {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"name": "some-director", "uuid": "some-director-uuid", "version": "1.3262.0 (00000000)", "cpi": "aws_cpi"}`))
+		}))
+		Expect(ioutil.WriteFile(filepath.Join(stateDir, "bosh-ip"), []byte("127.0.0.1"), 0600)).To(Succeed())
+		generatedCert = mustReadFile(filepath.Join(stateDir, "director-cert.pem"))
+		directorCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: directorServer.TLS.Certificates[0].Certificate[0]})
+		Expect(ioutil.WriteFile(filepath.Join(stateDir, "director-cert.pem"), directorCert, 0600)).To(Succeed())
+		directorURL, err := url.Parse(directorServer.URL)
+		Expect(err).NotTo(HaveOccurred())
+		_, directorPort, err := net.SplitHostPort(directorURL.Host)
+		Expect(err).NotTo(HaveOccurred())
+		envVars["TUBES_DIRECTOR_PORT"] = directorPort
+	})
+
+	AfterEach(func() {
+		fakeAWS.Close()
+		sshServer.Close()
+		manifestServer.Close()
+		boshIOServer.Close()
+		directorServer.Close()
+		Expect(os.RemoveAll(homeDir)).To(Succeed())
+		Expect(os.RemoveAll(binDir)).To(Succeed())
+	})
+
+	It("should run bosh-init on the NAT box and store the director state", func() {
+		session := start("-n", stackName, "deploy-director")
+
+		Eventually(session.Err, "20s").Should(gbytes.Say("bosh-init deploying director.yml"))
+		Eventually(session.Err, NormalTimeout).Should(gbytes.Say("Finished deploying"))
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+
+		Expect(ioutil.ReadFile(filepath.Join(homeDir, "tubes-deploy", "director.yml"))).To(
+			Equal(mustReadFile(filepath.Join(stateDir, "director.yml"))))
+		Expect(ioutil.ReadFile(filepath.Join(stateDir, "director-state.json"))).To(
+			MatchJSON(`{"director_id": "some-director-id"}`))
+	})
+
+	It("should pin the NAT box host key", func() {
+		session := start("-n", stackName, "deploy-director")
+		Eventually(session, "20s").Should(gexec.Exit(0))
+
+		Expect(mustReadFile(filepath.Join(stateDir, "nat-host-key"))).To(Equal(ssh.MarshalAuthorizedKey(sshServer.HostKey)))
+	})
+
+	It("should refuse to deploy to a NAT box whose host key does not match the pinned one", func() {
+		otherKey, err := rsa.GenerateKey(cryptorand.Reader, 1024)
+		Expect(err).NotTo(HaveOccurred())
+		otherPublicKey, err := ssh.NewPublicKey(&otherKey.PublicKey)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ioutil.WriteFile(filepath.Join(stateDir, "nat-host-key"), ssh.MarshalAuthorizedKey(otherPublicKey), 0600)).To(Succeed())
+
+		session := start("-n", stackName, "deploy-director")
+		Eventually(session, "20s").Should(gexec.Exit(1))
+
+		Expect(session.Err.Contents()).To(ContainSubstring("NAT box host key does not match nat-host-key in the state directory"))
+		Expect(sshServer.Commands()).To(BeEmpty())
+		_, err = os.Stat(filepath.Join(homeDir, "tubes-deploy"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("should discover and store the director's UUID", func() {
+		session := start("-n", stackName, "deploy-director")
+		Eventually(session, "20s").Should(gexec.Exit(0))
+		Expect(session.Err.Contents()).To(ContainSubstring(`Director "some-director" is up`))
+
+		Expect(ioutil.ReadFile(filepath.Join(stateDir, "director-cpi"))).To(Equal([]byte("aws_cpi")))
+
+		session = start("-n", stackName, "show", "--bosh-uuid")
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+		Expect(session.Out.Contents()).To(Equal([]byte("some-director-uuid")))
+	})
+
+	It("should refuse a director whose certificate is not the one in the state directory", func() {
+		Expect(ioutil.WriteFile(filepath.Join(stateDir, "director-cert.pem"), generatedCert, 0600)).To(Succeed())
+
+		session := start("-n", stackName, "deploy-director")
+		Eventually(session, "20s").Should(gexec.Exit(1))
+		Expect(session.Err.Contents()).To(ContainSubstring("director at 127.0.0.1 is not healthy"))
+	})
+
+	Context("when the director uses an instance profile", func() {
+		BeforeEach(func() {
+			By("recording the instance profile option, since the NAT box no longer has the profile")
+			Expect(ioutil.WriteFile(filepath.Join(stateDir, "up-options.yml"), []byte("instance_profile: true\n"), 0600)).To(Succeed())
+		})
+
+		It("should give bosh-init short-lived credentials for the director's role, and leave none on the NAT box", func() {
+			session := start("-n", stackName, "deploy-director")
+			Eventually(session, "20s").Should(gexec.Exit(0))
+			Expect(session.Err.Contents()).To(ContainSubstring("using session token some-session-token"))
+
+			Expect(fakeAWS.STS.AssumedRoles).To(Equal([]string{"arn:aws:iam::123456789012:role/some-iam-role"}))
+			_, err := os.Stat(filepath.Join(homeDir, "tubes-deploy", "bosh-init.env"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+
+	Context("when the director is private", func() {
+		BeforeEach(func() {
+			By("recording the director as private, so that it is reached through the NAT box")
+			Expect(ioutil.WriteFile(filepath.Join(stateDir, "up-options.yml"), []byte("private_director: true\n"), 0600)).To(Succeed())
+		})
+
+		It("should discover the director through an SSH tunnel to the NAT box", func() {
+			session := start("-n", stackName, "deploy-director")
+			Eventually(session, "20s").Should(gexec.Exit(0))
+			Expect(session.Err.Contents()).To(ContainSubstring("Checking the director through an SSH tunnel to the NAT box"))
+			Expect(session.Err.Contents()).To(ContainSubstring(`Director "some-director" is up`))
+
+			directorURL, err := url.Parse(directorServer.URL)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sshServer.Forwards()).To(ContainElement(directorURL.Host))
+
+			session = start("-n", stackName, "show", "--bosh-uuid")
+			Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+			Expect(session.Out.Contents()).To(Equal([]byte("some-director-uuid")))
+		})
+	})
+})
+
+func mustReadFile(path string) []byte {
+	contents, err := ioutil.ReadFile(path)
+	Expect(err).NotTo(HaveOccurred())
+	return contents
+}
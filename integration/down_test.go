@@ -0,0 +1,231 @@
+package integration_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+
+	"github.com/rosenhouse/tubes/integration"
+)
+
+var _ = Describe("Down action", func() {
+	var (
+		stackName  string
+		workingDir string
+		fakeAWS    *integration.FakeAWS
+		start      func(args ...string) *gexec.Session
+
+		manifestServer *httptest.Server
+		boshIOServer   *httptest.Server
+	)
+
+	const NormalTimeout = "5s"
+
+	stateFileExists := func(key string) bool {
+		_, err := os.Stat(filepath.Join(workingDir, "environments", stackName, key))
+		return err == nil
+	}
+
+	BeforeEach(func() {
+		stackName = fmt.Sprintf("tubes-acceptance-test-%x", rand.Int())
+		var err error
+		workingDir, err = ioutil.TempDir("", "tubes-acceptance-test")
+		Expect(err).NotTo(HaveOccurred())
+
+		logger := integration.NewAWSCallLogger(GinkgoWriter)
+		fakeAWS = integration.NewFakeAWS(logger)
+
+		concourseManifestTemplate, err := ioutil.ReadFile("fixtures/concourse-template.yml")
+		Expect(err).NotTo(HaveOccurred())
+		manifestServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(concourseManifestTemplate)
+		}))
+
+		boshIOServer = httptest.NewServer(&integration.FakeBoshIO{})
+
+		start = buildStarter(&workingDir, map[string]string{
+			"AWS_DEFAULT_REGION":                    "us-west-2",
+			"AWS_ACCESS_KEY_ID":                     "some-access-key-id",
+			"AWS_SECRET_ACCESS_KEY":                 "some-secret-access-key",
+			"TUBES_AWS_ENDPOINTS":                   fakeAWS.EndpointOverridesEnvVar(),
+			"TUBES_CONCOURSE_MANIFEST_TEMPLATE_URL": manifestServer.URL + "/concourse-template.yml",
+			"TUBES_BOSH_IO_URL":                     boshIOServer.URL,
+		})
+
+		session := start("-n", stackName, "up")
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+	})
+
+	AfterEach(func() {
+		fakeAWS.Close()
+
+		if manifestServer != nil {
+			manifestServer.Close()
+		}
+
+		if boshIOServer != nil {
+			boshIOServer.Close()
+		}
+	})
+
+	It("should succeed when run again after the environment is gone", func() {
+		session := start("-n", stackName, "down")
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+
+		session = start("-n", stackName, "down")
+
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+		Expect(session.Err).To(gbytes.Say("Base stack is already gone"))
+		Expect(session.Err).To(gbytes.Say("Concourse stack is already gone"))
+		Expect(session.Err).To(gbytes.Say("Finished"))
+	})
+
+	It("should tear down an environment whose Concourse stack never got created", func() {
+		fakeAWS.CloudFormation.Stacks = fakeAWS.CloudFormation.Stacks[:1]
+
+		session := start("-n", stackName, "down")
+
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+		Expect(session.Err).To(gbytes.Say("Concourse stack is already gone"))
+		Expect(session.Err).To(gbytes.Say("Deleting base stack"))
+		Expect(*fakeAWS.CloudFormation.Stacks[0].StackStatus).To(Equal("DELETE_COMPLETE"))
+		Expect(fakeAWS.EC2.KeyPairs).NotTo(HaveKey(stackName))
+	})
+
+	It("should terminate the VMs that BOSH created before deleting the stacks", func() {
+		fakeAWS.EC2.Instances = []*ec2.Instance{
+			&ec2.Instance{
+				InstanceId: aws.String("i-director"),
+				VpcId:      aws.String("some-vpc-id"),
+				Tags:       []*ec2.Tag{&ec2.Tag{Key: aws.String("director"), Value: aws.String("bosh-init")}},
+				BlockDeviceMappings: []*ec2.InstanceBlockDeviceMapping{
+					&ec2.InstanceBlockDeviceMapping{
+						Ebs: &ec2.EbsInstanceBlockDevice{VolumeId: aws.String("vol-persistent"), DeleteOnTermination: aws.Bool(false)},
+					},
+				},
+				NetworkInterfaces: []*ec2.InstanceNetworkInterface{
+					&ec2.InstanceNetworkInterface{
+						NetworkInterfaceId: aws.String("eni-extra"),
+						Attachment:         &ec2.InstanceNetworkInterfaceAttachment{DeleteOnTermination: aws.Bool(false)},
+					},
+				},
+			},
+			&ec2.Instance{
+				InstanceId: aws.String("i-not-bosh"),
+				VpcId:      aws.String("some-vpc-id"),
+			},
+			&ec2.Instance{
+				InstanceId: aws.String("i-other-vpc"),
+				VpcId:      aws.String("other-vpc-id"),
+				Tags:       []*ec2.Tag{&ec2.Tag{Key: aws.String("director"), Value: aws.String("other-director")}},
+			},
+		}
+		fakeAWS.EC2.Volumes = []string{"vol-persistent", "vol-other"}
+		fakeAWS.EC2.NetworkInterfaces = []string{"eni-extra", "eni-other"}
+
+		session := start("-n", stackName, "down")
+
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+		Expect(session.Err).To(gbytes.Say("Terminating 1 BOSH VMs, then deleting 1 volumes and 1 network interfaces they leave behind"))
+		Expect(session.Err).To(gbytes.Say("Deleting Concourse stack"))
+		Expect(session.Err).To(gbytes.Say("Finished"))
+
+		Expect(fakeAWS.EC2.InstanceStates).To(Equal(map[string]string{"i-director": "terminated"}))
+		Expect(fakeAWS.EC2.Volumes).To(Equal([]string{"vol-other"}))
+		Expect(fakeAWS.EC2.NetworkInterfaces).To(Equal([]string{"eni-other"}))
+	})
+
+	It("should delete the volumes and network interfaces BOSH left detached in the VPC", func() {
+		directorTags := []*ec2.Tag{&ec2.Tag{Key: aws.String("director"), Value: aws.String(stackName)}}
+		fakeAWS.EC2.Subnets = []*ec2.Subnet{
+			&ec2.Subnet{VpcId: aws.String("some-vpc-id"), AvailabilityZone: aws.String("some-zone")},
+			&ec2.Subnet{VpcId: aws.String("other-vpc-id"), AvailabilityZone: aws.String("other-zone")},
+		}
+		fakeAWS.EC2.Volumes = []string{"vol-detached", "vol-other-zone", "vol-untagged"}
+		fakeAWS.EC2.DetachedVolumes = []*ec2.Volume{
+			&ec2.Volume{VolumeId: aws.String("vol-detached"), AvailabilityZone: aws.String("some-zone"), Tags: directorTags},
+			&ec2.Volume{VolumeId: aws.String("vol-other-zone"), AvailabilityZone: aws.String("other-zone"), Tags: directorTags},
+			&ec2.Volume{VolumeId: aws.String("vol-untagged"), AvailabilityZone: aws.String("some-zone")},
+		}
+		fakeAWS.EC2.NetworkInterfaces = []string{"eni-detached", "eni-other-vpc"}
+		fakeAWS.EC2.DetachedNetworkInterfaces = []*ec2.NetworkInterface{
+			&ec2.NetworkInterface{NetworkInterfaceId: aws.String("eni-detached"), VpcId: aws.String("some-vpc-id"), TagSet: directorTags},
+			&ec2.NetworkInterface{NetworkInterfaceId: aws.String("eni-other-vpc"), VpcId: aws.String("other-vpc-id"), TagSet: directorTags},
+		}
+
+		session := start("-n", stackName, "down")
+
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+		Expect(session.Err).To(gbytes.Say("Terminating 0 BOSH VMs, then deleting 1 volumes and 1 network interfaces they leave behind"))
+		Expect(session.Err).To(gbytes.Say("Finished"))
+
+		Expect(fakeAWS.EC2.Volumes).To(Equal([]string{"vol-other-zone", "vol-untagged"}))
+		Expect(fakeAWS.EC2.NetworkInterfaces).To(Equal([]string{"eni-other-vpc"}))
+	})
+
+	It("should keep the detached volumes of another environment's director in the same zone", func() {
+		fakeAWS.EC2.Subnets = []*ec2.Subnet{
+			&ec2.Subnet{VpcId: aws.String("some-vpc-id"), AvailabilityZone: aws.String("some-zone")},
+		}
+		fakeAWS.EC2.Volumes = []string{"vol-detached", "vol-other-environment"}
+		fakeAWS.EC2.DetachedVolumes = []*ec2.Volume{
+			&ec2.Volume{
+				VolumeId:         aws.String("vol-detached"),
+				AvailabilityZone: aws.String("some-zone"),
+				Tags:             []*ec2.Tag{&ec2.Tag{Key: aws.String("director"), Value: aws.String(stackName)}},
+			},
+			&ec2.Volume{
+				VolumeId:         aws.String("vol-other-environment"),
+				AvailabilityZone: aws.String("some-zone"),
+				Tags:             []*ec2.Tag{&ec2.Tag{Key: aws.String("director"), Value: aws.String("other-environment")}},
+			},
+		}
+
+		session := start("-n", stackName, "down")
+
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+		Expect(session.Err).To(gbytes.Say("Terminating 0 BOSH VMs, then deleting 1 volumes and 0 network interfaces they leave behind"))
+
+		Expect(fakeAWS.EC2.Volumes).To(Equal([]string{"vol-other-environment"}))
+	})
+
+	Context("when the base stack fails to delete", func() {
+		BeforeEach(func() {
+			fakeAWS.CloudFormation.DeleteFailures[stackName+"-base"] = []string{"VPC"}
+		})
+
+		It("should carry on, report the failure and keep the state directory", func() {
+			session := start("-n", stackName, "down")
+
+			Eventually(session, NormalTimeout).Should(gexec.Exit(1))
+			Expect(session.Err).To(gbytes.Say(`deleting the base stack: stack "` + stackName + `-base" has unhealthy status "DELETE_FAILED": VPC DELETE_FAILED`))
+			Expect(fakeAWS.EC2.KeyPairs).NotTo(HaveKey(stackName))
+			Expect(stateFileExists("ssh-key")).To(BeTrue())
+		})
+
+		It("should finish once told to retain the resource that won't delete", func() {
+			session := start("-n", stackName, "down")
+			Eventually(session, NormalTimeout).Should(gexec.Exit(1))
+
+			session = start("-n", stackName, "down", "--retain", "VPC")
+
+			Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+			Expect(session.Err).To(gbytes.Say("Concourse stack is already gone"))
+			Expect(session.Err).To(gbytes.Say("Retaining VPC"))
+			Expect(session.Err).To(gbytes.Say("Finished"))
+			Expect(*fakeAWS.CloudFormation.Stacks[0].StackStatus).To(Equal("DELETE_COMPLETE"))
+			Expect(stateFileExists("ssh-key")).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,157 @@
+package integration_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+
+	"github.com/rosenhouse/tubes/integration"
+)
+
+var _ = Describe("Drift action", func() {
+	var (
+		stackName  string
+		envVars    map[string]string
+		workingDir string
+		fakeAWS    *integration.FakeAWS
+		start      func(args ...string) *gexec.Session
+
+		manifestServer *httptest.Server
+		boshIOServer   *httptest.Server
+	)
+
+	const NormalTimeout = "5s"
+
+	BeforeEach(func() {
+		stackName = fmt.Sprintf("tubes-acceptance-test-%x", rand.Int())
+		var err error
+		workingDir, err = ioutil.TempDir("", "tubes-acceptance-test")
+		Expect(err).NotTo(HaveOccurred())
+
+		logger := integration.NewAWSCallLogger(GinkgoWriter)
+		fakeAWS = integration.NewFakeAWS(logger)
+
+		concourseManifestTemplate, err := ioutil.ReadFile("fixtures/concourse-template.yml")
+		Expect(err).NotTo(HaveOccurred())
+		manifestServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(concourseManifestTemplate)
+		}))
+
+		boshIOServer = httptest.NewServer(&integration.FakeBoshIO{})
+
+		envVars = map[string]string{
+			"AWS_DEFAULT_REGION":                    "us-west-2",
+			"AWS_ACCESS_KEY_ID":                     "some-access-key-id",
+			"AWS_SECRET_ACCESS_KEY":                 "some-secret-access-key",
+			"TUBES_AWS_ENDPOINTS":                   fakeAWS.EndpointOverridesEnvVar(),
+			"TUBES_CONCOURSE_MANIFEST_TEMPLATE_URL": manifestServer.URL + "/concourse-template.yml",
+			"TUBES_BOSH_IO_URL":                     boshIOServer.URL,
+		}
+
+		start = buildStarter(&workingDir, envVars)
+
+		session := start("-n", stackName, "up")
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+	})
+
+	AfterEach(func() {
+		fakeAWS.Close()
+
+		if manifestServer != nil {
+			manifestServer.Close()
+		}
+
+		if boshIOServer != nil {
+			boshIOServer.Close()
+		}
+	})
+
+	Context("when a security group was edited in the console", func() {
+		BeforeEach(func() {
+			fakeAWS.CloudFormation.Drifts[stackName+"-base"] = []*cloudformation.StackResourceDrift{
+				&cloudformation.StackResourceDrift{
+					LogicalResourceId:        aws.String("BOSHSecurityGroup"),
+					PhysicalResourceId:       aws.String("sg-1234"),
+					ResourceType:             aws.String("AWS::EC2::SecurityGroup"),
+					StackResourceDriftStatus: aws.String("MODIFIED"),
+					PropertyDifferences: []*cloudformation.PropertyDifference{
+						&cloudformation.PropertyDifference{
+							PropertyPath:   aws.String("/SecurityGroupIngress/2"),
+							ExpectedValue:  aws.String("null"),
+							ActualValue:    aws.String(`{"CidrIp":"0.0.0.0/0","FromPort":3389}`),
+							DifferenceType: aws.String("ADD"),
+						},
+					},
+				},
+			}
+		})
+
+		It("should print the property differences, and fail", func() {
+			session := start("-n", stackName, "drift")
+
+			Eventually(session, NormalTimeout).Should(gexec.Exit(1))
+			Expect(session.Out).To(gbytes.Say(stackName + `-base: DRIFTED\n`))
+			Expect(session.Out).To(gbytes.Say(`MODIFIED BOSHSecurityGroup \(AWS::EC2::SecurityGroup\) sg-1234\n`))
+			Expect(session.Out).To(gbytes.Say(`ADD\s+/SecurityGroupIngress/2: expected null, actual {"CidrIp":"0.0.0.0/0","FromPort":3389}\n`))
+			Expect(session.Out).To(gbytes.Say(stackName + `-concourse: IN_SYNC\n`))
+			Expect(session.Err).To(gbytes.Say("the stacks have drifted from their templates"))
+		})
+
+		It("should print how to undo it with --show-fixes, without changing the stacks", func() {
+			session := start("-n", stackName, "drift", "--show-fixes")
+
+			Eventually(session, NormalTimeout).Should(gexec.Exit(1))
+			Expect(session.Out).To(gbytes.Say(stackName + `-base: DRIFTED\n`))
+			Expect(session.Out).To(gbytes.Say(`To fix by hand:\n`))
+			Expect(session.Out).To(gbytes.Say(`  remove /SecurityGroupIngress/2 from BOSHSecurityGroup \(sg-1234\)\n`))
+			Expect(session.Err).To(gbytes.Say("the stacks have drifted from their templates, so fix them by hand"))
+
+			session = start("-n", stackName, "drift")
+			Eventually(session, NormalTimeout).Should(gexec.Exit(1))
+		})
+
+		It("should not be undone by re-applying the unchanged templates", func() {
+			session := start("-n", stackName, "up")
+			Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+
+			session = start("-n", stackName, "drift")
+			Eventually(session, NormalTimeout).Should(gexec.Exit(1))
+			Expect(session.Out).To(gbytes.Say(stackName + `-base: DRIFTED\n`))
+		})
+	})
+
+	Context("when a resource was deleted in the console", func() {
+		It("should say how to recreate it with --show-fixes", func() {
+			fakeAWS.CloudFormation.Drifts[stackName+"-concourse"] = []*cloudformation.StackResourceDrift{
+				&cloudformation.StackResourceDrift{
+					LogicalResourceId:        aws.String("LoadBalancer"),
+					ResourceType:             aws.String("AWS::ElasticLoadBalancing::LoadBalancer"),
+					StackResourceDriftStatus: aws.String("DELETED"),
+				},
+			}
+
+			session := start("-n", stackName, "drift", "--show-fixes")
+
+			Eventually(session, NormalTimeout).Should(gexec.Exit(1))
+			Expect(session.Out).To(gbytes.Say(`DELETED\s+LoadBalancer`))
+			Expect(session.Out).To(gbytes.Say("LoadBalancer of " + stackName + "-concourse was deleted: run down and up to recreate it"))
+			Expect(session.Err).To(gbytes.Say("the stacks have drifted from their templates, so fix them by hand"))
+		})
+	})
+
+	It("should succeed when nothing has drifted", func() {
+		session := start("-n", stackName, "drift")
+
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+		Expect(session.Out).To(gbytes.Say(stackName + `-base: IN_SYNC\n`))
+	})
+})
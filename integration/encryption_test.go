@@ -0,0 +1,121 @@
+package integration_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+
+	"github.com/rosenhouse/tubes/integration"
+)
+
+var _ = Describe("Encrypted state directory", func() {
+	var (
+		stackName  string
+		envVars    map[string]string
+		workingDir string
+		stateDir   string
+		fakeAWS    *integration.FakeAWS
+		start      func(args ...string) *gexec.Session
+
+		manifestServer *httptest.Server
+		boshIOServer   *httptest.Server
+	)
+
+	const NormalTimeout = "5s"
+
+	BeforeEach(func() {
+		stackName = fmt.Sprintf("tubes-acceptance-test-%x", rand.Int())
+		var err error
+		workingDir, err = ioutil.TempDir("", "tubes-acceptance-test")
+		Expect(err).NotTo(HaveOccurred())
+		stateDir = filepath.Join(workingDir, "environments", stackName)
+
+		logger := integration.NewAWSCallLogger(GinkgoWriter)
+		fakeAWS = integration.NewFakeAWS(logger)
+
+		concourseManifestTemplate, err := ioutil.ReadFile("fixtures/concourse-template.yml")
+		Expect(err).NotTo(HaveOccurred())
+		manifestServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(concourseManifestTemplate)
+		}))
+
+		boshIOServer = httptest.NewServer(&integration.FakeBoshIO{})
+
+		envVars = map[string]string{
+			"AWS_DEFAULT_REGION":                    "us-west-2",
+			"AWS_ACCESS_KEY_ID":                     "some-access-key-id",
+			"AWS_SECRET_ACCESS_KEY":                 "some-secret-access-key",
+			"TUBES_AWS_ENDPOINTS":                   fakeAWS.EndpointOverridesEnvVar(),
+			"TUBES_CONCOURSE_MANIFEST_TEMPLATE_URL": manifestServer.URL + "/concourse-template.yml",
+			"TUBES_BOSH_IO_URL":                     boshIOServer.URL,
+			"TUBES_PASSPHRASE":                      "some-passphrase",
+		}
+
+		start = buildStarter(&workingDir, envVars)
+
+		session := start("-n", stackName, "up")
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+	})
+
+	AfterEach(func() {
+		fakeAWS.Close()
+
+		if manifestServer != nil {
+			manifestServer.Close()
+		}
+
+		if boshIOServer != nil {
+			boshIOServer.Close()
+		}
+	})
+
+	readState := func(key string) string {
+		value, err := ioutil.ReadFile(filepath.Join(stateDir, key))
+		Expect(err).NotTo(HaveOccurred())
+		return string(value)
+	}
+
+	It("should encrypt the secrets, and decrypt them transparently", func() {
+		for _, key := range []string{"ssh-key", "bosh-password", "bosh-environment", "director.yml", "director-secret-access-key", "director-key.pem"} {
+			Expect(readState(key)).To(HavePrefix("tubes-secretbox-v1\n"))
+		}
+		Expect(readState("bosh-ip")).To(Equal("192.168.12.13"))
+
+		session := start("-n", stackName, "show", "--bosh-password")
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+		Expect(session.Out.Contents()).To(HaveLen(12))
+	})
+
+	It("should export the plaintext on unlock, and encrypt it again on lock", func() {
+		session := start("-n", stackName, "unlock")
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+		Expect(session.Err).To(gbytes.Say("Unlocked ssh-key, bosh-password"))
+		Expect(readState("bosh-environment")).To(ContainSubstring("export BOSH_PASSWORD="))
+
+		session = start("-n", stackName, "lock")
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+		Expect(readState("bosh-environment")).To(HavePrefix("tubes-secretbox-v1\n"))
+	})
+
+	Context("when the passphrase is missing or wrong", func() {
+		It("should fail to decrypt", func() {
+			delete(envVars, "TUBES_PASSPHRASE")
+			session := start("-n", stackName, "show", "--bosh-password")
+			Eventually(session, NormalTimeout).Should(gexec.Exit(1))
+			Expect(session.Err).To(gbytes.Say("set TUBES_PASSPHRASE"))
+
+			envVars["TUBES_PASSPHRASE"] = "wrong"
+			session = start("-n", stackName, "show", "--bosh-password")
+			Eventually(session, NormalTimeout).Should(gexec.Exit(1))
+			Expect(session.Err).To(gbytes.Say("unable to decrypt bosh-password"))
+		})
+	})
+})
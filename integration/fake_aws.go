@@ -11,6 +11,8 @@ type FakeAWS struct {
 	CloudFormation *FakeCloudFormation
 	EC2            *FakeEC2
 	IAM            *FakeIAM
+	S3             *FakeS3
+	STS            *FakeSTS
 
 	servers map[string]*httptest.Server
 }
@@ -29,11 +31,15 @@ func NewFakeAWS(logger *AWSCallLogger) *FakeAWS {
 		CloudFormation: NewFakeCloudFormation(logger),
 		EC2:            NewFakeEC2(logger),
 		IAM:            NewFakeIAM(logger),
+		S3:             NewFakeS3(logger),
+		STS:            NewFakeSTS(logger),
 	}
 	f.servers = map[string]*httptest.Server{
 		"cloudformation": httptest.NewServer(awsfaker.New(f.CloudFormation)),
 		"ec2":            httptest.NewServer(awsfaker.New(f.EC2)),
 		"iam":            httptest.NewServer(awsfaker.New(f.IAM)),
+		"s3":             httptest.NewServer(f.S3),
+		"sts":            httptest.NewServer(awsfaker.New(f.STS)),
 	}
 
 	return f
@@ -1,33 +1,78 @@
 package integration
 
 import (
+	"encoding/json"
 	"fmt"
 	"math/rand"
+	"net/http"
+	"reflect"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/rosenhouse/awsfaker"
 	"github.com/rosenhouse/tubes/aws_enemy"
 )
 
 type FakeCloudFormation struct {
 	*AWSCallLogger
 
-	Stacks []*cloudformation.Stack
+	Stacks     []*cloudformation.Stack
+	Templates  map[string]string
+	ChangeSets map[string]*cloudformation.DescribeChangeSetOutput
+
+	// Drifts are the resources of each stack, by stack name, that were changed outside of CloudFormation
+	Drifts          map[string][]*cloudformation.StackResourceDrift
+	driftDetections map[string]string
+
+	// DeleteFailures are the resources of each stack, by stack name, that fail to delete
+	DeleteFailures map[string][]string
+
+	// CreateStatus, if set, is the status of new stacks, e.g. CREATE_IN_PROGRESS to keep up waiting
+	CreateStatus string
 }
 
 func NewFakeCloudFormation(logger *AWSCallLogger) *FakeCloudFormation {
 	return &FakeCloudFormation{
 		AWSCallLogger: logger,
+		Templates:     map[string]string{},
+		ChangeSets:    map[string]*cloudformation.DescribeChangeSetOutput{},
+
+		Drifts:          map[string][]*cloudformation.StackResourceDrift{},
+		driftDetections: map[string]string{},
+
+		DeleteFailures: map[string][]string{},
 	}
 }
 
+// findStack prefers a live stack, but like AWS will still find deleted stacks
 func (f *FakeCloudFormation) findStack(nameOrID string) *cloudformation.Stack {
+	var deleted *cloudformation.Stack
 	for _, v := range f.Stacks {
 		if nameOrID == *v.StackName || nameOrID == *v.StackId {
-			return v
+			if *v.StackStatus != "DELETE_COMPLETE" {
+				return v
+			}
+			deleted = v
 		}
 	}
-	return nil
+	return deleted
+}
+
+func parametersMap(parameters []*cloudformation.Parameter) map[string]string {
+	m := map[string]string{}
+	for _, p := range parameters {
+		m[aws.StringValue(p.ParameterKey)] = aws.StringValue(p.ParameterValue)
+	}
+	return m
+}
+
+func tagsMap(tags []*cloudformation.Tag) map[string]string {
+	m := map[string]string{}
+	for _, tag := range tags {
+		m[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return m
 }
 
 func (f *FakeCloudFormation) DescribeStacks(input *cloudformation.DescribeStacksInput) (*cloudformation.DescribeStacksOutput, error) {
@@ -48,41 +93,253 @@ func (f *FakeCloudFormation) DescribeStacks(input *cloudformation.DescribeStacks
 	return nil, aws_enemy.CloudFormation{}.DescribeStackResources_StackMissingError(stackName)
 }
 
+func (f *FakeCloudFormation) ListStacks(input *cloudformation.ListStacksInput) (*cloudformation.ListStacksOutput, error) {
+	f.logCall(input)
+
+	statuses := map[string]bool{}
+	for _, status := range input.StackStatusFilter {
+		statuses[aws.StringValue(status)] = true
+	}
+	output := &cloudformation.ListStacksOutput{}
+	for _, stack := range f.Stacks {
+		if len(statuses) > 0 && !statuses[*stack.StackStatus] {
+			continue
+		}
+		output.StackSummaries = append(output.StackSummaries, &cloudformation.StackSummary{
+			StackName:   stack.StackName,
+			StackId:     stack.StackId,
+			StackStatus: stack.StackStatus,
+		})
+	}
+	return output, nil
+}
+
 func (f *FakeCloudFormation) CreateStack(input *cloudformation.CreateStackInput) (*cloudformation.CreateStackOutput, error) {
 	f.logCall(input)
 
 	stackName := aws.StringValue(input.StackName)
 	stack := f.findStack(stackName)
-	if stack != nil {
+	if stack != nil && *stack.StackStatus != "DELETE_COMPLETE" {
 		return nil, aws_enemy.CloudFormation{}.CreateStack_AlreadyExistsError(stackName)
 	}
 
+	status := "CREATE_COMPLETE"
+	if f.CreateStatus != "" {
+		status = f.CreateStatus
+	}
+
 	newStackId := aws.String(fmt.Sprintf("%x", rand.Int31()))
 	newStack := &cloudformation.Stack{
 		StackName:   input.StackName,
 		StackId:     newStackId,
-		StackStatus: aws.String("CREATE_COMPLETE"),
+		StackStatus: aws.String(status),
 		Parameters:  input.Parameters,
+		Tags:        input.Tags,
 	}
 	f.Stacks = append(f.Stacks, newStack)
+	f.Templates[*newStackId] = aws.StringValue(input.TemplateBody)
 
 	return &cloudformation.CreateStackOutput{
 		StackId: newStackId,
 	}, nil
 }
 
-func (f *FakeCloudFormation) DeleteStack(input *cloudformation.DeleteStackInput) (*cloudformation.DeleteStackOutput, error) {
+func (f *FakeCloudFormation) UpdateStack(input *cloudformation.UpdateStackInput) (*cloudformation.UpdateStackOutput, error) {
+	f.logCall(input)
+
+	stackName := aws.StringValue(input.StackName)
+	stack := f.findStack(stackName)
+	if stack == nil || *stack.StackStatus == "DELETE_COMPLETE" {
+		return nil, aws_enemy.CloudFormation{}.UpdateStack_StackMissingError(stackName)
+	}
+
+	template := aws.StringValue(input.TemplateBody)
+	sameTags := input.Tags == nil || reflect.DeepEqual(tagsMap(input.Tags), tagsMap(stack.Tags))
+	if template == f.Templates[*stack.StackId] && reflect.DeepEqual(parametersMap(input.Parameters), parametersMap(stack.Parameters)) && sameTags {
+		// like real CloudFormation, an update with no changes leaves any drift alone
+		return nil, aws_enemy.CloudFormation{}.UpdateStack_NoChangesError()
+	}
+
+	// an update restores resources that were modified outside of CloudFormation.
+	// Real CloudFormation only restores the properties that the update changes.
+	f.Drifts[*stack.StackName] = resourceDrifts(f.Drifts[*stack.StackName], "DELETED")
+
+	f.Templates[*stack.StackId] = template
+	stack.Parameters = input.Parameters
+	if input.Tags != nil {
+		stack.Tags = input.Tags
+	}
+	stack.StackStatus = aws.String("UPDATE_COMPLETE")
+
+	return &cloudformation.UpdateStackOutput{
+		StackId: stack.StackId,
+	}, nil
+}
+
+func templateResources(template string) map[string]interface{} {
+	var parsed struct {
+		Resources map[string]interface{}
+	}
+	json.Unmarshal([]byte(template), &parsed)
+	return parsed.Resources
+}
+
+func resourceChange(action, logicalID string, resource interface{}) *cloudformation.Change {
+	resourceType, _ := resource.(map[string]interface{})["Type"].(string)
+	return &cloudformation.Change{
+		Type: aws.String("Resource"),
+		ResourceChange: &cloudformation.ResourceChange{
+			Action:            aws.String(action),
+			LogicalResourceId: aws.String(logicalID),
+			ResourceType:      aws.String(resourceType),
+			Replacement:       aws.String("Conditional"),
+		},
+	}
+}
+
+func (f *FakeCloudFormation) CreateChangeSet(input *cloudformation.CreateChangeSetInput) (*cloudformation.CreateChangeSetOutput, error) {
+	f.logCall(input)
+
+	stackName := aws.StringValue(input.StackName)
+	stack := f.findStack(stackName)
+	if stack == nil || *stack.StackStatus == "DELETE_COMPLETE" {
+		return nil, aws_enemy.CloudFormation{}.UpdateStack_StackMissingError(stackName)
+	}
+
+	changeSetName := aws.StringValue(input.ChangeSetName)
+	if _, ok := f.ChangeSets[*stack.StackId+"/"+changeSetName]; ok {
+		return nil, &awsfaker.ErrorResponse{
+			HTTPStatusCode:  http.StatusBadRequest,
+			AWSErrorCode:    "AlreadyExistsException",
+			AWSErrorMessage: fmt.Sprintf("ChangeSet %s already exists", changeSetName),
+		}
+	}
+
+	oldResources := templateResources(f.Templates[*stack.StackId])
+	newResources := templateResources(aws.StringValue(input.TemplateBody))
+	changes := []*cloudformation.Change{}
+	for logicalID, resource := range newResources {
+		oldResource, ok := oldResources[logicalID]
+		if !ok {
+			changes = append(changes, resourceChange("Add", logicalID, resource))
+		} else if !reflect.DeepEqual(oldResource, resource) {
+			changes = append(changes, resourceChange("Modify", logicalID, resource))
+		}
+	}
+	for logicalID, resource := range oldResources {
+		if _, ok := newResources[logicalID]; !ok {
+			changes = append(changes, resourceChange("Remove", logicalID, resource))
+		}
+	}
+
+	changeSet := &cloudformation.DescribeChangeSetOutput{
+		StackName:     stack.StackName,
+		StackId:       stack.StackId,
+		ChangeSetName: input.ChangeSetName,
+		Parameters:    input.Parameters,
+		Changes:       changes,
+		Status:        aws.String("CREATE_COMPLETE"),
+	}
+	if len(changes) == 0 && reflect.DeepEqual(parametersMap(input.Parameters), parametersMap(stack.Parameters)) {
+		changeSet.Status = aws.String("FAILED")
+		changeSet.StatusReason = aws.String("The submitted information didn't contain changes. Submit different information to create a change set.")
+	}
+	f.ChangeSets[*stack.StackId+"/"+changeSetName] = changeSet
+
+	return &cloudformation.CreateChangeSetOutput{
+		Id: aws.String(fmt.Sprintf("%x", rand.Int31())),
+	}, nil
+}
+
+func (f *FakeCloudFormation) DescribeChangeSet(input *cloudformation.DescribeChangeSetInput) (*cloudformation.DescribeChangeSetOutput, error) {
+	f.logCall(input)
+
+	changeSetName := aws.StringValue(input.ChangeSetName)
+	stack := f.findStack(aws.StringValue(input.StackName))
+	if stack == nil {
+		return nil, aws_enemy.CloudFormation{}.DescribeChangeSet_NotFoundError(changeSetName)
+	}
+
+	changeSet, ok := f.ChangeSets[*stack.StackId+"/"+changeSetName]
+	if !ok {
+		return nil, aws_enemy.CloudFormation{}.DescribeChangeSet_NotFoundError(changeSetName)
+	}
+	return changeSet, nil
+}
+
+func (f *FakeCloudFormation) DeleteChangeSet(input *cloudformation.DeleteChangeSetInput) (*cloudformation.DeleteChangeSetOutput, error) {
 	f.logCall(input)
 
 	stackName := aws.StringValue(input.StackName)
 	stack := f.findStack(stackName)
 	if stack != nil {
-		stack.StackStatus = aws.String("DELETE_COMPLETE")
+		delete(f.ChangeSets, *stack.StackId+"/"+aws.StringValue(input.ChangeSetName))
+	}
+
+	return &cloudformation.DeleteChangeSetOutput{}, nil
+}
+
+func (f *FakeCloudFormation) DeleteStack(input *cloudformation.DeleteStackInput) (*cloudformation.DeleteStackOutput, error) {
+	f.logCall(input)
+
+	stackName := aws.StringValue(input.StackName)
+	stack := f.findStack(stackName)
+	if stack == nil || *stack.StackStatus == "DELETE_COMPLETE" {
+		return &cloudformation.DeleteStackOutput{}, nil
+	}
+	if len(input.RetainResources) > 0 && *stack.StackStatus != "DELETE_FAILED" {
+		return nil, &awsfaker.ErrorResponse{
+			HTTPStatusCode:  http.StatusBadRequest,
+			AWSErrorCode:    "ValidationError",
+			AWSErrorMessage: fmt.Sprintf("Invalid operation on stack: %s. RetainResources can only be specified when the stack is in the DELETE_FAILED state.", *stack.StackId),
+		}
+	}
+
+	retained := map[string]bool{}
+	for _, logicalID := range input.RetainResources {
+		retained[aws.StringValue(logicalID)] = true
+	}
+	stack.StackStatus = aws.String("DELETE_COMPLETE")
+	for _, logicalID := range f.DeleteFailures[*stack.StackName] {
+		if !retained[logicalID] {
+			stack.StackStatus = aws.String("DELETE_FAILED")
+		}
 	}
 
 	return &cloudformation.DeleteStackOutput{}, nil
 }
 
+func (f *FakeCloudFormation) DescribeStackEvents(input *cloudformation.DescribeStackEventsInput) (*cloudformation.DescribeStackEventsOutput, error) {
+	f.logCall(input)
+
+	stackName := aws.StringValue(input.StackName)
+	stack := f.findStack(stackName)
+	if stack == nil {
+		return nil, aws_enemy.CloudFormation{}.DescribeStacks_StackMissingError(stackName)
+	}
+
+	// a failed delete reports the resources that failed, newest first
+	output := &cloudformation.DescribeStackEventsOutput{}
+	if *stack.StackStatus == "DELETE_FAILED" {
+		for _, logicalID := range f.DeleteFailures[*stack.StackName] {
+			output.StackEvents = append(output.StackEvents, &cloudformation.StackEvent{
+				EventId:              aws.String(*stack.StackId + "-" + logicalID),
+				StackName:            stack.StackName,
+				LogicalResourceId:    aws.String(logicalID),
+				ResourceStatus:       aws.String("DELETE_FAILED"),
+				ResourceStatusReason: aws.String("resource has dependencies outside of the stack"),
+			})
+		}
+		output.StackEvents = append(output.StackEvents, &cloudformation.StackEvent{
+			EventId:           aws.String(*stack.StackId + "-delete"),
+			StackName:         stack.StackName,
+			LogicalResourceId: stack.StackName,
+			ResourceStatus:    aws.String("DELETE_IN_PROGRESS"),
+		})
+	}
+	return output, nil
+}
+
 func (f *FakeCloudFormation) DescribeStackResources(input *cloudformation.DescribeStackResourcesInput) (*cloudformation.DescribeStackResourcesOutput, error) {
 	f.logCall(input)
 
@@ -93,43 +350,192 @@ func (f *FakeCloudFormation) DescribeStackResources(input *cloudformation.Descri
 	}
 
 	const stackID = "arn:aws:cloudformation:us-west-2:123456789012:stack/MyProductionStack/abc9dbf0-43c2-11e3-a6e8-50fa526be49c"
-	return &cloudformation.DescribeStackResourcesOutput{
-		StackResources: []*cloudformation.StackResource{
-			&cloudformation.StackResource{
-				LogicalResourceId:  aws.String("BOSHSubnet"),
-				PhysicalResourceId: aws.String("subnet-12345"),
-				StackId:            aws.String(stackID),
-			},
+	if strings.HasSuffix(stackName, "-concourse") {
+		concourseResources := []*cloudformation.StackResource{
 			&cloudformation.StackResource{
-				LogicalResourceId:  aws.String("BOSHSecurityGroup"),
-				PhysicalResourceId: aws.String("sg-1234"),
+				LogicalResourceId:  aws.String("ConcourseSubnet"),
+				PhysicalResourceId: aws.String("subnet-concourse"),
 				StackId:            aws.String(stackID),
 			},
 			&cloudformation.StackResource{
-				LogicalResourceId:  aws.String("BOSHDirectorIP"),
-				PhysicalResourceId: aws.String("192.168.12.13"),
+				LogicalResourceId:  aws.String("ConcourseSubnetZ2"),
+				PhysicalResourceId: aws.String("subnet-concourse-z2"),
 				StackId:            aws.String(stackID),
 			},
 			&cloudformation.StackResource{
-				LogicalResourceId:  aws.String("BOSHDirectorUser"),
-				PhysicalResourceId: aws.String("some-iam-user"),
+				LogicalResourceId:  aws.String("ConcourseSecurityGroup"),
+				PhysicalResourceId: aws.String("sg-concourse"),
 				StackId:            aws.String(stackID),
 			},
 			&cloudformation.StackResource{
-				LogicalResourceId:  aws.String("NATInstance"),
-				PhysicalResourceId: aws.String("some-nat-instance-id"),
-				StackId:            aws.String(stackID),
-			},
-			&cloudformation.StackResource{
-				LogicalResourceId:  aws.String("NATEIP"),
-				PhysicalResourceId: aws.String("some-nat-elastic-ip"),
-				StackId:            aws.String(stackID),
-			},
-			&cloudformation.StackResource{
-				LogicalResourceId:  aws.String("VPC"),
-				PhysicalResourceId: aws.String("some-vpc-id"),
+				LogicalResourceId:  aws.String("LoadBalancer"),
+				PhysicalResourceId: aws.String("some-concourse-elb"),
 				StackId:            aws.String(stackID),
 			},
+		}
+		return declaredResources(f.Templates[*stack.StackId], concourseResources), nil
+	}
+
+	baseResources := []*cloudformation.StackResource{
+		&cloudformation.StackResource{
+			LogicalResourceId:  aws.String("BOSHSubnet"),
+			PhysicalResourceId: aws.String("subnet-12345"),
+			StackId:            aws.String(stackID),
+		},
+		&cloudformation.StackResource{
+			LogicalResourceId:  aws.String("PublicSubnetZ2"),
+			PhysicalResourceId: aws.String("subnet-public-z2"),
+			StackId:            aws.String(stackID),
+		},
+		&cloudformation.StackResource{
+			LogicalResourceId:  aws.String("PrivateSubnetZ2"),
+			PhysicalResourceId: aws.String("subnet-private-z2"),
+			StackId:            aws.String(stackID),
+		},
+		&cloudformation.StackResource{
+			LogicalResourceId:  aws.String("BOSHSecurityGroup"),
+			PhysicalResourceId: aws.String("sg-1234"),
+			StackId:            aws.String(stackID),
+		},
+		&cloudformation.StackResource{
+			LogicalResourceId:  aws.String("BOSHDirectorIP"),
+			PhysicalResourceId: aws.String("192.168.12.13"),
+			StackId:            aws.String(stackID),
+		},
+		&cloudformation.StackResource{
+			LogicalResourceId:  aws.String("BOSHDirectorUser"),
+			PhysicalResourceId: aws.String("some-iam-user"),
+			StackId:            aws.String(stackID),
+		},
+		&cloudformation.StackResource{
+			LogicalResourceId:  aws.String("BOSHDirectorRole"),
+			PhysicalResourceId: aws.String("some-iam-role"),
+			StackId:            aws.String(stackID),
+		},
+		&cloudformation.StackResource{
+			LogicalResourceId:  aws.String("BOSHDirectorInstanceProfile"),
+			PhysicalResourceId: aws.String("some-instance-profile"),
+			StackId:            aws.String(stackID),
+		},
+		&cloudformation.StackResource{
+			LogicalResourceId:  aws.String("NATInstance"),
+			PhysicalResourceId: aws.String("some-nat-instance-id"),
+			StackId:            aws.String(stackID),
+		},
+		&cloudformation.StackResource{
+			LogicalResourceId:  aws.String("NATEIP"),
+			PhysicalResourceId: aws.String("some-nat-elastic-ip"),
+			StackId:            aws.String(stackID),
+		},
+		&cloudformation.StackResource{
+			LogicalResourceId:  aws.String("VPC"),
+			PhysicalResourceId: aws.String("some-vpc-id"),
+			StackId:            aws.String(stackID),
+		},
+		&cloudformation.StackResource{
+			LogicalResourceId:  aws.String("NATInstanceZ2"),
+			PhysicalResourceId: aws.String("some-nat-instance-id-z2"),
+			StackId:            aws.String(stackID),
 		},
+		&cloudformation.StackResource{
+			LogicalResourceId:  aws.String("NATGateway"),
+			PhysicalResourceId: aws.String("nat-12345"),
+			StackId:            aws.String(stackID),
+		},
+		&cloudformation.StackResource{
+			LogicalResourceId:  aws.String("NATGatewayZ2"),
+			PhysicalResourceId: aws.String("nat-z2"),
+			StackId:            aws.String(stackID),
+		},
+		&cloudformation.StackResource{
+			LogicalResourceId:  aws.String("BastionInstance"),
+			PhysicalResourceId: aws.String("some-bastion-instance-id"),
+			StackId:            aws.String(stackID),
+		},
+		&cloudformation.StackResource{
+			LogicalResourceId:  aws.String("BastionEIP"),
+			PhysicalResourceId: aws.String("some-bastion-elastic-ip"),
+			StackId:            aws.String(stackID),
+		},
+	}
+
+	return declaredResources(f.Templates[*stack.StackId], baseResources), nil
+}
+
+// declaredResources only reports the resources that the stack's template actually declares
+func declaredResources(template string, resources []*cloudformation.StackResource) *cloudformation.DescribeStackResourcesOutput {
+	declared := templateResources(template)
+	output := &cloudformation.DescribeStackResourcesOutput{}
+	for _, resource := range resources {
+		if _, ok := declared[*resource.LogicalResourceId]; ok || declared == nil {
+			output.StackResources = append(output.StackResources, resource)
+		}
+	}
+	return output
+}
+
+func resourceDrifts(drifts []*cloudformation.StackResourceDrift, statuses ...string) []*cloudformation.StackResourceDrift {
+	matching := []*cloudformation.StackResourceDrift{}
+	for _, drift := range drifts {
+		for _, status := range statuses {
+			if aws.StringValue(drift.StackResourceDriftStatus) == status {
+				matching = append(matching, drift)
+			}
+		}
+	}
+	return matching
+}
+
+func (f *FakeCloudFormation) DetectStackDrift(input *cloudformation.DetectStackDriftInput) (*cloudformation.DetectStackDriftOutput, error) {
+	f.logCall(input)
+
+	stackName := aws.StringValue(input.StackName)
+	stack := f.findStack(stackName)
+	if stack == nil || *stack.StackStatus == "DELETE_COMPLETE" {
+		return nil, aws_enemy.CloudFormation{}.DetectStackDrift_StackMissingError(stackName)
+	}
+
+	detectionID := fmt.Sprintf("%x", rand.Int31())
+	f.driftDetections[detectionID] = *stack.StackName
+	return &cloudformation.DetectStackDriftOutput{
+		StackDriftDetectionId: aws.String(detectionID),
+	}, nil
+}
+
+func (f *FakeCloudFormation) DescribeStackDriftDetectionStatus(input *cloudformation.DescribeStackDriftDetectionStatusInput) (*cloudformation.DescribeStackDriftDetectionStatusOutput, error) {
+	f.logCall(input)
+
+	stackName, ok := f.driftDetections[aws.StringValue(input.StackDriftDetectionId)]
+	if !ok {
+		return nil, &awsfaker.ErrorResponse{
+			HTTPStatusCode:  http.StatusBadRequest,
+			AWSErrorCode:    "ValidationError",
+			AWSErrorMessage: fmt.Sprintf("Drift detection %s does not exist", aws.StringValue(input.StackDriftDetectionId)),
+		}
+	}
+
+	drifted := resourceDrifts(f.Drifts[stackName], "MODIFIED", "DELETED")
+	status := "IN_SYNC"
+	if len(drifted) > 0 {
+		status = "DRIFTED"
+	}
+	return &cloudformation.DescribeStackDriftDetectionStatusOutput{
+		StackDriftDetectionId:     input.StackDriftDetectionId,
+		DetectionStatus:           aws.String("DETECTION_COMPLETE"),
+		StackDriftStatus:          aws.String(status),
+		DriftedStackResourceCount: aws.Int64(int64(len(drifted))),
+	}, nil
+}
+
+func (f *FakeCloudFormation) DescribeStackResourceDrifts(input *cloudformation.DescribeStackResourceDriftsInput) (*cloudformation.DescribeStackResourceDriftsOutput, error) {
+	f.logCall(input)
+
+	stackName := aws.StringValue(input.StackName)
+	if f.findStack(stackName) == nil {
+		return nil, aws_enemy.CloudFormation{}.DescribeStacks_StackMissingError(stackName)
+	}
+
+	return &cloudformation.DescribeStackResourceDriftsOutput{
+		StackResourceDrifts: resourceDrifts(f.Drifts[stackName], aws.StringValueSlice(input.StackResourceDriftStatusFilters)...),
 	}, nil
 }
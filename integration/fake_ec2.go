@@ -5,6 +5,9 @@ import (
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"fmt"
+	"net/http"
+	"sort"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
@@ -15,15 +18,35 @@ import (
 type FakeEC2 struct {
 	*AWSCallLogger
 
-	KeyPairs map[string]string
-	Images   []*ec2.Image
+	KeyPairs  map[string]string
+	Images    []*ec2.Image
+	Addresses []*ec2.Address
+
+	// InstanceStates overrides the state of instances, which are otherwise running
+	InstanceStates map[string]string
+
+	// NATGatewayStates overrides the state of NAT gateways, which are otherwise available
+	NATGatewayStates map[string]string
+
+	// Instances are found by filters, as BOSH would have created them.
+	// Volumes and NetworkInterfaces are the IDs that exist, and those of them
+	// in DetachedVolumes and DetachedNetworkInterfaces are found by filters too.
+	// Subnets are found by the vpc-id filter.
+	Instances                 []*ec2.Instance
+	Volumes                   []string
+	NetworkInterfaces         []string
+	DetachedVolumes           []*ec2.Volume
+	DetachedNetworkInterfaces []*ec2.NetworkInterface
+	Subnets                   []*ec2.Subnet
 }
 
 func NewFakeEC2(logger *AWSCallLogger) *FakeEC2 {
 	return &FakeEC2{
 		AWSCallLogger: logger,
 
-		KeyPairs: map[string]string{},
+		KeyPairs:         map[string]string{},
+		InstanceStates:   map[string]string{},
+		NATGatewayStates: map[string]string{},
 
 		Images: []*ec2.Image{
 			&ec2.Image{
@@ -82,9 +105,57 @@ func (f *FakeEC2) CreateKeyPair(input *ec2.CreateKeyPairInput) (*ec2.CreateKeyPa
 }
 
 func (f *FakeEC2) DeleteKeyPair(input *ec2.DeleteKeyPairInput) (*ec2.DeleteKeyPairOutput, error) {
+	f.logCall(input)
+
+	delete(f.KeyPairs, aws.StringValue(input.KeyName))
 	return &ec2.DeleteKeyPairOutput{}, nil
 }
 
+func (f *FakeEC2) DescribeKeyPairs(input *ec2.DescribeKeyPairsInput) (*ec2.DescribeKeyPairsOutput, error) {
+	f.logCall(input)
+
+	output := &ec2.DescribeKeyPairsOutput{}
+	if len(input.KeyNames) == 0 {
+		keyNames := []string{}
+		for keyName := range f.KeyPairs {
+			keyNames = append(keyNames, keyName)
+		}
+		sort.Strings(keyNames)
+		for _, keyName := range keyNames {
+			output.KeyPairs = append(output.KeyPairs, &ec2.KeyPairInfo{
+				KeyName:        aws.String(keyName),
+				KeyFingerprint: aws.String("some-key-fingerprint"),
+			})
+		}
+		return output, nil
+	}
+	for _, keyName := range input.KeyNames {
+		if _, ok := f.KeyPairs[*keyName]; !ok {
+			return nil, aws_enemy.EC2{}.DescribeKeyPairs_NotFoundError(*keyName)
+		}
+		output.KeyPairs = append(output.KeyPairs, &ec2.KeyPairInfo{
+			KeyName:        keyName,
+			KeyFingerprint: aws.String("some-key-fingerprint"),
+		})
+	}
+	return output, nil
+}
+
+func (f *FakeEC2) ImportKeyPair(input *ec2.ImportKeyPairInput) (*ec2.ImportKeyPairOutput, error) {
+	f.logCall(input)
+
+	keyName := *input.KeyName
+	if _, ok := f.KeyPairs[keyName]; ok {
+		return nil, aws_enemy.EC2{}.CreateKeyPair_AlreadyExistsError(keyName)
+	}
+	f.KeyPairs[keyName] = string(input.PublicKeyMaterial)
+
+	return &ec2.ImportKeyPairOutput{
+		KeyName:        input.KeyName,
+		KeyFingerprint: aws.String("some-key-fingerprint"),
+	}, nil
+}
+
 func (f *FakeEC2) DescribeImages(input *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
 	f.logCall(input)
 	return &ec2.DescribeImagesOutput{
@@ -95,12 +166,232 @@ func (f *FakeEC2) DescribeImages(input *ec2.DescribeImagesInput) (*ec2.DescribeI
 func (f *FakeEC2) DescribeSubnets(input *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
 	f.logCall(input)
 
-	return &ec2.DescribeSubnetsOutput{
-		Subnets: []*ec2.Subnet{
-			&ec2.Subnet{
-				AvailabilityZone: aws.String("some-availability-zone"),
-				CidrBlock:        aws.String("10.1.2.0/24"),
-			},
-		},
+	// the first subnet asked for is in the first zone, and so on
+	output := &ec2.DescribeSubnetsOutput{}
+	for i, subnetID := range input.SubnetIds {
+		availabilityZone := "some-availability-zone"
+		if i > 0 {
+			availabilityZone = fmt.Sprintf("some-availability-zone-%d", i+1)
+		}
+		output.Subnets = append(output.Subnets, &ec2.Subnet{
+			SubnetId:         subnetID,
+			AvailabilityZone: aws.String(availabilityZone),
+			CidrBlock:        aws.String(fmt.Sprintf("10.1.%d.0/24", 2+2*i)),
+		})
+	}
+	if len(input.Filters) > 0 {
+		for _, subnet := range f.Subnets {
+			if matchesFilters(input.Filters, map[string][]string{"vpc-id": {aws.StringValue(subnet.VpcId)}}) {
+				output.Subnets = append(output.Subnets, subnet)
+			}
+		}
+	}
+	return output, nil
+}
+
+func (f *FakeEC2) instanceState(instanceID string) string {
+	if state, ok := f.InstanceStates[instanceID]; ok {
+		return state
+	}
+	return "running"
+}
+
+func (f *FakeEC2) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	f.logCall(input)
+
+	reservation := &ec2.Reservation{}
+	for _, instanceID := range input.InstanceIds {
+		reservation.Instances = append(reservation.Instances, &ec2.Instance{
+			InstanceId: instanceID,
+			State:      &ec2.InstanceState{Name: aws.String(f.instanceState(*instanceID))},
+		})
+	}
+	if len(input.Filters) > 0 {
+		for _, instance := range f.Instances {
+			state := f.instanceState(*instance.InstanceId)
+			if matchesInstanceFilters(instance, state, input.Filters) {
+				found := *instance
+				found.State = &ec2.InstanceState{Name: aws.String(state)}
+				reservation.Instances = append(reservation.Instances, &found)
+			}
+		}
+	}
+	return &ec2.DescribeInstancesOutput{
+		Reservations: []*ec2.Reservation{reservation},
 	}, nil
 }
+
+func (f *FakeEC2) DescribeNatGateways(input *ec2.DescribeNatGatewaysInput) (*ec2.DescribeNatGatewaysOutput, error) {
+	f.logCall(input)
+
+	output := &ec2.DescribeNatGatewaysOutput{}
+	for _, natGatewayID := range input.NatGatewayIds {
+		state, ok := f.NATGatewayStates[*natGatewayID]
+		if !ok {
+			state = "available"
+		}
+		output.NatGateways = append(output.NatGateways, &ec2.NatGateway{
+			NatGatewayId: natGatewayID,
+			State:        aws.String(state),
+		})
+	}
+	return output, nil
+}
+
+// matchesInstanceFilters supports just the filters that tubes uses
+func matchesInstanceFilters(instance *ec2.Instance, state string, filters []*ec2.Filter) bool {
+	return matchesFilters(filters, map[string][]string{
+		"vpc-id":              {aws.StringValue(instance.VpcId)},
+		"instance-state-name": {state},
+		"tag-key":             tagKeys(instance.Tags),
+	})
+}
+
+// matchesFilters is true when every filter matches one of the values of the
+// attribute it names
+func matchesFilters(filters []*ec2.Filter, attributes map[string][]string) bool {
+	for _, filter := range filters {
+		matched := false
+		for _, value := range filter.Values {
+			for _, attribute := range attributes[aws.StringValue(filter.Name)] {
+				matched = matched || attribute == *value
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func tagKeys(tags []*ec2.Tag) []string {
+	keys := []string{}
+	for _, tag := range tags {
+		keys = append(keys, aws.StringValue(tag.Key))
+	}
+	return keys
+}
+
+func tagValues(tags []*ec2.Tag, key string) []string {
+	values := []string{}
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) == key {
+			values = append(values, aws.StringValue(tag.Value))
+		}
+	}
+	return values
+}
+
+func (f *FakeEC2) DescribeVolumes(input *ec2.DescribeVolumesInput) (*ec2.DescribeVolumesOutput, error) {
+	f.logCall(input)
+
+	output := &ec2.DescribeVolumesOutput{}
+	for _, volume := range f.DetachedVolumes {
+		if _, exists := removeString(f.Volumes, *volume.VolumeId); !exists {
+			continue
+		}
+		if matchesFilters(input.Filters, map[string][]string{
+			"availability-zone": {aws.StringValue(volume.AvailabilityZone)},
+			"status":            {"available"},
+			"tag-key":           tagKeys(volume.Tags),
+			"tag:director":      tagValues(volume.Tags, "director"),
+		}) {
+			output.Volumes = append(output.Volumes, volume)
+		}
+	}
+	return output, nil
+}
+
+func (f *FakeEC2) DescribeNetworkInterfaces(input *ec2.DescribeNetworkInterfacesInput) (*ec2.DescribeNetworkInterfacesOutput, error) {
+	f.logCall(input)
+
+	output := &ec2.DescribeNetworkInterfacesOutput{}
+	for _, networkInterface := range f.DetachedNetworkInterfaces {
+		if _, exists := removeString(f.NetworkInterfaces, *networkInterface.NetworkInterfaceId); !exists {
+			continue
+		}
+		if matchesFilters(input.Filters, map[string][]string{
+			"vpc-id":  {aws.StringValue(networkInterface.VpcId)},
+			"status":  {"available"},
+			"tag-key": tagKeys(networkInterface.TagSet),
+		}) {
+			output.NetworkInterfaces = append(output.NetworkInterfaces, networkInterface)
+		}
+	}
+	return output, nil
+}
+
+func (f *FakeEC2) TerminateInstances(input *ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error) {
+	f.logCall(input)
+
+	for _, instanceID := range input.InstanceIds {
+		f.InstanceStates[*instanceID] = "terminated"
+	}
+	return &ec2.TerminateInstancesOutput{}, nil
+}
+
+func (f *FakeEC2) DeleteVolume(input *ec2.DeleteVolumeInput) (*ec2.DeleteVolumeOutput, error) {
+	f.logCall(input)
+
+	volumeID := aws.StringValue(input.VolumeId)
+	remaining, found := removeString(f.Volumes, volumeID)
+	if !found {
+		return nil, &awsfaker.ErrorResponse{
+			HTTPStatusCode:  http.StatusBadRequest,
+			AWSErrorCode:    "InvalidVolume.NotFound",
+			AWSErrorMessage: fmt.Sprintf("The volume '%s' does not exist.", volumeID),
+		}
+	}
+	f.Volumes = remaining
+	return &ec2.DeleteVolumeOutput{}, nil
+}
+
+func (f *FakeEC2) DeleteNetworkInterface(input *ec2.DeleteNetworkInterfaceInput) (*ec2.DeleteNetworkInterfaceOutput, error) {
+	f.logCall(input)
+
+	networkInterfaceID := aws.StringValue(input.NetworkInterfaceId)
+	remaining, found := removeString(f.NetworkInterfaces, networkInterfaceID)
+	if !found {
+		return nil, &awsfaker.ErrorResponse{
+			HTTPStatusCode:  http.StatusBadRequest,
+			AWSErrorCode:    "InvalidNetworkInterfaceID.NotFound",
+			AWSErrorMessage: fmt.Sprintf("The networkInterface ID '%s' does not exist", networkInterfaceID),
+		}
+	}
+	f.NetworkInterfaces = remaining
+	return &ec2.DeleteNetworkInterfaceOutput{}, nil
+}
+
+func removeString(values []string, value string) ([]string, bool) {
+	remaining := []string{}
+	for _, v := range values {
+		if v != value {
+			remaining = append(remaining, v)
+		}
+	}
+	return remaining, len(remaining) < len(values)
+}
+
+func (f *FakeEC2) DescribeAddresses(input *ec2.DescribeAddressesInput) (*ec2.DescribeAddressesOutput, error) {
+	f.logCall(input)
+	return &ec2.DescribeAddressesOutput{
+		Addresses: f.Addresses,
+	}, nil
+}
+
+func (f *FakeEC2) ReleaseAddress(input *ec2.ReleaseAddressInput) (*ec2.ReleaseAddressOutput, error) {
+	f.logCall(input)
+
+	remaining := []*ec2.Address{}
+	for _, address := range f.Addresses {
+		if aws.StringValue(address.AllocationId) != aws.StringValue(input.AllocationId) {
+			remaining = append(remaining, address)
+		}
+	}
+	if len(remaining) == len(f.Addresses) {
+		return nil, aws_enemy.EC2{}.ReleaseAddress_NotFoundError(aws.StringValue(input.AllocationId))
+	}
+	f.Addresses = remaining
+
+	return &ec2.ReleaseAddressOutput{}, nil
+}
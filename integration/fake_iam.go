@@ -22,6 +22,9 @@ func NewFakeIAM(logger *AWSCallLogger) *FakeIAM {
 func (f *FakeIAM) CreateAccessKey(input *iam.CreateAccessKeyInput) (*iam.CreateAccessKeyOutput, error) {
 	f.logCall(input)
 
+	userName := aws.StringValue(input.UserName)
+	f.AccessKeys[userName] = append(f.AccessKeys[userName], "some-access-key")
+
 	return &iam.CreateAccessKeyOutput{
 		AccessKey: &iam.AccessKey{
 			AccessKeyId:     aws.String("some-access-key"),
@@ -33,17 +36,26 @@ func (f *FakeIAM) CreateAccessKey(input *iam.CreateAccessKeyInput) (*iam.CreateA
 func (f *FakeIAM) DeleteAccessKey(input *iam.DeleteAccessKeyInput) (*iam.DeleteAccessKeyOutput, error) {
 	f.logCall(input)
 
+	userName := aws.StringValue(input.UserName)
+	remaining := []string{}
+	for _, accessKey := range f.AccessKeys[userName] {
+		if accessKey != aws.StringValue(input.AccessKeyId) {
+			remaining = append(remaining, accessKey)
+		}
+	}
+	f.AccessKeys[userName] = remaining
+
 	return &iam.DeleteAccessKeyOutput{}, nil
 }
 
 func (f *FakeIAM) ListAccessKeys(input *iam.ListAccessKeysInput) (*iam.ListAccessKeysOutput, error) {
 	f.logCall(input)
 
-	return &iam.ListAccessKeysOutput{
-		AccessKeyMetadata: []*iam.AccessKeyMetadata{
-			&iam.AccessKeyMetadata{
-				AccessKeyId: aws.String("some-iam-user-access-key"),
-			},
-		},
-	}, nil
+	output := &iam.ListAccessKeysOutput{}
+	for _, accessKey := range f.AccessKeys[aws.StringValue(input.UserName)] {
+		output.AccessKeyMetadata = append(output.AccessKeyMetadata, &iam.AccessKeyMetadata{
+			AccessKeyId: aws.String(accessKey),
+		})
+	}
+	return output, nil
 }
@@ -0,0 +1,123 @@
+package integration
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FakeS3 is a stand-in for S3, speaking just enough of its REST API, with
+// path-style buckets, for the remote state store
+type FakeS3 struct {
+	*AWSCallLogger
+
+	mutex   sync.Mutex
+	Objects map[string][]byte
+}
+
+func NewFakeS3(logger *AWSCallLogger) *FakeS3 {
+	return &FakeS3{
+		AWSCallLogger: logger,
+
+		Objects: map[string][]byte{},
+	}
+}
+
+func (f *FakeS3) log(action string) {
+	(*log.Logger)(f.AWSCallLogger).Printf("s3.%s", action)
+}
+
+type s3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string
+	Message string
+}
+
+type s3ListBucketResult struct {
+	XMLName     xml.Name `xml:"ListBucketResult"`
+	Name        string
+	Prefix      string
+	IsTruncated bool
+	Contents    []s3Object
+}
+
+type s3Object struct {
+	Key  string
+	Size int
+}
+
+func writeXML(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xmlBytes, _ := xml.Marshal(body)
+	w.Write(xmlBytes)
+}
+
+// Get returns an object's contents, by bucket/key
+func (f *FakeS3) Get(objectPath string) ([]byte, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	value, ok := f.Objects[objectPath]
+	return value, ok
+}
+
+func (f *FakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	objectPath := strings.TrimPrefix(r.URL.Path, "/")
+	parts := strings.SplitN(objectPath, "/", 2)
+	bucket := parts[0]
+
+	if len(parts) == 1 || parts[1] == "" {
+		if r.Method != "GET" {
+			writeXML(w, http.StatusNotImplemented, s3Error{Code: "NotImplemented", Message: r.Method + " on a bucket"})
+			return
+		}
+		f.log("ListObjects")
+		prefix := r.URL.Query().Get("prefix")
+		result := s3ListBucketResult{Name: bucket, Prefix: prefix}
+		keys := []string{}
+		for key := range f.Objects {
+			if strings.HasPrefix(key, bucket+"/"+prefix) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			result.Contents = append(result.Contents, s3Object{Key: strings.TrimPrefix(key, bucket+"/"), Size: len(f.Objects[key])})
+		}
+		writeXML(w, http.StatusOK, result)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		f.log("GetObject")
+		value, ok := f.Objects[objectPath]
+		if !ok {
+			writeXML(w, http.StatusNotFound, s3Error{Code: "NoSuchKey", Message: "The specified key does not exist."})
+			return
+		}
+		w.Write(value)
+	case "PUT":
+		f.log("PutObject")
+		value, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeXML(w, http.StatusBadRequest, s3Error{Code: "IncompleteBody", Message: err.Error()})
+			return
+		}
+		f.Objects[objectPath] = value
+		w.WriteHeader(http.StatusOK)
+	case "DELETE":
+		f.log("DeleteObject")
+		delete(f.Objects, objectPath)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeXML(w, http.StatusNotImplemented, s3Error{Code: "NotImplemented", Message: r.Method})
+	}
+}
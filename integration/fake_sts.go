@@ -0,0 +1,32 @@
+package integration
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+type FakeSTS struct {
+	*AWSCallLogger
+
+	AssumedRoles []string
+}
+
+func NewFakeSTS(logger *AWSCallLogger) *FakeSTS {
+	return &FakeSTS{
+		AWSCallLogger: logger,
+	}
+}
+
+func (f *FakeSTS) AssumeRole(input *sts.AssumeRoleInput) (*sts.AssumeRoleOutput, error) {
+	f.logCall(input)
+
+	f.AssumedRoles = append(f.AssumedRoles, aws.StringValue(input.RoleArn))
+
+	return &sts.AssumeRoleOutput{
+		Credentials: &sts.Credentials{
+			AccessKeyId:     aws.String("some-temporary-access-key"),
+			SecretAccessKey: aws.String("some-temporary-secret-key"),
+			SessionToken:    aws.String("some-session-token"),
+		},
+	}, nil
+}
@@ -0,0 +1,182 @@
+package integration_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+
+	"github.com/rosenhouse/tubes/integration"
+)
+
+var _ = Describe("GC action", func() {
+	var (
+		keptName   string
+		goneName   string
+		workingDir string
+		fakeAWS    *integration.FakeAWS
+		start      func(args ...string) *gexec.Session
+	)
+
+	const NormalTimeout = "5s"
+
+	tubesStack := func(environment, role, status string) *cloudformation.Stack {
+		return &cloudformation.Stack{
+			StackName:   aws.String(environment + "-" + role),
+			StackId:     aws.String(fmt.Sprintf("%x", rand.Int31())),
+			StackStatus: aws.String(status),
+			Tags: []*cloudformation.Tag{
+				&cloudformation.Tag{Key: aws.String("tubes:environment"), Value: aws.String(environment)},
+				&cloudformation.Tag{Key: aws.String("tubes:role"), Value: aws.String(role)},
+			},
+		}
+	}
+
+	BeforeEach(func() {
+		suffix := fmt.Sprintf("%x", rand.Int())
+		keptName = "tubes-kept-" + suffix
+		goneName = "tubes-gone-" + suffix
+
+		var err error
+		workingDir, err = ioutil.TempDir("", "tubes-acceptance-test")
+		Expect(err).NotTo(HaveOccurred())
+		stateDir := filepath.Join(workingDir, "environments", keptName)
+		Expect(os.MkdirAll(stateDir, 0700)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(stateDir, "ssh-key"), []byte("some-ssh-key"), 0600)).To(Succeed())
+
+		logger := integration.NewAWSCallLogger(GinkgoWriter)
+		fakeAWS = integration.NewFakeAWS(logger)
+
+		fakeAWS.CloudFormation.Stacks = []*cloudformation.Stack{
+			tubesStack(keptName, "base", "CREATE_COMPLETE"),
+			tubesStack(goneName, "base", "DELETE_FAILED"),
+			tubesStack(goneName, "concourse", "DELETE_FAILED"),
+		}
+		fakeAWS.IAM.AccessKeys["some-iam-user"] = []string{"some-access-key"}
+		fakeAWS.EC2.KeyPairs[keptName] = "some-key"
+		fakeAWS.EC2.KeyPairs[goneName] = "some-key"
+		fakeAWS.EC2.KeyPairs["not-from-tubes"] = "some-key"
+		fakeAWS.EC2.Addresses = []*ec2.Address{
+			&ec2.Address{
+				AllocationId: aws.String("eipalloc-kept"),
+				PublicIp:     aws.String("1.1.1.1"),
+				Tags: []*ec2.Tag{
+					&ec2.Tag{Key: aws.String("tubes:environment"), Value: aws.String(keptName)},
+				},
+			},
+			&ec2.Address{
+				AllocationId: aws.String("eipalloc-gone"),
+				PublicIp:     aws.String("2.2.2.2"),
+				Tags: []*ec2.Tag{
+					&ec2.Tag{Key: aws.String("tubes:environment"), Value: aws.String(goneName)},
+				},
+			},
+		}
+
+		start = buildStarter(&workingDir, map[string]string{
+			"AWS_DEFAULT_REGION":    "us-west-2",
+			"AWS_ACCESS_KEY_ID":     "some-access-key-id",
+			"AWS_SECRET_ACCESS_KEY": "some-secret-access-key",
+			"TUBES_AWS_ENDPOINTS":   fakeAWS.EndpointOverridesEnvVar(),
+		})
+	})
+
+	AfterEach(func() {
+		fakeAWS.Close()
+	})
+
+	It("should report and delete the resources of the gone environment", func() {
+		session := start("gc", "--yes")
+
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+		Expect(session.Out).To(gbytes.Say(`access key\s+some-access-key\s+` + goneName))
+		Expect(session.Out).To(gbytes.Say(`elastic IP\s+2.2.2.2\s+` + goneName))
+		Expect(session.Out).To(gbytes.Say(`key pair\s+` + goneName))
+		Expect(session.Out).To(gbytes.Say(`stack\s+` + goneName + `-concourse\s+` + goneName + `\s+DELETE_FAILED`))
+		Expect(session.Out).To(gbytes.Say(`stack\s+` + goneName + `-base\s+` + goneName + `\s+DELETE_FAILED`))
+		Expect(session.Err).To(gbytes.Say("Finished"))
+
+		Expect(fakeAWS.IAM.AccessKeys["some-iam-user"]).To(BeEmpty())
+		Expect(fakeAWS.EC2.Addresses).To(HaveLen(1))
+		Expect(*fakeAWS.EC2.Addresses[0].AllocationId).To(Equal("eipalloc-kept"))
+		Expect(fakeAWS.EC2.KeyPairs).NotTo(HaveKey(goneName))
+		Expect(*fakeAWS.CloudFormation.Stacks[1].StackStatus).To(Equal("DELETE_COMPLETE"))
+		Expect(*fakeAWS.CloudFormation.Stacks[2].StackStatus).To(Equal("DELETE_COMPLETE"))
+	})
+
+	It("should leave alone the environment with a state directory, and resources tubes didn't name", func() {
+		session := start("gc", "--yes")
+
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+		Expect(session.Out).NotTo(gbytes.Say(keptName))
+		Expect(fakeAWS.EC2.KeyPairs).To(HaveKey(keptName))
+		Expect(fakeAWS.EC2.KeyPairs).To(HaveKey("not-from-tubes"))
+		Expect(*fakeAWS.CloudFormation.Stacks[0].StackStatus).To(Equal("CREATE_COMPLETE"))
+	})
+
+	It("should clean up the key pair of an environment whose stacks are already deleted", func() {
+		fakeAWS.CloudFormation.Stacks = append(fakeAWS.CloudFormation.Stacks, tubesStack("tubes-old", "base", "DELETE_COMPLETE"))
+		fakeAWS.EC2.KeyPairs["tubes-old"] = "some-key"
+
+		session := start("gc", "--yes")
+
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+		Expect(session.Out).To(gbytes.Say(`key pair\s+tubes-old\s+tubes-old\s+its environment is gone`))
+		Expect(fakeAWS.EC2.KeyPairs).NotTo(HaveKey("tubes-old"))
+	})
+
+	It("should keep the key pair of a deleted stack that merely has a tubes name", func() {
+		untagged := tubesStack("not-from-tubes", "base", "DELETE_COMPLETE")
+		untagged.Tags = nil
+		fakeAWS.CloudFormation.Stacks = append(fakeAWS.CloudFormation.Stacks, untagged)
+
+		session := start("gc", "--yes")
+
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+		Expect(session.Out).NotTo(gbytes.Say("not-from-tubes"))
+		Expect(fakeAWS.EC2.KeyPairs).To(HaveKey("not-from-tubes"))
+	})
+
+	It("should find the states in the state directory, when set", func() {
+		statesDir := filepath.Join(workingDir, "elsewhere")
+		Expect(os.Rename(filepath.Join(workingDir, "environments"), statesDir)).To(Succeed())
+
+		session := start("gc", "--yes", "--state-dir", statesDir)
+
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+		Expect(session.Out).NotTo(gbytes.Say(keptName))
+		Expect(fakeAWS.EC2.KeyPairs).To(HaveKey(keptName))
+		Expect(fakeAWS.EC2.KeyPairs).NotTo(HaveKey(goneName))
+	})
+
+	It("should refuse to run while any state is locked", func() {
+		lock := []byte(`{"id":"some-id","owner":"someone","command":"up","acquired":"2016-01-02T03:04:05Z"}`)
+		Expect(ioutil.WriteFile(filepath.Join(workingDir, "environments", keptName, "tubes.lock"), lock, 0600)).To(Succeed())
+
+		session := start("gc", "--yes")
+
+		Eventually(session, NormalTimeout).Should(gexec.Exit(1))
+		Expect(session.Err).To(gbytes.Say("the state of " + keptName + " is locked by someone, running up"))
+		Expect(fakeAWS.EC2.KeyPairs).To(HaveKey(goneName))
+		Expect(*fakeAWS.CloudFormation.Stacks[1].StackStatus).To(Equal("DELETE_FAILED"))
+	})
+
+	It("should delete nothing unless confirmed", func() {
+		session := start("gc")
+
+		Eventually(session, NormalTimeout).Should(gexec.Exit(1))
+		Expect(session.Err).To(gbytes.Say("Delete these 5 resources\\? Type yes to confirm"))
+		Expect(session.Err).To(gbytes.Say("not confirmed, so nothing was deleted"))
+		Expect(fakeAWS.EC2.KeyPairs).To(HaveKey(goneName))
+		Expect(*fakeAWS.CloudFormation.Stacks[1].StackStatus).To(Equal("DELETE_FAILED"))
+	})
+})
@@ -0,0 +1,129 @@
+package integration_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+
+	"github.com/rosenhouse/tubes/integration"
+)
+
+var _ = Describe("Git-tracked state directory", func() {
+	var (
+		stackName  string
+		envVars    map[string]string
+		workingDir string
+		stateDir   string
+		fakeAWS    *integration.FakeAWS
+		start      func(args ...string) *gexec.Session
+
+		manifestServer *httptest.Server
+		boshIOServer   *httptest.Server
+	)
+
+	const NormalTimeout = "5s"
+
+	git := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = workingDir
+		output, err := cmd.CombinedOutput()
+		Expect(err).NotTo(HaveOccurred(), string(output))
+		return string(output)
+	}
+
+	BeforeEach(func() {
+		stackName = fmt.Sprintf("tubes-acceptance-test-%x", rand.Int())
+		var err error
+		workingDir, err = ioutil.TempDir("", "tubes-acceptance-test")
+		Expect(err).NotTo(HaveOccurred())
+		stateDir = filepath.Join(workingDir, "environments", stackName)
+
+		logger := integration.NewAWSCallLogger(GinkgoWriter)
+		fakeAWS = integration.NewFakeAWS(logger)
+
+		concourseManifestTemplate, err := ioutil.ReadFile("fixtures/concourse-template.yml")
+		Expect(err).NotTo(HaveOccurred())
+		manifestServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(concourseManifestTemplate)
+		}))
+
+		boshIOServer = httptest.NewServer(&integration.FakeBoshIO{})
+
+		envVars = map[string]string{
+			"AWS_DEFAULT_REGION":                    "us-west-2",
+			"AWS_ACCESS_KEY_ID":                     "some-access-key-id",
+			"AWS_SECRET_ACCESS_KEY":                 "some-secret-access-key",
+			"TUBES_AWS_ENDPOINTS":                   fakeAWS.EndpointOverridesEnvVar(),
+			"TUBES_CONCOURSE_MANIFEST_TEMPLATE_URL": manifestServer.URL + "/concourse-template.yml",
+			"TUBES_BOSH_IO_URL":                     boshIOServer.URL,
+			"PATH":                                  os.Getenv("PATH"),
+		}
+
+		start = buildStarter(&workingDir, envVars)
+
+		git("init", "--quiet")
+		git("config", "user.name", "some-user")
+		git("config", "user.email", "some-user@example.com")
+
+		session := start("-n", stackName, "--git", "up")
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+	})
+
+	AfterEach(func() {
+		fakeAWS.Close()
+
+		if manifestServer != nil {
+			manifestServer.Close()
+		}
+
+		if boshIOServer != nil {
+			boshIOServer.Close()
+		}
+	})
+
+	It("should commit every change that up makes", func() {
+		Expect(git("status", "--porcelain")).To(BeEmpty())
+
+		log := git("log", "--format=%s")
+		Expect(log).To(ContainSubstring("tubes up: set ssh-key\n"))
+		Expect(log).To(ContainSubstring("tubes up: set director.yml\n"))
+	})
+
+	It("should list and diff past states", func() {
+		session := start("-n", stackName, "--git", "history")
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+		Expect(session.Out).To(gbytes.Say(`[0-9a-f]+ .* tubes up: set `))
+
+		firstRevision := strings.TrimSpace(git("rev-list", "--max-parents=0", "HEAD"))
+		session = start("-n", stackName, "--git", "history", "--diff", firstRevision)
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+		Expect(session.Out).To(gbytes.Say(`\+\+\+ b/environments/` + stackName + `/`))
+	})
+
+	Context("when the state directory has uncommitted changes", func() {
+		BeforeEach(func() {
+			Expect(ioutil.WriteFile(filepath.Join(stateDir, "stray"), []byte("stray"), 0600)).To(Succeed())
+		})
+
+		It("should refuse to run, unless forced", func() {
+			session := start("-n", stackName, "--git", "rotate-credentials")
+			Eventually(session, NormalTimeout).Should(gexec.Exit(1))
+			Expect(session.Err).To(gbytes.Say("uncommitted changes"))
+
+			session = start("-n", stackName, "--git", "--force", "rotate-credentials")
+			Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+			Expect(git("log", "-1", "--format=%s")).To(HavePrefix("tubes rotate-credentials: set "))
+		})
+	})
+})
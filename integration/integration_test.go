@@ -111,6 +111,7 @@ var _ = Describe("Basic workflow", func() {
 			Eventually(session.Err, NormalTimeout).Should(gbytes.Say("Deleting base stack"))
 			Eventually(session.Err, NormalTimeout).Should(gbytes.Say("Delete complete"))
 			Eventually(session.Err, NormalTimeout).Should(gbytes.Say("Deleting keypair"))
+			Eventually(session.Err, NormalTimeout).Should(gbytes.Say("Cleaning up the state directory"))
 			Eventually(session.Err, NormalTimeout).Should(gbytes.Say("Finished"))
 			Eventually(session, NormalTimeout).Should(gexec.Exit(0))
 		})
@@ -122,7 +123,7 @@ var _ = Describe("Basic workflow", func() {
 			It("should print a useful error", func() {
 				session := start([]string{}...)
 				Eventually(session, ErrTimeout).Should(gexec.Exit(1))
-				Expect(session.Err.Contents()).To(ContainSubstring("specify one command of: down, show or up"))
+				Expect(session.Err.Contents()).To(ContainSubstring("specify one command of: deploy-director, down, drift, force-unlock, gc, history, list, lock, plan, rotate-credentials, show, status, unlock or up"))
 			})
 		})
 
@@ -131,7 +132,7 @@ var _ = Describe("Basic workflow", func() {
 				session := start("-n", stackName, "nonsense_action")
 				Eventually(session, ErrTimeout).Should(gexec.Exit(1))
 				Expect(session.Err.Contents()).To(ContainSubstring("Unknown command"))
-				Expect(session.Err.Contents()).To(ContainSubstring("specify one command of: down, show or up"))
+				Expect(session.Err.Contents()).To(ContainSubstring("specify one command of: deploy-director, down, drift, force-unlock, gc, history, list, lock, plan, rotate-credentials, show, status, unlock or up"))
 			})
 		})
 	})
@@ -0,0 +1,131 @@
+package integration_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go/aws"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+
+	"github.com/rosenhouse/tubes/integration"
+)
+
+var _ = Describe("Interrupting up", func() {
+	var (
+		stackName  string
+		workingDir string
+		fakeAWS    *integration.FakeAWS
+		start      func(args ...string) *gexec.Session
+
+		manifestServer *httptest.Server
+		boshIOServer   *httptest.Server
+	)
+
+	const NormalTimeout = "5s"
+
+	statePath := func(key string) string {
+		return filepath.Join(workingDir, "environments", stackName, key)
+	}
+
+	BeforeEach(func() {
+		stackName = fmt.Sprintf("tubes-acceptance-test-%x", rand.Int())
+		var err error
+		workingDir, err = ioutil.TempDir("", "tubes-acceptance-test")
+		Expect(err).NotTo(HaveOccurred())
+
+		logger := integration.NewAWSCallLogger(GinkgoWriter)
+		fakeAWS = integration.NewFakeAWS(logger)
+		fakeAWS.CloudFormation.CreateStatus = "CREATE_IN_PROGRESS"
+
+		concourseManifestTemplate, err := ioutil.ReadFile("fixtures/concourse-template.yml")
+		Expect(err).NotTo(HaveOccurred())
+		manifestServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(concourseManifestTemplate)
+		}))
+
+		boshIOServer = httptest.NewServer(&integration.FakeBoshIO{})
+
+		start = buildStarter(&workingDir, map[string]string{
+			"AWS_DEFAULT_REGION":                    "us-west-2",
+			"AWS_ACCESS_KEY_ID":                     "some-access-key-id",
+			"AWS_SECRET_ACCESS_KEY":                 "some-secret-access-key",
+			"TUBES_AWS_ENDPOINTS":                   fakeAWS.EndpointOverridesEnvVar(),
+			"TUBES_CONCOURSE_MANIFEST_TEMPLATE_URL": manifestServer.URL + "/concourse-template.yml",
+			"TUBES_BOSH_IO_URL":                     boshIOServer.URL,
+		})
+	})
+
+	AfterEach(func() {
+		fakeAWS.Close()
+
+		if manifestServer != nil {
+			manifestServer.Close()
+		}
+
+		if boshIOServer != nil {
+			boshIOServer.Close()
+		}
+	})
+
+	interruptWhileWaiting := func() *gexec.Session {
+		session := start("-n", stackName, "up")
+		Eventually(session.Err, NormalTimeout).Should(gbytes.Say("Upserting base stack"))
+
+		session.Interrupt()
+		Eventually(session, NormalTimeout).Should(gexec.Exit(130))
+		return session
+	}
+
+	It("should stop waiting on the stack right away, and save a checkpoint", func() {
+		session := interruptWhileWaiting()
+
+		Expect(session.Err).To(gbytes.Say("stopping at the next safe point"))
+		Expect(session.Err).To(gbytes.Say("Interrupted while waiting for the base stack.  Saved a checkpoint to the state directory; run up again to carry on."))
+		Expect(session.Err).To(gbytes.Say(`stopped waiting for stack "` + stackName + `-base" at status "CREATE_IN_PROGRESS": context canceled`))
+
+		checkpoint, err := ioutil.ReadFile(statePath("checkpoint.yml"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(checkpoint)).To(ContainSubstring("step: waiting for the base stack"))
+	})
+
+	It("should release the state lock", func() {
+		interruptWhileWaiting()
+
+		_, err := os.Stat(statePath("tubes.lock"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("should release the state lock when interrupted twice", func() {
+		session := start("-n", stackName, "up")
+		Eventually(session.Err, NormalTimeout).Should(gbytes.Say("Upserting base stack"))
+
+		session.Interrupt()
+		session.Interrupt()
+		Eventually(session, NormalTimeout).Should(gexec.Exit(130))
+
+		_, err := os.Stat(statePath("tubes.lock"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("should carry on from there when up runs again", func() {
+		interruptWhileWaiting()
+		fakeAWS.CloudFormation.Stacks[0].StackStatus = aws.String("CREATE_COMPLETE")
+		fakeAWS.CloudFormation.CreateStatus = ""
+
+		session := start("-n", stackName, "up")
+
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+		Expect(session.Err).To(gbytes.Say("Resuming after up was interrupted while waiting for the base stack"))
+		Expect(session.Err).To(gbytes.Say("Finished"))
+		_, err := os.Stat(statePath("checkpoint.yml"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+})
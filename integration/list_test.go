@@ -0,0 +1,104 @@
+package integration_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+
+	"github.com/rosenhouse/tubes/integration"
+)
+
+var _ = Describe("List action", func() {
+	var (
+		stackName  string
+		envVars    map[string]string
+		workingDir string
+		fakeAWS    *integration.FakeAWS
+		start      func(args ...string) *gexec.Session
+
+		manifestServer *httptest.Server
+		boshIOServer   *httptest.Server
+	)
+
+	const NormalTimeout = "5s"
+
+	BeforeEach(func() {
+		stackName = fmt.Sprintf("tubes-acceptance-test-%x", rand.Int())
+		var err error
+		workingDir, err = ioutil.TempDir("", "tubes-acceptance-test")
+		Expect(err).NotTo(HaveOccurred())
+
+		logger := integration.NewAWSCallLogger(GinkgoWriter)
+		fakeAWS = integration.NewFakeAWS(logger)
+
+		concourseManifestTemplate, err := ioutil.ReadFile("fixtures/concourse-template.yml")
+		Expect(err).NotTo(HaveOccurred())
+		manifestServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(concourseManifestTemplate)
+		}))
+
+		boshIOServer = httptest.NewServer(&integration.FakeBoshIO{})
+
+		envVars = map[string]string{
+			"AWS_DEFAULT_REGION":                    "us-west-2",
+			"AWS_ACCESS_KEY_ID":                     "some-access-key-id",
+			"AWS_SECRET_ACCESS_KEY":                 "some-secret-access-key",
+			"TUBES_AWS_ENDPOINTS":                   fakeAWS.EndpointOverridesEnvVar(),
+			"TUBES_CONCOURSE_MANIFEST_TEMPLATE_URL": manifestServer.URL + "/concourse-template.yml",
+			"TUBES_BOSH_IO_URL":                     boshIOServer.URL,
+		}
+
+		start = buildStarter(&workingDir, envVars)
+
+		session := start("-n", stackName, "up")
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+	})
+
+	AfterEach(func() {
+		fakeAWS.Close()
+
+		if manifestServer != nil {
+			manifestServer.Close()
+		}
+
+		if boshIOServer != nil {
+			boshIOServer.Close()
+		}
+	})
+
+	It("should tag the stacks with their environment and role", func() {
+		Expect(fakeAWS.CloudFormation.Stacks[0].Tags).To(ContainElement(
+			&cloudformation.Tag{Key: aws.String("tubes:environment"), Value: aws.String(stackName)}))
+		Expect(fakeAWS.CloudFormation.Stacks[0].Tags).To(ContainElement(
+			&cloudformation.Tag{Key: aws.String("tubes:role"), Value: aws.String("base")}))
+		Expect(fakeAWS.CloudFormation.Stacks[1].Tags).To(ContainElement(
+			&cloudformation.Tag{Key: aws.String("tubes:role"), Value: aws.String("concourse")}))
+	})
+
+	It("should list local environments next to their stacks, and flag stacks without local state", func() {
+		ourWorkingDir := workingDir
+		var err error
+		workingDir, err = ioutil.TempDir("", "tubes-acceptance-test")
+		Expect(err).NotTo(HaveOccurred())
+		orphanName := stackName + "-elsewhere"
+		session := start("-n", orphanName, "up")
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+		workingDir = ourWorkingDir
+
+		session = start("list")
+
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+		Expect(session.Out).To(gbytes.Say(`ENVIRONMENT\s+STATE\s+BASE STACK\s+CONCOURSE STACK\s+OWNER`))
+		Expect(session.Out).To(gbytes.Say(stackName + `\s+local\s+CREATE_COMPLETE\s+CREATE_COMPLETE\s+\S+@\S+\s*\n`))
+		Expect(session.Out).To(gbytes.Say(orphanName + `\s+-\s+CREATE_COMPLETE\s+CREATE_COMPLETE\s+\S+@\S+\s+orphan: no state\n`))
+	})
+})
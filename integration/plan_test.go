@@ -0,0 +1,123 @@
+package integration_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+
+	"github.com/rosenhouse/tubes/integration"
+)
+
+var _ = Describe("Plan action", func() {
+	var (
+		stackName  string
+		envVars    map[string]string
+		workingDir string
+		fakeAWS    *integration.FakeAWS
+		start      func(args ...string) *gexec.Session
+
+		manifestServer *httptest.Server
+		boshIOServer   *httptest.Server
+	)
+
+	const NormalTimeout = "5s"
+
+	BeforeEach(func() {
+		stackName = fmt.Sprintf("tubes-acceptance-test-%x", rand.Int())
+		var err error
+		workingDir, err = ioutil.TempDir("", "tubes-acceptance-test")
+		Expect(err).NotTo(HaveOccurred())
+
+		logger := integration.NewAWSCallLogger(GinkgoWriter)
+		fakeAWS = integration.NewFakeAWS(logger)
+
+		concourseManifestTemplate, err := ioutil.ReadFile("fixtures/concourse-template.yml")
+		Expect(err).NotTo(HaveOccurred())
+		manifestServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(concourseManifestTemplate)
+		}))
+
+		boshIOServer = httptest.NewServer(&integration.FakeBoshIO{})
+
+		envVars = map[string]string{
+			"AWS_DEFAULT_REGION":                    "us-west-2",
+			"AWS_ACCESS_KEY_ID":                     "some-access-key-id",
+			"AWS_SECRET_ACCESS_KEY":                 "some-secret-access-key",
+			"TUBES_AWS_ENDPOINTS":                   fakeAWS.EndpointOverridesEnvVar(),
+			"TUBES_CONCOURSE_MANIFEST_TEMPLATE_URL": manifestServer.URL + "/concourse-template.yml",
+			"TUBES_BOSH_IO_URL":                     boshIOServer.URL,
+		}
+
+		start = buildStarter(&workingDir, envVars)
+	})
+
+	AfterEach(func() {
+		fakeAWS.Close()
+
+		if manifestServer != nil {
+			manifestServer.Close()
+		}
+
+		if boshIOServer != nil {
+			boshIOServer.Close()
+		}
+	})
+
+	Context("when the environment does not exist", func() {
+		It("should show that every resource would be added, without creating anything", func() {
+			session := start("-n", stackName, "plan")
+			Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+
+			Expect(session.Out).To(gbytes.Say(stackName + `-base \(create\)`))
+			Expect(session.Out).To(gbytes.Say(`KeyName: ` + stackName))
+			Expect(session.Out).To(gbytes.Say(`Add +VPC \(AWS::EC2::VPC\)`))
+			Expect(session.Out).To(gbytes.Say(stackName + `-concourse \(create\)`))
+			Expect(session.Out).To(gbytes.Say(`Add +LoadBalancer`))
+
+			Expect(fakeAWS.CloudFormation.Stacks).To(BeEmpty())
+			Expect(fakeAWS.EC2.KeyPairs).To(BeEmpty())
+		})
+	})
+
+	Context("when the environment is up to date", func() {
+		BeforeEach(func() {
+			session := start("-n", stackName, "up")
+			Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+		})
+
+		It("should show no changes and leave no change sets behind", func() {
+			session := start("-n", stackName, "plan")
+			Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+
+			Expect(session.Out).To(gbytes.Say(stackName + `-base \(update\)`))
+			Expect(session.Out).To(gbytes.Say(`No changes`))
+			Expect(session.Out).To(gbytes.Say(stackName + `-concourse \(update\)`))
+			Expect(session.Out).To(gbytes.Say(`VPCID: some-vpc-id`))
+			Expect(session.Out).To(gbytes.Say(`No changes`))
+
+			Expect(fakeAWS.CloudFormation.ChangeSets).To(BeEmpty())
+		})
+
+		It("should clear away a change set left behind by a plan that crashed", func() {
+			stackID := *fakeAWS.CloudFormation.Stacks[0].StackId
+			fakeAWS.CloudFormation.ChangeSets[stackID+"/tubes-plan"] = &cloudformation.DescribeChangeSetOutput{
+				Status: aws.String("CREATE_COMPLETE"),
+			}
+
+			session := start("-n", stackName, "plan")
+			Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+
+			Expect(session.Out).To(gbytes.Say(`No changes`))
+			Expect(fakeAWS.CloudFormation.ChangeSets).To(BeEmpty())
+		})
+	})
+})
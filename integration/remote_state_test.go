@@ -0,0 +1,123 @@
+package integration_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+
+	"github.com/rosenhouse/tubes/integration"
+)
+
+var _ = Describe("Remote state in S3", func() {
+	var (
+		stackName  string
+		envVars    map[string]string
+		workingDir string
+		stateURL   string
+		statePath  string
+		fakeAWS    *integration.FakeAWS
+		start      func(args ...string) *gexec.Session
+
+		manifestServer *httptest.Server
+		boshIOServer   *httptest.Server
+	)
+
+	const NormalTimeout = "5s"
+
+	BeforeEach(func() {
+		stackName = fmt.Sprintf("tubes-acceptance-test-%x", rand.Int())
+		var err error
+		workingDir, err = ioutil.TempDir("", "tubes-acceptance-test")
+		Expect(err).NotTo(HaveOccurred())
+		statePath = "some-bucket/environments/" + stackName
+		stateURL = "s3://" + statePath
+
+		logger := integration.NewAWSCallLogger(GinkgoWriter)
+		fakeAWS = integration.NewFakeAWS(logger)
+
+		concourseManifestTemplate, err := ioutil.ReadFile("fixtures/concourse-template.yml")
+		Expect(err).NotTo(HaveOccurred())
+		manifestServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(concourseManifestTemplate)
+		}))
+
+		boshIOServer = httptest.NewServer(&integration.FakeBoshIO{})
+
+		envVars = map[string]string{
+			"AWS_DEFAULT_REGION":                    "us-west-2",
+			"AWS_ACCESS_KEY_ID":                     "some-access-key-id",
+			"AWS_SECRET_ACCESS_KEY":                 "some-secret-access-key",
+			"TUBES_AWS_ENDPOINTS":                   fakeAWS.EndpointOverridesEnvVar(),
+			"TUBES_CONCOURSE_MANIFEST_TEMPLATE_URL": manifestServer.URL + "/concourse-template.yml",
+			"TUBES_BOSH_IO_URL":                     boshIOServer.URL,
+			"TUBES_LOCK_SETTLE_DELAY":               "10ms",
+		}
+
+		start = buildStarter(&workingDir, envVars)
+
+		session := start("-n", stackName, "--state-url", stateURL, "up")
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+	})
+
+	AfterEach(func() {
+		fakeAWS.Close()
+
+		if manifestServer != nil {
+			manifestServer.Close()
+		}
+
+		if boshIOServer != nil {
+			boshIOServer.Close()
+		}
+	})
+
+	It("should keep the state in S3, and none locally", func() {
+		_, err := os.Stat(filepath.Join(workingDir, "environments"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+
+		for _, key := range []string{"ssh-key", "director.yml", "bosh-password", "up-options.yml"} {
+			_, ok := fakeAWS.S3.Get(statePath + "/" + key)
+			Expect(ok).To(BeTrue(), key)
+		}
+
+		session := start("-n", stackName, "--state-url", stateURL, "show", "--bosh-ip")
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+		Expect(session.Out.Contents()).To(Equal([]byte("192.168.12.13")))
+	})
+
+	It("should release the lock when the command finishes", func() {
+		_, ok := fakeAWS.S3.Get(statePath + "/tubes.lock")
+		Expect(ok).To(BeFalse())
+	})
+
+	Context("when another run holds the lock", func() {
+		BeforeEach(func() {
+			fakeAWS.S3.Objects[statePath+"/tubes.lock"] = []byte(`{"id":"some-id","owner":"someone@elsewhere (pid 1)","command":"up","acquired":"2016-05-01T00:00:00Z"}`)
+		})
+
+		It("should refuse to change the state, until the lock is forced open", func() {
+			session := start("-n", stackName, "--state-url", stateURL, "rotate-credentials")
+			Eventually(session, NormalTimeout).Should(gexec.Exit(1))
+			Expect(session.Err).To(gbytes.Say(`locked by someone@elsewhere \(pid 1\), running up since 2016-05-01T00:00:00Z`))
+
+			session = start("-n", stackName, "--state-url", stateURL, "show", "--bosh-ip")
+			Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+
+			session = start("-n", stackName, "--state-url", stateURL, "force-unlock")
+			Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+			Expect(session.Err).To(gbytes.Say("Removed the state lock held by someone@elsewhere"))
+
+			session = start("-n", stackName, "--state-url", stateURL, "rotate-credentials")
+			Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+		})
+	})
+})
@@ -0,0 +1,103 @@
+package integration_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+
+	"github.com/rosenhouse/tubes/integration"
+)
+
+var _ = Describe("Rotate credentials action", func() {
+	var (
+		stackName  string
+		envVars    map[string]string
+		workingDir string
+		stateDir   string
+		fakeAWS    *integration.FakeAWS
+		start      func(args ...string) *gexec.Session
+
+		manifestServer *httptest.Server
+		boshIOServer   *httptest.Server
+	)
+
+	const NormalTimeout = "5s"
+
+	BeforeEach(func() {
+		stackName = fmt.Sprintf("tubes-acceptance-test-%x", rand.Int())
+		var err error
+		workingDir, err = ioutil.TempDir("", "tubes-acceptance-test")
+		Expect(err).NotTo(HaveOccurred())
+		stateDir = filepath.Join(workingDir, "environments", stackName)
+
+		logger := integration.NewAWSCallLogger(GinkgoWriter)
+		fakeAWS = integration.NewFakeAWS(logger)
+
+		concourseManifestTemplate, err := ioutil.ReadFile("fixtures/concourse-template.yml")
+		Expect(err).NotTo(HaveOccurred())
+		manifestServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(concourseManifestTemplate)
+		}))
+
+		boshIOServer = httptest.NewServer(&integration.FakeBoshIO{})
+
+		envVars = map[string]string{
+			"AWS_DEFAULT_REGION":                    "us-west-2",
+			"AWS_ACCESS_KEY_ID":                     "some-access-key-id",
+			"AWS_SECRET_ACCESS_KEY":                 "some-secret-access-key",
+			"TUBES_AWS_ENDPOINTS":                   fakeAWS.EndpointOverridesEnvVar(),
+			"TUBES_CONCOURSE_MANIFEST_TEMPLATE_URL": manifestServer.URL + "/concourse-template.yml",
+			"TUBES_BOSH_IO_URL":                     boshIOServer.URL,
+		}
+
+		start = buildStarter(&workingDir, envVars)
+
+		session := start("-n", stackName, "up")
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+	})
+
+	AfterEach(func() {
+		fakeAWS.Close()
+
+		if manifestServer != nil {
+			manifestServer.Close()
+		}
+
+		if boshIOServer != nil {
+			boshIOServer.Close()
+		}
+	})
+
+	It("should change the admin password, and say that the director needs a redeploy", func() {
+		oldPassword, err := ioutil.ReadFile(filepath.Join(stateDir, "bosh-password"))
+		Expect(err).NotTo(HaveOccurred())
+
+		session := start("-n", stackName, "rotate-credentials", "--only", "admin")
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+		Expect(session.Out).To(gbytes.Say("Rotated:\n  admin\n"))
+		Expect(session.Out).To(gbytes.Say("Needs a redeploy:\n  director, for admin.  Run: tubes -n " + stackName + " deploy-director"))
+
+		newPassword, err := ioutil.ReadFile(filepath.Join(stateDir, "bosh-password"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(newPassword).To(HaveLen(12))
+		Expect(newPassword).NotTo(Equal(oldPassword))
+
+		manifest, err := ioutil.ReadFile(filepath.Join(stateDir, "director.yml"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(manifest)).To(ContainSubstring(string(newPassword)))
+	})
+
+	It("should refuse to rotate credentials that every VM's agent holds", func() {
+		session := start("-n", stackName, "rotate-credentials", "--only", "nats")
+		Eventually(session, NormalTimeout).Should(gexec.Exit(1))
+		Expect(session.Err).To(gbytes.Say("nats can't be rotated live"))
+	})
+})
@@ -2,6 +2,7 @@ package integration_test
 
 import (
 	"crypto/x509"
+	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"io/ioutil"
@@ -126,6 +127,62 @@ var _ = Describe("Show action", func() {
 		Expect(env).To(HaveKey("BOSH_PASSWORD"))
 	})
 
+	It("should expose the IAM policy of the director user, for review", func() {
+		session := start("-n", stackName, "show", "--iam-policy")
+
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+
+		var policy map[string]interface{}
+		Expect(json.Unmarshal(session.Out.Contents(), &policy)).To(Succeed())
+		Expect(policy).To(HaveKeyWithValue("Version", "2012-10-17"))
+		Expect(string(session.Out.Contents())).To(ContainSubstring("ec2:RunInstances"))
+		Expect(string(session.Out.Contents())).NotTo(ContainSubstring("AdministratorAccess"))
+		Expect(string(session.Out.Contents())).To(ContainSubstring(":vpc/some-vpc-id"))
+		Expect(string(session.Out.Contents())).NotTo(ContainSubstring("Fn::Join"))
+		Expect(string(session.Out.Contents())).To(HaveSuffix("}\n"))
+	})
+
+	It("should print every value and the live stack resources as JSON, without secrets", func() {
+		session := start("-n", stackName, "show", "--json")
+
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+
+		var result struct {
+			Name   string
+			State  map[string]string
+			Stacks map[string]map[string]string
+		}
+		Expect(json.Unmarshal(session.Out.Contents(), &result)).To(Succeed())
+		Expect(result.Name).To(Equal(stackName))
+		Expect(result.State).To(HaveKeyWithValue("bosh-ip", "192.168.12.13"))
+		Expect(result.State).To(HaveKey("up-options.yml"))
+		Expect(result.State).NotTo(HaveKey("bosh-password"))
+		Expect(result.State).NotTo(HaveKey("ssh-key"))
+		Expect(result.Stacks["base"]).To(HaveKey("BOSHSubnet"))
+		Expect(result.Stacks["concourse"]).To(HaveKey("LoadBalancer"))
+
+		session = start("-n", stackName, "show", "--json", "--secrets")
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+		Expect(json.Unmarshal(session.Out.Contents(), &result)).To(Succeed())
+		Expect(result.State).To(HaveKey("bosh-password"))
+	})
+
+	It("should use the other flags to select what the JSON has", func() {
+		session := start("-n", stackName, "show", "--json", "--bosh-ip", "--bosh-password")
+
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+
+		var result struct {
+			State  map[string]string
+			Stacks map[string]map[string]string
+		}
+		Expect(json.Unmarshal(session.Out.Contents(), &result)).To(Succeed())
+		Expect(result.State).To(HaveLen(2))
+		Expect(result.State).To(HaveKeyWithValue("bosh-ip", "192.168.12.13"))
+		Expect(result.State["bosh-password"]).To(HaveLen(12))
+		Expect(result.Stacks).To(BeEmpty())
+	})
+
 	It("should support an explicit state directory, rather than the implicit subdirectory of the working directory", func() {
 		defaultStateDir := filepath.Join(workingDir, "environments", stackName)
 		session := start("-n", stackName, "--state-dir", defaultStateDir, "show", "--ssh")
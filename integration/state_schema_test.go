@@ -0,0 +1,113 @@
+package integration_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+
+	"github.com/rosenhouse/tubes/integration"
+	. "github.com/rosenhouse/tubes/lib/matchers"
+)
+
+var _ = Describe("State schema versioning", func() {
+	var (
+		stackName  string
+		envVars    map[string]string
+		workingDir string
+		stateDir   string
+		fakeAWS    *integration.FakeAWS
+		start      func(args ...string) *gexec.Session
+
+		manifestServer *httptest.Server
+		boshIOServer   *httptest.Server
+	)
+
+	const NormalTimeout = "5s"
+
+	BeforeEach(func() {
+		stackName = fmt.Sprintf("tubes-acceptance-test-%x", rand.Int())
+		var err error
+		workingDir, err = ioutil.TempDir("", "tubes-acceptance-test")
+		Expect(err).NotTo(HaveOccurred())
+		stateDir = filepath.Join(workingDir, "environments", stackName)
+
+		logger := integration.NewAWSCallLogger(GinkgoWriter)
+		fakeAWS = integration.NewFakeAWS(logger)
+
+		concourseManifestTemplate, err := ioutil.ReadFile("fixtures/concourse-template.yml")
+		Expect(err).NotTo(HaveOccurred())
+		manifestServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(concourseManifestTemplate)
+		}))
+
+		boshIOServer = httptest.NewServer(&integration.FakeBoshIO{})
+
+		envVars = map[string]string{
+			"AWS_DEFAULT_REGION":                    "us-west-2",
+			"AWS_ACCESS_KEY_ID":                     "some-access-key-id",
+			"AWS_SECRET_ACCESS_KEY":                 "some-secret-access-key",
+			"TUBES_AWS_ENDPOINTS":                   fakeAWS.EndpointOverridesEnvVar(),
+			"TUBES_CONCOURSE_MANIFEST_TEMPLATE_URL": manifestServer.URL + "/concourse-template.yml",
+			"TUBES_BOSH_IO_URL":                     boshIOServer.URL,
+		}
+
+		start = buildStarter(&workingDir, envVars)
+
+		session := start("-n", stackName, "up")
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+	})
+
+	AfterEach(func() {
+		fakeAWS.Close()
+
+		if manifestServer != nil {
+			manifestServer.Close()
+		}
+
+		if boshIOServer != nil {
+			boshIOServer.Close()
+		}
+	})
+
+	It("should record the schema version and the tubes version", func() {
+		Expect(ioutil.ReadFile(filepath.Join(stateDir, "tubes-state.yml"))).To(MatchYAML("schema_version: 2\ntubes_version: dev\n"))
+	})
+
+	It("should migrate a state directory from an older tubes", func() {
+		oldPassword := mustReadFile(filepath.Join(stateDir, "bosh-password"))
+		oldAccessKey := mustReadFile(filepath.Join(stateDir, "director-access-key-id"))
+
+		By("removing what older versions didn't record")
+		for _, key := range []string{"tubes-state.yml", "up-options.yml", "director-credentials.yml", "director-access-key-id", "director-secret-access-key"} {
+			Expect(os.Remove(filepath.Join(stateDir, key))).To(Succeed())
+		}
+
+		session := start("-n", stackName, "up")
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+		Expect(session.Err).To(gbytes.Say("Migrating the state directory to version 2"))
+
+		Expect(mustReadFile(filepath.Join(stateDir, "bosh-password"))).To(Equal(oldPassword))
+		Expect(mustReadFile(filepath.Join(stateDir, "director-access-key-id"))).To(Equal(oldAccessKey))
+		Expect(mustReadFile(filepath.Join(stateDir, "director-credentials.yml"))).To(ContainSubstring("admin: " + string(oldPassword)))
+		Expect(ioutil.ReadFile(filepath.Join(stateDir, "tubes-state.yml"))).To(MatchYAML("schema_version: 2\ntubes_version: dev\n"))
+	})
+
+	It("should refuse a state directory from a newer tubes", func() {
+		Expect(ioutil.WriteFile(filepath.Join(stateDir, "tubes-state.yml"), []byte("schema_version: 99\ntubes_version: 9.9.9\n"), 0600)).To(Succeed())
+
+		for _, command := range []string{"up", "show"} {
+			session := start("-n", stackName, command)
+			Eventually(session, NormalTimeout).Should(gexec.Exit(1))
+			Expect(session.Err).To(gbytes.Say("the state directory has schema version 99, from tubes 9.9.9.*Upgrade tubes"))
+		}
+	})
+})
@@ -0,0 +1,143 @@
+package integration_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+
+	"github.com/rosenhouse/tubes/application"
+	"github.com/rosenhouse/tubes/integration"
+)
+
+var _ = Describe("Status action", func() {
+	var (
+		stackName  string
+		envVars    map[string]string
+		workingDir string
+		fakeAWS    *integration.FakeAWS
+		start      func(args ...string) *gexec.Session
+
+		manifestServer *httptest.Server
+		boshIOServer   *httptest.Server
+	)
+
+	const NormalTimeout = "5s"
+
+	checks := func(session *gexec.Session) map[string]application.StatusCheck {
+		var report application.StatusReport
+		Expect(json.Unmarshal(session.Out.Contents(), &report)).To(Succeed())
+		Expect(report.Name).To(Equal(stackName))
+
+		byName := map[string]application.StatusCheck{}
+		for _, check := range report.Checks {
+			byName[check.Name] = check
+		}
+		return byName
+	}
+
+	BeforeEach(func() {
+		stackName = fmt.Sprintf("tubes-acceptance-test-%x", rand.Int())
+		var err error
+		workingDir, err = ioutil.TempDir("", "tubes-acceptance-test")
+		Expect(err).NotTo(HaveOccurred())
+
+		logger := integration.NewAWSCallLogger(GinkgoWriter)
+		fakeAWS = integration.NewFakeAWS(logger)
+
+		concourseManifestTemplate, err := ioutil.ReadFile("fixtures/concourse-template.yml")
+		Expect(err).NotTo(HaveOccurred())
+		manifestServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(concourseManifestTemplate)
+		}))
+
+		boshIOServer = httptest.NewServer(&integration.FakeBoshIO{})
+
+		envVars = map[string]string{
+			"AWS_DEFAULT_REGION":                    "us-west-2",
+			"AWS_ACCESS_KEY_ID":                     "some-access-key-id",
+			"AWS_SECRET_ACCESS_KEY":                 "some-secret-access-key",
+			"TUBES_AWS_ENDPOINTS":                   fakeAWS.EndpointOverridesEnvVar(),
+			"TUBES_CONCOURSE_MANIFEST_TEMPLATE_URL": manifestServer.URL + "/concourse-template.yml",
+			"TUBES_BOSH_IO_URL":                     boshIOServer.URL,
+			"TUBES_DIRECTOR_TIMEOUT":                "1s",
+		}
+
+		start = buildStarter(&workingDir, envVars)
+
+		session := start("-n", stackName, "up")
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+	})
+
+	AfterEach(func() {
+		fakeAWS.Close()
+
+		if manifestServer != nil {
+			manifestServer.Close()
+		}
+
+		if boshIOServer != nil {
+			boshIOServer.Close()
+		}
+	})
+
+	It("should match the stacks, instance and access keys with the state directory", func() {
+		session := start("-n", stackName, "status", "--json")
+
+		// there's no director to answer yet
+		Eventually(session, NormalTimeout).Should(gexec.Exit(1))
+
+		byName := checks(session)
+		for _, name := range []string{"base stack", "concourse stack", "nat instance", "bosh-ip", "nat-ip", "access keys"} {
+			Expect(byName[name].OK).To(BeTrue(), name+": "+byName[name].Detail)
+		}
+		Expect(byName["bosh-ip"].Detail).To(Equal("192.168.12.13"))
+		Expect(byName["director"].OK).To(BeFalse())
+		Expect(session.Err).To(gbytes.Say("the environment has drifted from the state directory"))
+	})
+
+	It("should print a table, and report instances that aren't running", func() {
+		fakeAWS.EC2.InstanceStates["some-nat-instance-id"] = "stopped"
+
+		session := start("-n", stackName, "status")
+
+		Eventually(session, NormalTimeout).Should(gexec.Exit(1))
+		Expect(session.Out).To(gbytes.Say(`CHECK\s+STATUS\s+DETAIL\n`))
+		Expect(session.Out).To(gbytes.Say(`base stack\s+ok\s+CREATE_COMPLETE\n`))
+		Expect(session.Out).To(gbytes.Say(`nat instance\s+DRIFT\s+some-nat-instance-id is stopped\n`))
+	})
+
+	It("should report NAT gateways that aren't available", func() {
+		natStackName := stackName + "-nat"
+		session := start("-n", natStackName, "up", "--nat-gateway")
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+		fakeAWS.EC2.NATGatewayStates["nat-12345"] = "failed"
+
+		session = start("-n", natStackName, "status", "--json")
+		Eventually(session, NormalTimeout).Should(gexec.Exit(1))
+
+		byName := checks(session)
+		Expect(byName["bastion instance"].OK).To(BeTrue())
+		Expect(byName["nat gateway"].OK).To(BeFalse())
+		Expect(byName["nat gateway"].Detail).To(Equal("nat-12345 is failed"))
+	})
+
+	It("should report missing stacks", func() {
+		session := start("-n", stackName, "down")
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+
+		session = start("-n", stackName, "status", "--json")
+		Eventually(session, NormalTimeout).Should(gexec.Exit(1))
+
+		byName := checks(session)
+		Expect(byName).To(HaveLen(2))
+		Expect(byName["base stack"].Detail).To(Equal("missing"))
+	})
+})
@@ -126,15 +126,38 @@ var _ = Describe("Up action dependency on state directory", func() {
 					session := start(args...)
 					Eventually(session, DefaultTimeout).Should(gexec.Exit(0))
 				})
+				Context("when the stacks already exist", func() {
+					It("should error", func() {
+						otherStateDir, err := ioutil.TempDir("", "tubes-integration-state-dir")
+						Expect(err).NotTo(HaveOccurred())
+						session := start("--state-dir", otherStateDir, "-n", stackName, "up")
+						Eventually(session, DefaultTimeout).Should(gexec.Exit(0))
+
+						session = start(args...)
+						Eventually(session, DefaultTimeout).Should(gexec.Exit(1))
+						Expect(session.Err).To(gbytes.Say("state directory is empty but stack .* already exists"))
+					})
+				})
 			})
 			Context("when the state directory is not empty", func() {
 				BeforeEach(func() {
 					Expect(ioutil.WriteFile(filepath.Join(stateDir, "anything"), nil, 0600)).To(Succeed())
 				})
-				It("should error", func() {
-					session := start(args...)
-					Eventually(session, DefaultTimeout).Should(gexec.Exit(1))
-					Expect(session.Err).To(gbytes.Say("state directory must be empty"))
+				Context("when there are no cloud resources", func() {
+					It("should create a new stack and populate the state directory", func() {
+						session := start(args...)
+						Eventually(session, DefaultTimeout).Should(gexec.Exit(0))
+						Expect(*fakeAWS.CloudFormation.Stacks[0].StackStatus).To(Equal("CREATE_COMPLETE"))
+						Expect(ioutil.ReadFile(filepath.Join(stateDir, "ssh-key"))).To(ContainSubstring("RSA PRIVATE KEY"))
+					})
+				})
+				Context("when a keypair for the environment already exists but is not in the state directory", func() {
+					It("should error", func() {
+						fakeAWS.EC2.KeyPairs[stackName] = "some-key"
+						session := start(args...)
+						Eventually(session, DefaultTimeout).Should(gexec.Exit(1))
+						Expect(session.Err).To(gbytes.Say("state directory has no ssh-key but keypair .* already exists"))
+					})
 				})
 			})
 		})
@@ -181,10 +204,10 @@ var _ = Describe("Up action dependency on state directory", func() {
 				BeforeEach(func() {
 					Expect(ioutil.WriteFile(filepath.Join(implicitStateDir, "anything"), nil, 0600)).To(Succeed())
 				})
-				It("should error", func() {
+				It("should create a new stack and populate the implied state directory", func() {
 					session := start(args...)
-					Eventually(session, DefaultTimeout).Should(gexec.Exit(1))
-					Expect(session.Err).To(gbytes.Say("state directory must be empty"))
+					Eventually(session, DefaultTimeout).Should(gexec.Exit(0))
+					Expect(ioutil.ReadFile(filepath.Join(implicitStateDir, "ssh-key"))).To(ContainSubstring("RSA PRIVATE KEY"))
 				})
 			})
 		})
@@ -1,11 +1,14 @@
 package integration_test
 
 import (
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"path/filepath"
 
 	"gopkg.in/yaml.v2"
@@ -100,6 +103,16 @@ var _ = Describe("Up action", func() {
 		By("storing the BOSH IP on the filesystem", func() {
 			Expect(ioutil.ReadFile(filepath.Join(defaultStateDir, "bosh-ip"))).To(Equal([]byte("192.168.12.13")))
 		})
+		By("storing a TLS certificate for the director, which only tubes trusts", func() {
+			certPEM, err := ioutil.ReadFile(filepath.Join(defaultStateDir, "director-cert.pem"))
+			Expect(err).NotTo(HaveOccurred())
+			block, _ := pem.Decode(certPEM)
+			Expect(block).NotTo(BeNil())
+			cert, err := x509.ParseCertificate(block.Bytes)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cert.IPAddresses[0].String()).To(Equal("192.168.12.13"))
+			Expect(ioutil.ReadFile(filepath.Join(defaultStateDir, "director.yml"))).To(ContainSubstring("BEGIN CERTIFICATE"))
+		})
 		By("storing the BOSH admin password on the filesystem", func() {
 			Expect(ioutil.ReadFile(filepath.Join(defaultStateDir, "bosh-password"))).To(HaveLen(12))
 		})
@@ -147,13 +160,119 @@ var _ = Describe("Up action", func() {
 			Expect(directorYAMLBytes).NotTo(ContainSubstring(envVars["AWS_SECRET_ACCESS_KEY"]))
 		})
 
-		// By("storing a generated cloud config in the state directory", func() {
-		// 	cloudConfigBytes, err := ioutil.ReadFile(filepath.Join(defaultStateDir, "cloud-config.yml"))
-		// 	Expect(err).NotTo(HaveOccurred())
+		By("storing a generated cloud config in the state directory", func() {
+			cloudConfigBytes, err := ioutil.ReadFile(filepath.Join(defaultStateDir, "cloud-config.yml"))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(cloudConfigBytes).To(ContainSubstring("availability_zone: some-availability-zone"))
+			Expect(cloudConfigBytes).To(ContainSubstring("subnet: subnet-concourse"))
+			Expect(cloudConfigBytes).To(ContainSubstring("- some-concourse-elb"))
+		})
+	})
+
+	It("should reconcile against existing state when run again", func() {
+		session := start("-n", stackName, "up")
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+
+		defaultStateDir := filepath.Join(workingDir, "environments", stackName)
+		sshKey, err := ioutil.ReadFile(filepath.Join(defaultStateDir, "ssh-key"))
+		Expect(err).NotTo(HaveOccurred())
+		boshPassword, err := ioutil.ReadFile(filepath.Join(defaultStateDir, "bosh-password"))
+		Expect(err).NotTo(HaveOccurred())
 
-		// 	Expect(cloudConfigBytes).To(ContainSubstring("network: concourse"))
-		// 	Expect(cloudConfigBytes).To(ContainSubstring("availability_zone: &az some-availability-zone"))
-		// })
+		session = start("-n", stackName, "up")
+		Eventually(session.Err, NormalTimeout).Should(gbytes.Say("Reusing existing keypair"))
+		Eventually(session.Err, NormalTimeout).Should(gbytes.Say("Finished"))
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+
+		Expect(fakeAWS.CloudFormation.Stacks).To(HaveLen(2))
+		Expect(fakeAWS.IAM.AccessKeys["some-iam-user"]).To(HaveLen(1))
+		Expect(ioutil.ReadFile(filepath.Join(defaultStateDir, "ssh-key"))).To(Equal(sshKey))
+		Expect(ioutil.ReadFile(filepath.Join(defaultStateDir, "bosh-password"))).To(Equal(boshPassword))
+	})
+
+	It("should keep the director off the internet when asked", func() {
+		session := start("-n", stackName, "up", "--private-director")
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+
+		Expect(fakeAWS.CloudFormation.Templates[*fakeAWS.CloudFormation.Stacks[0].StackId]).NotTo(ContainSubstring("BOSHDirectorIP"))
+
+		stateDir := filepath.Join(workingDir, "environments", stackName)
+		Expect(ioutil.ReadFile(filepath.Join(stateDir, "bosh-ip"))).To(Equal([]byte("10.1.2.6")))
+		Expect(ioutil.ReadFile(filepath.Join(stateDir, "bosh-environment"))).To(ContainSubstring(`export BOSH_ALL_PROXY="socks5://localhost:5000"`))
+		Expect(ioutil.ReadFile(filepath.Join(stateDir, "director.yml"))).NotTo(ContainSubstring("vip"))
+	})
+
+	It("should use a NAT gateway and a bastion when asked, and still tear down cleanly", func() {
+		session := start("-n", stackName, "up", "--nat-gateway")
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+
+		baseTemplate := fakeAWS.CloudFormation.Templates[*fakeAWS.CloudFormation.Stacks[0].StackId]
+		Expect(baseTemplate).To(ContainSubstring("AWS::EC2::NatGateway"))
+		Expect(fakeAWS.CloudFormation.Stacks[1].Parameters).To(ContainElement(&cloudformation.Parameter{
+			ParameterKey:   aws.String("NATGateway"),
+			ParameterValue: aws.String("nat-12345"),
+		}))
+
+		stateDir := filepath.Join(workingDir, "environments", stackName)
+		Expect(ioutil.ReadFile(filepath.Join(stateDir, "nat-ip"))).To(Equal([]byte("some-bastion-elastic-ip")))
+
+		session = start("-n", stackName, "down")
+		Eventually(session.Err, NormalTimeout).Should(gbytes.Say("Finished"))
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+		Expect(*fakeAWS.CloudFormation.Stacks[0].StackStatus).To(Equal("DELETE_COMPLETE"))
+		Expect(*fakeAWS.CloudFormation.Stacks[1].StackStatus).To(Equal("DELETE_COMPLETE"))
+	})
+
+	It("should spread Concourse across availability zones when asked", func() {
+		session := start("-n", stackName, "up", "--availability-zones", "2")
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+
+		Expect(fakeAWS.CloudFormation.Templates[*fakeAWS.CloudFormation.Stacks[0].StackId]).To(ContainSubstring("PublicSubnetZ2"))
+		Expect(fakeAWS.CloudFormation.Stacks[1].Parameters).To(ContainElement(&cloudformation.Parameter{
+			ParameterKey:   aws.String("AvailabilityZoneZ2"),
+			ParameterValue: aws.String("some-availability-zone-2"),
+		}))
+		Expect(fakeAWS.CloudFormation.Stacks[1].Parameters).To(ContainElement(&cloudformation.Parameter{
+			ParameterKey:   aws.String("PubliclyRoutableSubnetIDZ2"),
+			ParameterValue: aws.String("subnet-public-z2"),
+		}))
+		Expect(fakeAWS.CloudFormation.Stacks[1].Parameters).To(ContainElement(&cloudformation.Parameter{
+			ParameterKey:   aws.String("NATInstanceZ2"),
+			ParameterValue: aws.String("some-nat-instance-id-z2"),
+		}))
+
+		stateDir := filepath.Join(workingDir, "environments", stackName)
+		cloudConfig, err := ioutil.ReadFile(filepath.Join(stateDir, "cloud-config.yml"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cloudConfig).To(ContainSubstring("subnet-concourse-z2"))
+		Expect(cloudConfig).To(ContainSubstring("some-availability-zone-2"))
+	})
+
+	It("should give the director an instance profile instead of access keys when asked", func() {
+		session := start("-n", stackName, "up", "--instance-profile")
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+
+		baseTemplate := fakeAWS.CloudFormation.Templates[*fakeAWS.CloudFormation.Stacks[0].StackId]
+		Expect(baseTemplate).To(ContainSubstring("AWS::IAM::InstanceProfile"))
+		Expect(baseTemplate).NotTo(ContainSubstring("BOSHDirectorUser"))
+		Expect(baseTemplate).NotTo(ContainSubstring("IamInstanceProfile"))
+
+		stateDir := filepath.Join(workingDir, "environments", stackName)
+		for _, key := range []string{"director-access-key-id", "director-secret-access-key"} {
+			_, err := os.Stat(filepath.Join(stateDir, key))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		}
+
+		manifest, err := ioutil.ReadFile(filepath.Join(stateDir, "director.yml"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(manifest).To(ContainSubstring("credentials_source: env_or_profile"))
+		Expect(manifest).To(ContainSubstring("iam_instance_profile: some-instance-profile"))
+		Expect(manifest).NotTo(ContainSubstring("secret_access_key"))
+
+		session = start("-n", stackName, "down")
+		Eventually(session, NormalTimeout).Should(gexec.Exit(0))
+		Expect(session.Err.Contents()).To(ContainSubstring("no access keys to delete"))
 	})
 
 	It("should create a CloudFormation stack for the BOSH director", func() {
@@ -2,24 +2,48 @@ package awsclient
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
 	"github.com/aws/aws-sdk-go/service/ec2"
 )
 
+// Zone is an availability zone of the environment, with its base stack
+// subnets, and the NAT instance or NAT gateway that its private subnets use
+type Zone struct {
+	AvailabilityZone string
+	PublicSubnetID   string
+	PrivateSubnetID  string
+	NATInstanceID    string
+	NATGatewayID     string
+}
+
 type BaseStackResources struct {
-	AvailabilityZone  string
-	BOSHSubnetCIDR    string
-	BOSHSubnetID      string
-	BOSHElasticIP     string
-	BOSHSecurityGroup string
-	AccountID         string
-	BOSHUser          string
-	AWSRegion         string
-	NATInstanceID     string
-	NATElasticIP      string
-	VPCID             string
+	AvailabilityZone        string
+	BOSHSubnetCIDR          string
+	BOSHSubnetID            string
+	BOSHElasticIP           string
+	BOSHSecurityGroup       string
+	AccountID               string
+	BOSHUser                string
+	DirectorInstanceProfile string
+	DirectorRole            string
+	AWSRegion               string
+	NATInstanceID           string
+	NATElasticIP            string
+	NATGatewayID            string
+	BastionInstanceID       string
+	BastionElasticIP        string
+	VPCID                   string
+
+	// Zones starts with the zone of the BOSH subnet
+	Zones []Zone
+}
+
+// DirectorRoleARN is the ARN of the director's role, when it has one
+func (r BaseStackResources) DirectorRoleARN() string {
+	return fmt.Sprintf("arn:aws:iam::%s:role/%s", r.AccountID, r.DirectorRole)
 }
 
 func (c *Client) GetBaseStackResources(stackName string) (BaseStackResources, error) {
@@ -51,36 +75,81 @@ func (c *Client) GetBaseStackResources(stackName string) (BaseStackResources, er
 	if !ok {
 		return resources, errors.New("missing stack resource BOSHSecurityGroup")
 	}
-	resources.BOSHElasticIP, ok = mapping["BOSHDirectorIP"]
-	if !ok {
-		return resources, errors.New("missing stack resource BOSHDirectorIP")
-	}
-	resources.BOSHUser, ok = mapping["BOSHDirectorUser"]
-	if !ok {
-		return resources, errors.New("missing stack resource BOSHDirectorUser")
-	}
-	resources.NATInstanceID, ok = mapping["NATInstance"]
-	if !ok {
-		return resources, errors.New("missing stack resource NATInstance")
+	// a private director has no public IP
+	resources.BOSHElasticIP = mapping["BOSHDirectorIP"]
+	// with an instance profile, the director has no IAM user
+	if instanceProfile, ok := mapping["BOSHDirectorInstanceProfile"]; ok {
+		resources.DirectorInstanceProfile = instanceProfile
+		resources.DirectorRole = mapping["BOSHDirectorRole"]
+	} else {
+		resources.BOSHUser, ok = mapping["BOSHDirectorUser"]
+		if !ok {
+			return resources, errors.New("missing stack resource BOSHDirectorUser")
+		}
 	}
-	resources.NATElasticIP, ok = mapping["NATEIP"]
-	if !ok {
-		return resources, errors.New("missing stack resource NATEIP")
+	if natGatewayID, ok := mapping["NATGateway"]; ok {
+		resources.NATGatewayID = natGatewayID
+		resources.BastionInstanceID, ok = mapping["BastionInstance"]
+		if !ok {
+			return resources, errors.New("missing stack resource BastionInstance")
+		}
+		resources.BastionElasticIP, ok = mapping["BastionEIP"]
+		if !ok {
+			return resources, errors.New("missing stack resource BastionEIP")
+		}
+	} else {
+		resources.NATInstanceID, ok = mapping["NATInstance"]
+		if !ok {
+			return resources, errors.New("missing stack resource NATInstance")
+		}
+		resources.NATElasticIP, ok = mapping["NATEIP"]
+		if !ok {
+			return resources, errors.New("missing stack resource NATEIP")
+		}
+		// the NAT instance doubles as the bastion
+		resources.BastionInstanceID = resources.NATInstanceID
+		resources.BastionElasticIP = resources.NATElasticIP
 	}
 	resources.VPCID, ok = mapping["VPC"]
 	if !ok {
 		return resources, errors.New("missing stack resource VPC")
 	}
 
+	subnetIDs := []*string{}
+	for i := 0; i < MaxAvailabilityZones; i++ {
+		publicSubnetID, ok := mapping[publicSubnetName(i)]
+		if !ok {
+			break
+		}
+		resources.Zones = append(resources.Zones, Zone{
+			PublicSubnetID:  publicSubnetID,
+			PrivateSubnetID: mapping["PrivateSubnet"+ZoneSuffix(i)],
+			NATInstanceID:   mapping["NATInstance"+ZoneSuffix(i)],
+			NATGatewayID:    mapping["NATGateway"+ZoneSuffix(i)],
+		})
+		subnetIDs = append(subnetIDs, aws.String(publicSubnetID))
+	}
+
 	dsOutput, err := c.EC2.DescribeSubnets(&ec2.DescribeSubnetsInput{
-		SubnetIds: []*string{aws.String(resources.BOSHSubnetID)},
+		SubnetIds: subnetIDs,
 	})
 	if err != nil {
 		return BaseStackResources{}, err
 	}
-	subnet := *dsOutput.Subnets[0]
-	resources.AvailabilityZone = *subnet.AvailabilityZone
-	resources.BOSHSubnetCIDR = *subnet.CidrBlock
+	for i, zone := range resources.Zones {
+		for _, subnet := range dsOutput.Subnets {
+			if aws.StringValue(subnet.SubnetId) == zone.PublicSubnetID {
+				resources.Zones[i].AvailabilityZone = aws.StringValue(subnet.AvailabilityZone)
+				if i == 0 {
+					resources.BOSHSubnetCIDR = aws.StringValue(subnet.CidrBlock)
+				}
+			}
+		}
+		if resources.Zones[i].AvailabilityZone == "" {
+			return BaseStackResources{}, fmt.Errorf("subnet %q not found", zone.PublicSubnetID)
+		}
+	}
+	resources.AvailabilityZone = resources.Zones[0].AvailabilityZone
 
 	return resources, nil
 }
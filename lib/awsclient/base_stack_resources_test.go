@@ -66,6 +66,7 @@ var _ = Describe("Retrieving resource info for the base stack", func() {
 		ec2Client.DescribeSubnetsCall.Returns.Output = &ec2.DescribeSubnetsOutput{
 			Subnets: []*ec2.Subnet{
 				&ec2.Subnet{
+					SubnetId:         aws.String("subnet-12345"),
 					AvailabilityZone: aws.String("some-nat-az"),
 					CidrBlock:        aws.String("10.11.12.13/24"),
 				},
@@ -88,10 +89,114 @@ var _ = Describe("Retrieving resource info for the base stack", func() {
 			AWSRegion:         "some-region",
 			NATInstanceID:     "some-nat-box-instance-id",
 			NATElasticIP:      "some-nat-elastic-ip",
+			BastionInstanceID: "some-nat-box-instance-id",
+			BastionElasticIP:  "some-nat-elastic-ip",
 			VPCID:             "some-vpc-id",
+			Zones: []awsclient.Zone{
+				{
+					AvailabilityZone: "some-nat-az",
+					PublicSubnetID:   "subnet-12345",
+					PrivateSubnetID:  "private-subnet-ignore-this-for-now",
+					NATInstanceID:    "some-nat-box-instance-id",
+				},
+			},
 		}))
 	})
 
+	Context("when the stack spans several availability zones", func() {
+		BeforeEach(func() {
+			cloudFormationClient.DescribeStackResourcesCall.Returns.Output.StackResources = append(
+				cloudFormationClient.DescribeStackResourcesCall.Returns.Output.StackResources,
+				newResource("PublicSubnetZ2", "subnet-public-2"),
+				newResource("PrivateSubnetZ2", "subnet-private-2"),
+				newResource("NATInstanceZ2", "some-nat-box-instance-id-2"),
+			)
+			ec2Client.DescribeSubnetsCall.Returns.Output.Subnets = []*ec2.Subnet{
+				&ec2.Subnet{
+					SubnetId:         aws.String("subnet-public-2"),
+					AvailabilityZone: aws.String("some-other-az"),
+					CidrBlock:        aws.String("10.0.2.0/24"),
+				},
+				&ec2.Subnet{
+					SubnetId:         aws.String("subnet-12345"),
+					AvailabilityZone: aws.String("some-nat-az"),
+					CidrBlock:        aws.String("10.11.12.13/24"),
+				},
+			}
+		})
+
+		It("should return every zone with its subnets, starting with the BOSH subnet's", func() {
+			baseStack, err := client.GetBaseStackResources("some-stack-name")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ec2Client.DescribeSubnetsCall.Receives.Input.SubnetIds).To(Equal([]*string{
+				aws.String("subnet-12345"),
+				aws.String("subnet-public-2"),
+			}))
+			Expect(baseStack.Zones).To(Equal([]awsclient.Zone{
+				{
+					AvailabilityZone: "some-nat-az",
+					PublicSubnetID:   "subnet-12345",
+					PrivateSubnetID:  "private-subnet-ignore-this-for-now",
+					NATInstanceID:    "some-nat-box-instance-id",
+				},
+				{
+					AvailabilityZone: "some-other-az",
+					PublicSubnetID:   "subnet-public-2",
+					PrivateSubnetID:  "subnet-private-2",
+					NATInstanceID:    "some-nat-box-instance-id-2",
+				},
+			}))
+			Expect(baseStack.AvailabilityZone).To(Equal("some-nat-az"))
+			Expect(baseStack.BOSHSubnetCIDR).To(Equal("10.11.12.13/24"))
+		})
+
+		Context("when a subnet can't be described", func() {
+			It("should return an error", func() {
+				ec2Client.DescribeSubnetsCall.Returns.Output.Subnets = ec2Client.DescribeSubnetsCall.Returns.Output.Subnets[1:]
+
+				_, err := client.GetBaseStackResources("some-stack-name")
+				Expect(err).To(MatchError(`subnet "subnet-public-2" not found`))
+			})
+		})
+	})
+
+	Context("when the stack has a NAT gateway", func() {
+		BeforeEach(func() {
+			cloudFormationClient.DescribeStackResourcesCall.Returns.Output.StackResources = []*cloudformation.StackResource{
+				newResource("BOSHSecurityGroup", "sg-12345"),
+				newResource("BOSHSubnet", "subnet-12345"),
+				newResource("BOSHDirectorIP", "54.123.456.78"),
+				newResource("NATGateway", "some-nat-gateway-id"),
+				newResource("NATGatewayEIP", "some-nat-gateway-elastic-ip"),
+				newResource("BastionInstance", "some-bastion-instance-id"),
+				newResource("BastionEIP", "some-bastion-elastic-ip"),
+				newResource("BOSHDirectorUser", "some-iam-user"),
+				newResource("VPC", "some-vpc-id"),
+			}
+		})
+
+		It("should return the NAT gateway and the bastion, instead of a NAT instance", func() {
+			baseStack, err := client.GetBaseStackResources("some-stack-name")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(baseStack.NATGatewayID).To(Equal("some-nat-gateway-id"))
+			Expect(baseStack.BastionInstanceID).To(Equal("some-bastion-instance-id"))
+			Expect(baseStack.BastionElasticIP).To(Equal("some-bastion-elastic-ip"))
+			Expect(baseStack.NATInstanceID).To(BeEmpty())
+			Expect(baseStack.NATElasticIP).To(BeEmpty())
+		})
+
+		Context("when the bastion is missing", func() {
+			It("should return an error", func() {
+				cloudFormationClient.DescribeStackResourcesCall.Returns.Output.StackResources[5] = newResource("nope", "very")
+
+				_, err := client.GetBaseStackResources("some-stack-name")
+				Expect(err).To(MatchError("missing stack resource BastionInstance"))
+			})
+		})
+	})
+
 	It("should use the provided stack name to look up the stack resources", func() {
 		_, err := client.GetBaseStackResources("some-stack-name")
 		Expect(err).NotTo(HaveOccurred())
@@ -106,6 +211,38 @@ var _ = Describe("Retrieving resource info for the base stack", func() {
 		Expect(ec2Client.DescribeSubnetsCall.Receives.Input.SubnetIds).To(Equal([]*string{aws.String("subnet-12345")}))
 	})
 
+	Context("when the director is private", func() {
+		It("should leave the Elastic IP empty", func() {
+			cloudFormationClient.DescribeStackResourcesCall.Returns.Output.StackResources[2] = newResource("PrivateRouteTable", "some-route-table")
+
+			baseStack, err := client.GetBaseStackResources("some-stack-name")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(baseStack.BOSHElasticIP).To(BeEmpty())
+		})
+	})
+
+	Context("when the director has an instance profile instead of a user", func() {
+		It("should return the instance profile and its role, and no user", func() {
+			cloudFormationClient.DescribeStackResourcesCall.Returns.Output.StackResources[6] = newResource("BOSHDirectorInstanceProfile", "some-instance-profile")
+			cloudFormationClient.DescribeStackResourcesCall.Returns.Output.StackResources = append(
+				cloudFormationClient.DescribeStackResourcesCall.Returns.Output.StackResources,
+				newResource("BOSHDirectorRole", "some-role"))
+
+			baseStack, err := client.GetBaseStackResources("some-stack-name")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(baseStack.DirectorInstanceProfile).To(Equal("some-instance-profile"))
+			Expect(baseStack.DirectorRole).To(Equal("some-role"))
+			Expect(baseStack.BOSHUser).To(BeEmpty())
+		})
+
+		It("should build the role's ARN from the account ID", func() {
+			resources := awsclient.BaseStackResources{AccountID: "123456789012", DirectorRole: "some-role"}
+			Expect(resources.DirectorRoleARN()).To(Equal("arn:aws:iam::123456789012:role/some-role"))
+		})
+	})
+
 	Context("error cases", func() {
 		Context("when describing the stack resources errors", func() {
 			It("should return the error", func() {
@@ -1,6 +1,213 @@
 package awsclient
 
-import . "github.com/awslabs/aws-cfn-go-template"
+import (
+	"fmt"
+
+	. "github.com/awslabs/aws-cfn-go-template"
+)
+
+// BaseStackOptions select variants of the base stack
+type BaseStackOptions struct {
+	// PrivateDirector leaves the director without a public IP, so that it is
+	// only reachable from inside the VPC, e.g. through the NAT box
+	PrivateDirector bool
+
+	// NATGateway routes outbound traffic through a managed NAT gateway instead
+	// of the NAT instance.  A separate bastion instance is used for SSH.
+	NATGateway bool
+
+	// AvailabilityZones is the number of zones to span, with a public and a
+	// private subnet in each.  Zero means a single zone.
+	AvailabilityZones int
+
+	// InstanceProfile gives the director its AWS credentials through an IAM
+	// role and instance profile, instead of an IAM user with access keys
+	InstanceProfile bool
+}
+
+// NewBaseStackTemplate returns a copy of BaseStackTemplate, adapted to the options
+func NewBaseStackTemplate(options BaseStackOptions) Template {
+	template := BaseStackTemplate
+	template.Resources = copyResources(BaseStackTemplate.Resources)
+
+	if options.PrivateDirector {
+		delete(template.Resources, "BOSHDirectorIP")
+		template.Resources["BOSHSecurityGroup"] = withProperty(template.Resources["BOSHSecurityGroup"],
+			"SecurityGroupIngress", []Rule{
+				{
+					ToPort:     65535,
+					FromPort:   0,
+					IpProtocol: "-1",
+					CidrIp:     Ref("VPCCIDR"),
+				},
+			})
+	}
+
+	if options.NATGateway {
+		useNATGateway(&template)
+	}
+
+	if options.AvailabilityZones > 1 {
+		spanBaseStackZones(&template, options.AvailabilityZones)
+	}
+
+	if options.InstanceProfile {
+		useInstanceProfile(&template)
+	}
+
+	return template
+}
+
+// useInstanceProfile replaces the director user with a role and instance
+// profile.  Only the director VM gets the profile.  The account may assume
+// the role too, so that tubes can hand bosh-init short-lived credentials
+// for creating that VM without giving the profile to the public jumpbox.
+func useInstanceProfile(template *Template) {
+	delete(template.Resources, "BOSHDirectorUser")
+
+	template.Resources["BOSHDirectorRole"] = Resource{
+		Type: "AWS::IAM::Role",
+		Properties: map[string]interface{}{
+			"AssumeRolePolicyDocument": PolicyDocument{
+				Version: "2012-10-17",
+				Statement: []PolicyStatement{
+					{
+						Effect:    "Allow",
+						Action:    []string{"sts:AssumeRole"},
+						Principal: map[string]interface{}{"Service": []string{"ec2.amazonaws.com"}},
+					},
+					{
+						Effect:    "Allow",
+						Action:    []string{"sts:AssumeRole"},
+						Principal: map[string]interface{}{"AWS": Ref("AWS::AccountId")},
+					},
+				},
+			},
+			"Path": "/",
+		},
+	}
+	// the policy refers to the role's own ARN, so it can't be inline
+	template.Resources["BOSHDirectorPolicy"] = Resource{
+		Type: "AWS::IAM::Policy",
+		Properties: map[string]interface{}{
+			"PolicyName":     "BOSHDirector",
+			"PolicyDocument": DirectorRolePolicy,
+			"Roles":          []interface{}{Ref("BOSHDirectorRole")},
+		},
+	}
+	template.Resources["BOSHDirectorInstanceProfile"] = Resource{
+		Type: "AWS::IAM::InstanceProfile",
+		Properties: map[string]interface{}{
+			"Path":  "/",
+			"Roles": []interface{}{Ref("BOSHDirectorRole")},
+		},
+	}
+}
+
+// useNATGateway replaces the NAT instance with a managed NAT gateway, and
+// with a bastion instance that takes over its other job as the jumpbox
+func useNATGateway(template *Template) {
+	template.Parameters = copyParameters(template.Parameters)
+	delete(template.Parameters, "NATInstanceType")
+	template.Parameters["BastionInstanceType"] = Parameter{
+		Type:        "String",
+		Default:     "t2.medium",
+		Description: "EC2 instance type for the bastion, which runs bosh-init",
+	}
+
+	bastion := template.Resources["NATInstance"]
+	bastion = withoutProperty(bastion, "SourceDestCheck")
+	bastion = withProperty(bastion, "InstanceType", Ref("BastionInstanceType"))
+	bastion = withProperty(bastion, "SecurityGroupIds", []interface{}{Ref("BastionSecurityGroup")})
+	bastion = withProperty(bastion, "Tags", []Tag{{Key: "Name", Value: "Bastion"}})
+	template.Resources["BastionInstance"] = bastion
+	template.Resources["BastionSecurityGroup"] = withProperty(template.Resources["NATSecurityGroup"], "GroupDescription", "Bastion")
+	template.Resources["BastionEIP"] = withProperty(template.Resources["NATEIP"], "InstanceId", Ref("BastionInstance"))
+
+	delete(template.Resources, "NATInstance")
+	delete(template.Resources, "NATSecurityGroup")
+	delete(template.Resources, "NATEIP")
+
+	template.Resources["NATGatewayEIP"] = Resource{
+		Type: "AWS::EC2::EIP",
+		Properties: map[string]interface{}{
+			"Domain": "vpc",
+		},
+		DependsOn: "VPCGatewayAttachment",
+	}
+	template.Resources["NATGateway"] = Resource{
+		Type: "AWS::EC2::NatGateway",
+		Properties: map[string]interface{}{
+			"AllocationId": getAtt("NATGatewayEIP", "AllocationId"),
+			"SubnetId":     Ref("BOSHSubnet"),
+		},
+	}
+	template.Resources["PrivateOutboundRoute"] = Resource{
+		Type: "AWS::EC2::Route",
+		Properties: map[string]interface{}{
+			"NatGatewayId":         Ref("NATGateway"),
+			"DestinationCidrBlock": "0.0.0.0/0",
+			"RouteTableId":         Ref("PrivateRouteTable"),
+		},
+		DependsOn: "NATGateway",
+	}
+}
+
+// spanBaseStackZones pins the existing subnets to the first availability zone,
+// and adds a public and a private subnet for each of the other zones.  Each
+// zone gets its own NAT and private route table, so that losing one zone
+// doesn't cut the others off from the internet.
+func spanBaseStackZones(template *Template, zones int) {
+	template.Parameters = copyParameters(template.Parameters)
+	template.Resources["BOSHSubnet"] = withProperty(template.Resources["BOSHSubnet"], "AvailabilityZone", selectAZ(0))
+	template.Resources["PrivateSubnet"] = withProperty(template.Resources["PrivateSubnet"], "AvailabilityZone", selectAZ(0))
+	_, natGateway := template.Resources["NATGateway"]
+
+	for i := 1; i < zones; i++ {
+		suffix := ZoneSuffix(i)
+		template.Parameters["PublicSubnetCIDR"+suffix] = Parameter{
+			Type:        "String",
+			Default:     defaultPublicSubnetCIDRs[i],
+			Description: fmt.Sprintf("CIDR block for the public subnet in availability zone %d.", i+1),
+		}
+		template.Parameters["PrivateSubnetCIDR"+suffix] = Parameter{
+			Type:        "String",
+			Default:     defaultPrivateSubnetCIDRs[i],
+			Description: fmt.Sprintf("CIDR block for the private subnet in availability zone %d.", i+1),
+		}
+
+		publicSubnet := publicSubnetName(i)
+		template.Resources[publicSubnet] = subnetResource("VPC", "PublicSubnetCIDR"+suffix, "Public"+suffix, selectAZ(i))
+		template.Resources[publicSubnet+"RouteTableAssociation"] = routeTableAssociation(publicSubnet, "BOSHRouteTable")
+
+		privateRouteTable := "PrivateRouteTable" + suffix
+		template.Resources[privateRouteTable] = template.Resources["PrivateRouteTable"]
+		outboundRoute := withProperty(template.Resources["PrivateOutboundRoute"], "RouteTableId", Ref(privateRouteTable))
+		if natGateway {
+			nat := "NATGateway" + suffix
+			template.Resources["NATGatewayEIP"+suffix] = template.Resources["NATGatewayEIP"]
+			template.Resources[nat] = withProperty(
+				withProperty(template.Resources["NATGateway"], "SubnetId", Ref(publicSubnet)),
+				"AllocationId", getAtt("NATGatewayEIP"+suffix, "AllocationId"))
+			outboundRoute = withProperty(outboundRoute, "NatGatewayId", Ref(nat))
+			outboundRoute.DependsOn = nat
+		} else {
+			// only the first NAT instance runs bosh-init, so the others skip its setup
+			nat := "NATInstance" + suffix
+			natInstance := withoutProperty(template.Resources["NATInstance"], "UserData")
+			natInstance = withProperty(natInstance, "SubnetId", Ref(publicSubnet))
+			template.Resources[nat] = withProperty(natInstance, "Tags", []Tag{{Key: "Name", Value: "NAT" + suffix}})
+			template.Resources["NATEIP"+suffix] = withProperty(template.Resources["NATEIP"], "InstanceId", Ref(nat))
+			outboundRoute = withProperty(outboundRoute, "InstanceId", Ref(nat))
+			outboundRoute.DependsOn = nat
+		}
+		template.Resources["PrivateOutboundRoute"+suffix] = outboundRoute
+
+		privateSubnet := "PrivateSubnet" + suffix
+		template.Resources[privateSubnet] = subnetResource("VPC", "PrivateSubnetCIDR"+suffix, "Private"+suffix, selectAZ(i))
+		template.Resources[privateSubnet+"RouteTableAssociation"] = routeTableAssociation(privateSubnet, privateRouteTable)
+	}
+}
 
 var BaseStackTemplate = Template{
 	AWSTemplateFormatVersion: "2010-09-09",
@@ -44,7 +251,12 @@ var BaseStackTemplate = Template{
 		"BOSHDirectorUser": {
 			Type: "AWS::IAM::User",
 			Properties: map[string]interface{}{
-				"ManagedPolicyArns": []string{"arn:aws:iam::aws:policy/AdministratorAccess"},
+				"Policies": []interface{}{
+					map[string]interface{}{
+						"PolicyName":     "BOSHDirector",
+						"PolicyDocument": DirectorPolicy,
+					},
+				},
 			},
 		},
 		"NATSecurityGroup": {
@@ -1,6 +1,8 @@
 package awsclient_test
 
 import (
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 
 	. "github.com/onsi/ginkgo"
@@ -9,6 +11,21 @@ import (
 	"github.com/rosenhouse/tubes/lib/awsclient"
 )
 
+type parsedTemplate struct {
+	Parameters map[string]interface{}
+	Resources  map[string]struct {
+		Type       string
+		Properties map[string]interface{}
+		DependsOn  interface{}
+	}
+}
+
+func parseTemplate(asJSON string) parsedTemplate {
+	var parsed parsedTemplate
+	Expect(json.Unmarshal([]byte(asJSON), &parsed)).To(Succeed())
+	return parsed
+}
+
 var _ = Describe("Generating the base template", func() {
 	It("should match the fixture", func() {
 		asJSON := awsclient.BaseStackTemplate.String()
@@ -18,4 +35,235 @@ var _ = Describe("Generating the base template", func() {
 
 		Expect(asJSON).To(MatchJSON(expected))
 	})
+
+	Context("with the default options", func() {
+		It("should be the same as the base template", func() {
+			Expect(awsclient.NewBaseStackTemplate(awsclient.BaseStackOptions{}).String()).To(
+				MatchJSON(awsclient.BaseStackTemplate.String()))
+		})
+	})
+
+	Context("when the director is private", func() {
+		var template parsedTemplate
+
+		BeforeEach(func() {
+			template = parseTemplate(awsclient.NewBaseStackTemplate(awsclient.BaseStackOptions{PrivateDirector: true}).String())
+		})
+
+		It("should not allocate a public IP for the director", func() {
+			Expect(template.Resources).NotTo(HaveKey("BOSHDirectorIP"))
+		})
+
+		It("should only allow access to the director from inside the VPC", func() {
+			Expect(template.Resources["BOSHSecurityGroup"].Properties["SecurityGroupIngress"]).To(Equal([]interface{}{
+				map[string]interface{}{
+					"ToPort":     "65535",
+					"FromPort":   "0",
+					"IpProtocol": "-1",
+					"CidrIp":     map[string]interface{}{"Ref": "VPCCIDR"},
+				},
+			}))
+		})
+
+		It("should still allow SSH to the NAT box", func() {
+			Expect(template.Resources["NATSecurityGroup"].Properties["SecurityGroupIngress"]).To(ContainElement(
+				HaveKeyWithValue("ToPort", "22")))
+		})
+
+		It("should leave the default template unchanged", func() {
+			expected, err := ioutil.ReadFile("fixtures/base_stack_template.json")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(awsclient.BaseStackTemplate.String()).To(MatchJSON(expected))
+		})
+	})
+
+	Context("when using a NAT gateway", func() {
+		var template parsedTemplate
+
+		BeforeEach(func() {
+			template = parseTemplate(awsclient.NewBaseStackTemplate(awsclient.BaseStackOptions{NATGateway: true}).String())
+		})
+
+		It("should route the private subnet through a managed NAT gateway in the BOSH subnet", func() {
+			Expect(template.Resources["NATGateway"].Type).To(Equal("AWS::EC2::NatGateway"))
+			Expect(template.Resources["NATGateway"].Properties).To(Equal(map[string]interface{}{
+				"AllocationId": map[string]interface{}{"Fn::GetAtt": []interface{}{"NATGatewayEIP", "AllocationId"}},
+				"SubnetId":     map[string]interface{}{"Ref": "BOSHSubnet"},
+			}))
+			Expect(template.Resources["NATGatewayEIP"].Type).To(Equal("AWS::EC2::EIP"))
+
+			Expect(template.Resources["PrivateOutboundRoute"].Properties).To(Equal(map[string]interface{}{
+				"NatGatewayId":         map[string]interface{}{"Ref": "NATGateway"},
+				"DestinationCidrBlock": "0.0.0.0/0",
+				"RouteTableId":         map[string]interface{}{"Ref": "PrivateRouteTable"},
+			}))
+		})
+
+		It("should replace the NAT instance with a separately sized bastion", func() {
+			Expect(template.Resources).NotTo(HaveKey("NATInstance"))
+			Expect(template.Resources).NotTo(HaveKey("NATEIP"))
+			Expect(template.Resources).NotTo(HaveKey("NATSecurityGroup"))
+			Expect(template.Parameters).NotTo(HaveKey("NATInstanceType"))
+			Expect(template.Parameters).To(HaveKey("BastionInstanceType"))
+
+			bastion := template.Resources["BastionInstance"]
+			Expect(bastion.Type).To(Equal("AWS::EC2::Instance"))
+			Expect(bastion.Properties).NotTo(HaveKey("SourceDestCheck"))
+			Expect(bastion.Properties["InstanceType"]).To(Equal(map[string]interface{}{"Ref": "BastionInstanceType"}))
+			Expect(bastion.Properties["SecurityGroupIds"]).To(Equal([]interface{}{map[string]interface{}{"Ref": "BastionSecurityGroup"}}))
+			Expect(bastion.Properties["UserData"]).NotTo(BeNil())
+
+			Expect(template.Resources["BastionEIP"].Properties["InstanceId"]).To(Equal(map[string]interface{}{"Ref": "BastionInstance"}))
+			Expect(template.Resources["BastionSecurityGroup"].Properties["SecurityGroupIngress"]).To(ContainElement(
+				HaveKeyWithValue("ToPort", "22")))
+		})
+
+		It("should leave the default template unchanged", func() {
+			expected, err := ioutil.ReadFile("fixtures/base_stack_template.json")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(awsclient.BaseStackTemplate.String()).To(MatchJSON(expected))
+		})
+	})
+
+	Context("when spanning several availability zones", func() {
+		var template parsedTemplate
+
+		BeforeEach(func() {
+			template = parseTemplate(awsclient.NewBaseStackTemplate(awsclient.BaseStackOptions{AvailabilityZones: 3}).String())
+		})
+
+		selectAZ := func(i string) map[string]interface{} {
+			return map[string]interface{}{"Fn::Select": []interface{}{i, map[string]interface{}{"Fn::GetAZs": ""}}}
+		}
+
+		It("should pin the existing subnets to the first zone", func() {
+			Expect(template.Resources["BOSHSubnet"].Properties["AvailabilityZone"]).To(Equal(selectAZ("0")))
+			Expect(template.Resources["PrivateSubnet"].Properties["AvailabilityZone"]).To(Equal(selectAZ("0")))
+		})
+
+		It("should add a public and a private subnet in each other zone", func() {
+			for i, suffix := range []string{"Z2", "Z3"} {
+				az := selectAZ(fmt.Sprintf("%d", i+1))
+
+				Expect(template.Resources["PublicSubnet"+suffix].Type).To(Equal("AWS::EC2::Subnet"))
+				Expect(template.Resources["PublicSubnet"+suffix].Properties["AvailabilityZone"]).To(Equal(az))
+				Expect(template.Resources["PublicSubnet"+suffix].Properties["CidrBlock"]).To(Equal(map[string]interface{}{"Ref": "PublicSubnetCIDR" + suffix}))
+				Expect(template.Resources["PublicSubnet"+suffix+"RouteTableAssociation"].Properties["RouteTableId"]).To(Equal(map[string]interface{}{"Ref": "BOSHRouteTable"}))
+
+				Expect(template.Resources["PrivateSubnet"+suffix].Properties["AvailabilityZone"]).To(Equal(az))
+			}
+			Expect(template.Resources).NotTo(HaveKey("PublicSubnetZ4"))
+		})
+
+		It("should give each zone its own NAT instance and private route table", func() {
+			for _, suffix := range []string{"Z2", "Z3"} {
+				Expect(template.Resources["PrivateRouteTable"+suffix].Type).To(Equal("AWS::EC2::RouteTable"))
+				Expect(template.Resources["PrivateSubnet"+suffix+"RouteTableAssociation"].Properties["RouteTableId"]).To(Equal(map[string]interface{}{"Ref": "PrivateRouteTable" + suffix}))
+
+				nat := template.Resources["NATInstance"+suffix]
+				Expect(nat.Type).To(Equal("AWS::EC2::Instance"))
+				Expect(nat.Properties["SubnetId"]).To(Equal(map[string]interface{}{"Ref": "PublicSubnet" + suffix}))
+				Expect(nat.Properties["SourceDestCheck"]).To(Equal(false))
+				Expect(nat.Properties).NotTo(HaveKey("UserData"))
+				Expect(template.Resources["NATEIP"+suffix].Properties["InstanceId"]).To(Equal(map[string]interface{}{"Ref": "NATInstance" + suffix}))
+
+				Expect(template.Resources["PrivateOutboundRoute"+suffix].Properties).To(Equal(map[string]interface{}{
+					"InstanceId":           map[string]interface{}{"Ref": "NATInstance" + suffix},
+					"DestinationCidrBlock": "0.0.0.0/0",
+					"RouteTableId":         map[string]interface{}{"Ref": "PrivateRouteTable" + suffix},
+				}))
+			}
+			Expect(template.Resources["PrivateSubnetRouteTableAssociation"].Properties["RouteTableId"]).To(Equal(map[string]interface{}{"Ref": "PrivateRouteTable"}))
+		})
+
+		Context("when using a NAT gateway", func() {
+			BeforeEach(func() {
+				template = parseTemplate(awsclient.NewBaseStackTemplate(awsclient.BaseStackOptions{AvailabilityZones: 3, NATGateway: true}).String())
+			})
+
+			It("should give each zone its own NAT gateway and private route table", func() {
+				for _, suffix := range []string{"Z2", "Z3"} {
+					Expect(template.Resources["NATGateway"+suffix].Properties).To(Equal(map[string]interface{}{
+						"AllocationId": map[string]interface{}{"Fn::GetAtt": []interface{}{"NATGatewayEIP" + suffix, "AllocationId"}},
+						"SubnetId":     map[string]interface{}{"Ref": "PublicSubnet" + suffix},
+					}))
+					Expect(template.Resources["NATGatewayEIP"+suffix].Type).To(Equal("AWS::EC2::EIP"))
+
+					Expect(template.Resources["PrivateOutboundRoute"+suffix].Properties).To(Equal(map[string]interface{}{
+						"NatGatewayId":         map[string]interface{}{"Ref": "NATGateway" + suffix},
+						"DestinationCidrBlock": "0.0.0.0/0",
+						"RouteTableId":         map[string]interface{}{"Ref": "PrivateRouteTable" + suffix},
+					}))
+					Expect(template.Resources["PrivateSubnet"+suffix+"RouteTableAssociation"].Properties["RouteTableId"]).To(Equal(map[string]interface{}{"Ref": "PrivateRouteTable" + suffix}))
+				}
+				Expect(template.Resources).NotTo(HaveKey("NATInstanceZ2"))
+			})
+		})
+
+		It("should give each new subnet its own CIDR block", func() {
+			Expect(template.Parameters["PublicSubnetCIDRZ2"]).To(HaveKeyWithValue("Default", "10.0.2.0/24"))
+			Expect(template.Parameters["PrivateSubnetCIDRZ2"]).To(HaveKeyWithValue("Default", "10.0.3.0/24"))
+			Expect(template.Parameters["PublicSubnetCIDRZ3"]).To(HaveKeyWithValue("Default", "10.0.4.0/24"))
+			Expect(template.Parameters["PrivateSubnetCIDRZ3"]).To(HaveKeyWithValue("Default", "10.0.5.0/24"))
+		})
+
+		It("should leave the default template unchanged", func() {
+			expected, err := ioutil.ReadFile("fixtures/base_stack_template.json")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(awsclient.BaseStackTemplate.String()).To(MatchJSON(expected))
+		})
+	})
+
+	Context("when the director uses an instance profile", func() {
+		var template parsedTemplate
+
+		BeforeEach(func() {
+			template = parseTemplate(awsclient.NewBaseStackTemplate(awsclient.BaseStackOptions{InstanceProfile: true}).String())
+		})
+
+		It("should not create an IAM user for the director", func() {
+			Expect(template.Resources).NotTo(HaveKey("BOSHDirectorUser"))
+		})
+
+		It("should create a role that EC2 instances and the account can assume", func() {
+			role := template.Resources["BOSHDirectorRole"]
+			Expect(role.Type).To(Equal("AWS::IAM::Role"))
+			Expect(role.Properties["AssumeRolePolicyDocument"]).To(HaveKeyWithValue("Statement", ConsistOf(
+				HaveKeyWithValue("Principal", map[string]interface{}{"Service": []interface{}{"ec2.amazonaws.com"}}),
+				HaveKeyWithValue("Principal", map[string]interface{}{"AWS": map[string]interface{}{"Ref": "AWS::AccountId"}}),
+			)))
+		})
+
+		It("should attach the director policy to the role, letting it pass only itself", func() {
+			policy := template.Resources["BOSHDirectorPolicy"]
+			Expect(policy.Type).To(Equal("AWS::IAM::Policy"))
+			Expect(policy.Properties["Roles"]).To(Equal([]interface{}{map[string]interface{}{"Ref": "BOSHDirectorRole"}}))
+
+			var expected interface{}
+			Expect(json.Unmarshal([]byte(awsclient.DirectorRolePolicy.String()), &expected)).To(Succeed())
+			Expect(policy.Properties["PolicyDocument"]).To(Equal(expected))
+		})
+
+		It("should create an instance profile for the role", func() {
+			profile := template.Resources["BOSHDirectorInstanceProfile"]
+			Expect(profile.Type).To(Equal("AWS::IAM::InstanceProfile"))
+			Expect(profile.Properties["Roles"]).To(Equal([]interface{}{map[string]interface{}{"Ref": "BOSHDirectorRole"}}))
+		})
+
+		It("should not give the profile to the NAT box, which is reachable from the internet", func() {
+			Expect(template.Resources["NATInstance"].Properties).NotTo(HaveKey("IamInstanceProfile"))
+			Expect(template.Resources["NATInstance"].DependsOn).To(BeNil())
+		})
+
+		Context("when using a NAT gateway", func() {
+			It("should not give the profile to the bastion either", func() {
+				template = parseTemplate(awsclient.NewBaseStackTemplate(awsclient.BaseStackOptions{InstanceProfile: true, NATGateway: true}).String())
+
+				Expect(template.Resources["BastionInstance"].Properties).NotTo(HaveKey("IamInstanceProfile"))
+			})
+		})
+	})
 })
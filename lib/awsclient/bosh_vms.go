@@ -0,0 +1,223 @@
+package awsclient
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"golang.org/x/net/context"
+)
+
+// BOSHVMs are the instances a BOSH director created in a VPC, with the volumes
+// and network interfaces that outlive them.  CloudFormation doesn't know about
+// any of them, so they keep the VPC from deleting.
+type BOSHVMs struct {
+	InstanceIDs         []string
+	VolumeIDs           []string
+	NetworkInterfaceIDs []string
+}
+
+// BOSH tags every VM it creates with the name of its director, and bosh-init
+// does the same for the director VM itself
+const boshDirectorTag = "director"
+
+// ListBOSHVMs finds the instances in the VPC that are tagged by a BOSH director
+// and not yet terminated, and the volumes and network interfaces the named
+// director tagged that are left detached, e.g. by an earlier down
+func (c *Client) ListBOSHVMs(vpcID, directorName string) (BOSHVMs, error) {
+	vms, err := c.listBOSHInstances(vpcID)
+	if err != nil {
+		return BOSHVMs{}, err
+	}
+
+	volumeIDs, err := c.listDetachedBOSHVolumes(vpcID, directorName)
+	if err != nil {
+		return BOSHVMs{}, err
+	}
+	vms.VolumeIDs = append(vms.VolumeIDs, volumeIDs...)
+
+	networkInterfaceIDs, err := c.listDetachedBOSHNetworkInterfaces(vpcID)
+	if err != nil {
+		return BOSHVMs{}, err
+	}
+	vms.NetworkInterfaceIDs = append(vms.NetworkInterfaceIDs, networkInterfaceIDs...)
+	return vms, nil
+}
+
+func (c *Client) listBOSHInstances(vpcID string) (BOSHVMs, error) {
+	vms := BOSHVMs{}
+	input := &ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			&ec2.Filter{Name: aws.String("vpc-id"), Values: []*string{aws.String(vpcID)}},
+			&ec2.Filter{Name: aws.String("tag-key"), Values: []*string{aws.String(boshDirectorTag)}},
+			&ec2.Filter{Name: aws.String("instance-state-name"), Values: aws.StringSlice([]string{"pending", "running", "stopping", "stopped"})},
+		},
+	}
+
+	for {
+		output, err := c.EC2.DescribeInstances(input)
+		if err != nil {
+			return BOSHVMs{}, err
+		}
+		for _, reservation := range output.Reservations {
+			for _, instance := range reservation.Instances {
+				vms.InstanceIDs = append(vms.InstanceIDs, aws.StringValue(instance.InstanceId))
+				for _, mapping := range instance.BlockDeviceMappings {
+					if mapping.Ebs != nil && !aws.BoolValue(mapping.Ebs.DeleteOnTermination) {
+						vms.VolumeIDs = append(vms.VolumeIDs, aws.StringValue(mapping.Ebs.VolumeId))
+					}
+				}
+				for _, networkInterface := range instance.NetworkInterfaces {
+					if networkInterface.Attachment != nil && !aws.BoolValue(networkInterface.Attachment.DeleteOnTermination) {
+						vms.NetworkInterfaceIDs = append(vms.NetworkInterfaceIDs, aws.StringValue(networkInterface.NetworkInterfaceId))
+					}
+				}
+			}
+		}
+		if output.NextToken == nil {
+			return vms, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// listDetachedBOSHVolumes finds the available volumes tagged by the named
+// director.  Volumes don't belong to a VPC, so they are looked for in the
+// availability zones of its subnets, which other environments share too.
+func (c *Client) listDetachedBOSHVolumes(vpcID, directorName string) ([]string, error) {
+	subnets, err := c.EC2.DescribeSubnets(&ec2.DescribeSubnetsInput{
+		Filters: []*ec2.Filter{
+			&ec2.Filter{Name: aws.String("vpc-id"), Values: []*string{aws.String(vpcID)}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	availabilityZones := []*string{}
+	seen := map[string]bool{}
+	for _, subnet := range subnets.Subnets {
+		availabilityZone := aws.StringValue(subnet.AvailabilityZone)
+		if !seen[availabilityZone] {
+			seen[availabilityZone] = true
+			availabilityZones = append(availabilityZones, subnet.AvailabilityZone)
+		}
+	}
+	if len(availabilityZones) == 0 {
+		return nil, nil
+	}
+
+	volumeIDs := []string{}
+	input := &ec2.DescribeVolumesInput{
+		Filters: []*ec2.Filter{
+			&ec2.Filter{Name: aws.String("availability-zone"), Values: availabilityZones},
+			&ec2.Filter{Name: aws.String("tag:" + boshDirectorTag), Values: []*string{aws.String(directorName)}},
+			&ec2.Filter{Name: aws.String("status"), Values: []*string{aws.String("available")}},
+		},
+	}
+	for {
+		output, err := c.EC2.DescribeVolumes(input)
+		if err != nil {
+			return nil, err
+		}
+		for _, volume := range output.Volumes {
+			volumeIDs = append(volumeIDs, aws.StringValue(volume.VolumeId))
+		}
+		if output.NextToken == nil {
+			return volumeIDs, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// listDetachedBOSHNetworkInterfaces finds the available network interfaces
+// in the VPC that are tagged by a director
+func (c *Client) listDetachedBOSHNetworkInterfaces(vpcID string) ([]string, error) {
+	output, err := c.EC2.DescribeNetworkInterfaces(&ec2.DescribeNetworkInterfacesInput{
+		Filters: []*ec2.Filter{
+			&ec2.Filter{Name: aws.String("vpc-id"), Values: []*string{aws.String(vpcID)}},
+			&ec2.Filter{Name: aws.String("tag-key"), Values: []*string{aws.String(boshDirectorTag)}},
+			&ec2.Filter{Name: aws.String("status"), Values: []*string{aws.String("available")}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	networkInterfaceIDs := []string{}
+	for _, networkInterface := range output.NetworkInterfaces {
+		networkInterfaceIDs = append(networkInterfaceIDs, aws.StringValue(networkInterface.NetworkInterfaceId))
+	}
+	return networkInterfaceIDs, nil
+}
+
+// DeleteBOSHVMs terminates the instances, waits until they are gone, then
+// deletes the volumes and network interfaces they leave behind
+func (c *Client) DeleteBOSHVMs(ctx context.Context, vms BOSHVMs) error {
+	if len(vms.InstanceIDs) > 0 {
+		_, err := c.EC2.TerminateInstances(&ec2.TerminateInstancesInput{
+			InstanceIds: aws.StringSlice(vms.InstanceIDs),
+		})
+		if err != nil {
+			return err
+		}
+
+		err = c.waitForTermination(ctx, vms.InstanceIDs)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, volumeID := range vms.VolumeIDs {
+		_, err := c.EC2.DeleteVolume(&ec2.DeleteVolumeInput{
+			VolumeId: aws.String(volumeID),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, networkInterfaceID := range vms.NetworkInterfaceIDs {
+		_, err := c.EC2.DeleteNetworkInterface(&ec2.DeleteNetworkInterfaceInput{
+			NetworkInterfaceId: aws.String(networkInterfaceID),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) waitForTermination(ctx context.Context, instanceIDs []string) error {
+	const sleepDuration = 5 * time.Second
+	elapsed := 0 * time.Second
+
+	for {
+		output, err := c.EC2.DescribeInstances(&ec2.DescribeInstancesInput{
+			InstanceIds: aws.StringSlice(instanceIDs),
+		})
+		if err != nil {
+			return err
+		}
+
+		remaining := 0
+		for _, reservation := range output.Reservations {
+			for _, instance := range reservation.Instances {
+				if instance.State == nil || aws.StringValue(instance.State.Name) != "terminated" {
+					remaining++
+				}
+			}
+		}
+		if remaining == 0 {
+			return nil
+		}
+
+		if elapsed >= c.CloudFormationWaitTimeout {
+			return fmt.Errorf("timed out waiting for %d instances to terminate (max %s)", remaining, elapsed)
+		}
+		if err := c.Clock.Sleep(ctx, sleepDuration); err != nil {
+			return err
+		}
+		elapsed += sleepDuration
+	}
+}
@@ -0,0 +1,268 @@
+package awsclient_test
+
+import (
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rosenhouse/tubes/lib/awsclient"
+	"github.com/rosenhouse/tubes/mocks"
+	"golang.org/x/net/context"
+)
+
+var _ = Describe("BOSH VM operations", func() {
+	var (
+		client    awsclient.Client
+		ec2Client *mocks.EC2Client
+		clock     *mocks.Clock
+	)
+
+	BeforeEach(func() {
+		ec2Client = &mocks.EC2Client{}
+		clock = &mocks.Clock{}
+		client = awsclient.Client{
+			EC2:                       ec2Client,
+			Clock:                     clock,
+			CloudFormationWaitTimeout: 10 * time.Second,
+		}
+	})
+
+	Describe("ListBOSHVMs", func() {
+		BeforeEach(func() {
+			ec2Client.DescribeInstancesCall.Returns.Output = &ec2.DescribeInstancesOutput{
+				Reservations: []*ec2.Reservation{
+					&ec2.Reservation{
+						Instances: []*ec2.Instance{
+							&ec2.Instance{
+								InstanceId: aws.String("i-director"),
+								BlockDeviceMappings: []*ec2.InstanceBlockDeviceMapping{
+									&ec2.InstanceBlockDeviceMapping{
+										Ebs: &ec2.EbsInstanceBlockDevice{VolumeId: aws.String("vol-root"), DeleteOnTermination: aws.Bool(true)},
+									},
+									&ec2.InstanceBlockDeviceMapping{
+										Ebs: &ec2.EbsInstanceBlockDevice{VolumeId: aws.String("vol-persistent"), DeleteOnTermination: aws.Bool(false)},
+									},
+								},
+								NetworkInterfaces: []*ec2.InstanceNetworkInterface{
+									&ec2.InstanceNetworkInterface{
+										NetworkInterfaceId: aws.String("eni-primary"),
+										Attachment:         &ec2.InstanceNetworkInterfaceAttachment{DeleteOnTermination: aws.Bool(true)},
+									},
+									&ec2.InstanceNetworkInterface{
+										NetworkInterfaceId: aws.String("eni-extra"),
+										Attachment:         &ec2.InstanceNetworkInterfaceAttachment{DeleteOnTermination: aws.Bool(false)},
+									},
+								},
+							},
+						},
+					},
+					&ec2.Reservation{
+						Instances: []*ec2.Instance{
+							&ec2.Instance{InstanceId: aws.String("i-web")},
+						},
+					},
+				},
+			}
+			ec2Client.DescribeSubnetsCall.Returns.Output = &ec2.DescribeSubnetsOutput{
+				Subnets: []*ec2.Subnet{
+					&ec2.Subnet{AvailabilityZone: aws.String("some-zone")},
+					&ec2.Subnet{AvailabilityZone: aws.String("some-other-zone")},
+					&ec2.Subnet{AvailabilityZone: aws.String("some-zone")},
+				},
+			}
+			ec2Client.DescribeVolumesCall.Returns.Output = &ec2.DescribeVolumesOutput{
+				Volumes: []*ec2.Volume{
+					&ec2.Volume{VolumeId: aws.String("vol-detached")},
+				},
+			}
+			ec2Client.DescribeNetworkInterfacesCall.Returns.Output = &ec2.DescribeNetworkInterfacesOutput{
+				NetworkInterfaces: []*ec2.NetworkInterface{
+					&ec2.NetworkInterface{NetworkInterfaceId: aws.String("eni-detached")},
+				},
+			}
+		})
+
+		It("should filter for instances in the VPC that a director tagged and that aren't terminated", func() {
+			_, err := client.ListBOSHVMs("some-vpc-id", "some-director")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ec2Client.DescribeInstancesCall.Receives.Input.Filters).To(Equal([]*ec2.Filter{
+				&ec2.Filter{Name: aws.String("vpc-id"), Values: []*string{aws.String("some-vpc-id")}},
+				&ec2.Filter{Name: aws.String("tag-key"), Values: []*string{aws.String("director")}},
+				&ec2.Filter{Name: aws.String("instance-state-name"), Values: aws.StringSlice([]string{"pending", "running", "stopping", "stopped"})},
+			}))
+		})
+
+		It("should filter for detached volumes that the named director tagged, in the zones of the VPC's subnets", func() {
+			_, err := client.ListBOSHVMs("some-vpc-id", "some-director")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ec2Client.DescribeSubnetsCall.Receives.Input.Filters).To(Equal([]*ec2.Filter{
+				&ec2.Filter{Name: aws.String("vpc-id"), Values: []*string{aws.String("some-vpc-id")}},
+			}))
+			Expect(ec2Client.DescribeVolumesCall.Receives.Input.Filters).To(Equal([]*ec2.Filter{
+				&ec2.Filter{Name: aws.String("availability-zone"), Values: aws.StringSlice([]string{"some-zone", "some-other-zone"})},
+				&ec2.Filter{Name: aws.String("tag:director"), Values: []*string{aws.String("some-director")}},
+				&ec2.Filter{Name: aws.String("status"), Values: []*string{aws.String("available")}},
+			}))
+		})
+
+		It("should filter for detached network interfaces in the VPC that a director tagged", func() {
+			_, err := client.ListBOSHVMs("some-vpc-id", "some-director")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ec2Client.DescribeNetworkInterfacesCall.Receives.Input.Filters).To(Equal([]*ec2.Filter{
+				&ec2.Filter{Name: aws.String("vpc-id"), Values: []*string{aws.String("some-vpc-id")}},
+				&ec2.Filter{Name: aws.String("tag-key"), Values: []*string{aws.String("director")}},
+				&ec2.Filter{Name: aws.String("status"), Values: []*string{aws.String("available")}},
+			}))
+		})
+
+		It("should return the instances, with the volumes and network interfaces that outlive them or are already detached", func() {
+			vms, err := client.ListBOSHVMs("some-vpc-id", "some-director")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(vms).To(Equal(awsclient.BOSHVMs{
+				InstanceIDs:         []string{"i-director", "i-web"},
+				VolumeIDs:           []string{"vol-persistent", "vol-detached"},
+				NetworkInterfaceIDs: []string{"eni-extra", "eni-detached"},
+			}))
+		})
+
+		Context("when the VPC has no subnets", func() {
+			It("should not look for volumes", func() {
+				ec2Client.DescribeSubnetsCall.Returns.Output.Subnets = nil
+
+				vms, err := client.ListBOSHVMs("some-vpc-id", "some-director")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(vms.VolumeIDs).To(Equal([]string{"vol-persistent"}))
+				Expect(ec2Client.DescribeVolumesCall.Receives.Input).To(BeNil())
+			})
+		})
+
+		Context("when describing the instances fails", func() {
+			It("should return the error", func() {
+				ec2Client.DescribeInstancesCall.Returns.Error = errors.New("some error")
+
+				_, err := client.ListBOSHVMs("some-vpc-id", "some-director")
+				Expect(err).To(MatchError("some error"))
+			})
+		})
+
+		Context("when describing the subnets fails", func() {
+			It("should return the error", func() {
+				ec2Client.DescribeSubnetsCall.Returns.Error = errors.New("some error")
+
+				_, err := client.ListBOSHVMs("some-vpc-id", "some-director")
+				Expect(err).To(MatchError("some error"))
+			})
+		})
+
+		Context("when describing the volumes fails", func() {
+			It("should return the error", func() {
+				ec2Client.DescribeVolumesCall.Returns.Error = errors.New("some error")
+
+				_, err := client.ListBOSHVMs("some-vpc-id", "some-director")
+				Expect(err).To(MatchError("some error"))
+			})
+		})
+
+		Context("when describing the network interfaces fails", func() {
+			It("should return the error", func() {
+				ec2Client.DescribeNetworkInterfacesCall.Returns.Error = errors.New("some error")
+
+				_, err := client.ListBOSHVMs("some-vpc-id", "some-director")
+				Expect(err).To(MatchError("some error"))
+			})
+		})
+	})
+
+	Describe("DeleteBOSHVMs", func() {
+		var vms awsclient.BOSHVMs
+
+		BeforeEach(func() {
+			vms = awsclient.BOSHVMs{
+				InstanceIDs:         []string{"i-director", "i-web"},
+				VolumeIDs:           []string{"vol-persistent"},
+				NetworkInterfaceIDs: []string{"eni-extra"},
+			}
+			ec2Client.DescribeInstancesCall.Returns.Output = &ec2.DescribeInstancesOutput{
+				Reservations: []*ec2.Reservation{
+					&ec2.Reservation{
+						Instances: []*ec2.Instance{
+							&ec2.Instance{InstanceId: aws.String("i-director"), State: &ec2.InstanceState{Name: aws.String("terminated")}},
+							&ec2.Instance{InstanceId: aws.String("i-web"), State: &ec2.InstanceState{Name: aws.String("terminated")}},
+						},
+					},
+				},
+			}
+		})
+
+		It("should terminate the instances and wait for them", func() {
+			Expect(client.DeleteBOSHVMs(context.Background(), vms)).To(Succeed())
+
+			Expect(ec2Client.TerminateInstancesCall.Receives.Input.InstanceIds).To(Equal(aws.StringSlice([]string{"i-director", "i-web"})))
+			Expect(ec2Client.DescribeInstancesCall.Receives.Input.InstanceIds).To(Equal(aws.StringSlice([]string{"i-director", "i-web"})))
+			Expect(clock.SleepCalls).To(BeEmpty())
+		})
+
+		It("should then delete the volumes and network interfaces", func() {
+			Expect(client.DeleteBOSHVMs(context.Background(), vms)).To(Succeed())
+
+			Expect(ec2Client.DeleteVolumeCall.Receives.Input.VolumeId).To(Equal(aws.String("vol-persistent")))
+			Expect(ec2Client.DeleteNetworkInterfaceCall.Receives.Input.NetworkInterfaceId).To(Equal(aws.String("eni-extra")))
+		})
+
+		Context("when there are no instances", func() {
+			It("should not call TerminateInstances", func() {
+				Expect(client.DeleteBOSHVMs(context.Background(), awsclient.BOSHVMs{})).To(Succeed())
+
+				Expect(ec2Client.TerminateInstancesCall.Receives.Input).To(BeNil())
+				Expect(ec2Client.DescribeInstancesCall.Receives.Input).To(BeNil())
+			})
+		})
+
+		Context("when the instances never terminate", func() {
+			It("should give up after the timeout", func() {
+				ec2Client.DescribeInstancesCall.Returns.Output.Reservations[0].Instances[1].State.Name = aws.String("shutting-down")
+
+				err := client.DeleteBOSHVMs(context.Background(), vms)
+				Expect(err).To(MatchError("timed out waiting for 1 instances to terminate (max 10s)"))
+				Expect(clock.SleepCalls).To(HaveLen(2))
+				Expect(clock.SleepCalls[0].Receives.Duration).To(Equal(5 * time.Second))
+				Expect(ec2Client.DeleteVolumeCall.Receives.Input).To(BeNil())
+			})
+		})
+
+		Context("when terminating the instances fails", func() {
+			It("should return the error", func() {
+				ec2Client.TerminateInstancesCall.Returns.Error = errors.New("some error")
+				Expect(client.DeleteBOSHVMs(context.Background(), vms)).To(MatchError("some error"))
+			})
+		})
+
+		Context("when describing the instances fails", func() {
+			It("should return the error", func() {
+				ec2Client.DescribeInstancesCall.Returns.Error = errors.New("some error")
+				Expect(client.DeleteBOSHVMs(context.Background(), vms)).To(MatchError("some error"))
+			})
+		})
+
+		Context("when deleting a volume fails", func() {
+			It("should return the error", func() {
+				ec2Client.DeleteVolumeCall.Returns.Error = errors.New("some error")
+				Expect(client.DeleteBOSHVMs(context.Background(), vms)).To(MatchError("some error"))
+			})
+		})
+
+		Context("when deleting a network interface fails", func() {
+			It("should return the error", func() {
+				ec2Client.DeleteNetworkInterfaceCall.Returns.Error = errors.New("some error")
+				Expect(client.DeleteBOSHVMs(context.Background(), vms)).To(MatchError("some error"))
+			})
+		})
+	})
+})
@@ -11,6 +11,9 @@ import (
 	"github.com/aws/aws-sdk-go/service/cloudformation"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"golang.org/x/net/context"
 )
 
 type Config struct {
@@ -35,16 +38,35 @@ func (c *Config) getEndpoint(serviceName string) (*aws.Config, error) {
 type ec2Client interface {
 	DescribeImages(*ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error)
 	DescribeSubnets(*ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error)
+	DescribeInstances(*ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error)
+	DescribeNatGateways(*ec2.DescribeNatGatewaysInput) (*ec2.DescribeNatGatewaysOutput, error)
 	CreateKeyPair(*ec2.CreateKeyPairInput) (*ec2.CreateKeyPairOutput, error)
 	DeleteKeyPair(*ec2.DeleteKeyPairInput) (*ec2.DeleteKeyPairOutput, error)
+	DescribeKeyPairs(*ec2.DescribeKeyPairsInput) (*ec2.DescribeKeyPairsOutput, error)
+	ImportKeyPair(*ec2.ImportKeyPairInput) (*ec2.ImportKeyPairOutput, error)
+	DescribeAddresses(*ec2.DescribeAddressesInput) (*ec2.DescribeAddressesOutput, error)
+	ReleaseAddress(*ec2.ReleaseAddressInput) (*ec2.ReleaseAddressOutput, error)
+	TerminateInstances(*ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error)
+	DescribeVolumes(*ec2.DescribeVolumesInput) (*ec2.DescribeVolumesOutput, error)
+	DeleteVolume(*ec2.DeleteVolumeInput) (*ec2.DeleteVolumeOutput, error)
+	DescribeNetworkInterfaces(*ec2.DescribeNetworkInterfacesInput) (*ec2.DescribeNetworkInterfacesOutput, error)
+	DeleteNetworkInterface(*ec2.DeleteNetworkInterfaceInput) (*ec2.DeleteNetworkInterfaceOutput, error)
 }
 
 type cloudformationClient interface {
 	DescribeStackResources(*cloudformation.DescribeStackResourcesInput) (*cloudformation.DescribeStackResourcesOutput, error)
 	DescribeStacks(*cloudformation.DescribeStacksInput) (*cloudformation.DescribeStacksOutput, error)
+	ListStacks(*cloudformation.ListStacksInput) (*cloudformation.ListStacksOutput, error)
 	CreateStack(*cloudformation.CreateStackInput) (*cloudformation.CreateStackOutput, error)
 	UpdateStack(*cloudformation.UpdateStackInput) (*cloudformation.UpdateStackOutput, error)
 	DeleteStack(*cloudformation.DeleteStackInput) (*cloudformation.DeleteStackOutput, error)
+	DescribeStackEvents(*cloudformation.DescribeStackEventsInput) (*cloudformation.DescribeStackEventsOutput, error)
+	CreateChangeSet(*cloudformation.CreateChangeSetInput) (*cloudformation.CreateChangeSetOutput, error)
+	DescribeChangeSet(*cloudformation.DescribeChangeSetInput) (*cloudformation.DescribeChangeSetOutput, error)
+	DeleteChangeSet(*cloudformation.DeleteChangeSetInput) (*cloudformation.DeleteChangeSetOutput, error)
+	DetectStackDrift(*cloudformation.DetectStackDriftInput) (*cloudformation.DetectStackDriftOutput, error)
+	DescribeStackDriftDetectionStatus(*cloudformation.DescribeStackDriftDetectionStatusInput) (*cloudformation.DescribeStackDriftDetectionStatusOutput, error)
+	DescribeStackResourceDrifts(*cloudformation.DescribeStackResourceDriftsInput) (*cloudformation.DescribeStackResourceDriftsOutput, error)
 }
 
 type iamClient interface {
@@ -54,16 +76,38 @@ type iamClient interface {
 	ListAccessKeys(*iam.ListAccessKeysInput) (*iam.ListAccessKeysOutput, error)
 }
 
+type s3Client interface {
+	GetObject(*s3.GetObjectInput) (*s3.GetObjectOutput, error)
+	PutObject(*s3.PutObjectInput) (*s3.PutObjectOutput, error)
+	DeleteObject(*s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error)
+	ListObjects(*s3.ListObjectsInput) (*s3.ListObjectsOutput, error)
+}
+
+type stsClient interface {
+	AssumeRole(*sts.AssumeRoleInput) (*sts.AssumeRoleOutput, error)
+}
+
+// clock sleeps between polls, returning early with the context's error once it is cancelled
 type clock interface {
-	Sleep(time.Duration)
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+type logger interface {
+	Printf(format string, v ...interface{})
 }
 
 type Client struct {
 	EC2                       ec2Client
 	CloudFormation            cloudformationClient
 	IAM                       iamClient
+	S3                        s3Client
+	STS                       stsClient
 	Clock                     clock
+	Logger                    logger
 	CloudFormationWaitTimeout time.Duration
+
+	// Owner is recorded in the tags of the stacks this client creates
+	Owner string
 }
 
 func New(config Config) (*Client, error) {
@@ -91,19 +135,48 @@ func New(config Config) (*Client, error) {
 	if err != nil {
 		return nil, err
 	}
+	s3EndpointConfig, err := config.getEndpoint("s3")
+	if err != nil {
+		return nil, err
+	}
+	stsEndpointConfig, err := config.getEndpoint("sts")
+	if err != nil {
+		return nil, err
+	}
+	if s3EndpointConfig.Endpoint != nil {
+		// stand-ins for S3 don't do virtual-hosted buckets
+		s3EndpointConfig.S3ForcePathStyle = aws.Bool(true)
+	}
 
 	return &Client{
 		EC2:            ec2.New(session, ec2EndpointConfig),
 		CloudFormation: cloudformation.New(session, cloudformationEndpointConfig),
 		IAM:            iam.New(session, iamEndpointConfig),
+		S3:             s3.New(session, s3EndpointConfig),
+		STS:            sts.New(session, stsEndpointConfig),
 		Clock:          clockImpl{},
 		CloudFormationWaitTimeout: config.CloudFormationWaitTimeout,
 	}, nil
 }
 
+func (c *Client) logf(format string, v ...interface{}) {
+	if c.Logger != nil {
+		c.Logger.Printf(format, v...)
+	}
+}
+
 type clockImpl struct{}
 
-func (c clockImpl) Sleep(d time.Duration) { time.Sleep(d) }
+func (c clockImpl) Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
 
 // ARN represents an Amazon Resource Name
 // http://docs.aws.amazon.com/general/latest/gr/aws-arns-and-namespaces.html
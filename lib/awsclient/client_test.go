@@ -7,6 +7,8 @@ import (
 	"github.com/aws/aws-sdk-go/service/cloudformation"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sts"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -54,6 +56,8 @@ var _ = Describe("AWS Client", func() {
 					"ec2":            "http://some-fake-ec2-server.example.com:1234",
 					"cloudformation": "http://some-fake-cloudformation-server.example.com:1234",
 					"iam":            "http://some-fake-iam-server.example.com:1234",
+					"s3":             "http://some-fake-s3-server.example.com:1234",
+					"sts":            "http://some-fake-sts-server.example.com:1234",
 				}
 				config.EndpointOverrides = endpointOverrides
 			})
@@ -68,6 +72,11 @@ var _ = Describe("AWS Client", func() {
 				Expect(*cloudformationClient.Config.Endpoint).To(Equal("http://some-fake-cloudformation-server.example.com:1234"))
 				iamClient := client.IAM.(*iam.IAM)
 				Expect(*iamClient.Config.Endpoint).To(Equal("http://some-fake-iam-server.example.com:1234"))
+				s3Client := client.S3.(*s3.S3)
+				Expect(*s3Client.Config.Endpoint).To(Equal("http://some-fake-s3-server.example.com:1234"))
+				Expect(*s3Client.Config.S3ForcePathStyle).To(BeTrue())
+				stsClient := client.STS.(*sts.STS)
+				Expect(*stsClient.Config.Endpoint).To(Equal("http://some-fake-sts-server.example.com:1234"))
 			})
 			Context("when some endpoints are missing", func() {
 				It("should return an error", func() {
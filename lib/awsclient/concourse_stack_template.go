@@ -1,6 +1,99 @@
 package awsclient
 
-import . "github.com/awslabs/aws-cfn-go-template"
+import (
+	"fmt"
+
+	. "github.com/awslabs/aws-cfn-go-template"
+)
+
+const (
+	DefaultVPCCIDR             = "10.0.0.0/16"
+	DefaultConcourseSubnetCIDR = "10.0.16.0/24"
+)
+
+// ConcourseStackOptions select variants of the Concourse stack
+type ConcourseStackOptions struct {
+	// NATGateway routes outbound traffic through the base stack's NAT
+	// gateway, instead of its NAT instance
+	NATGateway bool
+
+	// AvailabilityZones is the number of zones to span, with a Concourse
+	// subnet in each.  Zero means a single zone.
+	AvailabilityZones int
+}
+
+// NewConcourseStackTemplate returns a copy of ConcourseStackTemplate, adapted to the options
+func NewConcourseStackTemplate(options ConcourseStackOptions) Template {
+	template := ConcourseStackTemplate
+	template.Resources = copyResources(ConcourseStackTemplate.Resources)
+
+	if options.NATGateway {
+		template.Parameters = copyParameters(ConcourseStackTemplate.Parameters)
+		delete(template.Parameters, "NATInstance")
+		template.Parameters["NATGateway"] = Parameter{
+			Type:        "String",
+			Description: "ID of the NAT gateway",
+		}
+		template.Resources["ConcourseOutboundRoute"] = withProperty(
+			withoutProperty(template.Resources["ConcourseOutboundRoute"], "InstanceId"),
+			"NatGatewayId", Ref("NATGateway"))
+	}
+
+	if options.AvailabilityZones > 1 {
+		spanConcourseStackZones(&template, options.AvailabilityZones)
+	}
+
+	return template
+}
+
+// spanConcourseStackZones adds a Concourse subnet for each availability zone
+// after the first, and spreads the load balancer across the public subnets.
+// Each subnet routes outbound traffic through the NAT in its own zone.
+func spanConcourseStackZones(template *Template, zones int) {
+	template.Parameters = copyParameters(template.Parameters)
+	loadBalancerSubnets := []interface{}{Ref("PubliclyRoutableSubnetID")}
+	nat, natProperty, natDescription := "NATInstance", "InstanceId", "Instance ID of NAT box"
+	if _, ok := template.Parameters["NATGateway"]; ok {
+		nat, natProperty, natDescription = "NATGateway", "NatGatewayId", "ID of the NAT gateway"
+	}
+
+	for i := 1; i < zones; i++ {
+		suffix := ZoneSuffix(i)
+		template.Parameters["AvailabilityZone"+suffix] = Parameter{
+			Type:        "AWS::EC2::AvailabilityZone::Name",
+			Description: fmt.Sprintf("Availability zone %d", i+1),
+		}
+		template.Parameters["ConcourseSubnetCIDR"+suffix] = Parameter{
+			Type:        "String",
+			Default:     DefaultConcourseSubnetCIDRs[i],
+			Description: fmt.Sprintf("CIDR block for the Concourse subnet in availability zone %d", i+1),
+		}
+		template.Parameters["PubliclyRoutableSubnetID"+suffix] = Parameter{
+			Type:        "String",
+			Description: fmt.Sprintf("ID of a publicly routable subnet in availability zone %d", i+1),
+		}
+
+		template.Parameters[nat+suffix] = Parameter{
+			Type:        template.Parameters[nat].Type,
+			Description: fmt.Sprintf("%s in availability zone %d", natDescription, i+1),
+		}
+
+		routeTable := "ConcourseRouteTable" + suffix
+		template.Resources[routeTable] = template.Resources["ConcourseRouteTable"]
+		template.Resources["ConcourseOutboundRoute"+suffix] = withProperty(
+			withProperty(template.Resources["ConcourseOutboundRoute"], "RouteTableId", Ref(routeTable)),
+			natProperty, Ref(nat+suffix))
+
+		subnet := "ConcourseSubnet" + suffix
+		template.Resources[subnet] = subnetResource("VPCID", "ConcourseSubnetCIDR"+suffix, "Concourse"+suffix, Ref("AvailabilityZone"+suffix))
+		template.Resources[subnet+"RouteTableAssociation"] = routeTableAssociation(subnet, routeTable)
+
+		loadBalancerSubnets = append(loadBalancerSubnets, Ref("PubliclyRoutableSubnetID"+suffix))
+	}
+
+	loadBalancer := withProperty(template.Resources["LoadBalancer"], "Subnets", loadBalancerSubnets)
+	template.Resources["LoadBalancer"] = withProperty(loadBalancer, "CrossZone", true)
+}
 
 var ConcourseStackTemplate = Template{
 	AWSTemplateFormatVersion: "2010-09-09",
@@ -12,7 +105,7 @@ var ConcourseStackTemplate = Template{
 		},
 		"VPCCIDR": Parameter{
 			Type:        "String",
-			Default:     "10.0.0.0/16",
+			Default:     DefaultVPCCIDR,
 			Description: "CIDR block of the parent VPC",
 		},
 		"NATInstance": Parameter{
@@ -21,7 +114,7 @@ var ConcourseStackTemplate = Template{
 		},
 		"ConcourseSubnetCIDR": Parameter{
 			Type:        "String",
-			Default:     "10.0.16.0/24",
+			Default:     DefaultConcourseSubnetCIDR,
 			Description: "CIDR block for the Concourse subnet",
 		},
 		"PubliclyRoutableSubnetID": Parameter{
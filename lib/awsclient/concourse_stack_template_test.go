@@ -18,4 +18,81 @@ var _ = Describe("Generating the concourse template", func() {
 
 		Expect(asJSON).To(MatchJSON(expected))
 	})
+
+	Context("when using a NAT gateway", func() {
+		var template parsedTemplate
+
+		BeforeEach(func() {
+			template = parseTemplate(awsclient.NewConcourseStackTemplate(awsclient.ConcourseStackOptions{NATGateway: true}).String())
+		})
+
+		It("should route outbound traffic through the NAT gateway", func() {
+			Expect(template.Parameters).NotTo(HaveKey("NATInstance"))
+			Expect(template.Parameters).To(HaveKey("NATGateway"))
+			Expect(template.Resources["ConcourseOutboundRoute"].Properties).To(Equal(map[string]interface{}{
+				"NatGatewayId":         map[string]interface{}{"Ref": "NATGateway"},
+				"DestinationCidrBlock": "0.0.0.0/0",
+				"RouteTableId":         map[string]interface{}{"Ref": "ConcourseRouteTable"},
+			}))
+		})
+
+		It("should leave the default template unchanged", func() {
+			expected, err := ioutil.ReadFile("fixtures/concourse_stack_template.json")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(awsclient.ConcourseStackTemplate.String()).To(MatchJSON(expected))
+		})
+	})
+
+	Context("when spanning several availability zones", func() {
+		var template parsedTemplate
+
+		BeforeEach(func() {
+			template = parseTemplate(awsclient.NewConcourseStackTemplate(awsclient.ConcourseStackOptions{AvailabilityZones: 2}).String())
+		})
+
+		It("should add a Concourse subnet in the other zone", func() {
+			Expect(template.Parameters).To(HaveKey("AvailabilityZoneZ2"))
+			Expect(template.Parameters["ConcourseSubnetCIDRZ2"]).To(HaveKeyWithValue("Default", "10.0.17.0/24"))
+
+			subnet := template.Resources["ConcourseSubnetZ2"]
+			Expect(subnet.Type).To(Equal("AWS::EC2::Subnet"))
+			Expect(subnet.Properties["AvailabilityZone"]).To(Equal(map[string]interface{}{"Ref": "AvailabilityZoneZ2"}))
+			Expect(subnet.Properties["CidrBlock"]).To(Equal(map[string]interface{}{"Ref": "ConcourseSubnetCIDRZ2"}))
+			Expect(template.Resources["ConcourseSubnetZ2RouteTableAssociation"].Properties["RouteTableId"]).To(Equal(map[string]interface{}{"Ref": "ConcourseRouteTableZ2"}))
+		})
+
+		It("should route the new subnet through the NAT instance in its own zone", func() {
+			Expect(template.Parameters["NATInstanceZ2"]).To(HaveKeyWithValue("Type", "AWS::EC2::Instance::Id"))
+			Expect(template.Resources["ConcourseRouteTableZ2"].Type).To(Equal("AWS::EC2::RouteTable"))
+			Expect(template.Resources["ConcourseOutboundRouteZ2"].Properties).To(Equal(map[string]interface{}{
+				"InstanceId":           map[string]interface{}{"Ref": "NATInstanceZ2"},
+				"DestinationCidrBlock": "0.0.0.0/0",
+				"RouteTableId":         map[string]interface{}{"Ref": "ConcourseRouteTableZ2"},
+			}))
+		})
+
+		Context("when using a NAT gateway", func() {
+			It("should route the new subnet through the NAT gateway in its own zone", func() {
+				template = parseTemplate(awsclient.NewConcourseStackTemplate(awsclient.ConcourseStackOptions{AvailabilityZones: 2, NATGateway: true}).String())
+
+				Expect(template.Parameters).To(HaveKey("NATGatewayZ2"))
+				Expect(template.Parameters).NotTo(HaveKey("NATInstanceZ2"))
+				Expect(template.Resources["ConcourseOutboundRouteZ2"].Properties).To(Equal(map[string]interface{}{
+					"NatGatewayId":         map[string]interface{}{"Ref": "NATGatewayZ2"},
+					"DestinationCidrBlock": "0.0.0.0/0",
+					"RouteTableId":         map[string]interface{}{"Ref": "ConcourseRouteTableZ2"},
+				}))
+			})
+		})
+
+		It("should spread the load balancer across the public subnets of every zone", func() {
+			loadBalancer := template.Resources["LoadBalancer"]
+			Expect(loadBalancer.Properties["Subnets"]).To(Equal([]interface{}{
+				map[string]interface{}{"Ref": "PubliclyRoutableSubnetID"},
+				map[string]interface{}{"Ref": "PubliclyRoutableSubnetIDZ2"},
+			}))
+			Expect(loadBalancer.Properties["CrossZone"]).To(BeTrue())
+		})
+	})
 })
@@ -0,0 +1,245 @@
+package awsclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	. "github.com/awslabs/aws-cfn-go-template"
+)
+
+// PolicyDocument is an IAM policy, in the form CloudFormation embeds it
+type PolicyDocument struct {
+	Version   string
+	Statement []PolicyStatement
+}
+
+type PolicyStatement struct {
+	Sid       string `json:",omitempty"`
+	Effect    string
+	Principal interface{} `json:",omitempty"`
+	Action    []string
+	Resource  interface{}                       `json:",omitempty"`
+	Condition map[string]map[string]interface{} `json:",omitempty"`
+}
+
+func (p PolicyDocument) String() string {
+	bytes, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return string(bytes)
+}
+
+// join builds an Fn::Join of the parts, with no separator
+func join(parts ...interface{}) map[string]interface{} {
+	return map[string]interface{}{"Fn::Join": []interface{}{"", parts}}
+}
+
+// arn is the ARN of an EC2 or ELB resource in the stack's region and account
+func arn(service string, resource ...interface{}) map[string]interface{} {
+	parts := []interface{}{"arn:aws:" + service + ":", Ref("AWS::Region"), ":", Ref("AWS::AccountId"), ":"}
+	return join(append(parts, resource...)...)
+}
+
+// inTheVPC limits a statement to resources in the base stack's VPC
+var inTheVPC = map[string]map[string]interface{}{
+	"StringEquals": {"ec2:Vpc": arn("ec2", "vpc/", Ref("VPC"))},
+}
+
+// DirectorPolicy allows only what the BOSH AWS CPI needs.  Instances are
+// limited to the environment's VPC.  Volumes, snapshots and images have no
+// VPC, so those are limited to the stack's region and account instead.
+var DirectorPolicy = PolicyDocument{
+	Version: "2012-10-17",
+	Statement: []PolicyStatement{
+		{
+			Sid:    "Describe",
+			Effect: "Allow",
+			Action: []string{
+				"ec2:DescribeAddresses",
+				"ec2:DescribeAvailabilityZones",
+				"ec2:DescribeImages",
+				"ec2:DescribeInstances",
+				"ec2:DescribeRegions",
+				"ec2:DescribeSecurityGroups",
+				"ec2:DescribeSnapshots",
+				"ec2:DescribeSubnets",
+				"ec2:DescribeVolumes",
+				"elasticloadbalancing:DescribeLoadBalancers",
+			},
+			Resource: "*",
+		},
+		{
+			Sid:       "RunInstancesInTheVPC",
+			Effect:    "Allow",
+			Action:    []string{"ec2:RunInstances"},
+			Resource:  arn("ec2", "subnet/*"),
+			Condition: inTheVPC,
+		},
+		{
+			Sid:    "RunInstances",
+			Effect: "Allow",
+			Action: []string{"ec2:RunInstances"},
+			Resource: []interface{}{
+				arn("ec2", "instance/*"),
+				arn("ec2", "volume/*"),
+				arn("ec2", "network-interface/*"),
+				arn("ec2", "security-group/*"),
+				arn("ec2", "key-pair/*"),
+				join("arn:aws:ec2:", Ref("AWS::Region"), "::image/*"),
+			},
+		},
+		{
+			Sid:    "ManageInstancesInTheVPC",
+			Effect: "Allow",
+			Action: []string{
+				"ec2:AssociateAddress",
+				"ec2:AttachVolume",
+				"ec2:DetachVolume",
+				"ec2:ModifyInstanceAttribute",
+				"ec2:RebootInstances",
+				"ec2:StopInstances",
+				"ec2:TerminateInstances",
+			},
+			Resource:  arn("ec2", "instance/*"),
+			Condition: inTheVPC,
+		},
+		{
+			Sid:    "ManageVolumes",
+			Effect: "Allow",
+			Action: []string{
+				"ec2:AttachVolume",
+				"ec2:CreateVolume",
+				"ec2:DeleteVolume",
+				"ec2:DetachVolume",
+			},
+			Resource: arn("ec2", "volume/*"),
+		},
+		{
+			Sid:    "ManageSnapshotsAndImages",
+			Effect: "Allow",
+			Action: []string{
+				"ec2:CreateSnapshot",
+				"ec2:CreateTags",
+				"ec2:DeleteSnapshot",
+				"ec2:DeregisterImage",
+				"ec2:RegisterImage",
+			},
+			// these actions don't support resource-level permissions
+			Resource: "*",
+		},
+		{
+			Sid:    "ManageLoadBalancers",
+			Effect: "Allow",
+			Action: []string{
+				"elasticloadbalancing:DeregisterInstancesFromLoadBalancer",
+				"elasticloadbalancing:RegisterInstancesWithLoadBalancer",
+			},
+			Resource: arn("elasticloadbalancing", "loadbalancer/*"),
+		},
+	},
+}
+
+// DirectorRolePolicy is DirectorPolicy for a director with an instance
+// profile.  It may also pass its own role, and no other, to the VMs it creates.
+var DirectorRolePolicy = PolicyDocument{
+	Version: DirectorPolicy.Version,
+	Statement: append(append([]PolicyStatement{}, DirectorPolicy.Statement...),
+		PolicyStatement{
+			Sid:      "PassOwnRole",
+			Effect:   "Allow",
+			Action:   []string{"iam:PassRole"},
+			Resource: getAtt("BOSHDirectorRole", "Arn"),
+		},
+	),
+}
+
+// Resolve returns the policy as IAM sees it in a live stack, with its Ref,
+// Fn::GetAtt and Fn::Join replaced.  values holds what each Ref resolves to,
+// by logical ID or pseudo parameter, and each Fn::GetAtt as "LogicalID.Attribute".
+func (p PolicyDocument) Resolve(values map[string]string) (PolicyDocument, error) {
+	var tree interface{}
+	err := json.Unmarshal([]byte(p.String()), &tree)
+	if err != nil {
+		return PolicyDocument{}, err // not tested
+	}
+
+	resolved, err := resolveIntrinsics(tree, values)
+	if err != nil {
+		return PolicyDocument{}, err
+	}
+
+	resolvedJSON, err := json.Marshal(resolved)
+	if err != nil {
+		return PolicyDocument{}, err // not tested
+	}
+	var policy PolicyDocument
+	err = json.Unmarshal(resolvedJSON, &policy)
+	if err != nil {
+		return PolicyDocument{}, err // not tested
+	}
+	return policy, nil
+}
+
+func resolveIntrinsics(node interface{}, values map[string]string) (interface{}, error) {
+	switch node := node.(type) {
+	case []interface{}:
+		resolved := []interface{}{}
+		for _, item := range node {
+			value, err := resolveIntrinsics(item, values)
+			if err != nil {
+				return nil, err
+			}
+			resolved = append(resolved, value)
+		}
+		return resolved, nil
+
+	case map[string]interface{}:
+		if ref, ok := node["Ref"].(string); ok && len(node) == 1 {
+			return lookupValue(values, ref)
+		}
+		if att, ok := node["Fn::GetAtt"].([]interface{}); ok && len(node) == 1 && len(att) == 2 {
+			return lookupValue(values, fmt.Sprintf("%s.%s", att[0], att[1]))
+		}
+		if args, ok := node["Fn::Join"].([]interface{}); ok && len(node) == 1 && len(args) == 2 {
+			separator, _ := args[0].(string)
+			parts, err := resolveIntrinsics(args[1], values)
+			if err != nil {
+				return nil, err
+			}
+			list, ok := parts.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("can't join %v", parts)
+			}
+			strs := []string{}
+			for _, part := range list {
+				str, ok := part.(string)
+				if !ok {
+					return nil, fmt.Errorf("can't join %v", part)
+				}
+				strs = append(strs, str)
+			}
+			return strings.Join(strs, separator), nil
+		}
+
+		resolved := map[string]interface{}{}
+		for key, value := range node {
+			value, err := resolveIntrinsics(value, values)
+			if err != nil {
+				return nil, err
+			}
+			resolved[key] = value
+		}
+		return resolved, nil
+	}
+	return node, nil
+}
+
+func lookupValue(values map[string]string, name string) (string, error) {
+	value, ok := values[name]
+	if !ok || value == "" {
+		return "", fmt.Errorf("no value for %s in the policy", name)
+	}
+	return value, nil
+}
@@ -0,0 +1,166 @@
+package awsclient_test
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/rosenhouse/tubes/lib/awsclient"
+)
+
+var cpiActions = []string{
+	"ec2:AssociateAddress",
+	"ec2:AttachVolume",
+	"ec2:CreateSnapshot",
+	"ec2:CreateTags",
+	"ec2:CreateVolume",
+	"ec2:DeleteSnapshot",
+	"ec2:DeleteVolume",
+	"ec2:DeregisterImage",
+	"ec2:DescribeAddresses",
+	"ec2:DescribeAvailabilityZones",
+	"ec2:DescribeImages",
+	"ec2:DescribeInstances",
+	"ec2:DescribeRegions",
+	"ec2:DescribeSecurityGroups",
+	"ec2:DescribeSnapshots",
+	"ec2:DescribeSubnets",
+	"ec2:DescribeVolumes",
+	"ec2:DetachVolume",
+	"ec2:ModifyInstanceAttribute",
+	"ec2:RebootInstances",
+	"ec2:RegisterImage",
+	"ec2:RunInstances",
+	"ec2:StopInstances",
+	"ec2:TerminateInstances",
+	"elasticloadbalancing:DeregisterInstancesFromLoadBalancer",
+	"elasticloadbalancing:DescribeLoadBalancers",
+	"elasticloadbalancing:RegisterInstancesWithLoadBalancer",
+}
+
+type parsedPolicy struct {
+	Version   string
+	Statement []struct {
+		Effect    string
+		Action    []string
+		Resource  interface{}
+		Condition map[string]map[string]interface{}
+	}
+}
+
+var _ = Describe("DirectorPolicy", func() {
+	var policy parsedPolicy
+
+	BeforeEach(func() {
+		policy = parsedPolicy{}
+		Expect(json.Unmarshal([]byte(awsclient.DirectorPolicy.String()), &policy)).To(Succeed())
+	})
+
+	It("should be a valid policy document", func() {
+		Expect(policy.Version).To(Equal("2012-10-17"))
+		Expect(policy.Statement).NotTo(BeEmpty())
+		for _, statement := range policy.Statement {
+			Expect(statement.Effect).To(Equal("Allow"))
+			Expect(statement.Action).NotTo(BeEmpty())
+			Expect(statement.Resource).NotTo(BeNil())
+		}
+	})
+
+	It("should allow exactly the actions the AWS CPI needs", func() {
+		actions := map[string]bool{}
+		for _, statement := range policy.Statement {
+			for _, action := range statement.Action {
+				actions[action] = true
+			}
+		}
+
+		allowed := []string{}
+		for action := range actions {
+			allowed = append(allowed, action)
+		}
+		Expect(allowed).To(ConsistOf(cpiActions))
+	})
+
+	It("should not allow any wildcard actions", func() {
+		for _, statement := range policy.Statement {
+			for _, action := range statement.Action {
+				Expect(action).NotTo(ContainSubstring("*"))
+			}
+		}
+	})
+
+	It("should limit instance management to the environment's VPC", func() {
+		for _, statement := range policy.Statement {
+			for _, action := range statement.Action {
+				if action == "ec2:TerminateInstances" {
+					Expect(statement.Condition["StringEquals"]).To(HaveKey("ec2:Vpc"))
+				}
+			}
+		}
+	})
+
+	It("should not let the director user pass any role", func() {
+		Expect(awsclient.DirectorPolicy.String()).NotTo(ContainSubstring("iam:PassRole"))
+	})
+
+	It("should be attached to the director user in place of administrator access", func() {
+		template := parseTemplate(awsclient.BaseStackTemplate.String())
+
+		user := template.Resources["BOSHDirectorUser"]
+		Expect(user.Properties).NotTo(HaveKey("ManagedPolicyArns"))
+		Expect(user.Properties["Policies"]).To(HaveLen(1))
+		Expect(awsclient.BaseStackTemplate.String()).NotTo(ContainSubstring("AdministratorAccess"))
+	})
+})
+
+var _ = Describe("DirectorRolePolicy", func() {
+	It("should add passing the director's own role, and no other, to the director policy", func() {
+		var policy parsedPolicy
+		Expect(json.Unmarshal([]byte(awsclient.DirectorRolePolicy.String()), &policy)).To(Succeed())
+
+		Expect(policy.Statement).To(HaveLen(len(awsclient.DirectorPolicy.Statement) + 1))
+		passRole := policy.Statement[len(policy.Statement)-1]
+		Expect(passRole.Action).To(Equal([]string{"iam:PassRole"}))
+		Expect(passRole.Resource).To(Equal(map[string]interface{}{"Fn::GetAtt": []interface{}{"BOSHDirectorRole", "Arn"}}))
+	})
+})
+
+var _ = Describe("PolicyDocument", func() {
+	Describe("Resolve", func() {
+		values := map[string]string{
+			"AWS::Region":          "some-region",
+			"AWS::AccountId":       "123456789012",
+			"VPC":                  "vpc-12345",
+			"BOSHDirectorRole.Arn": "arn:aws:iam::123456789012:role/some-role",
+		}
+
+		It("should fill in the values of the live stack, as IAM sees them", func() {
+			policy, err := awsclient.DirectorRolePolicy.Resolve(values)
+			Expect(err).NotTo(HaveOccurred())
+
+			policyJSON := policy.String()
+			Expect(policyJSON).NotTo(ContainSubstring("Fn::"))
+			Expect(policyJSON).NotTo(ContainSubstring("Ref"))
+			Expect(policyJSON).To(ContainSubstring(`"arn:aws:ec2:some-region:123456789012:vpc/vpc-12345"`))
+			Expect(policyJSON).To(ContainSubstring(`"arn:aws:ec2:some-region::image/*"`))
+			Expect(policyJSON).To(ContainSubstring(`"arn:aws:iam::123456789012:role/some-role"`))
+			Expect(policy.Statement).To(HaveLen(len(awsclient.DirectorRolePolicy.Statement)))
+		})
+
+		It("should leave the policy itself unchanged", func() {
+			before := awsclient.DirectorPolicy.String()
+
+			_, err := awsclient.DirectorPolicy.Resolve(values)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(awsclient.DirectorPolicy.String()).To(Equal(before))
+		})
+
+		Context("when a value is missing", func() {
+			It("should return an error", func() {
+				_, err := awsclient.DirectorRolePolicy.Resolve(map[string]string{"AWS::Region": "some-region"})
+				Expect(err).To(MatchError(ContainSubstring("no value for")))
+			})
+		})
+	})
+})
@@ -0,0 +1,50 @@
+package awsclient
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// ElasticIP is an Elastic IP that a tubes stack allocated, found by the
+// environment tag that CloudFormation copies from the stack
+type ElasticIP struct {
+	AllocationID string
+	PublicIP     string
+	Environment  string
+	Associated   bool
+}
+
+// ListElasticIPs returns every Elastic IP in the region with a tubes environment tag
+func (c *Client) ListElasticIPs() ([]ElasticIP, error) {
+	output, err := c.EC2.DescribeAddresses(&ec2.DescribeAddressesInput{})
+	if err != nil {
+		return nil, err
+	}
+
+	ips := []ElasticIP{}
+	for _, address := range output.Addresses {
+		environment := ""
+		for _, tag := range address.Tags {
+			if aws.StringValue(tag.Key) == EnvironmentTag {
+				environment = aws.StringValue(tag.Value)
+			}
+		}
+		if environment == "" {
+			continue
+		}
+		ips = append(ips, ElasticIP{
+			AllocationID: aws.StringValue(address.AllocationId),
+			PublicIP:     aws.StringValue(address.PublicIp),
+			Environment:  environment,
+			Associated:   address.AssociationId != nil,
+		})
+	}
+	return ips, nil
+}
+
+func (c *Client) ReleaseElasticIP(allocationID string) error {
+	_, err := c.EC2.ReleaseAddress(&ec2.ReleaseAddressInput{
+		AllocationId: aws.String(allocationID),
+	})
+	return err
+}
@@ -0,0 +1,89 @@
+package awsclient_test
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rosenhouse/tubes/lib/awsclient"
+	"github.com/rosenhouse/tubes/mocks"
+)
+
+var _ = Describe("Elastic IP operations", func() {
+	var (
+		client    awsclient.Client
+		ec2Client *mocks.EC2Client
+	)
+
+	BeforeEach(func() {
+		ec2Client = &mocks.EC2Client{}
+		client = awsclient.Client{
+			EC2: ec2Client,
+		}
+	})
+
+	Describe("ListElasticIPs", func() {
+		BeforeEach(func() {
+			ec2Client.DescribeAddressesCall.Returns.Output = &ec2.DescribeAddressesOutput{
+				Addresses: []*ec2.Address{
+					&ec2.Address{
+						AllocationId: aws.String("eipalloc-1"),
+						PublicIp:     aws.String("1.2.3.4"),
+						Tags: []*ec2.Tag{
+							&ec2.Tag{Key: aws.String("tubes:environment"), Value: aws.String("some-env")},
+						},
+					},
+					&ec2.Address{
+						AllocationId:  aws.String("eipalloc-2"),
+						PublicIp:      aws.String("5.6.7.8"),
+						AssociationId: aws.String("eipassoc-2"),
+						Tags: []*ec2.Tag{
+							&ec2.Tag{Key: aws.String("tubes:environment"), Value: aws.String("other-env")},
+						},
+					},
+					&ec2.Address{
+						AllocationId: aws.String("eipalloc-3"),
+						PublicIp:     aws.String("9.10.11.12"),
+					},
+				},
+			}
+		})
+
+		It("should return the Elastic IPs with a tubes environment tag", func() {
+			ips, err := client.ListElasticIPs()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ips).To(Equal([]awsclient.ElasticIP{
+				{AllocationID: "eipalloc-1", PublicIP: "1.2.3.4", Environment: "some-env"},
+				{AllocationID: "eipalloc-2", PublicIP: "5.6.7.8", Environment: "other-env", Associated: true},
+			}))
+		})
+
+		Context("when the SDK returns an error", func() {
+			It("should return the error", func() {
+				ec2Client.DescribeAddressesCall.Returns.Error = errors.New("some error")
+
+				_, err := client.ListElasticIPs()
+				Expect(err).To(MatchError("some error"))
+			})
+		})
+	})
+
+	Describe("ReleaseElasticIP", func() {
+		It("should release the address by its allocation ID", func() {
+			Expect(client.ReleaseElasticIP("eipalloc-1")).To(Succeed())
+
+			Expect(ec2Client.ReleaseAddressCall.Receives.Input.AllocationId).To(Equal(aws.String("eipalloc-1")))
+		})
+
+		Context("when the SDK returns an error", func() {
+			It("should return the error", func() {
+				ec2Client.ReleaseAddressCall.Returns.Error = errors.New("some error")
+
+				Expect(client.ReleaseElasticIP("eipalloc-1")).To(MatchError("some error"))
+			})
+		})
+	})
+})
@@ -0,0 +1,60 @@
+package awsclient_test
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"github.com/rosenhouse/tubes/lib/awsclient"
+	"github.com/rosenhouse/tubes/mocks"
+)
+
+var _ = Describe("Getting the state of an instance", func() {
+	var (
+		client    awsclient.Client
+		ec2Client *mocks.EC2Client
+	)
+
+	BeforeEach(func() {
+		ec2Client = &mocks.EC2Client{}
+		ec2Client.DescribeInstancesCall.Returns.Output = &ec2.DescribeInstancesOutput{
+			Reservations: []*ec2.Reservation{
+				{
+					Instances: []*ec2.Instance{
+						{
+							InstanceId: aws.String("some-instance-id"),
+							State:      &ec2.InstanceState{Name: aws.String("running")},
+						},
+					},
+				},
+			},
+		}
+
+		client = awsclient.Client{EC2: ec2Client}
+	})
+
+	It("should return the state of the instance", func() {
+		Expect(client.InstanceState("some-instance-id")).To(Equal("running"))
+		Expect(ec2Client.DescribeInstancesCall.Receives.Input.InstanceIds).To(Equal([]*string{aws.String("some-instance-id")}))
+	})
+
+	Context("when the instance isn't in the response", func() {
+		It("should return an error", func() {
+			_, err := client.InstanceState("other-instance-id")
+			Expect(err).To(MatchError("instance other-instance-id not found"))
+		})
+	})
+
+	Context("when describing the instance fails", func() {
+		It("should return the error", func() {
+			ec2Client.DescribeInstancesCall.Returns.Error = errors.New("some error")
+
+			_, err := client.InstanceState("some-instance-id")
+			Expect(err).To(MatchError("some error"))
+		})
+	})
+})
@@ -1,8 +1,14 @@
 package awsclient
 
 import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"golang.org/x/crypto/ssh"
 )
 
 func (c *Client) CreateKeyPair(keyName string) (string, error) {
@@ -22,3 +28,68 @@ func (c *Client) DeleteKeyPair(keyName string) error {
 	})
 	return err
 }
+
+func errorIsBecauseKeyPairDoesNotExist(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && awsErr.Code() == "InvalidKeyPair.NotFound"
+}
+
+func (c *Client) KeyPairExists(keyName string) (bool, error) {
+	output, err := c.EC2.DescribeKeyPairs(&ec2.DescribeKeyPairsInput{
+		KeyNames: []*string{aws.String(keyName)},
+	})
+	if err != nil {
+		if errorIsBecauseKeyPairDoesNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return len(output.KeyPairs) > 0, nil
+}
+
+// ListKeyPairs returns the names of every key pair in the region
+func (c *Client) ListKeyPairs() ([]string, error) {
+	output, err := c.EC2.DescribeKeyPairs(&ec2.DescribeKeyPairsInput{})
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, keyPair := range output.KeyPairs {
+		names = append(names, aws.StringValue(keyPair.KeyName))
+	}
+	return names, nil
+}
+
+// ImportKeyPair uploads the public half of a PEM-encoded RSA private key
+func (c *Client) ImportKeyPair(keyName string, pemBytes []byte) error {
+	publicKey, err := openSSHPublicKey(pemBytes)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.EC2.ImportKeyPair(&ec2.ImportKeyPairInput{
+		KeyName:           aws.String(keyName),
+		PublicKeyMaterial: publicKey,
+	})
+	return err
+}
+
+// openSSHPublicKey returns the authorized_keys form of the public key
+func openSSHPublicKey(pemBytes []byte) ([]byte, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("ssh key is not PEM encoded")
+	}
+	privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := ssh.NewPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return nil, err // not tested
+	}
+	return ssh.MarshalAuthorizedKey(publicKey), nil
+}
@@ -1,6 +1,10 @@
 package awsclient_test
 
 import (
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"math/rand"
@@ -11,6 +15,7 @@ import (
 	. "github.com/onsi/gomega"
 	"github.com/rosenhouse/tubes/lib/awsclient"
 	"github.com/rosenhouse/tubes/mocks"
+	"golang.org/x/crypto/ssh"
 )
 
 var _ = Describe("Keypair operations", func() {
@@ -65,4 +70,71 @@ var _ = Describe("Keypair operations", func() {
 			})
 		})
 	})
+
+	Describe("ListKeyPairs", func() {
+		It("should describe every key pair, and return their names", func() {
+			ec2Client.DescribeKeyPairsCall.Returns.Output = &ec2.DescribeKeyPairsOutput{
+				KeyPairs: []*ec2.KeyPairInfo{
+					&ec2.KeyPairInfo{KeyName: aws.String("some-key")},
+					&ec2.KeyPairInfo{KeyName: aws.String("other-key")},
+				},
+			}
+
+			names, err := client.ListKeyPairs()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(names).To(Equal([]string{"some-key", "other-key"}))
+			Expect(ec2Client.DescribeKeyPairsCall.Receives.Input.KeyNames).To(BeEmpty())
+		})
+
+		Context("when the SDK returns an error", func() {
+			It("should return the error", func() {
+				ec2Client.DescribeKeyPairsCall.Returns.Error = errors.New("some error")
+
+				_, err := client.ListKeyPairs()
+				Expect(err).To(MatchError("some error"))
+			})
+		})
+	})
+
+	Describe("ImportKeyPair", func() {
+		var (
+			rsaKey   *rsa.PrivateKey
+			pemBytes []byte
+		)
+
+		BeforeEach(func() {
+			var err error
+			rsaKey, err = rsa.GenerateKey(cryptorand.Reader, 1024)
+			Expect(err).NotTo(HaveOccurred())
+			pemBytes = pem.EncodeToMemory(&pem.Block{
+				Type:  "RSA PRIVATE KEY",
+				Bytes: x509.MarshalPKCS1PrivateKey(rsaKey),
+			})
+		})
+
+		It("should upload the public half of the key, in authorized_keys form", func() {
+			Expect(client.ImportKeyPair(keyName, pemBytes)).To(Succeed())
+
+			Expect(*ec2Client.ImportKeyPairCall.Receives.Input.KeyName).To(Equal(keyName))
+			publicKey, _, _, _, err := ssh.ParseAuthorizedKey(ec2Client.ImportKeyPairCall.Receives.Input.PublicKeyMaterial)
+			Expect(err).NotTo(HaveOccurred())
+			expectedKey, err := ssh.NewPublicKey(&rsaKey.PublicKey)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(publicKey.Marshal()).To(Equal(expectedKey.Marshal()))
+		})
+
+		Context("when the key is not PEM encoded", func() {
+			It("should return an error", func() {
+				Expect(client.ImportKeyPair(keyName, []byte("some garbage"))).To(MatchError("ssh key is not PEM encoded"))
+			})
+		})
+
+		Context("when the SDK returns an error", func() {
+			It("should return the error", func() {
+				ec2Client.ImportKeyPairCall.Returns.Error = errors.New("some error")
+
+				Expect(client.ImportKeyPair(keyName, pemBytes)).To(MatchError("some error"))
+			})
+		})
+	})
 })
@@ -0,0 +1,25 @@
+package awsclient
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// NATGatewayState returns the state of the NAT gateway, e.g. available or failed
+func (c *Client) NATGatewayState(natGatewayID string) (string, error) {
+	output, err := c.EC2.DescribeNatGateways(&ec2.DescribeNatGatewaysInput{
+		NatGatewayIds: []*string{aws.String(natGatewayID)},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	for _, natGateway := range output.NatGateways {
+		if aws.StringValue(natGateway.NatGatewayId) == natGatewayID {
+			return aws.StringValue(natGateway.State), nil
+		}
+	}
+	return "", fmt.Errorf("NAT gateway %s not found", natGatewayID)
+}
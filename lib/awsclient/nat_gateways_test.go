@@ -0,0 +1,56 @@
+package awsclient_test
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"github.com/rosenhouse/tubes/lib/awsclient"
+	"github.com/rosenhouse/tubes/mocks"
+)
+
+var _ = Describe("Getting the state of a NAT gateway", func() {
+	var (
+		client    awsclient.Client
+		ec2Client *mocks.EC2Client
+	)
+
+	BeforeEach(func() {
+		ec2Client = &mocks.EC2Client{}
+		ec2Client.DescribeNatGatewaysCall.Returns.Output = &ec2.DescribeNatGatewaysOutput{
+			NatGateways: []*ec2.NatGateway{
+				{
+					NatGatewayId: aws.String("some-nat-gateway-id"),
+					State:        aws.String("available"),
+				},
+			},
+		}
+
+		client = awsclient.Client{EC2: ec2Client}
+	})
+
+	It("should return the state of the NAT gateway", func() {
+		Expect(client.NATGatewayState("some-nat-gateway-id")).To(Equal("available"))
+		Expect(ec2Client.DescribeNatGatewaysCall.Receives.Input.NatGatewayIds).To(Equal([]*string{aws.String("some-nat-gateway-id")}))
+	})
+
+	Context("when the NAT gateway isn't in the response", func() {
+		It("should return an error", func() {
+			_, err := client.NATGatewayState("other-nat-gateway-id")
+			Expect(err).To(MatchError("NAT gateway other-nat-gateway-id not found"))
+		})
+	})
+
+	Context("when describing the NAT gateway fails", func() {
+		It("should return the error", func() {
+			ec2Client.DescribeNatGatewaysCall.Returns.Error = errors.New("some error")
+
+			_, err := client.NATGatewayState("some-nat-gateway-id")
+			Expect(err).To(MatchError("some error"))
+		})
+	})
+})
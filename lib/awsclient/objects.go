@@ -0,0 +1,76 @@
+package awsclient
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// GetObject reads an S3 object.  A missing object is an error satisfying os.IsNotExist
+func (c *Client) GetObject(bucket, key string) ([]byte, error) {
+	output, err := c.S3.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if isNoSuchKey(err) {
+			return nil, &os.PathError{Op: "get", Path: "s3://" + bucket + "/" + key, Err: os.ErrNotExist}
+		}
+		return nil, err
+	}
+	defer output.Body.Close()
+
+	return ioutil.ReadAll(output.Body)
+}
+
+func isNoSuchKey(err error) bool {
+	if requestFailure, ok := err.(awserr.RequestFailure); ok && requestFailure.StatusCode() == 404 {
+		return true
+	}
+	awsErr, ok := err.(awserr.Error)
+	return ok && awsErr.Code() == "NoSuchKey"
+}
+
+func (c *Client) PutObject(bucket, key string, value []byte) error {
+	_, err := c.S3.PutObject(&s3.PutObjectInput{
+		Bucket:               aws.String(bucket),
+		Key:                  aws.String(key),
+		Body:                 bytes.NewReader(value),
+		ServerSideEncryption: aws.String("AES256"),
+	})
+	return err
+}
+
+func (c *Client) DeleteObject(bucket, key string) error {
+	_, err := c.S3.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+// ListObjects lists the keys of every object with the prefix
+func (c *Client) ListObjects(bucket, prefix string) ([]string, error) {
+	keys := []string{}
+	input := &s3.ListObjectsInput{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}
+	for {
+		output, err := c.S3.ListObjects(input)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range output.Contents {
+			keys = append(keys, aws.StringValue(object.Key))
+		}
+		if !aws.BoolValue(output.IsTruncated) || len(output.Contents) == 0 {
+			return keys, nil
+		}
+		input.Marker = output.Contents[len(output.Contents)-1].Key
+	}
+}
@@ -0,0 +1,131 @@
+package awsclient_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/rosenhouse/tubes/lib/awsclient"
+	"github.com/rosenhouse/tubes/mocks"
+)
+
+var _ = Describe("Object operations", func() {
+	var (
+		client   awsclient.Client
+		s3Client *mocks.S3Client
+	)
+
+	BeforeEach(func() {
+		s3Client = &mocks.S3Client{}
+		client = awsclient.Client{
+			S3: s3Client,
+		}
+	})
+
+	Describe("GetObject", func() {
+		It("should return the object contents", func() {
+			s3Client.GetObjectCall.Returns.Output = &s3.GetObjectOutput{
+				Body: ioutil.NopCloser(strings.NewReader("some-contents")),
+			}
+
+			Expect(client.GetObject("some-bucket", "some/key")).To(Equal([]byte("some-contents")))
+			Expect(*s3Client.GetObjectCall.Receives.Input.Bucket).To(Equal("some-bucket"))
+			Expect(*s3Client.GetObjectCall.Receives.Input.Key).To(Equal("some/key"))
+		})
+
+		Context("when the object does not exist", func() {
+			It("should return an error that satisfies os.IsNotExist", func() {
+				s3Client.GetObjectCall.Returns.Error = awserr.NewRequestFailure(
+					awserr.New("NoSuchKey", "The specified key does not exist.", nil), 404, "some-request-id")
+
+				_, err := client.GetObject("some-bucket", "some/key")
+				Expect(os.IsNotExist(err)).To(BeTrue())
+				Expect(err).To(MatchError(ContainSubstring("s3://some-bucket/some/key")))
+			})
+		})
+
+		Context("when the SDK returns another error", func() {
+			It("should return the error", func() {
+				s3Client.GetObjectCall.Returns.Error = errors.New("some error")
+
+				_, err := client.GetObject("some-bucket", "some/key")
+				Expect(err).To(MatchError("some error"))
+			})
+		})
+	})
+
+	Describe("PutObject", func() {
+		It("should upload the contents, encrypted on the server side", func() {
+			Expect(client.PutObject("some-bucket", "some/key", []byte("some-contents"))).To(Succeed())
+
+			input := s3Client.PutObjectCall.Receives.Input
+			Expect(*input.Bucket).To(Equal("some-bucket"))
+			Expect(*input.Key).To(Equal("some/key"))
+			Expect(*input.ServerSideEncryption).To(Equal("AES256"))
+			Expect(ioutil.ReadAll(input.Body)).To(Equal([]byte("some-contents")))
+		})
+
+		Context("when the SDK returns an error", func() {
+			It("should return the error", func() {
+				s3Client.PutObjectCall.Returns.Error = errors.New("some error")
+
+				Expect(client.PutObject("some-bucket", "some/key", nil)).To(MatchError("some error"))
+			})
+		})
+	})
+
+	Describe("DeleteObject", func() {
+		It("should delete the object", func() {
+			Expect(client.DeleteObject("some-bucket", "some/key")).To(Succeed())
+
+			Expect(*s3Client.DeleteObjectCall.Receives.Input.Bucket).To(Equal("some-bucket"))
+			Expect(*s3Client.DeleteObjectCall.Receives.Input.Key).To(Equal("some/key"))
+		})
+
+		Context("when the SDK returns an error", func() {
+			It("should return the error", func() {
+				s3Client.DeleteObjectCall.Returns.Error = errors.New("some error")
+
+				Expect(client.DeleteObject("some-bucket", "some/key")).To(MatchError("some error"))
+			})
+		})
+	})
+
+	Describe("ListObjects", func() {
+		It("should list every key with the prefix, across pages", func() {
+			s3Client.ListObjectsCalls = []mocks.ListObjectsCall{
+				{Output: &s3.ListObjectsOutput{
+					IsTruncated: aws.Bool(true),
+					Contents:    []*s3.Object{{Key: aws.String("some/prefix/a")}, {Key: aws.String("some/prefix/b")}},
+				}},
+				{Output: &s3.ListObjectsOutput{
+					IsTruncated: aws.Bool(false),
+					Contents:    []*s3.Object{{Key: aws.String("some/prefix/c")}},
+				}},
+			}
+
+			Expect(client.ListObjects("some-bucket", "some/prefix/")).To(Equal([]string{"some/prefix/a", "some/prefix/b", "some/prefix/c"}))
+
+			Expect(*s3Client.ListObjectsCalls[0].Input.Prefix).To(Equal("some/prefix/"))
+			Expect(s3Client.ListObjectsCalls[0].Input.Marker).To(BeNil())
+			Expect(*s3Client.ListObjectsCalls[1].Input.Marker).To(Equal("some/prefix/b"))
+		})
+
+		Context("when the SDK returns an error", func() {
+			It("should return the error", func() {
+				s3Client.ListObjectsCalls = []mocks.ListObjectsCall{{Error: errors.New("some error")}}
+
+				_, err := client.ListObjects("some-bucket", "some/prefix/")
+				Expect(err).To(MatchError("some error"))
+			})
+		})
+	})
+})
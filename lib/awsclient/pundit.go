@@ -31,3 +31,18 @@ func (p CloudFormationDeletePundit) IsHealthy(statusString string) bool {
 func (p CloudFormationDeletePundit) IsComplete(statusString string) bool {
 	return CloudFormationUpsertPundit{}.IsComplete(statusString)
 }
+
+// CloudFormationDriftDetectionPundit judges the status of a drift detection, rather than a stack
+type CloudFormationDriftDetectionPundit struct{}
+
+func (p CloudFormationDriftDetectionPundit) IsHealthy(statusString string) bool {
+	switch statusString {
+	case "DETECTION_IN_PROGRESS", "DETECTION_COMPLETE":
+		return true
+	}
+	return false
+}
+
+func (p CloudFormationDriftDetectionPundit) IsComplete(statusString string) bool {
+	return statusString == "DETECTION_COMPLETE"
+}
@@ -149,3 +149,20 @@ var _ = Describe("interpreting status strings for stack upserts", func() {
 		}
 	})
 })
+
+var _ = Describe("interpreting status strings for drift detection", func() {
+	var pundit awsclient.CloudFormationDriftDetectionPundit
+	BeforeEach(func() { pundit = awsclient.CloudFormationDriftDetectionPundit{} })
+
+	It("reports the healthy statuses as such", func() {
+		Expect(pundit.IsHealthy("DETECTION_IN_PROGRESS")).To(BeTrue())
+		Expect(pundit.IsHealthy("DETECTION_COMPLETE")).To(BeTrue())
+		Expect(pundit.IsHealthy("DETECTION_FAILED")).To(BeFalse())
+	})
+
+	It("reports only a finished detection as complete", func() {
+		Expect(pundit.IsComplete("DETECTION_COMPLETE")).To(BeTrue())
+		Expect(pundit.IsComplete("DETECTION_IN_PROGRESS")).To(BeFalse())
+		Expect(pundit.IsComplete("DETECTION_FAILED")).To(BeFalse())
+	})
+})
@@ -0,0 +1,31 @@
+package awsclient
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// TemporaryCredentials are the short-lived keys from assuming a role
+type TemporaryCredentials struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+}
+
+// AssumeRole gets credentials for the role that last an hour, the most
+// that AWS allows
+func (c *Client) AssumeRole(roleARN, sessionName string) (TemporaryCredentials, error) {
+	output, err := c.STS.AssumeRole(&sts.AssumeRoleInput{
+		RoleArn:         aws.String(roleARN),
+		RoleSessionName: aws.String(sessionName),
+		DurationSeconds: aws.Int64(3600),
+	})
+	if err != nil {
+		return TemporaryCredentials{}, err
+	}
+	return TemporaryCredentials{
+		AccessKey:    aws.StringValue(output.Credentials.AccessKeyId),
+		SecretKey:    aws.StringValue(output.Credentials.SecretAccessKey),
+		SessionToken: aws.StringValue(output.Credentials.SessionToken),
+	}, nil
+}
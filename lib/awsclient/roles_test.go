@@ -0,0 +1,62 @@
+package awsclient_test
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/rosenhouse/tubes/lib/awsclient"
+	"github.com/rosenhouse/tubes/mocks"
+)
+
+var _ = Describe("Role operations", func() {
+	var (
+		client    awsclient.Client
+		stsClient *mocks.STSClient
+	)
+
+	BeforeEach(func() {
+		stsClient = &mocks.STSClient{}
+		client = awsclient.Client{
+			STS: stsClient,
+		}
+	})
+
+	Describe("AssumeRole", func() {
+		It("should return hour-long credentials for the role", func() {
+			stsClient.AssumeRoleCall.Returns.Output = &sts.AssumeRoleOutput{
+				Credentials: &sts.Credentials{
+					AccessKeyId:     aws.String("some-access-key"),
+					SecretAccessKey: aws.String("some-secret-key"),
+					SessionToken:    aws.String("some-session-token"),
+				},
+			}
+
+			credentials, err := client.AssumeRole("some-role-arn", "some-session")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(credentials).To(Equal(awsclient.TemporaryCredentials{
+				AccessKey:    "some-access-key",
+				SecretKey:    "some-secret-key",
+				SessionToken: "some-session-token",
+			}))
+
+			input := stsClient.AssumeRoleCall.Receives.Input
+			Expect(*input.RoleArn).To(Equal("some-role-arn"))
+			Expect(*input.RoleSessionName).To(Equal("some-session"))
+			Expect(*input.DurationSeconds).To(Equal(int64(3600)))
+		})
+
+		Context("when the SDK returns an error", func() {
+			It("should return the error", func() {
+				stsClient.AssumeRoleCall.Returns.Error = errors.New("some error")
+
+				_, err := client.AssumeRole("some-role-arn", "some-session")
+				Expect(err).To(MatchError("some error"))
+			})
+		})
+	})
+})
@@ -5,9 +5,16 @@ import (
 	"github.com/aws/aws-sdk-go/service/cloudformation"
 )
 
-func (c *Client) DeleteStack(stackName string) error {
-	_, err := c.CloudFormation.DeleteStack(&cloudformation.DeleteStackInput{
+// DeleteStack deletes the stack, leaving behind the resources with the given
+// logical IDs.  CloudFormation only allows retaining resources of a stack in
+// DELETE_FAILED.
+func (c *Client) DeleteStack(stackName string, retainResources ...string) error {
+	input := &cloudformation.DeleteStackInput{
 		StackName: aws.String(stackName),
-	})
+	}
+	if len(retainResources) > 0 {
+		input.RetainResources = aws.StringSlice(retainResources)
+	}
+	_, err := c.CloudFormation.DeleteStack(input)
 	return err
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math/rand"
 
+	"github.com/aws/aws-sdk-go/aws"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/rosenhouse/tubes/lib/awsclient"
@@ -30,6 +31,13 @@ var _ = Describe("Idempotent delete of a CloudFormation stack", func() {
 		Expect(client.DeleteStack(stackName)).To(Succeed())
 
 		Expect(*cloudFormationClient.DeleteStackCall.Receives.Input.StackName).To(Equal(stackName))
+		Expect(cloudFormationClient.DeleteStackCall.Receives.Input.RetainResources).To(BeNil())
+	})
+
+	It("should pass along the resources to retain", func() {
+		Expect(client.DeleteStack(stackName, "VPC", "BOSHDirectorUser")).To(Succeed())
+
+		Expect(aws.StringValueSlice(cloudFormationClient.DeleteStackCall.Receives.Input.RetainResources)).To(Equal([]string{"VPC", "BOSHDirectorUser"}))
 	})
 
 	Context("when AWS client returns an unrecognized error", func() {
@@ -39,5 +47,4 @@ var _ = Describe("Idempotent delete of a CloudFormation stack", func() {
 			Expect(client.DeleteStack(stackName)).To(MatchError("some error"))
 		})
 	})
-
 })
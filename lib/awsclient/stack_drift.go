@@ -0,0 +1,130 @@
+package awsclient
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"golang.org/x/net/context"
+)
+
+// PropertyDifference is one property of a resource that no longer matches the template
+type PropertyDifference struct {
+	PropertyPath   string
+	ExpectedValue  string
+	ActualValue    string
+	DifferenceType string // one of ADD, REMOVE, NOT_EQUAL
+}
+
+// ResourceDrift is a resource that was changed or deleted outside of CloudFormation
+type ResourceDrift struct {
+	LogicalID    string
+	PhysicalID   string
+	ResourceType string
+	Status       string // one of MODIFIED, DELETED
+	Differences  []PropertyDifference
+}
+
+// StackDrift is the result of drift detection on a stack
+type StackDrift struct {
+	StackName string
+	Status    string // one of IN_SYNC, DRIFTED, NOT_CHECKED
+	Resources []ResourceDrift
+}
+
+// DetectStackDrift runs CloudFormation drift detection on the stack, waits
+// for it to finish, and returns the resources that have drifted
+func (c *Client) DetectStackDrift(ctx context.Context, stackName string) (StackDrift, error) {
+	drift := StackDrift{StackName: stackName}
+
+	output, err := c.CloudFormation.DetectStackDrift(&cloudformation.DetectStackDriftInput{
+		StackName: aws.String(stackName),
+	})
+	if err != nil {
+		return drift, err
+	}
+
+	drift.Status, err = c.waitForDriftDetection(ctx, stackName, aws.StringValue(output.StackDriftDetectionId), CloudFormationDriftDetectionPundit{})
+	if err != nil {
+		return drift, err
+	}
+	if drift.Status != "DRIFTED" {
+		return drift, nil
+	}
+
+	drift.Resources, err = c.describeResourceDrifts(stackName)
+	return drift, err
+}
+
+func (c *Client) waitForDriftDetection(ctx context.Context, stackName, detectionID string, pundit CloudFormationStatusPundit) (string, error) {
+	const sleepDuration = 5 * time.Second
+	elapsed := 0 * time.Second
+
+	for {
+		output, err := c.CloudFormation.DescribeStackDriftDetectionStatus(&cloudformation.DescribeStackDriftDetectionStatusInput{
+			StackDriftDetectionId: aws.String(detectionID),
+		})
+		if err != nil {
+			return "", err
+		}
+
+		status := aws.StringValue(output.DetectionStatus)
+		if !pundit.IsHealthy(status) {
+			return "", fmt.Errorf("drift detection on stack %q failed: %s", stackName, aws.StringValue(output.DetectionStatusReason))
+		}
+		if pundit.IsComplete(status) {
+			return aws.StringValue(output.StackDriftStatus), nil
+		}
+
+		if elapsed >= c.CloudFormationWaitTimeout {
+			return "", fmt.Errorf("timed out waiting for drift detection (max %s, %s).  Check CloudFormation for details.", elapsed, status)
+		}
+		if err := c.Clock.Sleep(ctx, sleepDuration); err != nil {
+			return "", err
+		}
+		elapsed += sleepDuration
+	}
+}
+
+func (c *Client) describeResourceDrifts(stackName string) ([]ResourceDrift, error) {
+	resources := []ResourceDrift{}
+	var nextToken *string
+	for {
+		output, err := c.CloudFormation.DescribeStackResourceDrifts(&cloudformation.DescribeStackResourceDriftsInput{
+			StackName:                       aws.String(stackName),
+			StackResourceDriftStatusFilters: aws.StringSlice([]string{"MODIFIED", "DELETED"}),
+			NextToken:                       nextToken,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, resourceDrift := range output.StackResourceDrifts {
+			resources = append(resources, newResourceDrift(resourceDrift))
+		}
+		if output.NextToken == nil {
+			return resources, nil
+		}
+		nextToken = output.NextToken
+	}
+}
+
+func newResourceDrift(resourceDrift *cloudformation.StackResourceDrift) ResourceDrift {
+	drift := ResourceDrift{
+		LogicalID:    aws.StringValue(resourceDrift.LogicalResourceId),
+		PhysicalID:   aws.StringValue(resourceDrift.PhysicalResourceId),
+		ResourceType: aws.StringValue(resourceDrift.ResourceType),
+		Status:       aws.StringValue(resourceDrift.StackResourceDriftStatus),
+		Differences:  []PropertyDifference{},
+	}
+	for _, difference := range resourceDrift.PropertyDifferences {
+		drift.Differences = append(drift.Differences, PropertyDifference{
+			PropertyPath:   aws.StringValue(difference.PropertyPath),
+			ExpectedValue:  aws.StringValue(difference.ExpectedValue),
+			ActualValue:    aws.StringValue(difference.ActualValue),
+			DifferenceType: aws.StringValue(difference.DifferenceType),
+		})
+	}
+	return drift
+}
@@ -0,0 +1,173 @@
+package awsclient_test
+
+import (
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/rosenhouse/tubes/lib/awsclient"
+	"github.com/rosenhouse/tubes/mocks"
+	"golang.org/x/net/context"
+)
+
+var _ = Describe("Detecting drift of a CloudFormation stack", func() {
+	var (
+		client               awsclient.Client
+		cloudFormationClient *mocks.CloudFormationClient
+		clock                *mocks.Clock
+	)
+
+	BeforeEach(func() {
+		cloudFormationClient = &mocks.CloudFormationClient{}
+		clock = &mocks.Clock{}
+		client = awsclient.Client{
+			CloudFormation:            cloudFormationClient,
+			Clock:                     clock,
+			CloudFormationWaitTimeout: 20 * time.Second,
+		}
+
+		cloudFormationClient.DetectStackDriftCall.Returns.Output = &cloudformation.DetectStackDriftOutput{
+			StackDriftDetectionId: aws.String("some-detection-id"),
+		}
+		cloudFormationClient.DescribeStackDriftDetectionStatusCall.Returns.Output = &cloudformation.DescribeStackDriftDetectionStatusOutput{
+			DetectionStatus:  aws.String("DETECTION_COMPLETE"),
+			StackDriftStatus: aws.String("DRIFTED"),
+		}
+		cloudFormationClient.DescribeStackResourceDriftsCall.Returns.Output = &cloudformation.DescribeStackResourceDriftsOutput{
+			StackResourceDrifts: []*cloudformation.StackResourceDrift{
+				&cloudformation.StackResourceDrift{
+					LogicalResourceId:        aws.String("BOSHSecurityGroup"),
+					PhysicalResourceId:       aws.String("sg-1234"),
+					ResourceType:             aws.String("AWS::EC2::SecurityGroup"),
+					StackResourceDriftStatus: aws.String("MODIFIED"),
+					PropertyDifferences: []*cloudformation.PropertyDifference{
+						&cloudformation.PropertyDifference{
+							PropertyPath:   aws.String("/SecurityGroupIngress/2"),
+							ExpectedValue:  aws.String("null"),
+							ActualValue:    aws.String(`{"CidrIp":"0.0.0.0/0","FromPort":22}`),
+							DifferenceType: aws.String("ADD"),
+						},
+					},
+				},
+				&cloudformation.StackResourceDrift{
+					LogicalResourceId:        aws.String("NATInstance"),
+					PhysicalResourceId:       aws.String("i-1234"),
+					ResourceType:             aws.String("AWS::EC2::Instance"),
+					StackResourceDriftStatus: aws.String("DELETED"),
+				},
+			},
+		}
+	})
+
+	It("should start drift detection on the stack, and describe its result", func() {
+		_, err := client.DetectStackDrift(context.Background(), "some-stack")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(*cloudFormationClient.DetectStackDriftCall.Receives.Input.StackName).To(Equal("some-stack"))
+		Expect(*cloudFormationClient.DescribeStackDriftDetectionStatusCall.Receives.Input.StackDriftDetectionId).To(Equal("some-detection-id"))
+	})
+
+	It("should only ask for the resources that have drifted", func() {
+		_, err := client.DetectStackDrift(context.Background(), "some-stack")
+		Expect(err).NotTo(HaveOccurred())
+
+		input := cloudFormationClient.DescribeStackResourceDriftsCall.Receives.Input
+		Expect(*input.StackName).To(Equal("some-stack"))
+		Expect(aws.StringValueSlice(input.StackResourceDriftStatusFilters)).To(ConsistOf("MODIFIED", "DELETED"))
+	})
+
+	It("should return the drifted resources with their property differences", func() {
+		drift, err := client.DetectStackDrift(context.Background(), "some-stack")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(drift).To(Equal(awsclient.StackDrift{
+			StackName: "some-stack",
+			Status:    "DRIFTED",
+			Resources: []awsclient.ResourceDrift{
+				{
+					LogicalID:    "BOSHSecurityGroup",
+					PhysicalID:   "sg-1234",
+					ResourceType: "AWS::EC2::SecurityGroup",
+					Status:       "MODIFIED",
+					Differences: []awsclient.PropertyDifference{{
+						PropertyPath:   "/SecurityGroupIngress/2",
+						ExpectedValue:  "null",
+						ActualValue:    `{"CidrIp":"0.0.0.0/0","FromPort":22}`,
+						DifferenceType: "ADD",
+					}},
+				},
+				{
+					LogicalID:    "NATInstance",
+					PhysicalID:   "i-1234",
+					ResourceType: "AWS::EC2::Instance",
+					Status:       "DELETED",
+					Differences:  []awsclient.PropertyDifference{},
+				},
+			},
+		}))
+	})
+
+	Context("when the stack is in sync", func() {
+		It("should not describe the resources", func() {
+			cloudFormationClient.DescribeStackDriftDetectionStatusCall.Returns.Output.StackDriftStatus = aws.String("IN_SYNC")
+
+			drift, err := client.DetectStackDrift(context.Background(), "some-stack")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(drift.Status).To(Equal("IN_SYNC"))
+			Expect(drift.Resources).To(BeEmpty())
+			Expect(cloudFormationClient.DescribeStackResourceDriftsCall.Receives.Input).To(BeNil())
+		})
+	})
+
+	Context("when detection fails", func() {
+		It("should return the reason", func() {
+			cloudFormationClient.DescribeStackDriftDetectionStatusCall.Returns.Output.DetectionStatus = aws.String("DETECTION_FAILED")
+			cloudFormationClient.DescribeStackDriftDetectionStatusCall.Returns.Output.DetectionStatusReason = aws.String("some reason")
+
+			_, err := client.DetectStackDrift(context.Background(), "some-stack")
+			Expect(err).To(MatchError(`drift detection on stack "some-stack" failed: some reason`))
+		})
+	})
+
+	Context("when detection never finishes", func() {
+		It("should poll, then time out", func() {
+			cloudFormationClient.DescribeStackDriftDetectionStatusCall.Returns.Output.DetectionStatus = aws.String("DETECTION_IN_PROGRESS")
+
+			_, err := client.DetectStackDrift(context.Background(), "some-stack")
+			Expect(err).To(MatchError(ContainSubstring("timed out waiting for drift detection")))
+			Expect(cloudFormationClient.DescribeStackDriftDetectionStatusCallCount).To(Equal(5))
+			Expect(clock.SleepCalls).To(HaveLen(4))
+		})
+	})
+
+	Context("when starting detection errors", func() {
+		It("should return the error", func() {
+			cloudFormationClient.DetectStackDriftCall.Returns.Error = errors.New("some error")
+
+			_, err := client.DetectStackDrift(context.Background(), "some-stack")
+			Expect(err).To(MatchError("some error"))
+		})
+	})
+
+	Context("when describing the detection status errors", func() {
+		It("should return the error", func() {
+			cloudFormationClient.DescribeStackDriftDetectionStatusCall.Returns.Error = errors.New("some error")
+
+			_, err := client.DetectStackDrift(context.Background(), "some-stack")
+			Expect(err).To(MatchError("some error"))
+		})
+	})
+
+	Context("when describing the resource drifts errors", func() {
+		It("should return the error", func() {
+			cloudFormationClient.DescribeStackResourceDriftsCall.Returns.Error = errors.New("some error")
+
+			_, err := client.DetectStackDrift(context.Background(), "some-stack")
+			Expect(err).To(MatchError("some error"))
+		})
+	})
+})
@@ -0,0 +1,106 @@
+package awsclient
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+// EnvironmentStack is a stack that tubes created, found by its tags
+type EnvironmentStack struct {
+	StackName   string
+	Environment string
+	Role        string
+	Owner       string
+	Status      string
+}
+
+// ListEnvironmentStacks returns every live stack in the account with a tubes
+// environment tag
+func (c *Client) ListEnvironmentStacks() ([]EnvironmentStack, error) {
+	stacks := []EnvironmentStack{}
+	var nextToken *string
+	for {
+		output, err := c.CloudFormation.DescribeStacks(&cloudformation.DescribeStacksInput{
+			NextToken: nextToken,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, stack := range output.Stacks {
+			environment := tagValue(stack.Tags, EnvironmentTag)
+			status := aws.StringValue(stack.StackStatus)
+			if environment == "" || status == "DELETE_COMPLETE" {
+				continue
+			}
+			stacks = append(stacks, EnvironmentStack{
+				StackName:   aws.StringValue(stack.StackName),
+				Environment: environment,
+				Role:        tagValue(stack.Tags, RoleTag),
+				Owner:       tagValue(stack.Tags, OwnerTag),
+				Status:      status,
+			})
+		}
+		if output.NextToken == nil {
+			return stacks, nil
+		}
+		nextToken = output.NextToken
+	}
+}
+
+// ListDeletedBaseStacks returns the base stacks of the named environments with
+// tubes environment and role tags that were deleted recently enough for
+// CloudFormation to still list them, at most one per environment.  It keeps
+// them for 90 days, so only the named environments' stacks are described.
+func (c *Client) ListDeletedBaseStacks(environments []string) ([]EnvironmentStack, error) {
+	stacks := []EnvironmentStack{}
+	wanted := map[string]bool{}
+	for _, environment := range environments {
+		wanted[environment+"-base"] = true
+	}
+
+	var nextToken *string
+	for len(wanted) > 0 {
+		output, err := c.CloudFormation.ListStacks(&cloudformation.ListStacksInput{
+			StackStatusFilter: []*string{aws.String("DELETE_COMPLETE")},
+			NextToken:         nextToken,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, summary := range output.StackSummaries {
+			// summaries have no tags, so only describe the likely candidates.
+			// A deleted stack can only be described by its ID.
+			if !wanted[aws.StringValue(summary.StackName)] {
+				continue
+			}
+			described, err := c.CloudFormation.DescribeStacks(&cloudformation.DescribeStacksInput{
+				StackName: summary.StackId,
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			for _, stack := range described.Stacks {
+				environment := tagValue(stack.Tags, EnvironmentTag)
+				if environment == "" || tagValue(stack.Tags, RoleTag) != "base" {
+					continue
+				}
+				stacks = append(stacks, EnvironmentStack{
+					StackName:   aws.StringValue(stack.StackName),
+					Environment: environment,
+					Role:        "base",
+					Owner:       tagValue(stack.Tags, OwnerTag),
+					Status:      aws.StringValue(stack.StackStatus),
+				})
+				delete(wanted, aws.StringValue(stack.StackName))
+			}
+		}
+		if output.NextToken == nil {
+			break
+		}
+		nextToken = output.NextToken
+	}
+	return stacks, nil
+}
@@ -0,0 +1,186 @@
+package awsclient_test
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/rosenhouse/tubes/lib/awsclient"
+	"github.com/rosenhouse/tubes/mocks"
+)
+
+var _ = Describe("Listing the stacks of tubes environments", func() {
+	var (
+		client               awsclient.Client
+		cloudFormationClient *mocks.CloudFormationClientMultiCall
+	)
+
+	tags := func(keysAndValues ...string) []*cloudformation.Tag {
+		tags := []*cloudformation.Tag{}
+		for i := 0; i < len(keysAndValues); i += 2 {
+			tags = append(tags, &cloudformation.Tag{Key: aws.String(keysAndValues[i]), Value: aws.String(keysAndValues[i+1])})
+		}
+		return tags
+	}
+
+	BeforeEach(func() {
+		cloudFormationClient = mocks.NewCloudFormationClientMultiCall(2)
+		client = awsclient.Client{
+			CloudFormation: cloudFormationClient,
+		}
+
+		cloudFormationClient.DescribeStacksCalls[0].Output = &cloudformation.DescribeStacksOutput{
+			Stacks: []*cloudformation.Stack{
+				&cloudformation.Stack{
+					StackName:   aws.String("alpha-base"),
+					StackStatus: aws.String("UPDATE_COMPLETE"),
+					Tags:        tags("Name", "alpha-base", "tubes:environment", "alpha", "tubes:role", "base", "tubes:owner", "alice@laptop"),
+				},
+				&cloudformation.Stack{
+					StackName:   aws.String("something-else"),
+					StackStatus: aws.String("CREATE_COMPLETE"),
+					Tags:        tags("Name", "something-else"),
+				},
+			},
+			NextToken: aws.String("some-token"),
+		}
+		cloudFormationClient.DescribeStacksCalls[1].Output = &cloudformation.DescribeStacksOutput{
+			Stacks: []*cloudformation.Stack{
+				&cloudformation.Stack{
+					StackName:   aws.String("alpha-concourse"),
+					StackStatus: aws.String("CREATE_COMPLETE"),
+					Tags:        tags("tubes:environment", "alpha", "tubes:role", "concourse"),
+				},
+			},
+		}
+	})
+
+	It("should return the stacks with a tubes environment tag, from every page", func() {
+		stacks, err := client.ListEnvironmentStacks()
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(stacks).To(Equal([]awsclient.EnvironmentStack{
+			{StackName: "alpha-base", Environment: "alpha", Role: "base", Owner: "alice@laptop", Status: "UPDATE_COMPLETE"},
+			{StackName: "alpha-concourse", Environment: "alpha", Role: "concourse", Status: "CREATE_COMPLETE"},
+		}))
+		Expect(cloudFormationClient.DescribeStacksCalls[0].Input.StackName).To(BeNil())
+		Expect(cloudFormationClient.DescribeStacksCalls[1].Input.NextToken).To(Equal(aws.String("some-token")))
+	})
+
+	It("should skip deleted stacks", func() {
+		cloudFormationClient.DescribeStacksCalls[1].Output.Stacks[0].StackStatus = aws.String("DELETE_COMPLETE")
+
+		stacks, err := client.ListEnvironmentStacks()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stacks).To(HaveLen(1))
+	})
+
+	Context("when describing the stacks errors", func() {
+		It("should return the error", func() {
+			cloudFormationClient.DescribeStacksCalls[1].Error = errors.New("some error")
+
+			_, err := client.ListEnvironmentStacks()
+			Expect(err).To(MatchError("some error"))
+		})
+	})
+})
+
+var _ = Describe("Listing deleted base stacks", func() {
+	var (
+		client               awsclient.Client
+		cloudFormationClient *mocks.CloudFormationClient
+	)
+
+	BeforeEach(func() {
+		cloudFormationClient = &mocks.CloudFormationClient{}
+		client = awsclient.Client{
+			CloudFormation: cloudFormationClient,
+		}
+
+		cloudFormationClient.ListStacksCall.Returns.Output = &cloudformation.ListStacksOutput{
+			StackSummaries: []*cloudformation.StackSummary{
+				&cloudformation.StackSummary{
+					StackName:   aws.String("alpha-concourse"),
+					StackId:     aws.String("some-concourse-stack-id"),
+					StackStatus: aws.String("DELETE_COMPLETE"),
+				},
+				&cloudformation.StackSummary{
+					StackName:   aws.String("alpha-base"),
+					StackId:     aws.String("some-base-stack-id"),
+					StackStatus: aws.String("DELETE_COMPLETE"),
+				},
+				&cloudformation.StackSummary{
+					StackName:   aws.String("alpha-base"),
+					StackId:     aws.String("some-earlier-base-stack-id"),
+					StackStatus: aws.String("DELETE_COMPLETE"),
+				},
+			},
+		}
+		cloudFormationClient.DescribeStacksCall.Returns.Output = &cloudformation.DescribeStacksOutput{
+			Stacks: []*cloudformation.Stack{
+				&cloudformation.Stack{
+					StackName:   aws.String("alpha-base"),
+					StackStatus: aws.String("DELETE_COMPLETE"),
+					Tags: []*cloudformation.Tag{
+						{Key: aws.String("tubes:environment"), Value: aws.String("alpha")},
+						{Key: aws.String("tubes:role"), Value: aws.String("base")},
+					},
+				},
+			},
+		}
+	})
+
+	It("should describe the deleted base stacks of the named environments by ID, and return one tagged by tubes for each", func() {
+		stacks, err := client.ListDeletedBaseStacks([]string{"alpha"})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(stacks).To(Equal([]awsclient.EnvironmentStack{
+			{StackName: "alpha-base", Environment: "alpha", Role: "base", Status: "DELETE_COMPLETE"},
+		}))
+		Expect(cloudFormationClient.ListStacksCall.Receives.Input.StackStatusFilter).To(Equal([]*string{aws.String("DELETE_COMPLETE")}))
+		Expect(cloudFormationClient.DescribeStacksCall.Receives.Input.StackName).To(Equal(aws.String("some-base-stack-id")))
+	})
+
+	It("should not describe the stacks of other environments", func() {
+		stacks, err := client.ListDeletedBaseStacks([]string{"beta"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stacks).To(BeEmpty())
+		Expect(cloudFormationClient.DescribeStacksCall.Receives.Input).To(BeNil())
+	})
+
+	It("should not list the deleted stacks when no environments are named", func() {
+		stacks, err := client.ListDeletedBaseStacks(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stacks).To(BeEmpty())
+		Expect(cloudFormationClient.ListStacksCall.Receives.Input).To(BeNil())
+	})
+
+	It("should skip stacks that merely have a base name, without the tags", func() {
+		cloudFormationClient.DescribeStacksCall.Returns.Output.Stacks[0].Tags = nil
+
+		stacks, err := client.ListDeletedBaseStacks([]string{"alpha"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stacks).To(BeEmpty())
+	})
+
+	Context("when listing the stacks errors", func() {
+		It("should return the error", func() {
+			cloudFormationClient.ListStacksCall.Returns.Error = errors.New("some error")
+
+			_, err := client.ListDeletedBaseStacks([]string{"alpha"})
+			Expect(err).To(MatchError("some error"))
+		})
+	})
+
+	Context("when describing a stack errors", func() {
+		It("should return the error", func() {
+			cloudFormationClient.DescribeStacksCall.Returns.Error = errors.New("some error")
+
+			_, err := client.ListDeletedBaseStacks([]string{"alpha"})
+			Expect(err).To(MatchError("some error"))
+		})
+	})
+})
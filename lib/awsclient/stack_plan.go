@@ -0,0 +1,189 @@
+package awsclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"golang.org/x/net/context"
+)
+
+const planChangeSetName = "tubes-plan"
+
+// StackChange describes a single resource that an upsert would touch
+type StackChange struct {
+	Action       string // one of Add, Modify, Replace, Remove
+	LogicalID    string
+	ResourceType string
+}
+
+// StackPlan is the set of changes an upsert of the stack would make
+type StackPlan struct {
+	StackName string
+	NewStack  bool
+	Changes   []StackChange
+}
+
+// PlanStack reports what UpsertStack would do with the given template and
+// parameters, without changing anything.  For an existing stack this creates,
+// describes and deletes a CloudFormation change set.
+func (c *Client) PlanStack(ctx context.Context, stackName string, template string, parameters map[string]string) (plan StackPlan, err error) {
+	plan = StackPlan{StackName: stackName}
+
+	status, err := c.describeStackStatus(stackName)
+	if err != nil {
+		return plan, err
+	}
+
+	if status == "" {
+		plan.NewStack = true
+		plan.Changes, err = templateResources(template)
+		return plan, err
+	}
+
+	if err := checkStackIsUpdatable(stackName, status); err != nil {
+		return plan, err
+	}
+
+	// a plan that crashed part way through may have left its change set behind
+	err = c.deleteChangeSet(stackName)
+	if err != nil {
+		return plan, err
+	}
+
+	_, err = c.CloudFormation.CreateChangeSet(&cloudformation.CreateChangeSetInput{
+		StackName:     aws.String(stackName),
+		ChangeSetName: aws.String(planChangeSetName),
+		TemplateBody:  aws.String(template),
+		Parameters:    formatParameters(parameters),
+		Capabilities:  []*string{aws.String("CAPABILITY_IAM")},
+	})
+	if err != nil {
+		return plan, err
+	}
+	defer func() {
+		deleteErr := c.deleteChangeSet(stackName)
+		if err == nil {
+			err = deleteErr
+		}
+	}()
+
+	plan.Changes, err = c.describeChangeSet(ctx, stackName)
+	return plan, err
+}
+
+// deleteChangeSet deletes the plan's change set, if there is one
+func (c *Client) deleteChangeSet(stackName string) error {
+	_, err := c.CloudFormation.DeleteChangeSet(&cloudformation.DeleteChangeSetInput{
+		StackName:     aws.String(stackName),
+		ChangeSetName: aws.String(planChangeSetName),
+	})
+	if isChangeSetNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func isChangeSetNotFound(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && awsErr.Code() == "ChangeSetNotFound"
+}
+
+func changeSetHasNoChanges(reason string) bool {
+	return strings.Contains(reason, "didn't contain changes") ||
+		strings.Contains(reason, "No updates are to be performed")
+}
+
+func (c *Client) describeChangeSet(ctx context.Context, stackName string) ([]StackChange, error) {
+	const sleepDuration = 5 * time.Second
+	elapsed := 0 * time.Second
+
+	changes := []StackChange{}
+	var nextToken *string
+	for {
+		output, err := c.CloudFormation.DescribeChangeSet(&cloudformation.DescribeChangeSetInput{
+			StackName:     aws.String(stackName),
+			ChangeSetName: aws.String(planChangeSetName),
+			NextToken:     nextToken,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		status := aws.StringValue(output.Status)
+		switch status {
+		case "CREATE_COMPLETE":
+			for _, change := range output.Changes {
+				changes = append(changes, newStackChange(change.ResourceChange))
+			}
+			if output.NextToken == nil {
+				return changes, nil
+			}
+			nextToken = output.NextToken
+			continue
+		case "FAILED":
+			reason := aws.StringValue(output.StatusReason)
+			if changeSetHasNoChanges(reason) {
+				return changes, nil
+			}
+			return nil, fmt.Errorf("planning changes to stack %q failed: %s", stackName, reason)
+		}
+
+		if elapsed >= c.CloudFormationWaitTimeout {
+			return nil, fmt.Errorf("timed out waiting for change set (max %s, %s).  Check CloudFormation for details.", elapsed, status)
+		}
+		if err := c.Clock.Sleep(ctx, sleepDuration); err != nil {
+			return nil, err
+		}
+		elapsed += sleepDuration
+	}
+}
+
+func newStackChange(resourceChange *cloudformation.ResourceChange) StackChange {
+	if resourceChange == nil {
+		return StackChange{}
+	}
+	action := aws.StringValue(resourceChange.Action)
+	if action == "Modify" && aws.StringValue(resourceChange.Replacement) == "True" {
+		action = "Replace"
+	}
+	return StackChange{
+		Action:       action,
+		LogicalID:    aws.StringValue(resourceChange.LogicalResourceId),
+		ResourceType: aws.StringValue(resourceChange.ResourceType),
+	}
+}
+
+// templateResources lists every resource in the template as an addition
+func templateResources(template string) ([]StackChange, error) {
+	var parsed struct {
+		Resources map[string]struct {
+			Type string
+		}
+	}
+	err := json.Unmarshal([]byte(template), &parsed)
+	if err != nil {
+		return nil, fmt.Errorf("parsing template: %s", err)
+	}
+
+	logicalIDs := []string{}
+	for logicalID := range parsed.Resources {
+		logicalIDs = append(logicalIDs, logicalID)
+	}
+	sort.Strings(logicalIDs)
+
+	changes := []StackChange{}
+	for _, logicalID := range logicalIDs {
+		changes = append(changes, StackChange{
+			Action:       "Add",
+			LogicalID:    logicalID,
+			ResourceType: parsed.Resources[logicalID].Type,
+		})
+	}
+	return changes, nil
+}
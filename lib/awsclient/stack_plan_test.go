@@ -0,0 +1,245 @@
+package awsclient_test
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/rosenhouse/tubes/lib/awsclient"
+	"github.com/rosenhouse/tubes/mocks"
+	"golang.org/x/net/context"
+)
+
+var _ = Describe("Planning changes to a CloudFormation stack", func() {
+	var (
+		client               awsclient.Client
+		cloudFormationClient *mocks.CloudFormationClient
+		clock                *mocks.Clock
+		stackName            string
+		template             string
+		parameters           map[string]string
+	)
+
+	BeforeEach(func() {
+		cloudFormationClient = &mocks.CloudFormationClient{}
+		clock = &mocks.Clock{}
+		client = awsclient.Client{
+			CloudFormation:            cloudFormationClient,
+			Clock:                     clock,
+			CloudFormationWaitTimeout: 20 * time.Second,
+		}
+		stackName = fmt.Sprintf("some-stack-%x", rand.Int31()>>16)
+		template = `{ "Resources": { "SomeVPC": { "Type": "AWS::EC2::VPC" }, "AnInstance": { "Type": "AWS::EC2::Instance" } } }`
+		parameters = map[string]string{"a": "b"}
+
+		cloudFormationClient.DescribeStacksCall.Returns.Output = &cloudformation.DescribeStacksOutput{
+			Stacks: []*cloudformation.Stack{
+				&cloudformation.Stack{
+					StackStatus: aws.String("UPDATE_COMPLETE"),
+				},
+			},
+		}
+		cloudFormationClient.DescribeChangeSetCall.Returns.Output = &cloudformation.DescribeChangeSetOutput{
+			Status: aws.String("CREATE_COMPLETE"),
+			Changes: []*cloudformation.Change{
+				&cloudformation.Change{ResourceChange: &cloudformation.ResourceChange{
+					Action:            aws.String("Add"),
+					LogicalResourceId: aws.String("NewThing"),
+					ResourceType:      aws.String("AWS::EC2::Subnet"),
+				}},
+				&cloudformation.Change{ResourceChange: &cloudformation.ResourceChange{
+					Action:            aws.String("Modify"),
+					Replacement:       aws.String("False"),
+					LogicalResourceId: aws.String("ChangedThing"),
+					ResourceType:      aws.String("AWS::EC2::SecurityGroup"),
+				}},
+				&cloudformation.Change{ResourceChange: &cloudformation.ResourceChange{
+					Action:            aws.String("Modify"),
+					Replacement:       aws.String("True"),
+					LogicalResourceId: aws.String("ReplacedThing"),
+					ResourceType:      aws.String("AWS::EC2::Instance"),
+				}},
+			},
+		}
+	})
+
+	Context("when the stack does not exist", func() {
+		BeforeEach(func() {
+			cloudFormationClient.DescribeStacksCall.Returns.Output = nil
+			cloudFormationClient.DescribeStacksCall.Returns.Error = awserr.NewRequestFailure(
+				awserr.New("ValidationError", "Stack with id STACKNAMEHERE does not exist", nil),
+				400, "some-request-id")
+		})
+
+		It("should list every resource in the template as an addition, without creating a change set", func() {
+			plan, err := client.PlanStack(context.Background(), stackName, template, parameters)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(plan.StackName).To(Equal(stackName))
+			Expect(plan.NewStack).To(BeTrue())
+			Expect(plan.Changes).To(Equal([]awsclient.StackChange{
+				{Action: "Add", LogicalID: "AnInstance", ResourceType: "AWS::EC2::Instance"},
+				{Action: "Add", LogicalID: "SomeVPC", ResourceType: "AWS::EC2::VPC"},
+			}))
+			Expect(cloudFormationClient.CreateChangeSetCall.Receives.Input).To(BeNil())
+		})
+
+		Context("when the template is malformed", func() {
+			It("should return an error", func() {
+				_, err := client.PlanStack(context.Background(), stackName, "nope", parameters)
+				Expect(err).To(MatchError(ContainSubstring("parsing template")))
+			})
+		})
+	})
+
+	Context("when the stack exists", func() {
+		It("should create a change set with the template and parameters", func() {
+			_, err := client.PlanStack(context.Background(), stackName, template, parameters)
+			Expect(err).NotTo(HaveOccurred())
+
+			input := cloudFormationClient.CreateChangeSetCall.Receives.Input
+			Expect(*input.StackName).To(Equal(stackName))
+			Expect(*input.ChangeSetName).To(Equal("tubes-plan"))
+			Expect(*input.TemplateBody).To(Equal(template))
+			Expect(input.Parameters).To(ConsistOf(
+				[]*cloudformation.Parameter{
+					&cloudformation.Parameter{ParameterKey: aws.String("a"), ParameterValue: aws.String("b")},
+				}))
+			Expect(input.Capabilities).To(Equal([]*string{aws.String("CAPABILITY_IAM")}))
+		})
+
+		It("should return the added, modified and replaced resources", func() {
+			plan, err := client.PlanStack(context.Background(), stackName, template, parameters)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(plan.NewStack).To(BeFalse())
+			Expect(plan.Changes).To(Equal([]awsclient.StackChange{
+				{Action: "Add", LogicalID: "NewThing", ResourceType: "AWS::EC2::Subnet"},
+				{Action: "Modify", LogicalID: "ChangedThing", ResourceType: "AWS::EC2::SecurityGroup"},
+				{Action: "Replace", LogicalID: "ReplacedThing", ResourceType: "AWS::EC2::Instance"},
+			}))
+		})
+
+		It("should delete the change set afterwards", func() {
+			_, err := client.PlanStack(context.Background(), stackName, template, parameters)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(*cloudFormationClient.DescribeChangeSetCall.Receives.Input.ChangeSetName).To(Equal("tubes-plan"))
+			Expect(*cloudFormationClient.DeleteChangeSetCall.Receives.Input.StackName).To(Equal(stackName))
+			Expect(*cloudFormationClient.DeleteChangeSetCall.Receives.Input.ChangeSetName).To(Equal("tubes-plan"))
+		})
+
+		It("should first delete any change set left behind by an earlier plan", func() {
+			_, err := client.PlanStack(context.Background(), stackName, template, parameters)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(cloudFormationClient.DeleteChangeSetCallCount).To(Equal(2))
+		})
+
+		Context("when there is no change set to delete", func() {
+			It("should carry on", func() {
+				cloudFormationClient.DeleteChangeSetCall.Returns.Error = awserr.NewRequestFailure(
+					awserr.New("ChangeSetNotFound", "ChangeSet [tubes-plan] does not exist", nil),
+					404, "some-request-id")
+
+				plan, err := client.PlanStack(context.Background(), stackName, template, parameters)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(plan.Changes).To(HaveLen(3))
+			})
+		})
+
+		Context("when the stack is not in a state that can be updated", func() {
+			It("should return an error without creating a change set", func() {
+				cloudFormationClient.DescribeStacksCall.Returns.Output.Stacks[0].StackStatus = aws.String("UPDATE_IN_PROGRESS")
+
+				_, err := client.PlanStack(context.Background(), stackName, template, parameters)
+				Expect(err).To(MatchError(fmt.Sprintf(`refusing to update stack %q, status "UPDATE_IN_PROGRESS"`, stackName)))
+				Expect(cloudFormationClient.CreateChangeSetCall.Receives.Input).To(BeNil())
+			})
+		})
+
+		Context("when the change set fails because there are no changes", func() {
+			It("should return an empty list of changes", func() {
+				cloudFormationClient.DescribeChangeSetCall.Returns.Output = &cloudformation.DescribeChangeSetOutput{
+					Status:       aws.String("FAILED"),
+					StatusReason: aws.String("The submitted information didn't contain changes. Submit different information to create a change set."),
+				}
+
+				plan, err := client.PlanStack(context.Background(), stackName, template, parameters)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(plan.Changes).To(BeEmpty())
+				Expect(cloudFormationClient.DeleteChangeSetCall.Receives.Input).NotTo(BeNil())
+			})
+		})
+
+		Context("when the change set fails for some other reason", func() {
+			It("should return the reason and still delete the change set", func() {
+				cloudFormationClient.DescribeChangeSetCall.Returns.Output = &cloudformation.DescribeChangeSetOutput{
+					Status:       aws.String("FAILED"),
+					StatusReason: aws.String("some reason"),
+				}
+
+				_, err := client.PlanStack(context.Background(), stackName, template, parameters)
+				Expect(err).To(MatchError(fmt.Sprintf(`planning changes to stack %q failed: some reason`, stackName)))
+				Expect(cloudFormationClient.DeleteChangeSetCall.Receives.Input).NotTo(BeNil())
+			})
+		})
+
+		Context("when the change set never finishes being created", func() {
+			It("should time out", func() {
+				cloudFormationClient.DescribeChangeSetCall.Returns.Output.Status = aws.String("CREATE_IN_PROGRESS")
+
+				_, err := client.PlanStack(context.Background(), stackName, template, parameters)
+				Expect(err).To(MatchError(ContainSubstring("timed out waiting for change set")))
+				Expect(cloudFormationClient.DescribeChangeSetCallCount).To(Equal(5))
+				Expect(clock.SleepCalls).To(HaveLen(4))
+			})
+		})
+
+		Context("when creating the change set errors", func() {
+			It("should return the error", func() {
+				cloudFormationClient.CreateChangeSetCall.Returns.Error = errors.New("some error")
+
+				_, err := client.PlanStack(context.Background(), stackName, template, parameters)
+				Expect(err).To(MatchError("some error"))
+				Expect(cloudFormationClient.DeleteChangeSetCallCount).To(Equal(1))
+			})
+		})
+
+		Context("when describing the change set errors", func() {
+			It("should return the error and still delete the change set", func() {
+				cloudFormationClient.DescribeChangeSetCall.Returns.Error = errors.New("some error")
+
+				_, err := client.PlanStack(context.Background(), stackName, template, parameters)
+				Expect(err).To(MatchError("some error"))
+				Expect(cloudFormationClient.DeleteChangeSetCallCount).To(Equal(2))
+			})
+		})
+
+		Context("when deleting the change set errors", func() {
+			It("should return the error without creating another", func() {
+				cloudFormationClient.DeleteChangeSetCall.Returns.Error = errors.New("some error")
+
+				_, err := client.PlanStack(context.Background(), stackName, template, parameters)
+				Expect(err).To(MatchError("some error"))
+				Expect(cloudFormationClient.CreateChangeSetCall.Receives.Input).To(BeNil())
+			})
+		})
+	})
+
+	Context("when describing the stack errors", func() {
+		It("should return the error", func() {
+			cloudFormationClient.DescribeStacksCall.Returns.Error = errors.New("some error")
+
+			_, err := client.PlanStack(context.Background(), stackName, template, parameters)
+			Expect(err).To(MatchError("some error"))
+		})
+	})
+})
@@ -20,17 +20,50 @@ func formatParameters(parameters map[string]string) []*cloudformation.Parameter
 	return parameterSlice
 }
 
+const (
+	// EnvironmentTag and RoleTag identify the stacks that tubes creates
+	EnvironmentTag = "tubes:environment"
+	RoleTag        = "tubes:role"
+
+	// OwnerTag records who created the stack
+	OwnerTag = "tubes:owner"
+)
+
+// stackTags tags a stack named <environment>-<role>, as tubes names its stacks
+func stackTags(stackName, owner string) []*cloudformation.Tag {
+	tags := []*cloudformation.Tag{
+		&cloudformation.Tag{
+			Key:   aws.String("Name"),
+			Value: aws.String(stackName),
+		},
+	}
+	if i := strings.LastIndex(stackName, "-"); i > 0 {
+		tags = append(tags,
+			&cloudformation.Tag{Key: aws.String(EnvironmentTag), Value: aws.String(stackName[:i])},
+			&cloudformation.Tag{Key: aws.String(RoleTag), Value: aws.String(stackName[i+1:])},
+		)
+	}
+	if owner != "" {
+		tags = append(tags, &cloudformation.Tag{Key: aws.String(OwnerTag), Value: aws.String(owner)})
+	}
+	return tags
+}
+
+func tagValue(tags []*cloudformation.Tag, key string) string {
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) == key {
+			return aws.StringValue(tag.Value)
+		}
+	}
+	return ""
+}
+
 func (c *Client) createStack(stackName string, template string, parameters map[string]string) error {
 	_, err := c.CloudFormation.CreateStack(&cloudformation.CreateStackInput{
 		StackName:    aws.String(stackName),
 		TemplateBody: aws.String(template),
 		Parameters:   formatParameters(parameters),
-		Tags: []*cloudformation.Tag{
-			&cloudformation.Tag{
-				Key:   aws.String("Name"),
-				Value: aws.String(stackName),
-			},
-		},
+		Tags:         stackTags(stackName, c.Owner),
 		Capabilities: []*string{aws.String("CAPABILITY_IAM")},
 	})
 	return err
@@ -47,11 +80,12 @@ func errorIsBecauseNoOp(err error) bool {
 	return false
 }
 
-func (c *Client) updateStack(stackName string, template string, parameters map[string]string) error {
+func (c *Client) updateStack(stackName string, template string, parameters map[string]string, tags []*cloudformation.Tag) error {
 	_, err := c.CloudFormation.UpdateStack(&cloudformation.UpdateStackInput{
 		StackName:    aws.String(stackName),
 		TemplateBody: aws.String(template),
 		Parameters:   formatParameters(parameters),
+		Tags:         tags,
 		Capabilities: []*string{aws.String("CAPABILITY_IAM")},
 	})
 	if errorIsBecauseNoOp(err) {
@@ -68,22 +102,71 @@ func errorIsBecauseStackDoesNotExist(err error) bool {
 	return awsErr.Code() == "ValidationError" && strings.Contains(awsErr.Message(), "does not exist")
 }
 
-func (c *Client) UpsertStack(stackName string, template string, parameters map[string]string) error {
+// describeStackStatus returns the status of the named stack, or "" if it does
+// not exist or has been deleted
+func (c *Client) describeStackStatus(stackName string) (string, error) {
+	stack, err := c.describeStack(stackName)
+	if err != nil || stack == nil {
+		return "", err
+	}
+	return *stack.StackStatus, nil
+}
+
+// describeStack returns the named stack, or nil if it does not exist or has
+// been deleted
+func (c *Client) describeStack(stackName string) (*cloudformation.Stack, error) {
 	output, err := c.CloudFormation.DescribeStacks(&cloudformation.DescribeStacksInput{
 		StackName: aws.String(stackName),
 	})
 	if err != nil {
 		if errorIsBecauseStackDoesNotExist(err) {
-			return c.createStack(stackName, template, parameters)
+			return nil, nil
 		}
-		return err
+		return nil, err
 	}
 
-	status := *output.Stacks[0].StackStatus
+	stack := output.Stacks[0]
+	if *stack.StackStatus == "DELETE_COMPLETE" {
+		return nil, nil
+	}
+	return stack, nil
+}
+
+func checkStackIsUpdatable(stackName, status string) error {
 	pundit := CloudFormationUpsertPundit{}
 	if pundit.IsHealthy(status) && pundit.IsComplete(status) {
-		return c.updateStack(stackName, template, parameters)
+		return nil
 	}
-
 	return fmt.Errorf("refusing to update stack %q, status %q", stackName, status)
 }
+
+// UpsertStack creates or updates the stack.  Updates also tag stacks from
+// before tubes tagged them, keeping any recorded owner.
+func (c *Client) UpsertStack(stackName string, template string, parameters map[string]string) error {
+	stack, err := c.describeStack(stackName)
+	if err != nil {
+		return err
+	}
+
+	if stack == nil {
+		return c.createStack(stackName, template, parameters)
+	}
+
+	if err := checkStackIsUpdatable(stackName, *stack.StackStatus); err != nil {
+		return err
+	}
+	return c.updateStack(stackName, template, parameters, stackTags(stackName, tagValue(stack.Tags, OwnerTag)))
+}
+
+func (c *Client) StackExists(stackName string) (bool, error) {
+	status, err := c.describeStackStatus(stackName)
+	if err != nil {
+		return false, err
+	}
+	return status != "", nil
+}
+
+// StackStatus returns the status of the named stack, or "" if it does not exist
+func (c *Client) StackStatus(stackName string) (string, error) {
+	return c.describeStackStatus(stackName)
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -29,7 +30,7 @@ var _ = Describe("Idempotent upsert of a CloudFormation stack", func() {
 		client = awsclient.Client{
 			CloudFormation: cloudFormationClient,
 		}
-		stackName = fmt.Sprintf("some-stack-%x", rand.Int31()>>16)
+		stackName = fmt.Sprintf("some-env-%x-base", rand.Int31()>>16)
 		template = fmt.Sprintf(`{ "some": "template" }`)
 		parameters = map[string]string{"a": "b", "c": "d", "e": "f"}
 
@@ -67,13 +68,29 @@ var _ = Describe("Idempotent upsert of a CloudFormation stack", func() {
 					&cloudformation.Parameter{ParameterKey: aws.String("c"), ParameterValue: aws.String("d")},
 					&cloudformation.Parameter{ParameterKey: aws.String("e"), ParameterValue: aws.String("f")},
 				}))
+			Expect(cloudFormationClient.CreateStackCall.Receives.Input.Capabilities).To(Equal([]*string{aws.String("CAPABILITY_IAM")}))
+
+			Expect(cloudFormationClient.UpdateStackCall.Receives.Input).To(BeNil())
+		})
+
+		It("should tag the stack with its name, environment and role", func() {
+			Expect(client.UpsertStack(stackName, template, parameters)).To(Succeed())
+
 			Expect(cloudFormationClient.CreateStackCall.Receives.Input.Tags).To(ConsistOf(
 				[]*cloudformation.Tag{
 					&cloudformation.Tag{Key: aws.String("Name"), Value: aws.String(stackName)},
+					&cloudformation.Tag{Key: aws.String("tubes:environment"), Value: aws.String(strings.TrimSuffix(stackName, "-base"))},
+					&cloudformation.Tag{Key: aws.String("tubes:role"), Value: aws.String("base")},
 				}))
-			Expect(cloudFormationClient.CreateStackCall.Receives.Input.Capabilities).To(Equal([]*string{aws.String("CAPABILITY_IAM")}))
+		})
 
-			Expect(cloudFormationClient.UpdateStackCall.Receives.Input).To(BeNil())
+		It("should tag the stack with its owner, if the client has one", func() {
+			client.Owner = "alice@laptop"
+
+			Expect(client.UpsertStack(stackName, template, parameters)).To(Succeed())
+
+			Expect(cloudFormationClient.CreateStackCall.Receives.Input.Tags).To(ContainElement(
+				&cloudformation.Tag{Key: aws.String("tubes:owner"), Value: aws.String("alice@laptop")}))
 		})
 
 		Context("when creating the stack fails", func() {
@@ -108,6 +125,20 @@ var _ = Describe("Idempotent upsert of a CloudFormation stack", func() {
 				Expect(cloudFormationClient.UpdateStackCall.Receives.Input.Capabilities).To(Equal([]*string{aws.String("CAPABILITY_IAM")}))
 			})
 
+			It("should tag the stack, keeping the owner it was created by", func() {
+				client.Owner = "bob@desktop"
+				cloudFormationClient.DescribeStacksCall.Returns.Output.Stacks[0].Tags = []*cloudformation.Tag{
+					&cloudformation.Tag{Key: aws.String("tubes:owner"), Value: aws.String("alice@laptop")},
+				}
+
+				Expect(client.UpsertStack(stackName, template, parameters)).To(Succeed())
+
+				tags := cloudFormationClient.UpdateStackCall.Receives.Input.Tags
+				Expect(tags).To(ContainElement(&cloudformation.Tag{Key: aws.String("tubes:role"), Value: aws.String("base")}))
+				Expect(tags).To(ContainElement(&cloudformation.Tag{Key: aws.String("tubes:owner"), Value: aws.String("alice@laptop")}))
+				Expect(tags).NotTo(ContainElement(&cloudformation.Tag{Key: aws.String("tubes:owner"), Value: aws.String("bob@desktop")}))
+			})
+
 			Context("when UpdateStack returns an error because there are no changes", func() {
 				BeforeEach(func() {
 					cloudFormationClient.UpdateStackCall.Returns.Error = awserr.NewRequestFailure(
@@ -207,3 +238,57 @@ var _ = Describe("Idempotent upsert of a CloudFormation stack", func() {
 		})
 	})
 })
+
+var _ = Describe("Getting the status of a stack", func() {
+	var (
+		client               awsclient.Client
+		cloudFormationClient *mocks.CloudFormationClient
+	)
+
+	BeforeEach(func() {
+		cloudFormationClient = &mocks.CloudFormationClient{}
+		client = awsclient.Client{
+			CloudFormation: cloudFormationClient,
+		}
+		cloudFormationClient.DescribeStacksCall.Returns.Output = &cloudformation.DescribeStacksOutput{
+			Stacks: []*cloudformation.Stack{
+				&cloudformation.Stack{
+					StackStatus: aws.String("UPDATE_COMPLETE"),
+				},
+			},
+		}
+	})
+
+	It("should return the status", func() {
+		Expect(client.StackStatus("some-stack")).To(Equal("UPDATE_COMPLETE"))
+		Expect(*cloudFormationClient.DescribeStacksCall.Receives.Input.StackName).To(Equal("some-stack"))
+	})
+
+	Context("when the stack does not exist", func() {
+		It("should return an empty status", func() {
+			cloudFormationClient.DescribeStacksCall.Returns.Output = nil
+			cloudFormationClient.DescribeStacksCall.Returns.Error = awserr.NewRequestFailure(
+				awserr.New("ValidationError", "Stack with id some-stack does not exist", nil),
+				400, "some-request-id")
+
+			Expect(client.StackStatus("some-stack")).To(BeEmpty())
+		})
+	})
+
+	Context("when the stack has been deleted", func() {
+		It("should return an empty status", func() {
+			cloudFormationClient.DescribeStacksCall.Returns.Output.Stacks[0].StackStatus = aws.String("DELETE_COMPLETE")
+
+			Expect(client.StackStatus("some-stack")).To(BeEmpty())
+		})
+	})
+
+	Context("when describing the stack fails", func() {
+		It("should return the error", func() {
+			cloudFormationClient.DescribeStacksCall.Returns.Error = errors.New("some error")
+
+			_, err := client.StackStatus("some-stack")
+			Expect(err).To(MatchError("some error"))
+		})
+	})
+})
@@ -2,10 +2,12 @@ package awsclient
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"golang.org/x/net/context"
 )
 
 type CloudFormationStatusPundit interface {
@@ -13,12 +15,15 @@ type CloudFormationStatusPundit interface {
 	IsComplete(statusString string) bool
 }
 
-func (c *Client) WaitForStack(stackName string, pundit CloudFormationStatusPundit) error {
+// WaitForStack polls the stack until the pundit finds its status complete or
+// unhealthy, logging its events.  It stops early once ctx is cancelled.
+func (c *Client) WaitForStack(ctx context.Context, stackName string, pundit CloudFormationStatusPundit) error {
 	const sleepDuration = 5 * time.Second
 	elapsed := 0 * time.Second
 
 	var status string
 	var stackId string = stackName
+	var events *stackEventStream
 
 	for {
 		output, err := c.CloudFormation.DescribeStacks(&cloudformation.DescribeStacksInput{
@@ -29,20 +34,109 @@ func (c *Client) WaitForStack(stackName string, pundit CloudFormationStatusPundi
 		}
 		if stackId == stackName {
 			stackId = *output.Stacks[0].StackId
+			events = &stackEventStream{client: c, stackID: stackId, seen: map[string]bool{}}
 		}
 
 		status = *output.Stacks[0].StackStatus
 		if !pundit.IsHealthy(status) {
+			if err := events.poll(); err != nil {
+				return err
+			}
+			if failure := events.firstFailure; failure != nil {
+				return fmt.Errorf("stack %q has unhealthy status %q: %s %s: %s", stackName, status,
+					aws.StringValue(failure.LogicalResourceId),
+					aws.StringValue(failure.ResourceStatus),
+					aws.StringValue(failure.ResourceStatusReason))
+			}
 			return fmt.Errorf("stack %q has unhealthy status %q", stackName, status)
 		}
 		if pundit.IsComplete(status) {
-			return nil
+			if elapsed == 0 {
+				return nil // already done, any events belong to an earlier change
+			}
+			return events.poll()
+		}
+		if err := events.poll(); err != nil {
+			return err
 		}
 
 		if elapsed >= c.CloudFormationWaitTimeout {
 			return fmt.Errorf("timed out waiting for stack change to complete (max %s, %s).  Check CloudFormation for details.", elapsed, status)
 		}
-		c.Clock.Sleep(sleepDuration)
+		if err := c.Clock.Sleep(ctx, sleepDuration); err != nil {
+			return fmt.Errorf("stopped waiting for stack %q at status %q: %s", stackName, status, err)
+		}
 		elapsed += sleepDuration
 	}
 }
+
+// stackEventStream logs each stack event of the current stack operation once, oldest first
+type stackEventStream struct {
+	client       *Client
+	stackID      string
+	seen         map[string]bool
+	firstFailure *cloudformation.StackEvent
+}
+
+// isOperationStart is true for the stack-level event that begins a create, update or delete
+func isOperationStart(event *cloudformation.StackEvent) bool {
+	if aws.StringValue(event.LogicalResourceId) != aws.StringValue(event.StackName) {
+		return false
+	}
+	switch aws.StringValue(event.ResourceStatus) {
+	case "CREATE_IN_PROGRESS", "UPDATE_IN_PROGRESS", "DELETE_IN_PROGRESS":
+		return true
+	}
+	return false
+}
+
+func (s *stackEventStream) poll() error {
+	newEvents := []*cloudformation.StackEvent{}
+	var nextToken *string
+
+	// events are returned newest first, so read back until we reach one we've
+	// already logged, or the start of the current operation
+pages:
+	for {
+		output, err := s.client.CloudFormation.DescribeStackEvents(&cloudformation.DescribeStackEventsInput{
+			StackName: aws.String(s.stackID),
+			NextToken: nextToken,
+		})
+		if err != nil {
+			return err
+		}
+		for _, event := range output.StackEvents {
+			if s.seen[aws.StringValue(event.EventId)] {
+				break pages
+			}
+			newEvents = append(newEvents, event)
+			if isOperationStart(event) {
+				break pages
+			}
+		}
+		if output.NextToken == nil {
+			break
+		}
+		nextToken = output.NextToken
+	}
+
+	for i := len(newEvents) - 1; i >= 0; i-- {
+		event := newEvents[i]
+		s.seen[aws.StringValue(event.EventId)] = true
+
+		isResource := aws.StringValue(event.LogicalResourceId) != aws.StringValue(event.StackName)
+		if s.firstFailure == nil && isResource && strings.HasSuffix(aws.StringValue(event.ResourceStatus), "_FAILED") {
+			s.firstFailure = event
+		}
+
+		line := fmt.Sprintf("%s  %s  %s",
+			aws.StringValue(event.ResourceStatus),
+			aws.StringValue(event.LogicalResourceId),
+			aws.StringValue(event.ResourceType))
+		if reason := aws.StringValue(event.ResourceStatusReason); reason != "" {
+			line += ": " + reason
+		}
+		s.client.logf("%s", line)
+	}
+	return nil
+}
@@ -3,13 +3,17 @@ package awsclient_test
 import (
 	"errors"
 	"fmt"
+	"log"
 	"math/rand"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"golang.org/x/net/context"
 
 	"github.com/rosenhouse/tubes/lib/awsclient"
 	"github.com/rosenhouse/tubes/mocks"
@@ -21,6 +25,7 @@ var _ = Describe("waiting for the stack changes to complete", func() {
 		pundit               *mocks.CloudFormationStatusPundit
 		cloudFormationClient *mocks.CloudFormationClientMultiCall
 		clock                *mocks.Clock
+		logBuffer            *gbytes.Buffer
 		stackName            string
 		stackId              string
 		nCalls               int
@@ -56,16 +61,18 @@ var _ = Describe("waiting for the stack changes to complete", func() {
 		cloudFormationClient.DescribeStacksCalls[0].Output.Stacks[0].StackId = aws.String(stackId)
 		pundit.IsCompleteCalls[nCalls-1].Returns.Result = true
 		clock = &mocks.Clock{}
+		logBuffer = gbytes.NewBuffer()
 
 		client = awsclient.Client{
 			CloudFormation: cloudFormationClient,
 			Clock:          clock,
+			Logger:         log.New(logBuffer, "", 0),
 			CloudFormationWaitTimeout: 10 * time.Minute,
 		}
 	})
 
 	It("should call DescribeStacks repeatedly", func() {
-		Expect(client.WaitForStack(stackName, pundit)).To(Succeed())
+		Expect(client.WaitForStack(context.Background(), stackName, pundit)).To(Succeed())
 
 		for i := 0; i < nCalls; i++ {
 			Expect(*cloudFormationClient.DescribeStacksCalls[i].Input).NotTo(BeNil())
@@ -73,7 +80,7 @@ var _ = Describe("waiting for the stack changes to complete", func() {
 	})
 
 	It("should use the stackName on the first call and the stackID on subsequent calls to DescribeStacks", func() {
-		Expect(client.WaitForStack(stackName, pundit)).To(Succeed())
+		Expect(client.WaitForStack(context.Background(), stackName, pundit)).To(Succeed())
 
 		Expect(*cloudFormationClient.DescribeStacksCalls[0].Input.StackName).To(Equal(stackName))
 		for i := 1; i < nCalls; i++ {
@@ -82,7 +89,7 @@ var _ = Describe("waiting for the stack changes to complete", func() {
 	})
 
 	It("should check each status with the pundit", func() {
-		Expect(client.WaitForStack(stackName, pundit)).To(Succeed())
+		Expect(client.WaitForStack(context.Background(), stackName, pundit)).To(Succeed())
 
 		for i := 0; i < nCalls; i++ {
 			Expect(pundit.IsHealthyCalls[i].Receives.StatusString).To(Equal(fmt.Sprintf("some status %d", i)))
@@ -91,7 +98,7 @@ var _ = Describe("waiting for the stack changes to complete", func() {
 	})
 
 	It("should sleep in between retries", func() {
-		Expect(client.WaitForStack(stackName, pundit)).To(Succeed())
+		Expect(client.WaitForStack(context.Background(), stackName, pundit)).To(Succeed())
 
 		for i := 0; i < nCalls-1; i++ {
 			Expect(clock.SleepCalls[i].Receives.Duration).To(Equal(5 * time.Second))
@@ -104,7 +111,7 @@ var _ = Describe("waiting for the stack changes to complete", func() {
 			pundit.IsHealthyCalls[1].Returns.Result = false
 		})
 		It("should abort and return an error", func() {
-			Expect(client.WaitForStack(stackName, pundit)).To(MatchError(fmt.Sprintf("stack %q has unhealthy status %q", stackName, "some bad status")))
+			Expect(client.WaitForStack(context.Background(), stackName, pundit)).To(MatchError(fmt.Sprintf("stack %q has unhealthy status %q", stackName, "some bad status")))
 			Expect(pundit.IsCompleteCalls[1].Receives.StatusString).To(BeEmpty())
 			Expect(cloudFormationClient.DescribeStacksCalls[2].Input).To(BeNil())
 		})
@@ -116,12 +123,137 @@ var _ = Describe("waiting for the stack changes to complete", func() {
 			pundit.IsCompleteCalls[1].Returns.Result = true
 		})
 		It("should return immediately", func() {
-			Expect(client.WaitForStack(stackName, pundit)).To(Succeed())
+			Expect(client.WaitForStack(context.Background(), stackName, pundit)).To(Succeed())
 			Expect(cloudFormationClient.DescribeStacksCalls[2].Input).To(BeNil())
 			Expect(pundit.IsHealthyCalls[2].Receives.StatusString).To(BeEmpty())
 		})
 	})
 
+	Context("when the stack is already complete on the first check", func() {
+		It("should not look at stack events, since they belong to an earlier change", func() {
+			pundit.IsCompleteCalls[0].Returns.Result = true
+
+			Expect(client.WaitForStack(context.Background(), stackName, pundit)).To(Succeed())
+			Expect(cloudFormationClient.DescribeStackEventsCallCount).To(Equal(0))
+		})
+	})
+
+	Describe("streaming stack events", func() {
+		newEvent := func(id, logicalID, status, reason string) *cloudformation.StackEvent {
+			event := &cloudformation.StackEvent{
+				EventId:           aws.String(id),
+				StackName:         aws.String(stackName),
+				LogicalResourceId: aws.String(logicalID),
+				ResourceType:      aws.String("AWS::EC2::Instance"),
+				ResourceStatus:    aws.String(status),
+			}
+			if logicalID == stackName {
+				event.ResourceType = aws.String("AWS::CloudFormation::Stack")
+			}
+			if reason != "" {
+				event.ResourceStatusReason = aws.String(reason)
+			}
+			return event
+		}
+
+		var (
+			previousChange *cloudformation.StackEvent
+			stackStart     *cloudformation.StackEvent
+			instanceStart  *cloudformation.StackEvent
+			instanceDone   *cloudformation.StackEvent
+			stackDone      *cloudformation.StackEvent
+		)
+
+		BeforeEach(func() {
+			previousChange = newEvent("event-0", stackName, "UPDATE_COMPLETE", "")
+			stackStart = newEvent("event-1", stackName, "UPDATE_IN_PROGRESS", "User Initiated")
+			instanceStart = newEvent("event-2", "SomeInstance", "UPDATE_IN_PROGRESS", "")
+			instanceDone = newEvent("event-3", "SomeInstance", "UPDATE_COMPLETE", "")
+			stackDone = newEvent("event-4", stackName, "UPDATE_COMPLETE", "")
+
+			cloudFormationClient.DescribeStackEventsCalls = []mocks.DescribeStackEventsCall{
+				{Output: &cloudformation.DescribeStackEventsOutput{
+					StackEvents: []*cloudformation.StackEvent{instanceStart, stackStart, previousChange},
+				}},
+				{Output: &cloudformation.DescribeStackEventsOutput{
+					StackEvents: []*cloudformation.StackEvent{stackDone, instanceDone, instanceStart, stackStart, previousChange},
+				}},
+			}
+		})
+
+		It("should describe the events of the stack by id", func() {
+			Expect(client.WaitForStack(context.Background(), stackName, pundit)).To(Succeed())
+
+			Expect(*cloudFormationClient.DescribeStackEventsCalls[0].Input.StackName).To(Equal(stackId))
+		})
+
+		It("should log each event of the current change once, oldest first", func() {
+			Expect(client.WaitForStack(context.Background(), stackName, pundit)).To(Succeed())
+
+			Expect(logBuffer).To(gbytes.Say(fmt.Sprintf(`UPDATE_IN_PROGRESS  %s  AWS::CloudFormation::Stack: User Initiated\n`, stackName)))
+			Expect(logBuffer).To(gbytes.Say(`UPDATE_IN_PROGRESS  SomeInstance  AWS::EC2::Instance\n`))
+			Expect(logBuffer).To(gbytes.Say(`UPDATE_COMPLETE  SomeInstance  AWS::EC2::Instance\n`))
+			Expect(logBuffer).To(gbytes.Say(fmt.Sprintf(`UPDATE_COMPLETE  %s  AWS::CloudFormation::Stack\n`, stackName)))
+
+			lines := string(logBuffer.Contents())
+			Expect(strings.Count(lines, "UPDATE_IN_PROGRESS  SomeInstance")).To(Equal(1))
+			Expect(strings.Count(lines, "UPDATE_COMPLETE  "+stackName)).To(Equal(1))
+		})
+
+		It("should page through events until it finds one it has already seen", func() {
+			cloudFormationClient.DescribeStackEventsCalls = []mocks.DescribeStackEventsCall{
+				{Output: &cloudformation.DescribeStackEventsOutput{
+					StackEvents: []*cloudformation.StackEvent{instanceStart},
+					NextToken:   aws.String("some-token"),
+				}},
+				{Output: &cloudformation.DescribeStackEventsOutput{
+					StackEvents: []*cloudformation.StackEvent{stackStart, previousChange},
+				}},
+			}
+
+			Expect(client.WaitForStack(context.Background(), stackName, pundit)).To(Succeed())
+
+			Expect(cloudFormationClient.DescribeStackEventsCalls[0].Input.NextToken).To(BeNil())
+			Expect(*cloudFormationClient.DescribeStackEventsCalls[1].Input.NextToken).To(Equal("some-token"))
+			Expect(logBuffer).To(gbytes.Say(`UPDATE_IN_PROGRESS  ` + stackName))
+			Expect(logBuffer).To(gbytes.Say(`UPDATE_IN_PROGRESS  SomeInstance`))
+		})
+
+		Context("when the stack becomes unhealthy", func() {
+			BeforeEach(func() {
+				cloudFormationClient.DescribeStacksCalls[1] = newResult("some bad status", nil)
+				pundit.IsHealthyCalls[1].Returns.Result = false
+
+				cloudFormationClient.DescribeStackEventsCalls[1].Output.StackEvents = []*cloudformation.StackEvent{
+					newEvent("event-7", stackName, "UPDATE_ROLLBACK_IN_PROGRESS", "The following resource(s) failed to update: [SomeInstance]"),
+					newEvent("event-6", "OtherInstance", "UPDATE_FAILED", "Resource update cancelled"),
+					newEvent("event-5", "SomeInstance", "UPDATE_FAILED", "some root cause"),
+					instanceStart, stackStart, previousChange,
+				}
+			})
+
+			It("should return the reason of the first failed resource in the error", func() {
+				Expect(client.WaitForStack(context.Background(), stackName, pundit)).To(MatchError(fmt.Sprintf(
+					"stack %q has unhealthy status %q: SomeInstance UPDATE_FAILED: some root cause", stackName, "some bad status")))
+			})
+
+			It("should log the failures", func() {
+				client.WaitForStack(context.Background(), stackName, pundit)
+
+				Expect(logBuffer).To(gbytes.Say(`UPDATE_FAILED  SomeInstance  AWS::EC2::Instance: some root cause`))
+				Expect(logBuffer).To(gbytes.Say(`UPDATE_FAILED  OtherInstance  AWS::EC2::Instance: Resource update cancelled`))
+			})
+		})
+
+		Context("when DescribeStackEvents errors", func() {
+			It("should return the error", func() {
+				cloudFormationClient.DescribeStackEventsCalls[0].Error = errors.New("some events error")
+
+				Expect(client.WaitForStack(context.Background(), stackName, pundit)).To(MatchError("some events error"))
+			})
+		})
+	})
+
 	Context("when the stack change doesn't complete within the timeout", func() {
 		It("should return an error", func() {
 			nCalls = 15
@@ -141,16 +273,32 @@ var _ = Describe("waiting for the stack changes to complete", func() {
 				CloudFormationWaitTimeout: 65 * time.Second,
 			}
 
-			Expect(client.WaitForStack(stackName, pundit)).To(MatchError(
+			Expect(client.WaitForStack(context.Background(), stackName, pundit)).To(MatchError(
 				"timed out waiting for stack change to complete (max 1m5s, some status 13).  Check CloudFormation for details."))
 		})
 	})
 
+	Context("when the context is cancelled part way through the wait", func() {
+		It("should return from that sleep, without polling again", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			clock.OnSleep = func(callCount int) {
+				if callCount == 2 {
+					cancel()
+				}
+			}
+
+			Expect(client.WaitForStack(ctx, stackName, pundit)).To(MatchError(
+				fmt.Sprintf("stopped waiting for stack %q at status %q: context canceled", stackName, "some status 1")))
+			Expect(clock.SleepCalls).To(HaveLen(2))
+			Expect(cloudFormationClient.DescribeStacksCalls[2].Input).To(BeNil())
+		})
+	})
+
 	Context("when the DescribeStacks call errors", func() {
 		It("should immediately return the error", func() {
 			cloudFormationClient.DescribeStacksCalls[1] = newResult("whatever", errors.New("some aws error"))
 
-			Expect(client.WaitForStack(stackName, pundit)).To(MatchError("some aws error"))
+			Expect(client.WaitForStack(context.Background(), stackName, pundit)).To(MatchError("some aws error"))
 			Expect(cloudFormationClient.DescribeStacksCalls[2].Input).To(BeNil())
 		})
 	})
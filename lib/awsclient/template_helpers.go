@@ -1,5 +1,7 @@
 package awsclient
 
+import . "github.com/awslabs/aws-cfn-go-template"
+
 type Rule struct {
 	ToPort     int `json:",string"`
 	FromPort   int `json:",string"`
@@ -11,3 +13,41 @@ type Tag struct {
 	Key   string
 	Value string
 }
+
+func copyResources(resources map[string]Resource) map[string]Resource {
+	copied := map[string]Resource{}
+	for name, resource := range resources {
+		copied[name] = resource
+	}
+	return copied
+}
+
+// withProperty returns a copy of the resource with one property replaced
+func withProperty(resource Resource, key string, value interface{}) Resource {
+	properties := map[string]interface{}{}
+	for k, v := range resource.Properties {
+		properties[k] = v
+	}
+	properties[key] = value
+	resource.Properties = properties
+	return resource
+}
+
+func copyParameters(parameters map[string]Parameter) map[string]Parameter {
+	copied := map[string]Parameter{}
+	for name, parameter := range parameters {
+		copied[name] = parameter
+	}
+	return copied
+}
+
+// withoutProperty returns a copy of the resource with one property removed
+func withoutProperty(resource Resource, key string) Resource {
+	resource = withProperty(resource, key, nil)
+	delete(resource.Properties, key)
+	return resource
+}
+
+func getAtt(logicalID, attribute string) map[string]interface{} {
+	return map[string]interface{}{"Fn::GetAtt": []string{logicalID, attribute}}
+}
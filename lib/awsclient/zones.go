@@ -0,0 +1,64 @@
+package awsclient
+
+import (
+	"fmt"
+	"strconv"
+
+	. "github.com/awslabs/aws-cfn-go-template"
+)
+
+// MaxAvailabilityZones is the most availability zones an environment can span
+const MaxAvailabilityZones = 3
+
+// DefaultConcourseSubnetCIDRs are the CIDR blocks of the Concourse subnet in each zone
+var DefaultConcourseSubnetCIDRs = [MaxAvailabilityZones]string{DefaultConcourseSubnetCIDR, "10.0.17.0/24", "10.0.18.0/24"}
+
+var defaultPublicSubnetCIDRs = [MaxAvailabilityZones]string{"10.0.0.0/24", "10.0.2.0/24", "10.0.4.0/24"}
+var defaultPrivateSubnetCIDRs = [MaxAvailabilityZones]string{"10.0.1.0/24", "10.0.3.0/24", "10.0.5.0/24"}
+
+// ZoneSuffix is appended to the logical IDs and parameter names of the
+// resources in each availability zone after the first, e.g. ConcourseSubnetZ2
+func ZoneSuffix(zoneIndex int) string {
+	if zoneIndex == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Z%d", zoneIndex+1)
+}
+
+// publicSubnetName is the logical ID of the public subnet in a zone.  In
+// the first zone that is the BOSH subnet.
+func publicSubnetName(zoneIndex int) string {
+	if zoneIndex == 0 {
+		return "BOSHSubnet"
+	}
+	return "PublicSubnet" + ZoneSuffix(zoneIndex)
+}
+
+// selectAZ picks the nth availability zone of the region
+func selectAZ(zoneIndex int) map[string]interface{} {
+	return map[string]interface{}{
+		"Fn::Select": []interface{}{strconv.Itoa(zoneIndex), map[string]interface{}{"Fn::GetAZs": ""}},
+	}
+}
+
+func subnetResource(vpc, cidrParameter, name string, availabilityZone interface{}) Resource {
+	return Resource{
+		Type: "AWS::EC2::Subnet",
+		Properties: map[string]interface{}{
+			"VpcId":            Ref(vpc),
+			"AvailabilityZone": availabilityZone,
+			"CidrBlock":        Ref(cidrParameter),
+			"Tags":             []Tag{{Key: "Name", Value: name}},
+		},
+	}
+}
+
+func routeTableAssociation(subnet, routeTable string) Resource {
+	return Resource{
+		Type: "AWS::EC2::SubnetRouteTableAssociation",
+		Properties: map[string]interface{}{
+			"SubnetId":     Ref(subnet),
+			"RouteTableId": Ref(routeTable),
+		},
+	}
+}
@@ -0,0 +1,13 @@
+package boshinit_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestBoshinit(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Boshinit Suite")
+}
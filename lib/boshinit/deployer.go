@@ -0,0 +1,235 @@
+package boshinit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/context"
+)
+
+// files on the remote host live in this directory, relative to the user's home
+const workDir = "tubes-deploy"
+
+const statusScript = `if [ -f tubes-deploy/exit ]; then echo finished;
+elif [ -f tubes-deploy/pid ] && kill -0 "$(cat tubes-deploy/pid)" 2>/dev/null; then echo running;
+else echo idle; fi`
+
+// startScript runs the deploy detached from the SSH session, so that it
+// survives hangups.  The exit status is recorded once bosh-init finishes.
+// The pid is renamed into place, so statusScript never reads half of it.
+// Any bosh-init.env is read into bosh-init's environment and then deleted,
+// so the credentials in it don't outlive the deploy's start on disk.
+const startScript = `set -e
+cd tubes-deploy
+rm -f exit pid deploy.log
+nohup sh -c 'if [ -f bosh-init.env ]; then . ./bosh-init.env; rm -f bosh-init.env; fi
+bosh-init deploy director.yml > deploy.log 2>&1; echo $? > exit' > /dev/null 2>&1 &
+echo $! > pid.tmp
+mv pid.tmp pid`
+
+type clock interface {
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+type clockImpl struct{}
+
+func (c clockImpl) Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// RemoteDeployer runs bosh-init on a remote host over SSH
+type RemoteDeployer struct {
+	User         string
+	Port         int
+	PollInterval time.Duration
+	Clock        clock
+}
+
+func New(user string, port int) *RemoteDeployer {
+	return &RemoteDeployer{
+		User:         user,
+		Port:         port,
+		PollInterval: 5 * time.Second,
+		Clock:        clockImpl{},
+	}
+}
+
+type session struct {
+	client *ssh.Client
+}
+
+func (s *session) run(command string, stdin []byte, stdout io.Writer) error {
+	sshSession, err := s.client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer sshSession.Close()
+
+	var stderr bytes.Buffer
+	sshSession.Stdout = stdout
+	sshSession.Stderr = &stderr
+	if stdin != nil {
+		sshSession.Stdin = bytes.NewReader(stdin)
+	}
+
+	err = sshSession.Run(command)
+	if err != nil {
+		return fmt.Errorf("remote command failed: %s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func (s *session) output(command string) (string, error) {
+	var stdout bytes.Buffer
+	err := s.run(command, nil, &stdout)
+	return strings.TrimSpace(stdout.String()), err
+}
+
+func (s *session) upload(name string, contents []byte) error {
+	return s.run(fmt.Sprintf("mkdir -p %s && umask 077 && cat > %s/%s", workDir, workDir, name), contents, &bytes.Buffer{})
+}
+
+// Deploy copies the given files to the host and runs `bosh-init deploy director.yml`
+// there, streaming its output.  If a deploy is already running, or finished
+// while we were disconnected, it re-attaches to that deploy instead of starting
+// a new one.  It returns the contents of director-state.json after the deploy.
+// A bosh-init.env among the files holds shell variable assignments, which
+// are exported to bosh-init and then deleted from the host.
+// Once ctx is cancelled it stops following the deploy, which carries on.
+//
+// checkHostKey is given the host's public key, in authorized_keys format, and
+// must return an error unless it is the key expected for the host.  Nothing is
+// sent to the host until it has been checked.
+func (d *RemoteDeployer) Deploy(ctx context.Context, host string, privateKey []byte, checkHostKey func(hostKey []byte) error, files map[string][]byte, output io.Writer) ([]byte, error) {
+	client, err := d.dial(host, privateKey, checkHostKey)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	s := &session{client: client}
+
+	status, err := s.output(statusScript)
+	if err != nil {
+		return nil, err
+	}
+
+	if status == "idle" {
+		for name, contents := range files {
+			err = s.upload(name, contents)
+			if err != nil {
+				return nil, err
+			}
+		}
+		err = s.run(startScript, nil, &bytes.Buffer{})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		fmt.Fprintf(output, "Re-attaching to bosh-init deploy on %s\n", host)
+	}
+
+	exitStatus, err := d.follow(ctx, s, output, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var state bytes.Buffer
+	err = s.run("cat "+workDir+"/director-state.json 2>/dev/null || true", nil, &state)
+	if err != nil {
+		return nil, err
+	}
+
+	// the result has been collected, so the next run starts a fresh deploy,
+	// even if the pid has been reused by then
+	err = s.run(fmt.Sprintf("rm -f %s/exit %s/pid", workDir, workDir), nil, &bytes.Buffer{})
+	if err != nil {
+		return nil, err
+	}
+
+	var stateBytes []byte
+	if state.Len() > 0 {
+		stateBytes = state.Bytes()
+	}
+	if exitStatus != "0" {
+		return stateBytes, fmt.Errorf("bosh-init deploy failed with exit status %s", exitStatus)
+	}
+	return stateBytes, nil
+}
+
+// Tunnel connects to the host over SSH, checking its host key as Deploy does,
+// and returns a dial function that opens connections from the host, e.g. to a
+// private director.  Close the tunnel when done with it.
+func (d *RemoteDeployer) Tunnel(host string, privateKey []byte, checkHostKey func(hostKey []byte) error) (func(network, addr string) (net.Conn, error), io.Closer, error) {
+	client, err := d.dial(host, privateKey, checkHostKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return client.Dial, client, nil
+}
+
+func (d *RemoteDeployer) dial(host string, privateKey []byte, checkHostKey func(hostKey []byte) error) (*ssh.Client, error) {
+	signer, err := ssh.ParsePrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return ssh.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(d.Port)), &ssh.ClientConfig{
+		User: d.User,
+		Auth: []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			return checkHostKey(ssh.MarshalAuthorizedKey(key))
+		},
+	})
+}
+
+// follow copies the deploy log to the output until bosh-init exits, and returns its exit status.
+// It errors if the deploy is gone without recording one, e.g. because it was killed.
+func (d *RemoteDeployer) follow(ctx context.Context, s *session, output io.Writer, host string) (string, error) {
+	offset := 0
+	for {
+		// check for exit before reading the log, so that we never miss its end
+		exitStatus, err := s.output("cat " + workDir + "/exit 2>/dev/null || true")
+		if err != nil {
+			return "", err
+		}
+
+		var logChunk bytes.Buffer
+		err = s.run(fmt.Sprintf("tail -c +%d %s/deploy.log 2>/dev/null || true", offset+1, workDir), nil, &logChunk)
+		if err != nil {
+			return "", err
+		}
+		offset += logChunk.Len()
+		_, err = output.Write(logChunk.Bytes())
+		if err != nil {
+			return "", err
+		}
+
+		if exitStatus != "" {
+			return exitStatus, nil
+		}
+
+		status, err := s.output(statusScript)
+		if err != nil {
+			return "", err
+		}
+		if status == "idle" {
+			return "", fmt.Errorf("bosh-init deploy on %s stopped without recording its exit status, see %s/deploy.log there", host, workDir)
+		}
+		if err := d.Clock.Sleep(ctx, d.PollInterval); err != nil {
+			return "", fmt.Errorf("stopped following bosh-init deploy, which carries on on %s: %s", host, err)
+		}
+	}
+}
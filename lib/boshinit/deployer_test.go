@@ -0,0 +1,358 @@
+package boshinit_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+
+	"github.com/rosenhouse/tubes/lib/boshinit"
+	"github.com/rosenhouse/tubes/lib/sshfake"
+)
+
+const fakeBoshInit = `#!/bin/sh
+echo "Deployment manifest: '$2'"
+if [ -f director-state.json ]; then echo "existing state: $(cat director-state.json)"; fi
+if [ -n "$AWS_SESSION_TOKEN" ]; then echo "session token: $AWS_SESSION_TOKEN"; fi
+echo '{"director_id": "some-director-id"}' > director-state.json
+echo "Finished deploying"
+if [ -f ../bosh-init-should-fail ]; then exit 3; fi
+`
+
+var _ = Describe("RemoteDeployer", func() {
+	var (
+		homeDir      string
+		binDir       string
+		privateKey   []byte
+		server       *sshfake.Server
+		deployer     *boshinit.RemoteDeployer
+		output       *gbytes.Buffer
+		files        map[string][]byte
+		checkHostKey func(hostKey []byte) error
+		checkedKeys  [][]byte
+	)
+
+	BeforeEach(func() {
+		var err error
+		homeDir, err = ioutil.TempDir("", "tubes-boshinit-home")
+		Expect(err).NotTo(HaveOccurred())
+		binDir, err = ioutil.TempDir("", "tubes-boshinit-bin")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ioutil.WriteFile(filepath.Join(binDir, "bosh-init"), []byte(fakeBoshInit), 0755)).To(Succeed())
+
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+		Expect(err).NotTo(HaveOccurred())
+		privateKey = pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(rsaKey),
+		})
+		publicKey, err := ssh.NewPublicKey(&rsaKey.PublicKey)
+		Expect(err).NotTo(HaveOccurred())
+
+		server, err = sshfake.NewServer(homeDir, binDir, publicKey)
+		Expect(err).NotTo(HaveOccurred())
+
+		checkedKeys = nil
+		checkHostKey = func(hostKey []byte) error {
+			checkedKeys = append(checkedKeys, hostKey)
+			return nil
+		}
+
+		deployer = boshinit.New("ec2-user", server.Port())
+		deployer.PollInterval = 10 * time.Millisecond
+
+		output = gbytes.NewBuffer()
+		files = map[string][]byte{
+			"director.yml": []byte("some-manifest"),
+			"ssh-key":      privateKey,
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+		Expect(os.RemoveAll(homeDir)).To(Succeed())
+		Expect(os.RemoveAll(binDir)).To(Succeed())
+	})
+
+	It("should upload the files to the remote host, readable only by the user", func() {
+		_, err := deployer.Deploy(context.Background(), "127.0.0.1", privateKey, checkHostKey, files, output)
+		Expect(err).NotTo(HaveOccurred())
+
+		manifestPath := filepath.Join(homeDir, "tubes-deploy", "director.yml")
+		Expect(ioutil.ReadFile(manifestPath)).To(Equal([]byte("some-manifest")))
+		fileInfo, err := os.Stat(manifestPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fileInfo.Mode().Perm()).To(Equal(os.FileMode(0600)))
+
+		Expect(ioutil.ReadFile(filepath.Join(homeDir, "tubes-deploy", "ssh-key"))).To(Equal(privateKey))
+	})
+
+	It("should run bosh-init deploy and stream its output", func() {
+		_, err := deployer.Deploy(context.Background(), "127.0.0.1", privateKey, checkHostKey, files, output)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(output).To(gbytes.Say("Deployment manifest: 'director.yml'"))
+		Expect(output).To(gbytes.Say("Finished deploying"))
+	})
+
+	It("should return the new director state", func() {
+		state, err := deployer.Deploy(context.Background(), "127.0.0.1", privateKey, checkHostKey, files, output)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(state).To(MatchJSON(`{"director_id": "some-director-id"}`))
+	})
+
+	It("should run the deploy detached from the SSH session", func() {
+		_, err := deployer.Deploy(context.Background(), "127.0.0.1", privateKey, checkHostKey, files, output)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(server.Commands()).To(ContainElement(ContainSubstring("nohup")))
+	})
+
+	It("should check the host key before sending anything", func() {
+		_, err := deployer.Deploy(context.Background(), "127.0.0.1", privateKey, checkHostKey, files, output)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(checkedKeys).To(Equal([][]byte{ssh.MarshalAuthorizedKey(server.HostKey)}))
+	})
+
+	Context("when the host key is not the expected one", func() {
+		It("should return the error without sending anything", func() {
+			checkHostKey = func(hostKey []byte) error {
+				return errors.New("some host key error")
+			}
+
+			_, err := deployer.Deploy(context.Background(), "127.0.0.1", privateKey, checkHostKey, files, output)
+			Expect(err).To(MatchError(ContainSubstring("some host key error")))
+
+			Expect(server.Commands()).To(BeEmpty())
+			_, err = os.Stat(filepath.Join(homeDir, "tubes-deploy"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+
+	Context("when there is an existing director state", func() {
+		It("should be uploaded for bosh-init to use", func() {
+			files["director-state.json"] = []byte(`{"director_id": "old-director-id"}`)
+
+			_, err := deployer.Deploy(context.Background(), "127.0.0.1", privateKey, checkHostKey, files, output)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(output).To(gbytes.Say("existing state: {\"director_id\": \"old-director-id\"}"))
+		})
+	})
+
+	Context("when there is an environment file", func() {
+		BeforeEach(func() {
+			files["bosh-init.env"] = []byte("export AWS_SESSION_TOKEN='some-session-token'\n")
+		})
+
+		It("should give bosh-init its variables", func() {
+			_, err := deployer.Deploy(context.Background(), "127.0.0.1", privateKey, checkHostKey, files, output)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(output).To(gbytes.Say("session token: some-session-token"))
+		})
+
+		It("should delete it from the remote host", func() {
+			_, err := deployer.Deploy(context.Background(), "127.0.0.1", privateKey, checkHostKey, files, output)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = os.Stat(filepath.Join(homeDir, "tubes-deploy", "bosh-init.env"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+
+	Context("when bosh-init fails", func() {
+		BeforeEach(func() {
+			Expect(ioutil.WriteFile(filepath.Join(homeDir, "bosh-init-should-fail"), nil, 0600)).To(Succeed())
+		})
+
+		It("should return an error along with whatever state bosh-init left behind", func() {
+			state, err := deployer.Deploy(context.Background(), "127.0.0.1", privateKey, checkHostKey, files, output)
+			Expect(err).To(MatchError("bosh-init deploy failed with exit status 3"))
+			Expect(state).To(MatchJSON(`{"director_id": "some-director-id"}`))
+		})
+
+		It("should start a fresh deploy on the next run", func() {
+			deployer.Deploy(context.Background(), "127.0.0.1", privateKey, checkHostKey, files, output)
+			Expect(os.Remove(filepath.Join(homeDir, "bosh-init-should-fail"))).To(Succeed())
+
+			_, err := deployer.Deploy(context.Background(), "127.0.0.1", privateKey, checkHostKey, files, output)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output).NotTo(gbytes.Say("Re-attaching"))
+		})
+
+		It("should remove the pid along with the exit status, once collected", func() {
+			deployer.Deploy(context.Background(), "127.0.0.1", privateKey, checkHostKey, files, output)
+
+			_, err := os.Stat(filepath.Join(homeDir, "tubes-deploy", "pid"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+
+	Context("when a deploy finished while we were disconnected", func() {
+		BeforeEach(func() {
+			workDir := filepath.Join(homeDir, "tubes-deploy")
+			Expect(os.MkdirAll(workDir, 0700)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(workDir, "deploy.log"), []byte("some earlier output\n"), 0600)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(workDir, "director-state.json"), []byte(`{"director_id": "earlier"}`), 0600)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(workDir, "exit"), []byte("0\n"), 0600)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(workDir, "pid"), []byte(strconv.Itoa(os.Getpid())), 0600)).To(Succeed())
+		})
+
+		It("should re-attach and collect the result, rather than starting over", func() {
+			state, err := deployer.Deploy(context.Background(), "127.0.0.1", privateKey, checkHostKey, files, output)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(output).To(gbytes.Say("Re-attaching to bosh-init deploy on 127.0.0.1"))
+			Expect(output).To(gbytes.Say("some earlier output"))
+			Expect(state).To(MatchJSON(`{"director_id": "earlier"}`))
+
+			_, err = os.Stat(filepath.Join(homeDir, "tubes-deploy", "director.yml"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+			_, err = os.Stat(filepath.Join(homeDir, "tubes-deploy", "exit"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It("should start a fresh deploy on the next run, even though its pid is still in use", func() {
+			_, err := deployer.Deploy(context.Background(), "127.0.0.1", privateKey, checkHostKey, files, output)
+			Expect(err).NotTo(HaveOccurred())
+
+			output = gbytes.NewBuffer()
+			_, err = deployer.Deploy(context.Background(), "127.0.0.1", privateKey, checkHostKey, files, output)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output).NotTo(gbytes.Say("Re-attaching"))
+			Expect(output).To(gbytes.Say("Finished deploying"))
+		})
+	})
+
+	Context("when a deploy is still running", func() {
+		var workDir string
+
+		BeforeEach(func() {
+			workDir = filepath.Join(homeDir, "tubes-deploy")
+			Expect(os.MkdirAll(workDir, 0700)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(workDir, "deploy.log"), []byte("started\n"), 0600)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(workDir, "pid"), []byte(strconv.Itoa(os.Getpid())), 0600)).To(Succeed())
+		})
+
+		It("should re-attach and follow it until it exits", func() {
+			go func() {
+				defer GinkgoRecover()
+				time.Sleep(100 * time.Millisecond)
+				logFile, err := os.OpenFile(filepath.Join(workDir, "deploy.log"), os.O_APPEND|os.O_WRONLY, 0600)
+				Expect(err).NotTo(HaveOccurred())
+				logFile.Write([]byte("finished\n"))
+				logFile.Close()
+				Expect(ioutil.WriteFile(filepath.Join(workDir, "exit"), []byte("0\n"), 0600)).To(Succeed())
+			}()
+
+			_, err := deployer.Deploy(context.Background(), "127.0.0.1", privateKey, checkHostKey, files, output)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(output).To(gbytes.Say("Re-attaching"))
+			Expect(output).To(gbytes.Say("started\nfinished\n"))
+		})
+
+		Context("when the deploy is gone without recording its exit status", func() {
+			It("should return an error, rather than following it forever", func() {
+				deploy := exec.Command("sleep", "0.2")
+				Expect(deploy.Start()).To(Succeed())
+				go deploy.Wait()
+				Expect(ioutil.WriteFile(filepath.Join(workDir, "pid"), []byte(strconv.Itoa(deploy.Process.Pid)), 0600)).To(Succeed())
+
+				_, err := deployer.Deploy(context.Background(), "127.0.0.1", privateKey, checkHostKey, files, output)
+				Expect(err).To(MatchError("bosh-init deploy on 127.0.0.1 stopped without recording its exit status, see tubes-deploy/deploy.log there"))
+			})
+		})
+
+		Context("when the context is cancelled", func() {
+			It("should stop following right away, leaving the deploy running", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				deployer.PollInterval = time.Minute
+				go func() {
+					time.Sleep(100 * time.Millisecond)
+					cancel()
+				}()
+
+				_, err := deployer.Deploy(ctx, "127.0.0.1", privateKey, checkHostKey, files, output)
+				Expect(err).To(MatchError("stopped following bosh-init deploy, which carries on on 127.0.0.1: context canceled"))
+
+				Expect(output).To(gbytes.Say("started\n"))
+				_, err = os.Stat(filepath.Join(workDir, "pid"))
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+	})
+
+	Describe("Tunnel", func() {
+		It("should open connections from the host, after checking its host key", func() {
+			target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("through the tunnel"))
+			}))
+			defer target.Close()
+
+			dial, tunnel, err := deployer.Tunnel("127.0.0.1", privateKey, checkHostKey)
+			Expect(err).NotTo(HaveOccurred())
+			defer tunnel.Close()
+			Expect(checkedKeys).To(Equal([][]byte{ssh.MarshalAuthorizedKey(server.HostKey)}))
+
+			client := &http.Client{Transport: &http.Transport{Dial: dial}}
+			response, err := client.Get(target.URL)
+			Expect(err).NotTo(HaveOccurred())
+			defer response.Body.Close()
+			Expect(ioutil.ReadAll(response.Body)).To(Equal([]byte("through the tunnel")))
+
+			Expect(server.Forwards()).To(Equal([]string{target.Listener.Addr().String()}))
+		})
+
+		Context("when the host key is not the expected one", func() {
+			It("should return the error", func() {
+				checkHostKey = func(hostKey []byte) error {
+					return errors.New("some host key error")
+				}
+
+				_, _, err := deployer.Tunnel("127.0.0.1", privateKey, checkHostKey)
+				Expect(err).To(MatchError(ContainSubstring("some host key error")))
+			})
+		})
+	})
+
+	Context("when the private key is not authorized", func() {
+		It("should return an error", func() {
+			otherKey, err := rsa.GenerateKey(rand.Reader, 1024)
+			Expect(err).NotTo(HaveOccurred())
+			otherPEM := pem.EncodeToMemory(&pem.Block{
+				Type:  "RSA PRIVATE KEY",
+				Bytes: x509.MarshalPKCS1PrivateKey(otherKey),
+			})
+
+			_, err = deployer.Deploy(context.Background(), "127.0.0.1", otherPEM, checkHostKey, files, output)
+			Expect(err).To(MatchError(ContainSubstring("unable to authenticate")))
+		})
+	})
+
+	Context("when the private key is malformed", func() {
+		It("should return an error", func() {
+			_, err := deployer.Deploy(context.Background(), "127.0.0.1", []byte("nope"), checkHostKey, files, output)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
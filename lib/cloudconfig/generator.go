@@ -1,7 +1,141 @@
 package cloudconfig
 
+import (
+	"fmt"
+	"net"
+
+	"github.com/rosenhouse/tubes/lib/awsclient"
+	. "github.com/rosenhouse/tubes/lib/manifests"
+)
+
 type Generator struct{}
 
-func (g *Generator) Generate(stackResrouces map[string]string) ([]byte, error) {
-	return nil, nil
+func incrementIP(ip net.IP, amount byte) net.IP {
+	cloned := append([]byte(nil), ip.To4()...)
+	cloned[3] += amount
+	return cloned
+}
+
+func ipRange(base net.IP, first, last byte) string {
+	return fmt.Sprintf("%s - %s", incrementIP(base, first), incrementIP(base, last))
+}
+
+func requireResource(stackResources map[string]string, key string) (string, error) {
+	value, ok := stackResources[key]
+	if !ok || value == "" {
+		return "", fmt.Errorf("missing required stack resource %q", key)
+	}
+	return value, nil
+}
+
+// Generate builds a BOSH cloud config for the Concourse stack.  Along with the
+// stack resources, it requires the AvailabilityZone, ConcourseSubnetCIDR and
+// VPCCIDR the stack was created with.  A stack that spans several zones has a
+// ConcourseSubnetZ2, AvailabilityZoneZ2 and so on for each zone after the first.
+func (g *Generator) Generate(stackResources map[string]string) ([]byte, error) {
+	resources := map[string]string{}
+	for _, key := range []string{
+		"ConcourseSubnet", "ConcourseSecurityGroup", "LoadBalancer",
+		"AvailabilityZone", "ConcourseSubnetCIDR", "VPCCIDR",
+	} {
+		value, err := requireResource(stackResources, key)
+		if err != nil {
+			return nil, err
+		}
+		resources[key] = value
+	}
+
+	_, vpc, err := net.ParseCIDR(resources["VPCCIDR"])
+	if err != nil {
+		return nil, err
+	}
+
+	azs := []AZ{}
+	subnets := []Subnet{}
+	for i := 0; ; i++ {
+		suffix := awsclient.ZoneSuffix(i)
+		if _, ok := stackResources["ConcourseSubnet"+suffix]; !ok {
+			break
+		}
+		zoneResources := map[string]string{}
+		for _, key := range []string{"ConcourseSubnet", "AvailabilityZone", "ConcourseSubnetCIDR"} {
+			value, err := requireResource(stackResources, key+suffix)
+			if err != nil {
+				return nil, err
+			}
+			zoneResources[key] = value
+		}
+
+		_, subnet, err := net.ParseCIDR(zoneResources["ConcourseSubnetCIDR"])
+		if err != nil {
+			return nil, err
+		}
+
+		azName := fmt.Sprintf("z%d", i+1)
+		azs = append(azs, AZ{
+			Name:            azName,
+			CloudProperties: AZCloudProperties{AvailabilityZone: zoneResources["AvailabilityZone"]},
+		})
+		subnets = append(subnets, Subnet{
+			Range:    subnet.String(),
+			Gateway:  incrementIP(subnet.IP, 1).String(),
+			AZ:       azName,
+			Reserved: []string{ipRange(subnet.IP, 2, 9)},
+			Static:   []string{ipRange(subnet.IP, 10, 30)},
+			DNS:      []string{incrementIP(vpc.IP, 2).String()},
+			CloudProperties: SubnetCloudProperties{
+				Subnet:         zoneResources["ConcourseSubnet"],
+				SecurityGroups: []string{resources["ConcourseSecurityGroup"]},
+			},
+		})
+	}
+
+	cloudConfig := CloudConfig{
+		AZs: azs,
+		VMTypes: []VMType{
+			{
+				Name: "default",
+				CloudProperties: VMTypeCloudProperties{
+					InstanceType:  "m3.medium",
+					EphemeralDisk: EphemeralDisk{Size: 25000, Type: "gp2"},
+				},
+			},
+			{
+				Name: "worker",
+				CloudProperties: VMTypeCloudProperties{
+					InstanceType:  "m3.large",
+					EphemeralDisk: EphemeralDisk{Size: 100000, Type: "gp2"},
+				},
+			},
+		},
+		DiskTypes: []DiskType{
+			{
+				Name:            "default",
+				DiskSize:        10000,
+				CloudProperties: DiskPoolCloudProperties{Type: "gp2"},
+			},
+		},
+		Networks: []Network{
+			{
+				Name:    "private",
+				Type:    "manual",
+				Subnets: subnets,
+			},
+		},
+		VMExtensions: []VMExtension{
+			{
+				Name:            "elb",
+				CloudProperties: VMExtensionCloudProperties{ELBs: []string{resources["LoadBalancer"]}},
+			},
+		},
+		Compilation: Compilation{
+			Workers:             3,
+			ReuseCompilationVMs: true,
+			AZ:                  "z1",
+			VMType:              "default",
+			Network:             "private",
+		},
+	}
+
+	return []byte(cloudConfig.String()), nil
 }
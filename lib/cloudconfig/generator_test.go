@@ -1,17 +1,26 @@
 package cloudconfig_test
 
 import (
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
 	"github.com/rosenhouse/tubes/lib/cloudconfig"
+	"github.com/rosenhouse/tubes/lib/manifests"
+
+	. "github.com/rosenhouse/tubes/lib/matchers"
 )
 
 var _ = Describe("Generator", func() {
 
 	var (
-		stackResources map[string]string
-		generator      *cloudconfig.Generator
+		stackResources      map[string]string
+		generator           *cloudconfig.Generator
+		expectedCloudConfig manifests.CloudConfig
+		expectedYAML        string
 	)
 
 	BeforeEach(func() {
@@ -19,14 +28,121 @@ var _ = Describe("Generator", func() {
 			"ConcourseSecurityGroup": "some-concourse-security-group-id",
 			"ConcourseSubnet":        "some-concourse-subnet-id",
 			"LoadBalancer":           "some-concourse-elb",
+			"AvailabilityZone":       "some-availability-zone",
+			"ConcourseSubnetCIDR":    "10.0.16.0/24",
+			"VPCCIDR":                "10.0.0.0/16",
 		}
 
 		generator = &cloudconfig.Generator{}
-	})
 
-	XIt("generates a cloud config file using the given stack resources", func() {
-		cloudConfigBytes, err := generator.Generate(stackResources)
+		expectedBytes, err := ioutil.ReadFile("fixtures/cloud-config.yml")
 		Expect(err).NotTo(HaveOccurred())
-		Expect(cloudConfigBytes).To(Equal("some magic yaml"))
+		expectedYAML = string(expectedBytes)
+		expectedCloudConfig = manifests.CloudConfig{}
+		Expect(yaml.Unmarshal(expectedBytes, &expectedCloudConfig)).To(Succeed())
+	})
+
+	Describe("equality of structural data", func() {
+		It("should set the fields correctly", func() {
+			cloudConfigBytes, err := generator.Generate(stackResources)
+			Expect(err).NotTo(HaveOccurred())
+
+			var actualCloudConfig manifests.CloudConfig
+			Expect(yaml.Unmarshal(cloudConfigBytes, &actualCloudConfig)).To(Succeed())
+
+			Expect(actualCloudConfig.AZs).To(Equal(expectedCloudConfig.AZs))
+			Expect(actualCloudConfig.VMTypes).To(Equal(expectedCloudConfig.VMTypes))
+			Expect(actualCloudConfig.DiskTypes).To(Equal(expectedCloudConfig.DiskTypes))
+			Expect(actualCloudConfig.Networks).To(Equal(expectedCloudConfig.Networks))
+			Expect(actualCloudConfig.VMExtensions).To(Equal(expectedCloudConfig.VMExtensions))
+			Expect(actualCloudConfig.Compilation).To(Equal(expectedCloudConfig.Compilation))
+		})
+	})
+
+	Describe("equality of serialized data", func() {
+		It("should have all the same data as the fixture", func() {
+			cloudConfigBytes, err := generator.Generate(stackResources)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(cloudConfigBytes).To(MatchYAML(expectedYAML))
+		})
+	})
+
+	Context("when a required resource is missing", func() {
+		It("should return an error", func() {
+			delete(stackResources, "LoadBalancer")
+
+			_, err := generator.Generate(stackResources)
+			Expect(err).To(MatchError(`missing required stack resource "LoadBalancer"`))
+		})
+	})
+
+	Context("when the subnet CIDR is malformed", func() {
+		It("should return an error", func() {
+			stackResources["ConcourseSubnetCIDR"] = "nope"
+
+			_, err := generator.Generate(stackResources)
+			Expect(err).To(MatchError(ContainSubstring("invalid CIDR address")))
+		})
+	})
+
+	Context("when the VPC CIDR is malformed", func() {
+		It("should return an error", func() {
+			stackResources["VPCCIDR"] = "nope"
+
+			_, err := generator.Generate(stackResources)
+			Expect(err).To(MatchError(ContainSubstring("invalid CIDR address")))
+		})
+	})
+
+	Context("when the stack spans several availability zones", func() {
+		BeforeEach(func() {
+			stackResources["ConcourseSubnetZ2"] = "some-other-concourse-subnet-id"
+			stackResources["AvailabilityZoneZ2"] = "some-other-availability-zone"
+			stackResources["ConcourseSubnetCIDRZ2"] = "10.0.17.0/24"
+		})
+
+		It("should add an AZ and a subnet for each zone", func() {
+			cloudConfigBytes, err := generator.Generate(stackResources)
+			Expect(err).NotTo(HaveOccurred())
+
+			var actualCloudConfig manifests.CloudConfig
+			Expect(yaml.Unmarshal(cloudConfigBytes, &actualCloudConfig)).To(Succeed())
+
+			Expect(actualCloudConfig.AZs).To(Equal([]manifests.AZ{
+				expectedCloudConfig.AZs[0],
+				{
+					Name:            "z2",
+					CloudProperties: manifests.AZCloudProperties{AvailabilityZone: "some-other-availability-zone"},
+				},
+			}))
+
+			subnets := actualCloudConfig.Networks[0].Subnets
+			Expect(subnets).To(HaveLen(2))
+			Expect(subnets[0]).To(Equal(expectedCloudConfig.Networks[0].Subnets[0]))
+			Expect(subnets[1]).To(Equal(manifests.Subnet{
+				Range:    "10.0.17.0/24",
+				Gateway:  "10.0.17.1",
+				AZ:       "z2",
+				Reserved: []string{"10.0.17.2 - 10.0.17.9"},
+				Static:   []string{"10.0.17.10 - 10.0.17.30"},
+				DNS:      []string{"10.0.0.2"},
+				CloudProperties: manifests.SubnetCloudProperties{
+					Subnet:         "some-other-concourse-subnet-id",
+					SecurityGroups: []string{"some-concourse-security-group-id"},
+				},
+			}))
+
+			Expect(actualCloudConfig.Compilation).To(Equal(expectedCloudConfig.Compilation))
+		})
+
+		Context("when a zone is missing its CIDR", func() {
+			It("should return an error", func() {
+				delete(stackResources, "ConcourseSubnetCIDRZ2")
+
+				_, err := generator.Generate(stackResources)
+				Expect(err).To(MatchError(`missing required stack resource "ConcourseSubnetCIDRZ2"`))
+			})
+		})
 	})
 })
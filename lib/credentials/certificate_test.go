@@ -0,0 +1,58 @@
+package credentials_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rosenhouse/tubes/lib/credentials"
+)
+
+var _ = Describe("Generating certificates", func() {
+	var generator credentials.CertificateGenerator
+
+	BeforeEach(func() {
+		generator = credentials.CertificateGenerator{KeyBits: 1024}
+	})
+
+	It("should return a self-signed certificate for the IPs, with its key", func() {
+		certPEM, keyPEM, err := generator.Generate("some-name", []string{"10.0.0.6", "1.2.3.4"})
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = tls.X509KeyPair(certPEM, keyPEM)
+		Expect(err).NotTo(HaveOccurred())
+
+		block, _ := pem.Decode(certPEM)
+		Expect(block).NotTo(BeNil())
+		cert, err := x509.ParseCertificate(block.Bytes)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cert.Subject.CommonName).To(Equal("some-name"))
+
+		roots := x509.NewCertPool()
+		roots.AddCert(cert)
+		for _, ip := range []string{"10.0.0.6", "1.2.3.4"} {
+			_, err = cert.Verify(x509.VerifyOptions{DNSName: ip, Roots: roots})
+			Expect(err).NotTo(HaveOccurred())
+		}
+		_, err = cert.Verify(x509.VerifyOptions{DNSName: "5.6.7.8", Roots: roots})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should generate a new key every time", func() {
+		_, firstKey, err := generator.Generate("some-name", []string{"10.0.0.6"})
+		Expect(err).NotTo(HaveOccurred())
+		_, secondKey, err := generator.Generate("some-name", []string{"10.0.0.6"})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(firstKey).NotTo(Equal(secondKey))
+	})
+
+	Context("when an IP is malformed", func() {
+		It("should return an error", func() {
+			_, _, err := generator.Generate("some-name", []string{"nope"})
+			Expect(err).To(MatchError(`invalid IP address "nope"`))
+		})
+	})
+})
@@ -7,13 +7,17 @@ import (
 	. "github.com/rosenhouse/tubes/lib/manifests"
 )
 
+// DirectorConfig is what the director manifest is rendered from.  Name is
+// also the value of the director tag BOSH puts on every VM and disk it makes.
 type DirectorConfig struct {
+	Name           string
 	Software       Software
 	Credentials    Credentials
 	InternalIP     string
 	AWSNetwork     AWSNetwork
 	AWSCredentials AWSCredentials
 	AWSSSHKey      AWSSSHKey
+	SSL            SSL
 }
 
 type Software struct {
@@ -28,15 +32,15 @@ type Artifact struct {
 }
 
 type Credentials struct {
-	MBus              string
-	NATS              string
-	Redis             string
-	Postgres          string
-	Registry          string
-	BlobstoreDirector string
-	BlobstoreAgent    string
-	HM                string
-	Admin             string
+	MBus              string `yaml:"mbus"`
+	NATS              string `yaml:"nats"`
+	Redis             string `yaml:"redis"`
+	Postgres          string `yaml:"postgres"`
+	Registry          string `yaml:"registry"`
+	BlobstoreDirector string `yaml:"blobstore_director"`
+	BlobstoreAgent    string `yaml:"blobstore_agent"`
+	HM                string `yaml:"hm"`
+	Admin             string `yaml:"admin"`
 }
 
 type AWSNetwork struct {
@@ -47,10 +51,20 @@ type AWSNetwork struct {
 	SecurityGroup    string
 }
 
+// AWSCredentials are either static access keys, or the name of an IAM
+// instance profile that the director VM gets its credentials from
 type AWSCredentials struct {
 	Region          string
 	AccessKeyID     string
 	SecretAccessKey string
+	InstanceProfile string
+}
+
+// SSL is the PEM-encoded certificate and key that the director serves its
+// API with.  Without them, the director generates a certificate of its own.
+type SSL struct {
+	Cert string
+	Key  string
 }
 
 type AWSSSHKey struct {
@@ -77,6 +91,15 @@ func IncrementIP(ip net.IP, amount byte) net.IP {
 	return cloned
 }
 
+// InternalIP is the static IP of the director within the BOSH subnet
+func InternalIP(subnetCIDR string) (string, error) {
+	ip, _, err := net.ParseCIDR(subnetCIDR)
+	if err != nil {
+		return "", err
+	}
+	return IncrementIP(ip.To4(), 6).String(), nil
+}
+
 func convertSubnet(subnetCIDR, subnetID string) (Subnet, error) {
 	_, ipnet, err := net.ParseCIDR(subnetCIDR)
 	if err != nil {
@@ -97,12 +120,16 @@ type DirectorManifestGenerator struct{}
 func (g DirectorManifestGenerator) Generate(d DirectorConfig) (Manifest, error) {
 
 	awsProperties := map[interface{}]interface{}{
-		"access_key_id":           d.AWSCredentials.AccessKeyID,
-		"secret_access_key":       d.AWSCredentials.SecretAccessKey,
 		"default_key_name":        d.AWSSSHKey.Name,
 		"default_security_groups": []interface{}{d.AWSNetwork.SecurityGroup},
 		"region":                  d.AWSCredentials.Region,
 	}
+	if d.AWSCredentials.InstanceProfile != "" {
+		awsProperties["credentials_source"] = "env_or_profile"
+	} else {
+		awsProperties["access_key_id"] = d.AWSCredentials.AccessKeyID
+		awsProperties["secret_access_key"] = d.AWSCredentials.SecretAccessKey
+	}
 
 	privateSubnet, err := convertSubnet(d.AWSNetwork.BOSHSubnetCIDR, d.AWSNetwork.BOSHSubnetID)
 	if err != nil {
@@ -114,11 +141,30 @@ func (g DirectorManifestGenerator) Generate(d DirectorConfig) (Manifest, error)
 		Type:    "manual",
 		Subnets: []Subnet{privateSubnet},
 	}
-	eipNetwork := Network{
-		Name: "public",
-		Type: "vip",
+	networks := []Network{privateNetwork}
+	jobNetworks := []NetworkReference{
+		{
+			Name:      privateNetwork.Name,
+			StaticIPs: []string{d.InternalIP},
+			Default:   []string{"dns", "gateway"},
+		},
+	}
+
+	// without an elastic IP the director is private, so bosh-init must run
+	// from inside the VPC (i.e. on the NAT box) and reach it on its internal IP
+	directorAddress := d.InternalIP
+	if d.AWSNetwork.ElasticIP != "" {
+		eipNetwork := Network{
+			Name: "public",
+			Type: "vip",
+		}
+		networks = append(networks, eipNetwork)
+		jobNetworks = append(jobNetworks, NetworkReference{
+			Name:      eipNetwork.Name,
+			StaticIPs: []string{d.AWSNetwork.ElasticIP},
+		})
+		directorAddress = d.AWSNetwork.ElasticIP
 	}
-	networks := []Network{privateNetwork, eipNetwork}
 
 	resourcePools := []ResourcePool{
 		{
@@ -129,9 +175,10 @@ func (g DirectorManifestGenerator) Generate(d DirectorConfig) (Manifest, error)
 				SHA1: d.Software.Stemcell.SHA,
 			},
 			CloudProperties: ResourcePoolCloudProperties{
-				InstanceType:     defaultInstanceType,
-				AvailabilityZone: d.AWSNetwork.AvailabilityZone,
-				EphemeralDisk:    defaultEphemeralDisk,
+				InstanceType:       defaultInstanceType,
+				AvailabilityZone:   d.AWSNetwork.AvailabilityZone,
+				EphemeralDisk:      defaultEphemeralDisk,
+				IAMInstanceProfile: d.AWSCredentials.InstanceProfile,
 			},
 		},
 	}
@@ -179,17 +226,7 @@ func (g DirectorManifestGenerator) Generate(d DirectorConfig) (Manifest, error)
 		},
 		ResourcePool:       resourcePools[0].Name,
 		PersistentDiskPool: diskPools[0].Name,
-		Networks: []NetworkReference{
-			{
-				Name:      privateNetwork.Name,
-				StaticIPs: []string{d.InternalIP},
-				Default:   []string{"dns", "gateway"},
-			},
-			{
-				Name:      eipNetwork.Name,
-				StaticIPs: []string{d.AWSNetwork.ElasticIP},
-			},
-		},
+		Networks:           jobNetworks,
 		Properties: map[string]interface{}{
 			"nats": map[interface{}]interface{}{
 				"address":  "127.0.0.1",
@@ -230,7 +267,7 @@ func (g DirectorManifestGenerator) Generate(d DirectorConfig) (Manifest, error)
 			},
 			"director": map[interface{}]interface{}{
 				"address":     "127.0.0.1",
-				"name":        "my-bosh",
+				"name":        d.Name,
 				"db":          postgresProperties,
 				"cpi_job":     "aws_cpi",
 				"max_threads": 10,
@@ -266,18 +303,26 @@ func (g DirectorManifestGenerator) Generate(d DirectorConfig) (Manifest, error)
 		},
 	}
 
+	if d.SSL.Cert != "" {
+		directorProperties := job.Properties["director"].(map[interface{}]interface{})
+		directorProperties["ssl"] = map[interface{}]interface{}{
+			"cert": d.SSL.Cert,
+			"key":  d.SSL.Key,
+		}
+	}
+
 	cloudProvider := CloudProvider{
 		Template: Template{
 			Name:    "aws_cpi",
 			Release: cpiRelease.Name,
 		},
 		SSHTunnel: SSHTunnel{
-			Host:       d.AWSNetwork.ElasticIP,
+			Host:       directorAddress,
 			Port:       22,
 			User:       "vcap",
 			PrivateKey: d.AWSSSHKey.Path,
 		},
-		MBus: fmt.Sprintf("https://mbus:%s@%s:6868", d.Credentials.MBus, d.AWSNetwork.ElasticIP),
+		MBus: fmt.Sprintf("https://mbus:%s@%s:6868", d.Credentials.MBus, directorAddress),
 		Properties: map[string]interface{}{
 			"aws": awsProperties,
 			"agent": map[interface{}]interface{}{
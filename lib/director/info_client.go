@@ -0,0 +1,59 @@
+package director
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/rosenhouse/tubes/lib/webclient"
+)
+
+// Info is the response of the director's /info endpoint
+type Info struct {
+	Name    string `json:"name"`
+	UUID    string `json:"uuid"`
+	Version string `json:"version"`
+	CPI     string `json:"cpi"`
+}
+
+// Target is where to find a director's API
+type Target struct {
+	Host string
+
+	// CACert is the PEM-encoded certificate that the director's certificate
+	// must chain to.  tubes generates a self-signed certificate for each
+	// director, so this is usually that certificate itself.
+	CACert []byte
+
+	// Dial, if set, opens connections to the director, e.g. through an SSH
+	// tunnel to a private director
+	Dial func(network, addr string) (net.Conn, error)
+}
+
+// InfoClient talks to the API of a running director
+type InfoClient struct {
+	Port int
+
+	// Timeout, if set, limits each request
+	Timeout time.Duration
+}
+
+func (c *InfoClient) Info(target Target, username, password string) (Info, error) {
+	jsonClient := &webclient.JSONClient{
+		HTTPClient: &webclient.HTTPClient{
+			BaseURL:  "https://" + net.JoinHostPort(target.Host, strconv.Itoa(c.Port)),
+			CACert:   target.CACert,
+			Username: username,
+			Password: password,
+			Timeout:  c.Timeout,
+			Dial:     target.Dial,
+		},
+	}
+
+	var info Info
+	err := jsonClient.Get("/info", &info)
+	if err != nil {
+		return Info{}, err
+	}
+	return info, nil
+}
@@ -0,0 +1,147 @@
+package director_test
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/rosenhouse/tubes/lib/director"
+)
+
+var _ = Describe("InfoClient", func() {
+	var (
+		server   *httptest.Server
+		client   *director.InfoClient
+		target   director.Target
+		request  *http.Request
+		response string
+	)
+
+	BeforeEach(func() {
+		response = `{
+			"name": "some-director",
+			"uuid": "some-uuid",
+			"version": "1.3262.0 (00000000)",
+			"user": null,
+			"cpi": "aws_cpi",
+			"features": {}
+		}`
+		server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			request = r
+			w.Write([]byte(response))
+		}))
+
+		serverURL, err := url.Parse(server.URL)
+		Expect(err).NotTo(HaveOccurred())
+		host, port, err := net.SplitHostPort(serverURL.Host)
+		Expect(err).NotTo(HaveOccurred())
+		target = director.Target{
+			Host: host,
+			CACert: pem.EncodeToMemory(&pem.Block{
+				Type:  "CERTIFICATE",
+				Bytes: server.TLS.Certificates[0].Certificate[0],
+			}),
+		}
+
+		client = &director.InfoClient{}
+		client.Port, err = strconv.Atoi(port)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should GET /info over TLS, using the given credentials", func() {
+		_, err := client.Info(target, "admin", "some-password")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(request.Method).To(Equal("GET"))
+		Expect(request.URL.Path).To(Equal("/info"))
+		username, password, ok := request.BasicAuth()
+		Expect(ok).To(BeTrue())
+		Expect(username).To(Equal("admin"))
+		Expect(password).To(Equal("some-password"))
+	})
+
+	It("should return the parsed info", func() {
+		info, err := client.Info(target, "admin", "some-password")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(info).To(Equal(director.Info{
+			Name:    "some-director",
+			UUID:    "some-uuid",
+			Version: "1.3262.0 (00000000)",
+			CPI:     "aws_cpi",
+		}))
+	})
+
+	Context("when no certificate is pinned", func() {
+		It("should refuse the director's self-signed certificate", func() {
+			server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+			target.CACert = nil
+
+			_, err := client.Info(target, "admin", "some-password")
+			Expect(err).To(MatchError(HaveSuffix("x509: certificate signed by unknown authority")))
+		})
+	})
+
+	Context("when the target has a dial function", func() {
+		It("should reach the director with it", func() {
+			serverAddr := server.Listener.Addr().String()
+			var dialed string
+			target.Dial = func(network, addr string) (net.Conn, error) {
+				dialed = addr
+				return net.Dial(network, serverAddr)
+			}
+			target.Host = "127.0.0.1"
+			client.Port = 1234
+
+			info, err := client.Info(target, "admin", "some-password")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.UUID).To(Equal("some-uuid"))
+			Expect(dialed).To(Equal("127.0.0.1:1234"))
+		})
+	})
+
+	Context("when the director is slower than the timeout", func() {
+		It("should return an error", func() {
+			server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(500 * time.Millisecond)
+			})
+			client.Timeout = 50 * time.Millisecond
+
+			_, err := client.Info(target, "admin", "some-password")
+			Expect(err).To(MatchError(ContainSubstring("Client.Timeout exceeded")))
+		})
+	})
+
+	Context("when the director rejects the credentials", func() {
+		It("should return an error", func() {
+			server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusUnauthorized)
+			})
+
+			_, err := client.Info(target, "admin", "wrong-password")
+			Expect(err).To(MatchError("server returned status code 401"))
+		})
+	})
+
+	Context("when the response is not JSON", func() {
+		It("should return an error", func() {
+			response = "nope"
+
+			_, err := client.Info(target, "admin", "some-password")
+			Expect(err).To(MatchError(HavePrefix("server returned malformed JSON")))
+		})
+	})
+})
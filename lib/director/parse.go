@@ -0,0 +1,90 @@
+package director
+
+import (
+	"fmt"
+	"net/url"
+
+	"gopkg.in/yaml.v2"
+)
+
+type password struct {
+	Password string `yaml:"password"`
+}
+
+// parsedManifest holds just the secrets of a manifest rendered by DirectorManifestGenerator
+type parsedManifest struct {
+	Jobs []struct {
+		Properties struct {
+			NATS      password `yaml:"nats"`
+			Redis     password `yaml:"redis"`
+			Postgres  password `yaml:"postgres"`
+			Registry  password `yaml:"registry"`
+			Blobstore struct {
+				Director password `yaml:"director"`
+				Agent    password `yaml:"agent"`
+			} `yaml:"blobstore"`
+			Director struct {
+				UserManagement struct {
+					Local struct {
+						Users []struct {
+							Name     string `yaml:"name"`
+							Password string `yaml:"password"`
+						} `yaml:"users"`
+					} `yaml:"local"`
+				} `yaml:"user_management"`
+			} `yaml:"director"`
+			AWS struct {
+				AccessKeyID     string `yaml:"access_key_id"`
+				SecretAccessKey string `yaml:"secret_access_key"`
+			} `yaml:"aws"`
+		} `yaml:"properties"`
+	} `yaml:"jobs"`
+	CloudProvider struct {
+		MBus string `yaml:"mbus"`
+	} `yaml:"cloud_provider"`
+}
+
+// ParseCredentials recovers the passwords and AWS access keys from a manifest
+// rendered by DirectorManifestGenerator
+func ParseCredentials(manifestYAML []byte) (Credentials, AWSCredentials, error) {
+	var manifest parsedManifest
+	err := yaml.Unmarshal(manifestYAML, &manifest)
+	if err != nil {
+		return Credentials{}, AWSCredentials{}, fmt.Errorf("malformed director manifest: %s", err)
+	}
+	if len(manifest.Jobs) != 1 {
+		return Credentials{}, AWSCredentials{}, fmt.Errorf("malformed director manifest: expecting 1 job, found %d", len(manifest.Jobs))
+	}
+	properties := manifest.Jobs[0].Properties
+
+	credentials := Credentials{
+		NATS:              properties.NATS.Password,
+		Redis:             properties.Redis.Password,
+		Postgres:          properties.Postgres.Password,
+		Registry:          properties.Registry.Password,
+		BlobstoreDirector: properties.Blobstore.Director.Password,
+		BlobstoreAgent:    properties.Blobstore.Agent.Password,
+	}
+	for _, user := range properties.Director.UserManagement.Local.Users {
+		switch user.Name {
+		case "admin":
+			credentials.Admin = user.Password
+		case "hm":
+			credentials.HM = user.Password
+		}
+	}
+
+	mbusURL, err := url.Parse(manifest.CloudProvider.MBus)
+	if err != nil {
+		return Credentials{}, AWSCredentials{}, fmt.Errorf("malformed director manifest: mbus: %s", err)
+	}
+	if mbusURL.User != nil {
+		credentials.MBus, _ = mbusURL.User.Password()
+	}
+
+	awsCredentials := AWSCredentials{
+		AccessKeyID:     properties.AWS.AccessKeyID,
+		SecretAccessKey: properties.AWS.SecretAccessKey,
+	}
+	return credentials, awsCredentials, nil
+}
@@ -0,0 +1,77 @@
+package director_test
+
+import (
+	"io/ioutil"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/rosenhouse/tubes/lib/director"
+)
+
+var _ = Describe("ParseCredentials", func() {
+	It("should recover the credentials from the fixture", func() {
+		manifestYAML, err := ioutil.ReadFile("fixtures/bosh-init-aws.yml")
+		Expect(err).NotTo(HaveOccurred())
+
+		credentials, awsCredentials, err := ParseCredentials(manifestYAML)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(credentials).To(Equal(Credentials{
+			MBus:              "mbus-password",
+			NATS:              "nats-password",
+			Redis:             "redis-password",
+			Postgres:          "postgres-password",
+			Registry:          "admin",
+			BlobstoreDirector: "director-password",
+			BlobstoreAgent:    "agent-password",
+			HM:                "hm-password",
+			Admin:             "admin",
+		}))
+		Expect(awsCredentials.AccessKeyID).To(Equal("ACCESS-KEY-ID"))
+		Expect(awsCredentials.SecretAccessKey).To(Equal("SECRET-ACCESS-KEY"))
+	})
+
+	It("should round-trip a generated manifest", func() {
+		credentials := Credentials{
+			MBus:              "some-mbus",
+			NATS:              "some-nats",
+			Redis:             "some-redis",
+			Postgres:          "some-postgres",
+			Registry:          "some-registry",
+			BlobstoreDirector: "some-blobstore-director",
+			BlobstoreAgent:    "some-blobstore-agent",
+			HM:                "some-hm",
+			Admin:             "some-admin",
+		}
+		manifest, err := DirectorManifestGenerator{}.Generate(DirectorConfig{
+			Credentials: credentials,
+			InternalIP:  "10.0.0.6",
+			AWSNetwork: AWSNetwork{
+				BOSHSubnetCIDR: "10.0.0.0/24",
+				ElasticIP:      "some-elastic-ip",
+			},
+			AWSCredentials: AWSCredentials{InstanceProfile: "some-instance-profile"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		parsed, awsCredentials, err := ParseCredentials([]byte(manifest.String()))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(parsed).To(Equal(credentials))
+		Expect(awsCredentials).To(Equal(AWSCredentials{}))
+	})
+
+	Context("when the manifest is malformed", func() {
+		It("should return an error", func() {
+			_, _, err := ParseCredentials([]byte("%%%"))
+			Expect(err).To(MatchError(HavePrefix("malformed director manifest")))
+		})
+	})
+
+	Context("when the manifest has no job", func() {
+		It("should return an error", func() {
+			_, _, err := ParseCredentials([]byte("name: bosh\n"))
+			Expect(err).To(MatchError("malformed director manifest: expecting 1 job, found 0"))
+		})
+	})
+})
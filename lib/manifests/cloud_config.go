@@ -0,0 +1,62 @@
+package manifests
+
+import "gopkg.in/yaml.v2"
+
+type CloudConfig struct {
+	AZs          []AZ          `yaml:"azs"`
+	VMTypes      []VMType      `yaml:"vm_types"`
+	DiskTypes    []DiskType    `yaml:"disk_types"`
+	Networks     []Network     `yaml:"networks"`
+	VMExtensions []VMExtension `yaml:"vm_extensions"`
+	Compilation  Compilation   `yaml:"compilation"`
+}
+
+func (c CloudConfig) String() string {
+	s, e := yaml.Marshal(c)
+	if e != nil {
+		panic(e)
+	}
+	return string(s)
+}
+
+type AZ struct {
+	Name            string            `yaml:"name"`
+	CloudProperties AZCloudProperties `yaml:"cloud_properties"`
+}
+
+type AZCloudProperties struct {
+	AvailabilityZone string `yaml:"availability_zone"`
+}
+
+type VMType struct {
+	Name            string                `yaml:"name"`
+	CloudProperties VMTypeCloudProperties `yaml:"cloud_properties"`
+}
+
+type VMTypeCloudProperties struct {
+	InstanceType  string        `yaml:"instance_type"`
+	EphemeralDisk EphemeralDisk `yaml:"ephemeral_disk"`
+}
+
+type DiskType struct {
+	Name            string                  `yaml:"name"`
+	DiskSize        int                     `yaml:"disk_size"`
+	CloudProperties DiskPoolCloudProperties `yaml:"cloud_properties"`
+}
+
+type VMExtension struct {
+	Name            string                     `yaml:"name"`
+	CloudProperties VMExtensionCloudProperties `yaml:"cloud_properties"`
+}
+
+type VMExtensionCloudProperties struct {
+	ELBs []string `yaml:"elbs"`
+}
+
+type Compilation struct {
+	Workers             int    `yaml:"workers"`
+	ReuseCompilationVMs bool   `yaml:"reuse_compilation_vms"`
+	AZ                  string `yaml:"az"`
+	VMType              string `yaml:"vm_type"`
+	Network             string `yaml:"network"`
+}
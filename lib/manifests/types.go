@@ -39,9 +39,10 @@ type Stemcell struct {
 }
 
 type ResourcePoolCloudProperties struct {
-	InstanceType     string        `yaml:"instance_type"`
-	EphemeralDisk    EphemeralDisk `yaml:"ephemeral_disk"`
-	AvailabilityZone string        `yaml:"availability_zone"`
+	InstanceType       string        `yaml:"instance_type"`
+	EphemeralDisk      EphemeralDisk `yaml:"ephemeral_disk"`
+	AvailabilityZone   string        `yaml:"availability_zone"`
+	IAMInstanceProfile string        `yaml:"iam_instance_profile,omitempty"`
 }
 
 type EphemeralDisk struct {
@@ -74,12 +75,16 @@ type NetworkReference struct {
 type Subnet struct {
 	Range           string                `yaml:"range"`
 	Gateway         string                `yaml:"gateway"`
+	AZ              string                `yaml:"az,omitempty"`
+	Reserved        []string              `yaml:"reserved,omitempty"`
+	Static          []string              `yaml:"static,omitempty"`
 	DNS             []string              `yaml:"dns"`
 	CloudProperties SubnetCloudProperties `yaml:"cloud_properties"`
 }
 
 type SubnetCloudProperties struct {
-	Subnet string `yaml:"subnet"`
+	Subnet         string   `yaml:"subnet"`
+	SecurityGroups []string `yaml:"security_groups,omitempty"`
 }
 
 type Job struct {
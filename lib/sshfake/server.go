@@ -0,0 +1,214 @@
+// Package sshfake is an SSH server for tests, which runs commands locally
+package sshfake
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Server is an in-process SSH server that runs exec requests with the
+// local shell, inside HomeDir and with BinDir at the front of the PATH
+type Server struct {
+	HomeDir       string
+	BinDir        string
+	AuthorizedKey ssh.PublicKey
+
+	// HostKey is the key the server presents to clients, generated afresh for each server
+	HostKey ssh.PublicKey
+
+	listener net.Listener
+	config   *ssh.ServerConfig
+
+	lock     sync.Mutex
+	commands []string
+	forwards []string
+}
+
+func NewServer(homeDir, binDir string, authorizedKey ssh.PublicKey) (*Server, error) {
+	server := &Server{
+		HomeDir:       homeDir,
+		BinDir:        binDir,
+		AuthorizedKey: authorizedKey,
+	}
+
+	hostKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		return nil, err
+	}
+
+	server.config = &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), server.AuthorizedKey.Marshal()) {
+				return nil, errors.New("unauthorized key")
+			}
+			return nil, nil
+		},
+	}
+	server.config.AddHostKey(hostSigner)
+	server.HostKey = hostSigner.PublicKey()
+
+	server.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	go server.serve()
+
+	return server, nil
+}
+
+func (s *Server) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *Server) Close() error {
+	return s.listener.Close()
+}
+
+// Commands returns every command that has been run, in order
+func (s *Server) Commands() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string{}, s.commands...)
+}
+
+// Forwards returns every address that a client has tunneled to, in order
+func (s *Server) Forwards() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string{}, s.forwards...)
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handleConn(conn)
+	}
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	_, channels, requests, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		switch newChannel.ChannelType() {
+		case "session":
+			channel, channelRequests, err := newChannel.Accept()
+			if err != nil {
+				continue
+			}
+			go s.handleSession(channel, channelRequests)
+		case "direct-tcpip":
+			go s.handleForward(newChannel)
+		default:
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions and port forwarding are supported")
+		}
+	}
+}
+
+// handleForward connects a port forwarding channel, as opened by a client's
+// Dial, to the address it asks for
+func (s *Server) handleForward(newChannel ssh.NewChannel) {
+	var payload struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &payload); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, "malformed forwarding request")
+		return
+	}
+	addr := net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port)))
+
+	s.lock.Lock()
+	s.forwards = append(s.forwards, addr)
+	s.lock.Unlock()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	go func() {
+		io.Copy(conn, channel)
+		conn.Close()
+	}()
+	io.Copy(channel, conn)
+	channel.Close()
+}
+
+func (s *Server) handleSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+
+	for req := range requests {
+		if req.Type != "exec" {
+			req.Reply(false, nil)
+			continue
+		}
+
+		var payload struct{ Command string }
+		if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, nil)
+
+		s.lock.Lock()
+		s.commands = append(s.commands, payload.Command)
+		s.lock.Unlock()
+
+		exitStatus := s.run(payload.Command, channel)
+		channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{exitStatus}))
+		return
+	}
+}
+
+func (s *Server) run(command string, channel ssh.Channel) uint32 {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = s.HomeDir
+	cmd.Env = append(os.Environ(), "HOME="+s.HomeDir, "PATH="+s.BinDir+":"+os.Getenv("PATH"))
+	cmd.Stdin = channel
+	cmd.Stdout = channel
+	cmd.Stderr = channel.Stderr()
+
+	err := cmd.Run()
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			return uint32(status.ExitStatus())
+		}
+	}
+	channel.Stderr().Write([]byte(strconv.Quote(err.Error())))
+	return 255
+}
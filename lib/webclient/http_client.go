@@ -2,15 +2,42 @@ package webclient
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
+	"time"
 )
 
 type HTTPClient struct {
 	BaseURL       string
 	SkipTLSVerify bool
+
+	// CACert, if set, is a PEM-encoded certificate that the server must chain to
+	CACert []byte
+
+	// Username and Password, if set, are sent using HTTP basic auth
+	Username string
+	Password string
+
+	// Timeout, if set, limits the whole request
+	Timeout time.Duration
+
+	// Dial, if set, opens the connection to the server, e.g. through an SSH tunnel
+	Dial func(network, addr string) (net.Conn, error)
+}
+
+func (c *HTTPClient) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: c.SkipTLSVerify}
+	if len(c.CACert) > 0 {
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(c.CACert) {
+			return nil, fmt.Errorf("unable to parse CA certificate")
+		}
+	}
+	return config, nil
 }
 
 func (c *HTTPClient) resolvePath(path string) (string, error) {
@@ -26,10 +53,15 @@ func (c *HTTPClient) resolvePath(path string) (string, error) {
 }
 
 func (c *HTTPClient) Get(path string) ([]byte, error) {
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
 	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: c.SkipTLSVerify},
+		TLSClientConfig: tlsConfig,
+		Dial:            c.Dial,
 	}
-	client := &http.Client{Transport: tr}
+	client := &http.Client{Transport: tr, Timeout: c.Timeout}
 
 	fullURL, err := c.resolvePath(path)
 	if err != nil {
@@ -39,6 +71,9 @@ func (c *HTTPClient) Get(path string) ([]byte, error) {
 	if err != nil {
 		return nil, err // not tested
 	}
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
 
 	resp, err := client.Do(req)
 	if err != nil {
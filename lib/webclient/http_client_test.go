@@ -2,10 +2,13 @@ package webclient_test
 
 import (
 	"bytes"
+	"encoding/pem"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -101,6 +104,77 @@ var _ = Describe("HTTP Client", func() {
 				Expect(err).To(MatchError(HaveSuffix("x509: certificate signed by unknown authority")))
 			})
 		})
+
+		Context("when the CA cert is pinned", func() {
+			It("should succeed without skipping verification", func() {
+				c.CACert = pem.EncodeToMemory(&pem.Block{
+					Type:  "CERTIFICATE",
+					Bytes: tlsServer.TLS.Certificates[0].Certificate[0],
+				})
+
+				responseBody, err := c.Get("/some/path")
+
+				Expect(err).NotTo(HaveOccurred())
+				Expect(responseBody).To(Equal([]byte("some-bytes")))
+			})
+
+			Context("when the CA cert cannot be parsed", func() {
+				It("should return an error", func() {
+					c.CACert = []byte("not a cert")
+
+					_, err := c.Get("/some/path")
+					Expect(err).To(MatchError("unable to parse CA certificate"))
+				})
+			})
+		})
+	})
+
+	Context("when a dial function is set", func() {
+		It("should open the connection with it", func() {
+			var dialed string
+			c.Dial = func(network, addr string) (net.Conn, error) {
+				dialed = addr
+				return net.Dial(network, server.Listener.Addr().String())
+			}
+			c.BaseURL = "http://some-unresolvable-host:1234"
+
+			responseBody, err := c.Get("/some/path")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(responseBody).To(Equal([]byte("some-bytes")))
+			Expect(dialed).To(Equal("some-unresolvable-host:1234"))
+		})
+	})
+
+	Context("when a username is set", func() {
+		It("should send basic auth credentials", func() {
+			var username, password string
+			var ok bool
+			authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				username, password, ok = r.BasicAuth()
+			}))
+			defer authServer.Close()
+			c := webclient.HTTPClient{BaseURL: authServer.URL, Username: "some-user", Password: "some-password"}
+
+			_, err := c.Get("/some/path")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ok).To(BeTrue())
+			Expect(username).To(Equal("some-user"))
+			Expect(password).To(Equal("some-password"))
+		})
+	})
+
+	Context("when the server takes longer than the timeout", func() {
+		It("should return an error", func() {
+			slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(500 * time.Millisecond)
+			}))
+			defer slowServer.Close()
+			c := webclient.HTTPClient{BaseURL: slowServer.URL, Timeout: 50 * time.Millisecond}
+
+			_, err := c.Get("/some/path")
+			Expect(err).To(MatchError(ContainSubstring("Client.Timeout exceeded")))
+		})
 	})
 
 	Context("when the server responds with a non-2xx status", func() {
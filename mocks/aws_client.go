@@ -1,6 +1,9 @@
 package mocks
 
-import "github.com/rosenhouse/tubes/lib/awsclient"
+import (
+	"github.com/rosenhouse/tubes/lib/awsclient"
+	"golang.org/x/net/context"
+)
 
 type UpsertStackCall struct {
 	Receives struct {
@@ -13,8 +16,22 @@ type UpsertStackCall struct {
 	}
 }
 
+type PlanStackCall struct {
+	Receives struct {
+		Context    context.Context
+		StackName  string
+		Template   string
+		Parameters map[string]string
+	}
+	Returns struct {
+		Plan  awsclient.StackPlan
+		Error error
+	}
+}
+
 type WaitForStackCall struct {
 	Receives struct {
+		Context   context.Context
 		StackName string
 		Pundit    awsclient.CloudFormationStatusPundit
 	}
@@ -23,6 +40,37 @@ type WaitForStackCall struct {
 	}
 }
 
+type InstanceStateCall struct {
+	Receives struct {
+		InstanceID string
+	}
+	Returns struct {
+		State string
+		Error error
+	}
+}
+
+type StackStatusCall struct {
+	Receives struct {
+		StackName string
+	}
+	Returns struct {
+		Status string
+		Error  error
+	}
+}
+
+type DetectStackDriftCall struct {
+	Receives struct {
+		Context   context.Context
+		StackName string
+	}
+	Returns struct {
+		Drift awsclient.StackDrift
+		Error error
+	}
+}
+
 type GetStackResourcesCall struct {
 	Receives struct {
 		StackName string
@@ -34,7 +82,8 @@ type GetStackResourcesCall struct {
 }
 type DeleteStackCall struct {
 	Receives struct {
-		StackName string
+		StackName       string
+		RetainResources []string
 	}
 	Returns struct {
 		Error error
@@ -52,12 +101,43 @@ type AWSClient struct {
 	UpsertStackCalls     []UpsertStackCall
 	UpsertStackCallCount int
 
+	PlanStackCalls     []PlanStackCall
+	PlanStackCallCount int
+
 	DeleteStackCalls     []DeleteStackCall
 	DeleteStackCallCount int
 
 	WaitForStackCalls     []WaitForStackCall
 	WaitForStackCallCount int
 
+	StackExistsCall struct {
+		Receives struct {
+			StackName string
+		}
+		Returns struct {
+			Exists bool
+			Error  error
+		}
+	}
+
+	KeyPairExistsCall struct {
+		Receives struct {
+			StackName string
+		}
+		Returns struct {
+			Exists bool
+			Error  error
+		}
+	}
+	ImportKeyPairCall struct {
+		Receives struct {
+			StackName string
+			PEMBytes  []byte
+		}
+		Returns struct {
+			Error error
+		}
+	}
 	DeleteKeyPairCall struct {
 		Receives struct {
 			StackName string
@@ -88,6 +168,81 @@ type AWSClient struct {
 	GetStackResourcesCalls     []GetStackResourcesCall
 	GetStackResourcesCallCount int
 
+	StackStatusCalls     []StackStatusCall
+	StackStatusCallCount int
+
+	DetectStackDriftCalls     []DetectStackDriftCall
+	DetectStackDriftCallCount int
+
+	ListEnvironmentStacksCall struct {
+		Returns struct {
+			Stacks []awsclient.EnvironmentStack
+			Error  error
+		}
+	}
+	ListDeletedBaseStacksCall struct {
+		Receives struct {
+			Environments []string
+		}
+		Returns struct {
+			Stacks []awsclient.EnvironmentStack
+			Error  error
+		}
+	}
+	ListKeyPairsCall struct {
+		Returns struct {
+			KeyNames []string
+			Error    error
+		}
+	}
+	ListElasticIPsCall struct {
+		Returns struct {
+			ElasticIPs []awsclient.ElasticIP
+			Error      error
+		}
+	}
+	ReleaseElasticIPCall struct {
+		Receives struct {
+			AllocationID string
+		}
+		Returns struct {
+			Error error
+		}
+	}
+
+	ListBOSHVMsCall struct {
+		Receives struct {
+			VPCID        string
+			DirectorName string
+		}
+		Returns struct {
+			VMs   awsclient.BOSHVMs
+			Error error
+		}
+	}
+	DeleteBOSHVMsCall struct {
+		Receives struct {
+			Context context.Context
+			VMs     awsclient.BOSHVMs
+		}
+		Returns struct {
+			Error error
+		}
+	}
+
+	InstanceStateCalls     []InstanceStateCall
+	InstanceStateCallCount int
+
+	NATGatewayStateCall struct {
+		Receives struct {
+			NATGatewayID string
+		}
+		Returns struct {
+			State string
+			Error error
+		}
+	}
+
 	CreateAccessKeyCall struct {
 		Receives struct {
 			UserName string
@@ -116,6 +271,16 @@ type AWSClient struct {
 			Error      error
 		}
 	}
+	AssumeRoleCall struct {
+		Receives struct {
+			RoleARN     string
+			SessionName string
+		}
+		Returns struct {
+			Credentials awsclient.TemporaryCredentials
+			Error       error
+		}
+	}
 }
 
 func (c *AWSClient) GetLatestNATBoxAMIID() (string, error) {
@@ -141,38 +306,79 @@ func (c *AWSClient) UpsertStack(stackName string, template string, parameters ma
 	}
 }
 
-func (c *AWSClient) WaitForStack(stackName string, pundit awsclient.CloudFormationStatusPundit) error {
+func (c *AWSClient) PlanStack(ctx context.Context, stackName string, template string, parameters map[string]string) (awsclient.StackPlan, error) {
+	i := c.PlanStackCallCount
+	c.PlanStackCallCount++
+
+	if i >= len(c.PlanStackCalls) {
+		call := PlanStackCall{}
+		call.Receives.Context = ctx
+		call.Receives.StackName = stackName
+		call.Receives.Template = template
+		call.Receives.Parameters = parameters
+		c.PlanStackCalls = append(c.PlanStackCalls, call)
+		return awsclient.StackPlan{StackName: stackName}, nil
+	} else {
+		c.PlanStackCalls[i].Receives.Context = ctx
+		c.PlanStackCalls[i].Receives.StackName = stackName
+		c.PlanStackCalls[i].Receives.Template = template
+		c.PlanStackCalls[i].Receives.Parameters = parameters
+		return c.PlanStackCalls[i].Returns.Plan, c.PlanStackCalls[i].Returns.Error
+	}
+}
+
+func (c *AWSClient) WaitForStack(ctx context.Context, stackName string, pundit awsclient.CloudFormationStatusPundit) error {
 	i := c.WaitForStackCallCount
 	c.WaitForStackCallCount++
 
 	if i >= len(c.WaitForStackCalls) {
 		call := WaitForStackCall{}
+		call.Receives.Context = ctx
 		call.Receives.StackName = stackName
 		call.Receives.Pundit = pundit
 		c.WaitForStackCalls = append(c.WaitForStackCalls, call)
 		return nil
 	} else {
+		c.WaitForStackCalls[i].Receives.Context = ctx
 		c.WaitForStackCalls[i].Receives.StackName = stackName
 		c.WaitForStackCalls[i].Receives.Pundit = pundit
 		return c.WaitForStackCalls[i].Returns.Error
 	}
 }
 
-func (c *AWSClient) DeleteStack(stackName string) error {
+func (c *AWSClient) DeleteStack(stackName string, retainResources ...string) error {
 	i := c.DeleteStackCallCount
 	c.DeleteStackCallCount++
 
 	if i >= len(c.DeleteStackCalls) {
 		call := DeleteStackCall{}
 		call.Receives.StackName = stackName
+		call.Receives.RetainResources = retainResources
 		c.DeleteStackCalls = append(c.DeleteStackCalls, call)
 		return nil
 	} else {
 		c.DeleteStackCalls[i].Receives.StackName = stackName
+		c.DeleteStackCalls[i].Receives.RetainResources = retainResources
 		return c.DeleteStackCalls[i].Returns.Error
 	}
 }
 
+func (c *AWSClient) StackExists(stackName string) (bool, error) {
+	c.StackExistsCall.Receives.StackName = stackName
+	return c.StackExistsCall.Returns.Exists, c.StackExistsCall.Returns.Error
+}
+
+func (c *AWSClient) KeyPairExists(stackName string) (bool, error) {
+	c.KeyPairExistsCall.Receives.StackName = stackName
+	return c.KeyPairExistsCall.Returns.Exists, c.KeyPairExistsCall.Returns.Error
+}
+
+func (c *AWSClient) ImportKeyPair(stackName string, pemBytes []byte) error {
+	c.ImportKeyPairCall.Receives.StackName = stackName
+	c.ImportKeyPairCall.Receives.PEMBytes = pemBytes
+	return c.ImportKeyPairCall.Returns.Error
+}
+
 func (c *AWSClient) CreateKeyPair(stackName string) (string, error) {
 	c.CreateKeyPairCall.Receives.StackName = stackName
 	return c.CreateKeyPairCall.Returns.KeyPair, c.CreateKeyPairCall.Returns.Error
@@ -196,6 +402,89 @@ func (c *AWSClient) GetStackResources(stackName string) (map[string]string, erro
 	return c.GetStackResourcesCalls[i].Returns.Resources, c.GetStackResourcesCalls[i].Returns.Error
 }
 
+func (c *AWSClient) StackStatus(stackName string) (string, error) {
+	i := c.StackStatusCallCount
+	c.StackStatusCallCount++
+
+	if i >= len(c.StackStatusCalls) {
+		call := StackStatusCall{}
+		call.Receives.StackName = stackName
+		c.StackStatusCalls = append(c.StackStatusCalls, call)
+		return "", nil
+	}
+	c.StackStatusCalls[i].Receives.StackName = stackName
+	return c.StackStatusCalls[i].Returns.Status, c.StackStatusCalls[i].Returns.Error
+}
+
+func (c *AWSClient) DetectStackDrift(ctx context.Context, stackName string) (awsclient.StackDrift, error) {
+	i := c.DetectStackDriftCallCount
+	c.DetectStackDriftCallCount++
+
+	if i >= len(c.DetectStackDriftCalls) {
+		call := DetectStackDriftCall{}
+		call.Receives.Context = ctx
+		call.Receives.StackName = stackName
+		c.DetectStackDriftCalls = append(c.DetectStackDriftCalls, call)
+		return awsclient.StackDrift{}, nil
+	}
+	c.DetectStackDriftCalls[i].Receives.Context = ctx
+	c.DetectStackDriftCalls[i].Receives.StackName = stackName
+	return c.DetectStackDriftCalls[i].Returns.Drift, c.DetectStackDriftCalls[i].Returns.Error
+}
+
+func (c *AWSClient) ListEnvironmentStacks() ([]awsclient.EnvironmentStack, error) {
+	return c.ListEnvironmentStacksCall.Returns.Stacks, c.ListEnvironmentStacksCall.Returns.Error
+}
+
+func (c *AWSClient) ListDeletedBaseStacks(environments []string) ([]awsclient.EnvironmentStack, error) {
+	c.ListDeletedBaseStacksCall.Receives.Environments = environments
+	return c.ListDeletedBaseStacksCall.Returns.Stacks, c.ListDeletedBaseStacksCall.Returns.Error
+}
+
+func (c *AWSClient) ListKeyPairs() ([]string, error) {
+	return c.ListKeyPairsCall.Returns.KeyNames, c.ListKeyPairsCall.Returns.Error
+}
+
+func (c *AWSClient) ListElasticIPs() ([]awsclient.ElasticIP, error) {
+	return c.ListElasticIPsCall.Returns.ElasticIPs, c.ListElasticIPsCall.Returns.Error
+}
+
+func (c *AWSClient) ReleaseElasticIP(allocationID string) error {
+	c.ReleaseElasticIPCall.Receives.AllocationID = allocationID
+	return c.ReleaseElasticIPCall.Returns.Error
+}
+
+func (c *AWSClient) ListBOSHVMs(vpcID, directorName string) (awsclient.BOSHVMs, error) {
+	c.ListBOSHVMsCall.Receives.VPCID = vpcID
+	c.ListBOSHVMsCall.Receives.DirectorName = directorName
+	return c.ListBOSHVMsCall.Returns.VMs, c.ListBOSHVMsCall.Returns.Error
+}
+
+func (c *AWSClient) DeleteBOSHVMs(ctx context.Context, vms awsclient.BOSHVMs) error {
+	c.DeleteBOSHVMsCall.Receives.Context = ctx
+	c.DeleteBOSHVMsCall.Receives.VMs = vms
+	return c.DeleteBOSHVMsCall.Returns.Error
+}
+
+func (c *AWSClient) InstanceState(instanceID string) (string, error) {
+	i := c.InstanceStateCallCount
+	c.InstanceStateCallCount++
+
+	if i >= len(c.InstanceStateCalls) {
+		call := InstanceStateCall{}
+		call.Receives.InstanceID = instanceID
+		c.InstanceStateCalls = append(c.InstanceStateCalls, call)
+		return "", nil
+	}
+	c.InstanceStateCalls[i].Receives.InstanceID = instanceID
+	return c.InstanceStateCalls[i].Returns.State, c.InstanceStateCalls[i].Returns.Error
+}
+
+func (c *AWSClient) NATGatewayState(natGatewayID string) (string, error) {
+	c.NATGatewayStateCall.Receives.NATGatewayID = natGatewayID
+	return c.NATGatewayStateCall.Returns.State, c.NATGatewayStateCall.Returns.Error
+}
+
 func (c *AWSClient) CreateAccessKey(userName string) (string, string, error) {
 	c.CreateAccessKeyCall.Receives.UserName = userName
 	return c.CreateAccessKeyCall.Returns.AccessKey, c.CreateAccessKeyCall.Returns.SecretKey, c.CreateAccessKeyCall.Returns.Error
@@ -211,3 +500,9 @@ func (c *AWSClient) ListAccessKeys(userName string) ([]string, error) {
 	c.ListAccessKeysCall.Receives.UserName = userName
 	return c.ListAccessKeysCall.Returns.AccessKeys, c.ListAccessKeysCall.Returns.Error
 }
+
+func (c *AWSClient) AssumeRole(roleARN, sessionName string) (awsclient.TemporaryCredentials, error) {
+	c.AssumeRoleCall.Receives.RoleARN = roleARN
+	c.AssumeRoleCall.Receives.SessionName = sessionName
+	return c.AssumeRoleCall.Returns.Credentials, c.AssumeRoleCall.Returns.Error
+}
@@ -0,0 +1,23 @@
+package mocks
+
+type CertificateGenerator struct {
+	GenerateCallCount int
+	GenerateCall      struct {
+		Receives struct {
+			CommonName string
+			IPs        []string
+		}
+		Returns struct {
+			Cert  []byte
+			Key   []byte
+			Error error
+		}
+	}
+}
+
+func (g *CertificateGenerator) Generate(commonName string, ips []string) ([]byte, []byte, error) {
+	g.GenerateCallCount++
+	g.GenerateCall.Receives.CommonName = commonName
+	g.GenerateCall.Receives.IPs = ips
+	return g.GenerateCall.Returns.Cert, g.GenerateCall.Returns.Key, g.GenerateCall.Returns.Error
+}
@@ -1,6 +1,10 @@
 package mocks
 
-import "time"
+import (
+	"time"
+
+	"golang.org/x/net/context"
+)
 
 type SleepCall struct {
 	Receives struct {
@@ -10,10 +14,17 @@ type SleepCall struct {
 
 type Clock struct {
 	SleepCalls []SleepCall
+
+	// OnSleep, if set, is called on each sleep, e.g. to cancel the context part way through a wait
+	OnSleep func(callCount int)
 }
 
-func (c *Clock) Sleep(duration time.Duration) {
+func (c *Clock) Sleep(ctx context.Context, duration time.Duration) error {
 	call := SleepCall{}
 	call.Receives.Duration = duration
 	c.SleepCalls = append(c.SleepCalls, call)
+	if c.OnSleep != nil {
+		c.OnSleep(len(c.SleepCalls))
+	}
+	return ctx.Err()
 }
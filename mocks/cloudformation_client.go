@@ -8,9 +8,18 @@ type DescribeStacksCall struct {
 	Error  error
 }
 
+type DescribeStackEventsCall struct {
+	Input  *cloudformation.DescribeStackEventsInput
+	Output *cloudformation.DescribeStackEventsOutput
+	Error  error
+}
+
 type CloudFormationClientMultiCall struct {
 	DescribeStacksCallCount int
 	DescribeStacksCalls     []DescribeStacksCall
+
+	DescribeStackEventsCallCount int
+	DescribeStackEventsCalls     []DescribeStackEventsCall
 }
 
 func NewCloudFormationClientMultiCall(callCount int) *CloudFormationClientMultiCall {
@@ -30,6 +39,23 @@ func (c *CloudFormationClientMultiCall) DescribeStacks(input *cloudformation.Des
 	return out, err
 }
 
+func (c *CloudFormationClientMultiCall) DescribeStackEvents(input *cloudformation.DescribeStackEventsInput) (*cloudformation.DescribeStackEventsOutput, error) {
+	i := c.DescribeStackEventsCallCount
+	c.DescribeStackEventsCallCount++
+
+	if i >= len(c.DescribeStackEventsCalls) {
+		call := DescribeStackEventsCall{
+			Input:  input,
+			Output: &cloudformation.DescribeStackEventsOutput{},
+		}
+		c.DescribeStackEventsCalls = append(c.DescribeStackEventsCalls, call)
+		return call.Output, nil
+	}
+
+	c.DescribeStackEventsCalls[i].Input = input
+	return c.DescribeStackEventsCalls[i].Output, c.DescribeStackEventsCalls[i].Error
+}
+
 func (c *CloudFormationClientMultiCall) DescribeStackResources(input *cloudformation.DescribeStackResourcesInput) (*cloudformation.DescribeStackResourcesOutput, error) {
 	panic("not implemented")
 }
@@ -46,6 +72,34 @@ func (c *CloudFormationClientMultiCall) DeleteStack(input *cloudformation.Delete
 	panic("not implemented")
 }
 
+func (c *CloudFormationClientMultiCall) CreateChangeSet(input *cloudformation.CreateChangeSetInput) (*cloudformation.CreateChangeSetOutput, error) {
+	panic("not implemented")
+}
+
+func (c *CloudFormationClientMultiCall) DescribeChangeSet(input *cloudformation.DescribeChangeSetInput) (*cloudformation.DescribeChangeSetOutput, error) {
+	panic("not implemented")
+}
+
+func (c *CloudFormationClientMultiCall) DeleteChangeSet(input *cloudformation.DeleteChangeSetInput) (*cloudformation.DeleteChangeSetOutput, error) {
+	panic("not implemented")
+}
+
+func (c *CloudFormationClientMultiCall) DetectStackDrift(input *cloudformation.DetectStackDriftInput) (*cloudformation.DetectStackDriftOutput, error) {
+	panic("not implemented")
+}
+
+func (c *CloudFormationClientMultiCall) DescribeStackDriftDetectionStatus(input *cloudformation.DescribeStackDriftDetectionStatusInput) (*cloudformation.DescribeStackDriftDetectionStatusOutput, error) {
+	panic("not implemented")
+}
+
+func (c *CloudFormationClientMultiCall) DescribeStackResourceDrifts(input *cloudformation.DescribeStackResourceDriftsInput) (*cloudformation.DescribeStackResourceDriftsOutput, error) {
+	panic("not implemented")
+}
+
+func (c *CloudFormationClientMultiCall) ListStacks(input *cloudformation.ListStacksInput) (*cloudformation.ListStacksOutput, error) {
+	panic("not implemented")
+}
+
 type CloudFormationClient struct {
 	DescribeStackResourcesCall struct {
 		Receives struct {
@@ -67,6 +121,16 @@ type CloudFormationClient struct {
 		}
 	}
 
+	ListStacksCall struct {
+		Receives struct {
+			Input *cloudformation.ListStacksInput
+		}
+		Returns struct {
+			Output *cloudformation.ListStacksOutput
+			Error  error
+		}
+	}
+
 	CreateStackCall struct {
 		Receives struct {
 			Input *cloudformation.CreateStackInput
@@ -96,6 +160,79 @@ type CloudFormationClient struct {
 			Error  error
 		}
 	}
+
+	DescribeStackEventsCall struct {
+		Receives struct {
+			Input *cloudformation.DescribeStackEventsInput
+		}
+		Returns struct {
+			Output *cloudformation.DescribeStackEventsOutput
+			Error  error
+		}
+	}
+
+	CreateChangeSetCall struct {
+		Receives struct {
+			Input *cloudformation.CreateChangeSetInput
+		}
+		Returns struct {
+			Output *cloudformation.CreateChangeSetOutput
+			Error  error
+		}
+	}
+
+	DescribeChangeSetCallCount int
+	DescribeChangeSetCall      struct {
+		Receives struct {
+			Input *cloudformation.DescribeChangeSetInput
+		}
+		Returns struct {
+			Output *cloudformation.DescribeChangeSetOutput
+			Error  error
+		}
+	}
+
+	DeleteChangeSetCallCount int
+	DeleteChangeSetCall      struct {
+		Receives struct {
+			Input *cloudformation.DeleteChangeSetInput
+		}
+		Returns struct {
+			Output *cloudformation.DeleteChangeSetOutput
+			Error  error
+		}
+	}
+
+	DetectStackDriftCall struct {
+		Receives struct {
+			Input *cloudformation.DetectStackDriftInput
+		}
+		Returns struct {
+			Output *cloudformation.DetectStackDriftOutput
+			Error  error
+		}
+	}
+
+	DescribeStackDriftDetectionStatusCallCount int
+	DescribeStackDriftDetectionStatusCall      struct {
+		Receives struct {
+			Input *cloudformation.DescribeStackDriftDetectionStatusInput
+		}
+		Returns struct {
+			Output *cloudformation.DescribeStackDriftDetectionStatusOutput
+			Error  error
+		}
+	}
+
+	DescribeStackResourceDriftsCall struct {
+		Receives struct {
+			Input *cloudformation.DescribeStackResourceDriftsInput
+		}
+		Returns struct {
+			Output *cloudformation.DescribeStackResourceDriftsOutput
+			Error  error
+		}
+	}
 }
 
 func (c *CloudFormationClient) DescribeStackResources(input *cloudformation.DescribeStackResourcesInput) (*cloudformation.DescribeStackResourcesOutput, error) {
@@ -108,6 +245,11 @@ func (c *CloudFormationClient) DescribeStacks(input *cloudformation.DescribeStac
 	return c.DescribeStacksCall.Returns.Output, c.DescribeStacksCall.Returns.Error
 }
 
+func (c *CloudFormationClient) ListStacks(input *cloudformation.ListStacksInput) (*cloudformation.ListStacksOutput, error) {
+	c.ListStacksCall.Receives.Input = input
+	return c.ListStacksCall.Returns.Output, c.ListStacksCall.Returns.Error
+}
+
 func (c *CloudFormationClient) CreateStack(input *cloudformation.CreateStackInput) (*cloudformation.CreateStackOutput, error) {
 	c.CreateStackCall.Receives.Input = input
 	return c.CreateStackCall.Returns.Output, c.CreateStackCall.Returns.Error
@@ -122,3 +264,41 @@ func (c *CloudFormationClient) DeleteStack(input *cloudformation.DeleteStackInpu
 	c.DeleteStackCall.Receives.Input = input
 	return c.DeleteStackCall.Returns.Output, c.DeleteStackCall.Returns.Error
 }
+
+func (c *CloudFormationClient) DescribeStackEvents(input *cloudformation.DescribeStackEventsInput) (*cloudformation.DescribeStackEventsOutput, error) {
+	c.DescribeStackEventsCall.Receives.Input = input
+	return c.DescribeStackEventsCall.Returns.Output, c.DescribeStackEventsCall.Returns.Error
+}
+
+func (c *CloudFormationClient) CreateChangeSet(input *cloudformation.CreateChangeSetInput) (*cloudformation.CreateChangeSetOutput, error) {
+	c.CreateChangeSetCall.Receives.Input = input
+	return c.CreateChangeSetCall.Returns.Output, c.CreateChangeSetCall.Returns.Error
+}
+
+func (c *CloudFormationClient) DescribeChangeSet(input *cloudformation.DescribeChangeSetInput) (*cloudformation.DescribeChangeSetOutput, error) {
+	c.DescribeChangeSetCallCount++
+	c.DescribeChangeSetCall.Receives.Input = input
+	return c.DescribeChangeSetCall.Returns.Output, c.DescribeChangeSetCall.Returns.Error
+}
+
+func (c *CloudFormationClient) DeleteChangeSet(input *cloudformation.DeleteChangeSetInput) (*cloudformation.DeleteChangeSetOutput, error) {
+	c.DeleteChangeSetCallCount++
+	c.DeleteChangeSetCall.Receives.Input = input
+	return c.DeleteChangeSetCall.Returns.Output, c.DeleteChangeSetCall.Returns.Error
+}
+
+func (c *CloudFormationClient) DetectStackDrift(input *cloudformation.DetectStackDriftInput) (*cloudformation.DetectStackDriftOutput, error) {
+	c.DetectStackDriftCall.Receives.Input = input
+	return c.DetectStackDriftCall.Returns.Output, c.DetectStackDriftCall.Returns.Error
+}
+
+func (c *CloudFormationClient) DescribeStackDriftDetectionStatus(input *cloudformation.DescribeStackDriftDetectionStatusInput) (*cloudformation.DescribeStackDriftDetectionStatusOutput, error) {
+	c.DescribeStackDriftDetectionStatusCallCount++
+	c.DescribeStackDriftDetectionStatusCall.Receives.Input = input
+	return c.DescribeStackDriftDetectionStatusCall.Returns.Output, c.DescribeStackDriftDetectionStatusCall.Returns.Error
+}
+
+func (c *CloudFormationClient) DescribeStackResourceDrifts(input *cloudformation.DescribeStackResourceDriftsInput) (*cloudformation.DescribeStackResourceDriftsOutput, error) {
+	c.DescribeStackResourceDriftsCall.Receives.Input = input
+	return c.DescribeStackResourceDriftsCall.Returns.Output, c.DescribeStackResourceDriftsCall.Returns.Error
+}
@@ -1,5 +1,11 @@
 package mocks
 
+import (
+	"os"
+	"sort"
+	"strings"
+)
+
 func NewConfigStore() *ConfigStore {
 	return &ConfigStore{}
 }
@@ -48,6 +54,7 @@ type FunctionalConfigStore struct {
 	Values       map[string][]byte
 	Errors       map[string]error
 	IsEmptyError error
+	ListError    error
 }
 
 func (s *FunctionalConfigStore) Get(key string) ([]byte, error) {
@@ -59,6 +66,103 @@ func (s *FunctionalConfigStore) Set(key string, value []byte) error {
 	return s.Errors[key]
 }
 
+func (s *FunctionalConfigStore) Delete(key string) error {
+	delete(s.Values, key)
+	return s.Errors[key]
+}
+
+func (s *FunctionalConfigStore) List() ([]string, error) {
+	keys := []string{}
+	for key := range s.Values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, s.ListError
+}
+
 func (s *FunctionalConfigStore) IsEmpty() (bool, error) {
 	return len(s.Values) == 0, s.IsEmptyError
 }
+
+type HistoryConfigStore struct {
+	*FunctionalConfigStore
+
+	HistoryCall struct {
+		Returns struct {
+			Output []byte
+			Error  error
+		}
+	}
+
+	DiffCall struct {
+		Receives struct {
+			Revision string
+		}
+		Returns struct {
+			Output []byte
+			Error  error
+		}
+	}
+}
+
+func (s *HistoryConfigStore) History() ([]byte, error) {
+	return s.HistoryCall.Returns.Output, s.HistoryCall.Returns.Error
+}
+
+func (s *HistoryConfigStore) Diff(revision string) ([]byte, error) {
+	s.DiffCall.Receives.Revision = revision
+	return s.DiffCall.Returns.Output, s.DiffCall.Returns.Error
+}
+
+func NewFunctionalObjectStore() *FunctionalObjectStore {
+	return &FunctionalObjectStore{
+		Objects: make(map[string][]byte),
+		Errors:  make(map[string]error),
+	}
+}
+
+// FunctionalObjectStore keeps objects in memory, keyed by bucket/key
+type FunctionalObjectStore struct {
+	Objects     map[string][]byte
+	Errors      map[string]error
+	DeleteError error
+	ListError   error
+}
+
+func (s *FunctionalObjectStore) GetObject(bucket, key string) ([]byte, error) {
+	if err := s.Errors[bucket+"/"+key]; err != nil {
+		return nil, err
+	}
+	value, ok := s.Objects[bucket+"/"+key]
+	if !ok {
+		return nil, &os.PathError{Op: "get", Path: "s3://" + bucket + "/" + key, Err: os.ErrNotExist}
+	}
+	return value, nil
+}
+
+func (s *FunctionalObjectStore) PutObject(bucket, key string, value []byte) error {
+	if err := s.Errors[bucket+"/"+key]; err != nil {
+		return err
+	}
+	s.Objects[bucket+"/"+key] = value
+	return nil
+}
+
+func (s *FunctionalObjectStore) DeleteObject(bucket, key string) error {
+	if s.DeleteError != nil {
+		return s.DeleteError
+	}
+	delete(s.Objects, bucket+"/"+key)
+	return nil
+}
+
+func (s *FunctionalObjectStore) ListObjects(bucket, prefix string) ([]string, error) {
+	keys := []string{}
+	for objectKey := range s.Objects {
+		if strings.HasPrefix(objectKey, bucket+"/"+prefix) {
+			keys = append(keys, strings.TrimPrefix(objectKey, bucket+"/"))
+		}
+	}
+	sort.Strings(keys)
+	return keys, s.ListError
+}
@@ -0,0 +1,24 @@
+package mocks
+
+import "github.com/rosenhouse/tubes/lib/director"
+
+type DirectorClient struct {
+	InfoCall struct {
+		Receives struct {
+			Target   director.Target
+			Username string
+			Password string
+		}
+		Returns struct {
+			Info  director.Info
+			Error error
+		}
+	}
+}
+
+func (c *DirectorClient) Info(target director.Target, username, password string) (director.Info, error) {
+	c.InfoCall.Receives.Target = target
+	c.InfoCall.Receives.Username = username
+	c.InfoCall.Receives.Password = password
+	return c.InfoCall.Returns.Info, c.InfoCall.Returns.Error
+}
@@ -0,0 +1,39 @@
+package mocks
+
+import (
+	"io"
+
+	"golang.org/x/net/context"
+)
+
+type DirectorDeployer struct {
+	DeployCall struct {
+		Receives struct {
+			Context    context.Context
+			Host       string
+			PrivateKey []byte
+			Files      map[string][]byte
+		}
+		// HostKey, if set, is passed to the host key check before anything else happens
+		HostKey []byte
+		Writes  string
+		Returns struct {
+			State []byte
+			Error error
+		}
+	}
+}
+
+func (d *DirectorDeployer) Deploy(ctx context.Context, host string, privateKey []byte, checkHostKey func(hostKey []byte) error, files map[string][]byte, output io.Writer) ([]byte, error) {
+	d.DeployCall.Receives.Context = ctx
+	d.DeployCall.Receives.Host = host
+	d.DeployCall.Receives.PrivateKey = privateKey
+	if d.DeployCall.HostKey != nil {
+		if err := checkHostKey(d.DeployCall.HostKey); err != nil {
+			return nil, err
+		}
+	}
+	d.DeployCall.Receives.Files = files
+	output.Write([]byte(d.DeployCall.Writes))
+	return d.DeployCall.Returns.State, d.DeployCall.Returns.Error
+}
@@ -21,6 +21,24 @@ type EC2Client struct {
 			Error  error
 		}
 	}
+	DescribeInstancesCall struct {
+		Receives struct {
+			Input *ec2.DescribeInstancesInput
+		}
+		Returns struct {
+			Output *ec2.DescribeInstancesOutput
+			Error  error
+		}
+	}
+	DescribeNatGatewaysCall struct {
+		Receives struct {
+			Input *ec2.DescribeNatGatewaysInput
+		}
+		Returns struct {
+			Output *ec2.DescribeNatGatewaysOutput
+			Error  error
+		}
+	}
 	CreateKeyPairCall struct {
 		Receives struct {
 			Input *ec2.CreateKeyPairInput
@@ -39,6 +57,87 @@ type EC2Client struct {
 			Error  error
 		}
 	}
+	DescribeKeyPairsCall struct {
+		Receives struct {
+			Input *ec2.DescribeKeyPairsInput
+		}
+		Returns struct {
+			Output *ec2.DescribeKeyPairsOutput
+			Error  error
+		}
+	}
+	ImportKeyPairCall struct {
+		Receives struct {
+			Input *ec2.ImportKeyPairInput
+		}
+		Returns struct {
+			Output *ec2.ImportKeyPairOutput
+			Error  error
+		}
+	}
+	DescribeAddressesCall struct {
+		Receives struct {
+			Input *ec2.DescribeAddressesInput
+		}
+		Returns struct {
+			Output *ec2.DescribeAddressesOutput
+			Error  error
+		}
+	}
+	ReleaseAddressCall struct {
+		Receives struct {
+			Input *ec2.ReleaseAddressInput
+		}
+		Returns struct {
+			Output *ec2.ReleaseAddressOutput
+			Error  error
+		}
+	}
+	TerminateInstancesCall struct {
+		Receives struct {
+			Input *ec2.TerminateInstancesInput
+		}
+		Returns struct {
+			Output *ec2.TerminateInstancesOutput
+			Error  error
+		}
+	}
+	DescribeVolumesCall struct {
+		Receives struct {
+			Input *ec2.DescribeVolumesInput
+		}
+		Returns struct {
+			Output *ec2.DescribeVolumesOutput
+			Error  error
+		}
+	}
+	DeleteVolumeCall struct {
+		Receives struct {
+			Input *ec2.DeleteVolumeInput
+		}
+		Returns struct {
+			Output *ec2.DeleteVolumeOutput
+			Error  error
+		}
+	}
+	DescribeNetworkInterfacesCall struct {
+		Receives struct {
+			Input *ec2.DescribeNetworkInterfacesInput
+		}
+		Returns struct {
+			Output *ec2.DescribeNetworkInterfacesOutput
+			Error  error
+		}
+	}
+	DeleteNetworkInterfaceCall struct {
+		Receives struct {
+			Input *ec2.DeleteNetworkInterfaceInput
+		}
+		Returns struct {
+			Output *ec2.DeleteNetworkInterfaceOutput
+			Error  error
+		}
+	}
 }
 
 func (c *EC2Client) DescribeImages(input *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
@@ -51,6 +150,16 @@ func (c *EC2Client) DescribeSubnets(input *ec2.DescribeSubnetsInput) (*ec2.Descr
 	return c.DescribeSubnetsCall.Returns.Output, c.DescribeSubnetsCall.Returns.Error
 }
 
+func (c *EC2Client) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	c.DescribeInstancesCall.Receives.Input = input
+	return c.DescribeInstancesCall.Returns.Output, c.DescribeInstancesCall.Returns.Error
+}
+
+func (c *EC2Client) DescribeNatGateways(input *ec2.DescribeNatGatewaysInput) (*ec2.DescribeNatGatewaysOutput, error) {
+	c.DescribeNatGatewaysCall.Receives.Input = input
+	return c.DescribeNatGatewaysCall.Returns.Output, c.DescribeNatGatewaysCall.Returns.Error
+}
+
 func (c *EC2Client) CreateKeyPair(input *ec2.CreateKeyPairInput) (*ec2.CreateKeyPairOutput, error) {
 	c.CreateKeyPairCall.Receives.Input = input
 	return c.CreateKeyPairCall.Returns.Output, c.CreateKeyPairCall.Returns.Error
@@ -60,3 +169,48 @@ func (c *EC2Client) DeleteKeyPair(input *ec2.DeleteKeyPairInput) (*ec2.DeleteKey
 	c.DeleteKeyPairCall.Receives.Input = input
 	return c.DeleteKeyPairCall.Returns.Output, c.DeleteKeyPairCall.Returns.Error
 }
+
+func (c *EC2Client) DescribeKeyPairs(input *ec2.DescribeKeyPairsInput) (*ec2.DescribeKeyPairsOutput, error) {
+	c.DescribeKeyPairsCall.Receives.Input = input
+	return c.DescribeKeyPairsCall.Returns.Output, c.DescribeKeyPairsCall.Returns.Error
+}
+
+func (c *EC2Client) ImportKeyPair(input *ec2.ImportKeyPairInput) (*ec2.ImportKeyPairOutput, error) {
+	c.ImportKeyPairCall.Receives.Input = input
+	return c.ImportKeyPairCall.Returns.Output, c.ImportKeyPairCall.Returns.Error
+}
+
+func (c *EC2Client) DescribeAddresses(input *ec2.DescribeAddressesInput) (*ec2.DescribeAddressesOutput, error) {
+	c.DescribeAddressesCall.Receives.Input = input
+	return c.DescribeAddressesCall.Returns.Output, c.DescribeAddressesCall.Returns.Error
+}
+
+func (c *EC2Client) ReleaseAddress(input *ec2.ReleaseAddressInput) (*ec2.ReleaseAddressOutput, error) {
+	c.ReleaseAddressCall.Receives.Input = input
+	return c.ReleaseAddressCall.Returns.Output, c.ReleaseAddressCall.Returns.Error
+}
+
+func (c *EC2Client) TerminateInstances(input *ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error) {
+	c.TerminateInstancesCall.Receives.Input = input
+	return c.TerminateInstancesCall.Returns.Output, c.TerminateInstancesCall.Returns.Error
+}
+
+func (c *EC2Client) DescribeVolumes(input *ec2.DescribeVolumesInput) (*ec2.DescribeVolumesOutput, error) {
+	c.DescribeVolumesCall.Receives.Input = input
+	return c.DescribeVolumesCall.Returns.Output, c.DescribeVolumesCall.Returns.Error
+}
+
+func (c *EC2Client) DeleteVolume(input *ec2.DeleteVolumeInput) (*ec2.DeleteVolumeOutput, error) {
+	c.DeleteVolumeCall.Receives.Input = input
+	return c.DeleteVolumeCall.Returns.Output, c.DeleteVolumeCall.Returns.Error
+}
+
+func (c *EC2Client) DescribeNetworkInterfaces(input *ec2.DescribeNetworkInterfacesInput) (*ec2.DescribeNetworkInterfacesOutput, error) {
+	c.DescribeNetworkInterfacesCall.Receives.Input = input
+	return c.DescribeNetworkInterfacesCall.Returns.Output, c.DescribeNetworkInterfacesCall.Returns.Error
+}
+
+func (c *EC2Client) DeleteNetworkInterface(input *ec2.DeleteNetworkInterfaceInput) (*ec2.DeleteNetworkInterfaceOutput, error) {
+	c.DeleteNetworkInterfaceCall.Receives.Input = input
+	return c.DeleteNetworkInterfaceCall.Returns.Output, c.DeleteNetworkInterfaceCall.Returns.Error
+}
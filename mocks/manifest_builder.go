@@ -1,27 +1,34 @@
 package mocks
 
-import "github.com/rosenhouse/tubes/lib/awsclient"
+import (
+	"github.com/rosenhouse/tubes/lib/awsclient"
+	"github.com/rosenhouse/tubes/lib/director"
+)
 
 type ManifestBuilder struct {
 	BuildCall struct {
 		Receives struct {
-			StackName string
-			Resources awsclient.BaseStackResources
-			AccessKey string
-			SecretKey string
+			StackName   string
+			Resources   awsclient.BaseStackResources
+			AccessKey   string
+			SecretKey   string
+			Credentials director.Credentials
+			SSL         director.SSL
 		}
 		Returns struct {
-			ManifestYAML  []byte
-			AdminPassword string
-			Error         error
+			ManifestYAML []byte
+			Credentials  director.Credentials
+			Error        error
 		}
 	}
 }
 
-func (b *ManifestBuilder) Build(stackName string, resources awsclient.BaseStackResources, accessKey, secretKey string) ([]byte, string, error) {
+func (b *ManifestBuilder) Build(stackName string, resources awsclient.BaseStackResources, accessKey, secretKey string, credentials director.Credentials, ssl director.SSL) ([]byte, director.Credentials, error) {
 	b.BuildCall.Receives.StackName = stackName
 	b.BuildCall.Receives.Resources = resources
 	b.BuildCall.Receives.AccessKey = accessKey
 	b.BuildCall.Receives.SecretKey = secretKey
-	return b.BuildCall.Returns.ManifestYAML, b.BuildCall.Returns.AdminPassword, b.BuildCall.Returns.Error
+	b.BuildCall.Receives.Credentials = credentials
+	b.BuildCall.Receives.SSL = ssl
+	return b.BuildCall.Returns.ManifestYAML, b.BuildCall.Returns.Credentials, b.BuildCall.Returns.Error
 }
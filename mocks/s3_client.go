@@ -0,0 +1,69 @@
+package mocks
+
+import "github.com/aws/aws-sdk-go/service/s3"
+
+type ListObjectsCall struct {
+	Input  *s3.ListObjectsInput
+	Output *s3.ListObjectsOutput
+	Error  error
+}
+
+type S3Client struct {
+	GetObjectCall struct {
+		Receives struct {
+			Input *s3.GetObjectInput
+		}
+		Returns struct {
+			Output *s3.GetObjectOutput
+			Error  error
+		}
+	}
+
+	PutObjectCall struct {
+		Receives struct {
+			Input *s3.PutObjectInput
+		}
+		Returns struct {
+			Output *s3.PutObjectOutput
+			Error  error
+		}
+	}
+
+	DeleteObjectCall struct {
+		Receives struct {
+			Input *s3.DeleteObjectInput
+		}
+		Returns struct {
+			Output *s3.DeleteObjectOutput
+			Error  error
+		}
+	}
+
+	ListObjectsCallCount int
+	ListObjectsCalls     []ListObjectsCall
+}
+
+func (c *S3Client) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	c.GetObjectCall.Receives.Input = input
+	return c.GetObjectCall.Returns.Output, c.GetObjectCall.Returns.Error
+}
+
+func (c *S3Client) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	c.PutObjectCall.Receives.Input = input
+	return c.PutObjectCall.Returns.Output, c.PutObjectCall.Returns.Error
+}
+
+func (c *S3Client) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	c.DeleteObjectCall.Receives.Input = input
+	return c.DeleteObjectCall.Returns.Output, c.DeleteObjectCall.Returns.Error
+}
+
+func (c *S3Client) ListObjects(input *s3.ListObjectsInput) (*s3.ListObjectsOutput, error) {
+	i := c.ListObjectsCallCount
+	c.ListObjectsCallCount++
+
+	// copy, since the caller reuses the input to page
+	inputCopy := *input
+	c.ListObjectsCalls[i].Input = &inputCopy
+	return c.ListObjectsCalls[i].Output, c.ListObjectsCalls[i].Error
+}
@@ -0,0 +1,50 @@
+package mocks
+
+import (
+	"errors"
+	"io"
+	"net"
+)
+
+type SSHTunneler struct {
+	TunnelCallCount int
+	TunnelCall      struct {
+		Receives struct {
+			Host       string
+			PrivateKey []byte
+		}
+		// HostKey, if set, is passed to the host key check before the tunnel opens
+		HostKey []byte
+		Returns struct {
+			Error error
+		}
+	}
+
+	// Dialed records the addresses dialed through the tunnel
+	Dialed []string
+	Closed bool
+}
+
+func (t *SSHTunneler) Tunnel(host string, privateKey []byte, checkHostKey func(hostKey []byte) error) (func(network, addr string) (net.Conn, error), io.Closer, error) {
+	t.TunnelCallCount++
+	t.TunnelCall.Receives.Host = host
+	t.TunnelCall.Receives.PrivateKey = privateKey
+	if t.TunnelCall.HostKey != nil {
+		if err := checkHostKey(t.TunnelCall.HostKey); err != nil {
+			return nil, nil, err
+		}
+	}
+	if t.TunnelCall.Returns.Error != nil {
+		return nil, nil, t.TunnelCall.Returns.Error
+	}
+	dial := func(network, addr string) (net.Conn, error) {
+		t.Dialed = append(t.Dialed, addr)
+		return nil, errors.New("not implemented")
+	}
+	return dial, t, nil
+}
+
+func (t *SSHTunneler) Close() error {
+	t.Closed = true
+	return nil
+}
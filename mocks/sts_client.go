@@ -0,0 +1,20 @@
+package mocks
+
+import "github.com/aws/aws-sdk-go/service/sts"
+
+type STSClient struct {
+	AssumeRoleCall struct {
+		Receives struct {
+			Input *sts.AssumeRoleInput
+		}
+		Returns struct {
+			Output *sts.AssumeRoleOutput
+			Error  error
+		}
+	}
+}
+
+func (c *STSClient) AssumeRole(input *sts.AssumeRoleInput) (*sts.AssumeRoleOutput, error) {
+	c.AssumeRoleCall.Receives.Input = input
+	return c.AssumeRoleCall.Returns.Output, c.AssumeRoleCall.Returns.Error
+}